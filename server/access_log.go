@@ -0,0 +1,205 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// AccessLogRecord describes a request served by a handler wrapped by
+// AccessLog.
+type AccessLogRecord struct {
+	Time       time.Time
+	Method     string
+	Path       string
+	RequestURI string
+	Proto      string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+	RemoteAddr string
+	UserAgent  string
+	Referer    string
+
+	// RequestID is the ID assigned by RequestID, if it wraps the handler.
+	RequestID string
+
+	// LoginName and NodeName identify the caller. They are empty if the
+	// identity of the caller is not in the request context.
+	LoginName string
+	NodeName  string
+}
+
+// AccessLogSink stores access log records. Log is called after each request
+// and has to be safe for concurrent use.
+type AccessLogSink interface {
+	Log(record AccessLogRecord)
+}
+
+// AccessLogSinkFunc is an adapter to use an ordinary function as an
+// AccessLogSink.
+type AccessLogSinkFunc func(record AccessLogRecord)
+
+// Log calls f(record).
+func (f AccessLogSinkFunc) Log(record AccessLogRecord) {
+	f(record)
+}
+
+// NewSlogAccessLogSink returns an AccessLogSink which writes every record as
+// an info message with one attribute per field to logger. The default logger
+// is used if logger is nil.
+func NewSlogAccessLogSink(logger *slog.Logger) AccessLogSink {
+	return AccessLogSinkFunc(func(record AccessLogRecord) {
+		l := logger
+		if l == nil {
+			l = slog.Default()
+		}
+		l.LogAttrs(context.Background(), slog.LevelInfo, "request",
+			slog.Time("time", record.Time),
+			slog.String("method", record.Method),
+			slog.String("path", record.Path),
+			slog.String("proto", record.Proto),
+			slog.Int("status", record.Status),
+			slog.Int64("bytes", record.Bytes),
+			slog.Duration("duration", record.Duration),
+			slog.String("remote_addr", record.RemoteAddr),
+			slog.String("user_agent", record.UserAgent),
+			slog.String("request_id", record.RequestID),
+			slog.String("login", record.LoginName),
+			slog.String("node", record.NodeName),
+		)
+	})
+}
+
+// combinedLogTimeFormat is the time format of the combined log format.
+const combinedLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// NewCombinedAccessLogSink returns an AccessLogSink which writes every record
+// as a line in the combined log format of Apache and NGINX, with the login
+// name of the caller as the authenticated user, to w, so that log analyzers
+// such as GoAccess and AWStats can read it unmodified. Standard output is
+// used if w is nil.
+func NewCombinedAccessLogSink(w io.Writer) AccessLogSink {
+	if w == nil {
+		w = os.Stdout
+	}
+	var mu sync.Mutex
+	return AccessLogSinkFunc(func(record AccessLogRecord) {
+		line := formatCombinedLogLine(record)
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = io.WriteString(w, line)
+	})
+}
+
+// formatCombinedLogLine returns the record in the combined log format:
+// host ident authuser [time] "request" status bytes "referer" "user-agent".
+func formatCombinedLogLine(record AccessLogRecord) string {
+	host := record.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	requestURI := record.RequestURI
+	if requestURI == "" {
+		requestURI = record.Path
+	}
+	bytes := "-"
+	if record.Bytes > 0 {
+		bytes = strconv.FormatInt(record.Bytes, 10)
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		combinedLogField(host),
+		combinedLogField(record.LoginName),
+		record.Time.Format(combinedLogTimeFormat),
+		escapeCombinedLogString(record.Method), escapeCombinedLogString(requestURI), escapeCombinedLogString(record.Proto),
+		record.Status,
+		bytes,
+		escapeCombinedLogString(cmp.Or(record.Referer, "-")),
+		escapeCombinedLogString(cmp.Or(record.UserAgent, "-")),
+	)
+}
+
+// combinedLogField returns an unquoted field, which is "-" if it is empty
+// and cannot contain spaces separating it from the next field.
+func combinedLogField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return escapeCombinedLogString(strings.ReplaceAll(s, " ", "_"))
+}
+
+// escapeCombinedLogString escapes quotes, backslashes and non-printable
+// bytes as Apache does, so that values cannot break a log line apart.
+func escapeCombinedLogString(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// AccessLog returns a middleware passing one record per request to sink. The
+// caller and the request ID are read from the request context so the handler
+// has to be wrapped by Server.WithIdentity and RequestID as well for records
+// to carry them.
+func AccessLog(sink AccessLogSink) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := newStatusRecorder(w)
+			defer func() {
+				who, _ := IdentityFromContext(r.Context())
+				sink.Log(newAccessLogRecord(r, recorder, start, who))
+			}()
+			h.ServeHTTP(recorder, r)
+		})
+	}
+}
+
+// newAccessLogRecord returns the record of a request served since start by
+// the caller who, which may be nil.
+func newAccessLogRecord(r *http.Request, recorder *statusRecorder, start time.Time, who *apitype.WhoIsResponse) AccessLogRecord {
+	record := AccessLogRecord{
+		Time:       start,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RequestURI: r.RequestURI,
+		Proto:      r.Proto,
+		Status:     recorder.Status(),
+		Bytes:      recorder.size,
+		Duration:   time.Since(start),
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		Referer:    r.Referer(),
+	}
+	record.RequestID, _ = RequestIDFromContext(r.Context())
+	if who != nil {
+		if who.UserProfile != nil {
+			record.LoginName = who.UserProfile.LoginName
+		}
+		if who.Node != nil {
+			record.NodeName = strings.TrimSuffix(who.Node.Name, ".")
+		}
+	}
+	return record
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	var records []AccessLogRecord
+	sink := AccessLogSinkFunc(func(record AccessLogRecord) {
+		records = append(records, record)
+	})
+	h := AccessLog(sink)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, "queued")
+	}))
+
+	r := httptest.NewRequest("POST", "/jobs?priority=high", nil)
+	r.Header.Set("User-Agent", "curl/8.0")
+	r.Header.Set("Referer", "https://web.prawn-universe.ts.net/")
+	r = r.WithContext(ContextWithIdentity(r.Context(), newTestWhoIs("alice@example.com")))
+	r = r.WithContext(ContextWithRequestID(r.Context(), "request-1"))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(records) != 1 {
+		t.Fatalf("got %d records; want 1", len(records))
+	}
+	want := AccessLogRecord{
+		RequestID:  "request-1",
+		Method:     "POST",
+		Path:       "/jobs",
+		RequestURI: "/jobs?priority=high",
+		Proto:      "HTTP/1.1",
+		Status:     http.StatusAccepted,
+		Bytes:      6,
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  "curl/8.0",
+		Referer:    "https://web.prawn-universe.ts.net/",
+		LoginName:  "alice@example.com",
+		NodeName:   "test-node.prawn-universe.ts.net",
+	}
+	got := records[0]
+	got.Time = want.Time
+	got.Duration = want.Duration
+	if got != want {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestSlogAccessLogSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewSlogAccessLogSink(slog.New(slog.NewJSONHandler(&buf, nil)))
+	sink.Log(AccessLogRecord{Method: "GET", Path: "/", Status: http.StatusOK, Bytes: 42, LoginName: "alice@example.com"})
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "request" || entry["method"] != "GET" || entry["status"] != float64(200) ||
+		entry["bytes"] != float64(42) || entry["login"] != "alice@example.com" {
+		t.Errorf("got entry %v", entry)
+	}
+}
+
+func TestCombinedAccessLogSink(t *testing.T) {
+	at := time.Date(2026, time.March, 9, 14, 5, 7, 0, time.FixedZone("", 8*60*60))
+	tests := []struct {
+		name   string
+		record AccessLogRecord
+		want   string
+	}{
+		{
+			name: "tailnet caller",
+			record: AccessLogRecord{
+				Time: at, Method: "GET", Path: "/items", RequestURI: "/items?page=2", Proto: "HTTP/2.0",
+				Status: http.StatusOK, Bytes: 512, RemoteAddr: "100.64.0.1:51234",
+				UserAgent: "Mozilla/5.0 (X11; Linux x86_64)", Referer: "https://web.prawn-universe.ts.net/",
+				LoginName: "alice@example.com",
+			},
+			want: `100.64.0.1 - alice@example.com [09/Mar/2026:14:05:07 +0800] "GET /items?page=2 HTTP/2.0" 200 512 "https://web.prawn-universe.ts.net/" "Mozilla/5.0 (X11; Linux x86_64)"` + "\n",
+		},
+		{
+			name: "anonymous caller without body",
+			record: AccessLogRecord{
+				Time: at, Method: "HEAD", Path: "/", Proto: "HTTP/1.1",
+				Status: http.StatusNotModified, RemoteAddr: "[2001:db8::1]:443",
+			},
+			want: `2001:db8::1 - - [09/Mar/2026:14:05:07 +0800] "HEAD / HTTP/1.1" 304 - "-" "-"` + "\n",
+		},
+		{
+			name: "escaped values",
+			record: AccessLogRecord{
+				Time: at, Method: "GET", Path: "/", Proto: "HTTP/1.1", Status: http.StatusOK,
+				RemoteAddr: "100.64.0.1:1", UserAgent: "evil\" 200 1 \"\n", LoginName: "bob smith",
+			},
+			want: `100.64.0.1 - bob_smith [09/Mar/2026:14:05:07 +0800] "GET / HTTP/1.1" 200 - "-" "evil\" 200 1 \"\x0a"` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			NewCombinedAccessLogSink(&buf).Log(tt.record)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ALPNHandshakeTimeout is the maximum duration ALPNMux waits for a TLS
+// handshake to complete before closing the connection.
+const ALPNHandshakeTimeout = 10 * time.Second
+
+// ALPNMux dispatches connections accepted from a TLS listener to the handlers
+// registered for the application protocol negotiated in the TLS handshake.
+// This allows a single port to serve HTTP and custom protocols. Protocols
+// have to be listed in ServerConfig.TLSConfig.NextProtos to be negotiated.
+type ALPNMux struct {
+	listener net.Listener
+
+	mu        sync.RWMutex
+	handlers  map[string]func(net.Conn)
+	listeners []*alpnListener
+}
+
+// NewALPNMux creates a ALPNMux for the specified TLS listener, such as the
+// ones returned by Listen.
+func NewALPNMux(listener net.Listener) *ALPNMux {
+	return &ALPNMux{
+		listener: listener,
+		handlers: make(map[string]func(net.Conn)),
+	}
+}
+
+// Handle registers the handler for connections that negotiated the specified
+// protocol. Use an empty protocol for clients which do not negotiate any
+// protocol. The handler owns the connection and is responsible for closing
+// it.
+func (m *ALPNMux) Handle(protocol string, handler func(net.Conn)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[protocol] = handler
+}
+
+// Listener returns a listener which accepts connections that negotiated any
+// of the specified protocols. It is useful for passing connections to
+// http.Server, for example with protocols "h2" and "http/1.1".
+func (m *ALPNMux) Listener(protocols ...string) net.Listener {
+	l := &alpnListener{
+		addr:   m.listener.Addr(),
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.listeners = append(m.listeners, l)
+	m.mu.Unlock()
+	for _, protocol := range protocols {
+		m.Handle(protocol, l.push)
+	}
+	return l
+}
+
+// Serve accepts connections from the underlying listener and dispatches them
+// until the listener is closed.
+func (m *ALPNMux) Serve() error {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go m.dispatch(conn)
+	}
+}
+
+// Close closes the underlying listener and all listeners created by Listener.
+func (m *ALPNMux) Close() error {
+	m.mu.RLock()
+	for _, l := range m.listeners {
+		_ = l.Close()
+	}
+	m.mu.RUnlock()
+	return m.listener.Close()
+}
+
+func (m *ALPNMux) dispatch(conn net.Conn) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		logf(slog.LevelWarn, "closing non-TLS connection from [%s]", conn.RemoteAddr())
+		_ = conn.Close()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ALPNHandshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	protocol := tlsConn.ConnectionState().NegotiatedProtocol
+	m.mu.RLock()
+	handler, found := m.handlers[protocol]
+	m.mu.RUnlock()
+	if !found {
+		logf(slog.LevelWarn, "closing connection from [%s] with unhandled protocol [%s]", conn.RemoteAddr(), protocol)
+		_ = conn.Close()
+		return
+	}
+	handler(tlsConn)
+}
+
+// NegotiatedProtocol returns the application protocol negotiated in the TLS
+// handshake of the request. It returns an empty string for plaintext requests
+// or if no protocol has been negotiated.
+func NegotiatedProtocol(r *http.Request) string {
+	if r.TLS == nil {
+		return ""
+	}
+	return r.TLS.NegotiatedProtocol
+}
+
+// alpnListener is a net.Listener receiving connections from ALPNMux.
+type alpnListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *alpnListener) push(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		_ = conn.Close()
+	}
+}
+
+func (l *alpnListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *alpnListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *alpnListener) Addr() net.Addr {
+	return l.addr
+}
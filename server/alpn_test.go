@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestALPNMux(t *testing.T) {
+	cert := newTestCertificate(t)
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := tls.NewListener(tcpListener, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"custom/1", "http/1.1"},
+	})
+
+	mux := NewALPNMux(listener)
+	defer func() { _ = mux.Close() }()
+	mux.Handle("custom/1", func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+		_, _ = conn.Write([]byte("custom\n"))
+	})
+	httpServer := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(NegotiatedProtocol(r)))
+		}),
+		ReadHeaderTimeout: time.Second,
+	}
+	go func() { _ = httpServer.Serve(mux.Listener("http/1.1")) }()
+	go func() { _ = mux.Serve() }()
+
+	t.Run("custom protocol", func(t *testing.T) {
+		conn, err := tls.Dial("tcp", tcpListener.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true, // #nosec G402 -- self-signed test certificate
+			NextProtos:         []string{"custom/1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = conn.Close() }()
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != "custom\n" {
+			t.Errorf("got %q; want %q", line, "custom\n")
+		}
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true, // #nosec G402 -- self-signed test certificate
+					NextProtos:         []string{"http/1.1"},
+				},
+			},
+		}
+		resp, err := client.Get("https://" + tcpListener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("got %d; want %d", resp.StatusCode, http.StatusOK)
+		}
+		body := make([]byte, 16)
+		n, _ := resp.Body.Read(body)
+		if string(body[:n]) != "http/1.1" {
+			t.Errorf("got protocol %q; want %q", body[:n], "http/1.1")
+		}
+	})
+}
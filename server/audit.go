@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AuditRecord describes a request served by a handler wrapped by Audit.
+type AuditRecord struct {
+	Time       time.Time
+	LoginName  string
+	NodeName   string
+	RemoteAddr string
+	Method     string
+	Path       string
+	Status     int
+	Duration   time.Duration
+}
+
+// AuditSink stores audit records, for example in a log file or a database.
+// Record is called after each request and has to be safe for concurrent use.
+type AuditSink interface {
+	Record(record AuditRecord)
+}
+
+// AuditSinkFunc is an adapter to use an ordinary function as an AuditSink.
+type AuditSinkFunc func(record AuditRecord)
+
+// Record calls f(record).
+func (f AuditSinkFunc) Record(record AuditRecord) {
+	f(record)
+}
+
+// NewLogAuditSink returns an AuditSink which writes every record as an info
+// message with one attribute per field to logger. The default logger is used
+// if logger is nil.
+func NewLogAuditSink(logger *slog.Logger) AuditSink {
+	return AuditSinkFunc(func(record AuditRecord) {
+		l := logger
+		if l == nil {
+			l = slog.Default()
+		}
+		l.LogAttrs(context.Background(), slog.LevelInfo, "audit",
+			slog.Time("time", record.Time),
+			slog.String("login", record.LoginName),
+			slog.String("node", record.NodeName),
+			slog.String("remote_addr", record.RemoteAddr),
+			slog.String("method", record.Method),
+			slog.String("path", record.Path),
+			slog.Int("status", record.Status),
+			slog.Duration("duration", record.Duration),
+		)
+	})
+}
+
+// Audit wraps the provided handler and records the caller, method, path and
+// status of every request to sink. The caller is read from the request
+// context so the handler has to be wrapped by Server.WithIdentity as well for
+// records to carry the identity of callers.
+func Audit(sink AuditSink, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := newStatusRecorder(w)
+		defer func() {
+			record := AuditRecord{
+				Time:       start,
+				RemoteAddr: r.RemoteAddr,
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Status:     recorder.Status(),
+				Duration:   time.Since(start),
+			}
+			if who, found := IdentityFromContext(r.Context()); found {
+				if who.UserProfile != nil {
+					record.LoginName = who.UserProfile.LoginName
+				}
+				if who.Node != nil {
+					record.NodeName = strings.TrimSuffix(who.Node.Name, ".")
+				}
+			}
+			sink.Record(record)
+		}()
+		h.ServeHTTP(recorder, r)
+	})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	var records []AuditRecord
+	sink := AuditSinkFunc(func(record AuditRecord) {
+		records = append(records, record)
+	})
+	h := Audit(sink, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+
+	r := httptest.NewRequest("DELETE", "/admin/users/1", nil)
+	r = r.WithContext(ContextWithIdentity(r.Context(), newTestWhoIs("alice@example.com")))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(records) != 1 {
+		t.Fatalf("got %d records; want 1", len(records))
+	}
+	want := AuditRecord{
+		LoginName:  "alice@example.com",
+		NodeName:   "test-node.prawn-universe.ts.net",
+		RemoteAddr: r.RemoteAddr,
+		Method:     "DELETE",
+		Path:       "/admin/users/1",
+		Status:     http.StatusNotFound,
+	}
+	got := records[0]
+	got.Time = want.Time
+	got.Duration = want.Duration
+	if got != want {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestLogAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewLogAuditSink(slog.New(slog.NewJSONHandler(&buf, nil)))
+	sink.Record(AuditRecord{LoginName: "alice@example.com", Method: "DELETE", Path: "/admin/users/1", Status: http.StatusNoContent})
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "audit" || entry["login"] != "alice@example.com" || entry["method"] != "DELETE" ||
+		entry["path"] != "/admin/users/1" || entry["status"] != float64(http.StatusNoContent) {
+		t.Errorf("got entry %v", entry)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"tailscale.com/client/local"
+	"tailscale.com/ipn"
+)
+
+// AuthKeyFilePrefix marks a ServerConfig.TailscaleAuthKey as the path of a
+// file containing the auth key, such as "file:/run/secrets/ts-authkey". The
+// official Tailscale container images use the same convention.
+const AuthKeyFilePrefix = "file:"
+
+// AuthKeySource obtains Tailscale auth keys. It is asked for a key when the
+// server starts and again whenever the node has to log in again, so it can
+// be backed by a secret store such as Vault or AWS Secrets Manager to rotate
+// keys without restarting the process.
+type AuthKeySource interface {
+	Get(ctx context.Context) (string, error)
+}
+
+// AuthKeySourceFunc is an adapter to use an ordinary function as an
+// AuthKeySource.
+type AuthKeySourceFunc func(ctx context.Context) (string, error)
+
+// Get calls f(ctx).
+func (f AuthKeySourceFunc) Get(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// AuthKeyFromEnv returns an AuthKeySource reading the auth key from the
+// environment variable name.
+func AuthKeyFromEnv(name string) AuthKeySource {
+	return AuthKeySourceFunc(func(context.Context) (string, error) {
+		key := strings.TrimSpace(os.Getenv(name))
+		if key == "" {
+			return "", fmt.Errorf("environment variable [%s] is empty", name)
+		}
+		return key, nil
+	})
+}
+
+// AuthKeyFromFile returns an AuthKeySource reading the auth key from the file
+// at path, such as a mounted Kubernetes secret.
+func AuthKeyFromFile(path string) AuthKeySource {
+	return AuthKeySourceFunc(func(context.Context) (string, error) {
+		data, err := os.ReadFile(path) // #nosec G304 -- path is chosen by the operator
+		if err != nil {
+			return "", fmt.Errorf("failed to read auth key file: %w", err)
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return "", fmt.Errorf("auth key file [%s] is empty", path)
+		}
+		return key, nil
+	})
+}
+
+// AuthKeyFromCommand returns an AuthKeySource running the command name with
+// args and using its standard output as the auth key, such as
+// AuthKeyFromCommand("vault", "kv", "get", "-field=authkey", "secret/ts").
+func AuthKeyFromCommand(name string, args ...string) AuthKeySource {
+	return AuthKeySourceFunc(func(ctx context.Context) (string, error) {
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, name, args...) // #nosec G204 -- command is chosen by the operator
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to run auth key command [%s]: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+		}
+		key := strings.TrimSpace(string(out))
+		if key == "" {
+			return "", fmt.Errorf("auth key command [%s] printed nothing", name)
+		}
+		return key, nil
+	})
+}
+
+// authKeySourceFromConfig returns the source of auth keys of config, or nil if
+// config specifies a literal auth key.
+func authKeySourceFromConfig(config *ServerConfig) AuthKeySource {
+	if config.AuthKeySource != nil {
+		return config.AuthKeySource
+	}
+	if path, isFile := strings.CutPrefix(config.TailscaleAuthKey, AuthKeyFilePrefix); isFile {
+		return AuthKeyFromFile(path)
+	}
+	return nil
+}
+
+// reauthenticate logs this node in again with a freshly obtained auth key
+// whenever it needs to log in, for example after its node key has expired.
+// It returns when ctx is cancelled.
+func reauthenticate(ctx context.Context, client *local.Client, source AuthKeySource, logger *logger) {
+	for ctx.Err() == nil {
+		if err := watchLoginState(ctx, client, source, logger); err != nil && ctx.Err() == nil {
+			logger.logf(slog.LevelError, "failed to watch login state: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+func watchLoginState(ctx context.Context, client *local.Client, source AuthKeySource, logger *logger) error {
+	watcher, err := client.WatchIPNBus(ctx, ipn.NotifyInitialState)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = watcher.Close() }()
+	for {
+		n, err := watcher.Next()
+		if err != nil {
+			return err
+		}
+		if n.State == nil || *n.State != ipn.NeedsLogin {
+			continue
+		}
+		authKey, err := source.Get(ctx)
+		if err != nil {
+			logger.logf(slog.LevelError, "failed to obtain auth key to log in again: %v", err)
+			continue
+		}
+		logger.log(slog.LevelInfo, "reauthenticating", "logging in again with a new auth key")
+		if err := client.Start(ctx, ipn.Options{AuthKey: authKey}); err != nil {
+			logger.logf(slog.LevelError, "failed to restart with new auth key: %v", err)
+			continue
+		}
+		if err := client.StartLoginInteractive(ctx); err != nil {
+			logger.logf(slog.LevelError, "failed to log in with new auth key: %v", err)
+		}
+	}
+}
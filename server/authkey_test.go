@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthKeySources(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "authkey")
+	if err := os.WriteFile(keyFile, []byte("tskey-from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := os.WriteFile(emptyFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PRIVATESERVER_TEST_AUTHKEY", " tskey-from-env ")
+	t.Setenv("PRIVATESERVER_TEST_EMPTY_AUTHKEY", "")
+
+	tests := []struct {
+		name    string
+		source  AuthKeySource
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "env",
+			source: AuthKeyFromEnv("PRIVATESERVER_TEST_AUTHKEY"),
+			want:   "tskey-from-env",
+		},
+		{
+			name:    "empty env",
+			source:  AuthKeyFromEnv("PRIVATESERVER_TEST_EMPTY_AUTHKEY"),
+			wantErr: true,
+		},
+		{
+			name:   "file",
+			source: AuthKeyFromFile(keyFile),
+			want:   "tskey-from-file",
+		},
+		{
+			name:    "empty file",
+			source:  AuthKeyFromFile(emptyFile),
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			source:  AuthKeyFromFile(filepath.Join(dir, "missing")),
+			wantErr: true,
+		},
+		{
+			name:   "command",
+			source: AuthKeyFromCommand("cat", keyFile),
+			want:   "tskey-from-file",
+		},
+		{
+			name:    "failing command",
+			source:  AuthKeyFromCommand("cat", filepath.Join(dir, "missing")),
+			wantErr: true,
+		},
+		{
+			name:    "command printing nothing",
+			source:  AuthKeyFromCommand("cat", emptyFile),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.source.Get(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthKeySourceFromConfig(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "authkey")
+	if err := os.WriteFile(keyFile, []byte("tskey-from-file"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if source := authKeySourceFromConfig(&ServerConfig{TailscaleAuthKey: "tskey-test"}); source != nil {
+		t.Errorf("literal auth key has a source")
+	}
+
+	source := authKeySourceFromConfig(&ServerConfig{TailscaleAuthKey: AuthKeyFilePrefix + keyFile})
+	if source == nil {
+		t.Fatal("auth key file has no source")
+	}
+	got, err := source.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "tskey-from-file" {
+		t.Errorf("got %q; want %q", got, "tskey-from-file")
+	}
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// MaxBodyBytes returns a middleware limiting the size of request bodies to n
+// bytes, for example per route with Router.Handle. Requests declaring a
+// larger Content-Length are answered with status 413 without calling the
+// handler. Bodies of unknown length are wrapped by http.MaxBytesReader, so
+// reading beyond the limit fails with an *http.MaxBytesError which handlers
+// should answer with status 413 as well.
+func MaxBodyBytes(n int64) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				http.Error(w, fmt.Sprintf("request body is larger than %d bytes", n), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachePolicy is a caching policy of responses, sent in the Cache-Control
+// and Expires headers. The zero value sends no caching headers, leaving the
+// decision to the handler.
+type CachePolicy struct {
+	// NoStore forbids caching the response at all. The other fields are
+	// ignored if it is set.
+	NoStore bool
+
+	// NoCache requires caches to revalidate the response before using it.
+	NoCache bool
+
+	// Private forbids shared caches, such as proxies, to store the response.
+	// Responses are public otherwise.
+	Private bool
+
+	// MaxAge is how long the response is fresh.
+	MaxAge time.Duration
+
+	// Immutable tells browsers that the response never changes while fresh,
+	// so that they do not revalidate it even on reload.
+	Immutable bool
+}
+
+var (
+	// CacheNoStore is the policy of responses which must not be cached, such
+	// as API responses.
+	CacheNoStore = CachePolicy{NoStore: true}
+
+	// CacheRevalidate is the policy of responses which may be cached but
+	// have to be revalidated on every use, such as HTML pages referring to
+	// hashed assets.
+	CacheRevalidate = CachePolicy{NoCache: true}
+
+	// CacheImmutable is the policy of responses which never change, such as
+	// assets whose file name contains a hash of their content.
+	CacheImmutable = CachePolicy{MaxAge: 365 * 24 * time.Hour, Immutable: true}
+)
+
+// String returns the value of the Cache-Control header of the policy.
+func (p CachePolicy) String() string {
+	if p.NoStore {
+		return "no-store"
+	}
+	if p == (CachePolicy{}) {
+		return ""
+	}
+	var directives []string
+	if p.Private {
+		directives = append(directives, "private")
+	} else {
+		directives = append(directives, "public")
+	}
+	if p.NoCache {
+		directives = append(directives, "no-cache")
+	}
+	directives = append(directives, "max-age="+strconv.FormatInt(int64(p.MaxAge/time.Second), 10))
+	if p.Immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
+
+// apply sets the caching headers of the policy unless the handler has set
+// Cache-Control already. Only no-store is applied to error responses so that
+// a transient error is not cached as long as the content would be.
+func (p CachePolicy) apply(header http.Header, status int) {
+	value := p.String()
+	if value == "" || header.Get("Cache-Control") != "" {
+		return
+	}
+	if status >= http.StatusBadRequest && !p.NoStore {
+		return
+	}
+	header.Set("Cache-Control", value)
+	switch {
+	case p.NoStore || p.NoCache:
+		header.Set("Expires", "0")
+	default:
+		header.Set("Expires", time.Now().Add(p.MaxAge).UTC().Format(http.TimeFormat))
+	}
+}
+
+// CacheControl returns a middleware applying the caching policy to
+// responses, for example per route with Router.Handle. Policies are not
+// applied to responses whose handler set Cache-Control itself, and policies
+// other than no-store are not applied to error responses.
+func CacheControl(policy CachePolicy) Middleware {
+	return cacheMiddleware(func(*http.Request) CachePolicy { return policy })
+}
+
+// CacheRule selects the caching policy of files by their name.
+type CacheRule struct {
+	// Pattern is matched against the base name of the request path with
+	// path.Match, such as "*.css". An empty pattern matches every file.
+	Pattern string
+
+	// Hashed restricts the rule to file names containing a content hash as
+	// produced by bundlers, either at least 8 hexadecimal digits after a dot,
+	// such as "app.3f9a2c1b.js", or 8 base64url characters after a dot or a
+	// hyphen, such as "index-B7kQx2pL.css".
+	Hashed bool
+
+	// Policy is the caching policy of matching files.
+	Policy CachePolicy
+}
+
+// DefaultCacheRules cache hashed assets forever and make browsers revalidate
+// everything else.
+var DefaultCacheRules = []CacheRule{
+	{Hashed: true, Policy: CacheImmutable},
+	{Policy: CacheRevalidate},
+}
+
+// CacheByFile returns a middleware applying the policy of the first rule
+// matching the requested file, typically around StaticHandler. Responses
+// matching no rule are sent without caching headers. Rules default to
+// DefaultCacheRules. It panics if the pattern of a rule is malformed.
+func CacheByFile(rules []CacheRule) Middleware {
+	if len(rules) == 0 {
+		rules = DefaultCacheRules
+	}
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			panic(fmt.Sprintf("server: invalid cache rule pattern [%s]: %v", rule.Pattern, err))
+		}
+	}
+	return cacheMiddleware(func(r *http.Request) CachePolicy {
+		name := path.Base(r.URL.Path)
+		for _, rule := range rules {
+			if rule.Pattern != "" {
+				if matched, _ := path.Match(rule.Pattern, name); !matched {
+					continue
+				}
+			}
+			if rule.Hashed && !isHashedFileName(name) {
+				continue
+			}
+			return rule.Policy
+		}
+		return CachePolicy{}
+	})
+}
+
+// Content hashes are required to contain a digit so that ordinary words are
+// not mistaken for hashes.
+var (
+	hexHashPattern    = regexp.MustCompile(`\.[0-9a-f]*[0-9][0-9a-f]*$`)
+	base64HashPattern = regexp.MustCompile(`.[.-][0-9A-Za-z_-]{8}$`)
+)
+
+// isHashedFileName reports whether the file name contains a content hash
+// right before its extension.
+func isHashedFileName(name string) bool {
+	stem := strings.TrimSuffix(name, path.Ext(name))
+	if hash := hexHashPattern.FindString(stem); len(hash) > 8 {
+		return true
+	}
+	hash := base64HashPattern.FindString(stem)
+	return hash != "" && strings.ContainsAny(hash[2:], "0123456789")
+}
+
+// cacheMiddleware returns a middleware applying the policy selected for each
+// request when the handler writes the header.
+func cacheMiddleware(policy func(r *http.Request) CachePolicy) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := policy(r)
+			if p == (CachePolicy{}) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			cw := &cacheControlWriter{ResponseWriter: w, policy: p}
+			h.ServeHTTP(cw, r)
+			if !cw.wroteHeader {
+				cw.WriteHeader(http.StatusOK)
+			}
+		})
+	}
+}
+
+// cacheControlWriter applies a caching policy once the status code of the
+// response is known.
+type cacheControlWriter struct {
+	http.ResponseWriter
+	policy      CachePolicy
+	wroteHeader bool
+}
+
+func (w *cacheControlWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status >= 200 {
+		w.wroteHeader = true
+		w.policy.apply(w.Header(), status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheControlWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter so that
+// http.ResponseController can reach features such as flushing.
+func (w *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
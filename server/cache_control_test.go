@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCachePolicyString(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy CachePolicy
+		want   string
+	}{
+		{name: "zero", policy: CachePolicy{}, want: ""},
+		{name: "no store", policy: CacheNoStore, want: "no-store"},
+		{name: "revalidate", policy: CacheRevalidate, want: "public, no-cache, max-age=0"},
+		{name: "immutable", policy: CacheImmutable, want: "public, max-age=31536000, immutable"},
+		{name: "private", policy: CachePolicy{Private: true, MaxAge: time.Minute}, want: "private, max-age=60"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.String(); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      CachePolicy
+		status      int
+		handlerSets string
+		want        string
+		wantExpires bool
+	}{
+		{name: "no store", policy: CacheNoStore, status: http.StatusOK, want: "no-store", wantExpires: true},
+		{name: "no store on error", policy: CacheNoStore, status: http.StatusInternalServerError, want: "no-store", wantExpires: true},
+		{name: "max age", policy: CacheImmutable, status: http.StatusOK, want: CacheImmutable.String(), wantExpires: true},
+		{name: "max age not on error", policy: CacheImmutable, status: http.StatusNotFound, want: ""},
+		{name: "set by handler", policy: CacheImmutable, status: http.StatusOK, handlerSets: "private", want: "private"},
+		{name: "zero policy", policy: CachePolicy{}, status: http.StatusOK, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := CacheControl(tt.policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.handlerSets != "" {
+					w.Header().Set("Cache-Control", tt.handlerSets)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if got := w.Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("got Cache-Control %q; want %q", got, tt.want)
+			}
+			if got := w.Header().Get("Expires") != ""; got != tt.wantExpires {
+				t.Errorf("got Expires %t; want %t", got, tt.wantExpires)
+			}
+		})
+	}
+}
+
+func TestCacheByFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []CacheRule
+		path  string
+		want  string
+	}{
+		{name: "hex hash", path: "/assets/app.3f9a2c1b.js", want: CacheImmutable.String()},
+		{name: "base64 hash", path: "/assets/index-B7kQx2pL.css", want: CacheImmutable.String()},
+		{name: "no hash", path: "/index.html", want: CacheRevalidate.String()},
+		{name: "hyphenated name", path: "/assets/my-long-file-name.js", want: CacheRevalidate.String()},
+		{name: "version", path: "/jquery-3.7.1.min.js", want: CacheRevalidate.String()},
+		{
+			name:  "pattern",
+			rules: []CacheRule{{Pattern: "*.json", Policy: CacheNoStore}},
+			path:  "/data/items.json",
+			want:  "no-store",
+		},
+		{
+			name:  "no matching rule",
+			rules: []CacheRule{{Pattern: "*.json", Policy: CacheNoStore}},
+			path:  "/index.html",
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := CacheByFile(tt.rules)(serveHandler())
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if got := w.Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("got Cache-Control %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
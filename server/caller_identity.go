@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// CallerIdentity is the tailnet identity of the caller of a request.
+type CallerIdentity struct {
+	// LoginName is the login name of the user owning the node, such as
+	// "alice@example.com". It is "tagged-devices" for tagged nodes.
+	LoginName string
+
+	// DisplayName is the display name of the user owning the node.
+	DisplayName string
+
+	// NodeName is the fully qualified domain name of the node, such as
+	// "laptop.prawn-universe.ts.net".
+	NodeName string
+
+	// Tags are the ACL tags of the node.
+	Tags []string
+
+	// TailscaleIPs are the Tailscale IP addresses of the node.
+	TailscaleIPs []netip.Addr
+
+	// IsTagged reports whether the node is tagged rather than owned by a
+	// user.
+	IsTagged bool
+
+	// IsFunnel reports whether the request arrived over Tailscale Funnel from
+	// the public internet, in which case no other field is set.
+	IsFunnel bool
+}
+
+// MachineName returns the first label of the node name, such as "laptop" for
+// "laptop.prawn-universe.ts.net".
+func (c *CallerIdentity) MachineName() string {
+	name, _, _ := strings.Cut(c.NodeName, ".")
+	return name
+}
+
+// GetCallerIdentity retrieves the identity of the caller of the request. For
+// requests which arrived over Tailscale Funnel, an identity with only
+// IsFunnel set is returned. The identity stored by WithIdentity is used if
+// present.
+func (s *Server) GetCallerIdentity(r *http.Request) (*CallerIdentity, error) {
+	if IsFunnelRequest(r) {
+		return &CallerIdentity{IsFunnel: true}, nil
+	}
+	if identity, found := CallerIdentityFromContext(r.Context()); found {
+		return identity, nil
+	}
+	who, err := s.identify(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity from tailscale API: %w", err)
+	}
+	return NewCallerIdentity(who), nil
+}
+
+// CallerIdentityFromContext returns the identity of the caller stored in ctx
+// by WithIdentity or by the gRPC identity interceptors.
+func CallerIdentityFromContext(ctx context.Context) (*CallerIdentity, bool) {
+	who, found := IdentityFromContext(ctx)
+	if !found {
+		return nil, false
+	}
+	return NewCallerIdentity(who), true
+}
+
+// NewCallerIdentity converts a WhoIs response to a CallerIdentity, for
+// example one returned by GetCallerIdentityFromRemoteIPAddress.
+func NewCallerIdentity(who *apitype.WhoIsResponse) *CallerIdentity {
+	identity := new(CallerIdentity)
+	if who.UserProfile != nil {
+		identity.LoginName = who.UserProfile.LoginName
+		identity.DisplayName = who.UserProfile.DisplayName
+	}
+	if who.Node != nil {
+		identity.NodeName = strings.TrimSuffix(who.Node.Name, ".")
+		identity.Tags = who.Node.Tags
+		identity.IsTagged = who.Node.IsTagged()
+		for _, address := range who.Node.Addresses {
+			identity.TailscaleIPs = append(identity.TailscaleIPs, address.Addr())
+		}
+	}
+	return identity
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestCallerIdentityFromContext(t *testing.T) {
+	if _, found := CallerIdentityFromContext(context.Background()); found {
+		t.Error("identity is found in empty context")
+	}
+
+	who := newTestWhoIs("tagged-devices")
+	who.UserProfile.DisplayName = "Tagged Devices"
+	who.Node.Tags = []string{"tag:ci"}
+	who.Node.Addresses = []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32"), netip.MustParsePrefix("fd7a:115c:a1e0::1/128")}
+
+	identity, found := CallerIdentityFromContext(ContextWithIdentity(context.Background(), who))
+	if !found {
+		t.Fatal("identity is not found")
+	}
+	want := &CallerIdentity{
+		LoginName:    "tagged-devices",
+		DisplayName:  "Tagged Devices",
+		NodeName:     "test-node.prawn-universe.ts.net",
+		Tags:         []string{"tag:ci"},
+		TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("fd7a:115c:a1e0::1")},
+		IsTagged:     true,
+	}
+	if identity.LoginName != want.LoginName ||
+		identity.DisplayName != want.DisplayName ||
+		identity.NodeName != want.NodeName ||
+		!slices.Equal(identity.Tags, want.Tags) ||
+		!slices.Equal(identity.TailscaleIPs, want.TailscaleIPs) ||
+		identity.IsTagged != want.IsTagged ||
+		identity.IsFunnel {
+		t.Errorf("got %+v; want %+v", identity, want)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// capabilityContextKey is the context key of the grants of a capability
+// stored by RequireCapability.
+type capabilityContextKey[T any] struct {
+	capability tailcfg.PeerCapability
+}
+
+// CapabilityGrants returns the grants of the specified application capability
+// given to the caller in the tailnet policy file, such as
+// "example.com/cap/privateserver", each unmarshalled from JSON into T. It
+// returns nil if the capability has not been granted.
+func CapabilityGrants[T any](who *apitype.WhoIsResponse, capability tailcfg.PeerCapability) ([]T, error) {
+	if who == nil {
+		return nil, nil
+	}
+	grants, err := tailcfg.UnmarshalCapJSON[T](who.CapMap, capability)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse grants of capability [%s]: %w", capability, err)
+	}
+	return grants, nil
+}
+
+// RequireCapability wraps the provided handler and only admits callers which
+// have been granted the specified application capability. The grants are
+// unmarshalled into T and stored in the request context so that handlers can
+// retrieve them with CapabilityFromContext. The handler has to be wrapped by
+// Server.WithIdentity as well.
+func RequireCapability[T any](capability tailcfg.PeerCapability, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who, found := IdentityFromContext(r.Context())
+		if !found || !who.CapMap.HasCapability(capability) {
+			authDenied(r, "missing capability "+string(capability))
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		grants, err := CapabilityGrants[T](who, capability)
+		if err != nil {
+			logf(slog.LevelError, "failed to read capability grants of [%s]: %v", r.RemoteAddr, err)
+			http.Error(w, "invalid capability grant", http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(r.Context(), capabilityContextKey[T]{capability}, grants)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// CapabilityFromContext returns the grants of the specified capability stored
+// in ctx by RequireCapability.
+func CapabilityFromContext[T any](ctx context.Context, capability tailcfg.PeerCapability) ([]T, bool) {
+	grants, ok := ctx.Value(capabilityContextKey[T]{capability}).([]T)
+	return grants, ok
+}
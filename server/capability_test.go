@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+type testGrant struct {
+	Actions []string `json:"actions"`
+}
+
+const testCapability tailcfg.PeerCapability = "example.com/cap/privateserver"
+
+func TestRequireCapability(t *testing.T) {
+	tests := []struct {
+		name        string
+		capMap      tailcfg.PeerCapMap
+		wantCode    int
+		wantActions []string
+	}{
+		{
+			name:     "no capability",
+			capMap:   nil,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "granted capability",
+			capMap: tailcfg.PeerCapMap{
+				testCapability: {`{"actions":["read"]}`, `{"actions":["write"]}`},
+			},
+			wantCode:    http.StatusOK,
+			wantActions: []string{"read", "write"},
+		},
+		{
+			name: "malformed grant",
+			capMap: tailcfg.PeerCapMap{
+				testCapability: {`{"actions":"read"}`},
+			},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotActions []string
+			h := RequireCapability[testGrant](testCapability, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				grants, found := CapabilityFromContext[testGrant](r.Context(), testCapability)
+				if !found {
+					t.Fatal("grants are not found in context")
+				}
+				for _, grant := range grants {
+					gotActions = append(gotActions, grant.Actions...)
+				}
+			}))
+
+			who := newTestWhoIs("alice@example.com")
+			who.CapMap = tt.capMap
+			r := httptest.NewRequest("GET", "/", nil)
+			r = r.WithContext(ContextWithIdentity(r.Context(), who))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+			if !slices.Equal(gotActions, tt.wantActions) {
+				t.Errorf("got actions %v; want %v", gotActions, tt.wantActions)
+			}
+		})
+	}
+}
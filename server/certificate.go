@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+)
+
+// DefaultCertificateExpiryWarningThreshold is the remaining validity of a
+// certificate below which a warning is logged. Tailscale renews certificates
+// well before this so crossing it usually means renewal is failing.
+const DefaultCertificateExpiryWarningThreshold = 14 * 24 * time.Hour
+
+// certificateCheckInterval is the interval at which a server refreshes its
+// certificates, so that their expiry is warned about without any traffic.
+const certificateCheckInterval = 12 * time.Hour
+
+// certificateNotAfter exports the expiry time, in Unix seconds, of each
+// certificate served by this process keyed by domain.
+var certificateNotAfter = expvar.NewMap("privateserver_certificate_not_after_seconds")
+
+// certificateTracker records the expiry of certificates served by a server
+// and warns when they are about to expire.
+type certificateTracker struct {
+	threshold time.Duration
+	logger    *logger
+
+	mu       sync.Mutex
+	notAfter map[string]time.Time
+	warned   map[string]time.Time
+}
+
+func newCertificateTracker(threshold time.Duration, logger *logger) *certificateTracker {
+	if threshold == 0 {
+		threshold = DefaultCertificateExpiryWarningThreshold
+	}
+	return &certificateTracker{
+		threshold: threshold,
+		logger:    logger,
+		notAfter:  make(map[string]time.Time),
+		warned:    make(map[string]time.Time),
+	}
+}
+
+// observe returns a certificate selection function which records the expiry
+// of the certificates returned by getCertificate.
+func (t *certificateTracker) observe(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := getCertificate(hello)
+		if err != nil || cert == nil {
+			return cert, err
+		}
+		leaf, err := leafCertificate(cert)
+		if err != nil {
+			t.logger.log(slog.LevelWarn, "certificate_invalid", fmt.Sprintf("failed to inspect certificate for [%s]: %v", hello.ServerName, err), "domain", hello.ServerName)
+			return cert, nil
+		}
+		t.record(certificateDomain(leaf, hello.ServerName), leaf.NotAfter)
+		return cert, nil
+	}
+}
+
+// record stores the expiry of the certificate of domain and logs a warning
+// once per certificate if it expires within the threshold.
+func (t *certificateTracker) record(domain string, notAfter time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.notAfter[domain] = notAfter
+	certificateNotAfter.Set(domain, expvarInt(notAfter.Unix()))
+
+	remaining := time.Until(notAfter)
+	if remaining >= t.threshold || t.warned[domain].Equal(notAfter) {
+		return
+	}
+	t.warned[domain] = notAfter
+	if remaining <= 0 {
+		t.logger.log(slog.LevelWarn, "certificate_expired", fmt.Sprintf("WARNING: certificate of [%s] expired at %s", domain, notAfter.Format(time.RFC3339)), "domain", domain, "not_after", notAfter)
+		return
+	}
+	t.logger.log(slog.LevelWarn, "certificate_expiring", fmt.Sprintf("WARNING: certificate of [%s] expires in %s at %s", domain, remaining.Round(time.Minute), notAfter.Format(time.RFC3339)), "domain", domain, "not_after", notAfter)
+}
+
+// snapshot returns a copy of the recorded expiry times.
+func (t *certificateTracker) snapshot() map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return maps.Clone(t.notAfter)
+}
+
+// CertificateNotAfter returns the expiry time of each certificate served or
+// fetched by this server keyed by domain. Certificates are only known after
+// the first TLS handshake, a call to WarmCertificates or the periodic check
+// of the server, which runs every 12 hours.
+func (s *Server) CertificateNotAfter() map[string]time.Time {
+	return s.certificates.snapshot()
+}
+
+// checkCertificates refreshes the certificates of the server every interval
+// with WarmCertificates, which records their expiry and warns about those
+// expiring soon even if no TLS handshake takes place. It returns when ctx is
+// cancelled.
+func (s *Server) checkCertificates(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		err := s.WarmCertificates(checkCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			s.logger.log(slog.LevelError, "certificate_check_failed", fmt.Sprintf("failed to check certificates: %v", err))
+		}
+	}
+}
+
+// leafCertificate returns the parsed leaf certificate of cert.
+func leafCertificate(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("certificate chain is empty")
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+// certificateDomain returns the domain a certificate is recorded under.
+func certificateDomain(leaf *x509.Certificate, serverName string) string {
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames[0]
+	}
+	if leaf.Subject.CommonName != "" {
+		return leaf.Subject.CommonName
+	}
+	return serverName
+}
+
+func expvarInt(v int64) *expvar.Int {
+	i := new(expvar.Int)
+	i.Set(v)
+	return i
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestCertificateTrackerObserve(t *testing.T) {
+	cert := newTestCertificate(t)
+	tracker := newCertificateTracker(0, newLogger(t.Logf, slog.LevelInfo))
+	getCertificate := tracker.observe(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return &cert, nil
+	})
+
+	if _, err := getCertificate(&tls.ClientHelloInfo{ServerName: "localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	notAfter, found := tracker.snapshot()["localhost"]
+	if !found {
+		t.Fatal("expiry of localhost is not recorded")
+	}
+	if time.Until(notAfter) > time.Hour {
+		t.Errorf("got expiry %s; want within an hour", notAfter)
+	}
+	if !tracker.warned["localhost"].Equal(notAfter) {
+		t.Error("expiring certificate is not warned")
+	}
+}
+
+func TestCertificateTrackerRecord(t *testing.T) {
+	tests := []struct {
+		name       string
+		notAfter   time.Time
+		wantWarned bool
+	}{
+		{
+			name:       "valid certificate",
+			notAfter:   time.Now().Add(60 * 24 * time.Hour),
+			wantWarned: false,
+		},
+		{
+			name:       "expiring certificate",
+			notAfter:   time.Now().Add(24 * time.Hour),
+			wantWarned: true,
+		},
+		{
+			name:       "expired certificate",
+			notAfter:   time.Now().Add(-time.Hour),
+			wantWarned: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newCertificateTracker(DefaultCertificateExpiryWarningThreshold, newLogger(t.Logf, slog.LevelInfo))
+			tracker.record("test-hostname.prawn-universe.ts.net", tt.notAfter)
+			_, warned := tracker.warned["test-hostname.prawn-universe.ts.net"]
+			if warned != tt.wantWarned {
+				t.Errorf("got warned %t; want %t", warned, tt.wantWarned)
+			}
+		})
+	}
+}
+
+func TestCheckCertificates(t *testing.T) {
+	cert := newTestCertificate(t)
+	logger := newLogger(t.Logf, slog.LevelInfo)
+	s := &Server{
+		fqdn:                  "localhost",
+		selfSignedCertificate: &cert,
+		certificates:          newCertificateTracker(0, logger),
+		logger:                logger,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.checkCertificates(ctx, time.Millisecond)
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); len(s.certificates.snapshot()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("expiry is not recorded by the periodic check")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if _, warned := s.certificates.warned["localhost"]; !warned {
+		t.Error("expiring certificate is not warned by the periodic check")
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+)
+
+// Chain is an immutable list of middlewares applied in order, the first
+// being the outermost, so that
+//
+//	NewChain(RequestID, srv.WithIdentity, Recover).Then(h)
+//
+// is RequestID(srv.WithIdentity(Recover(h))). Middlewares taking and
+// returning a handler, such as RequestID, can be listed as they are, while
+// those with configuration are listed by the Middleware returned by their
+// constructor, such as Compress(CompressionOptions{}).
+//
+// The middlewares of this package work best in the following order, from
+// outermost to innermost, which is the order used by Config.Handler:
+//
+//  1. RequestID, so that every log line of a request carries its ID, then
+//     Metrics and Tracing, recording every request including rejected ones.
+//  2. Server.WithMaintenance, answering before any other work is done.
+//  3. AllowIPs, a cheap filter by address before the identity lookup.
+//  4. Server.WithIdentity or Server.FunnelAuth, looking up the caller once
+//     for all middlewares below.
+//  5. Recover, so that a panic is logged with the identity of the caller.
+//  6. Server.HSTS, SecureHeaders and CORS, setting headers of every
+//     response including errors.
+//  7. Compress, compressing everything written below.
+//  8. AccessLog and Audit, recording the caller and the outcome, including
+//     requests denied below.
+//  9. Timeout, MaxBodyBytes and CacheControl, bounding the work of the
+//     handler.
+//  10. Authorize and the Require functions, enforcing policies.
+//  11. RateLimitPerUser, RateLimitPerNode and RateLimitGlobal, counting only
+//     authorized requests.
+type Chain struct {
+	middlewares []Middleware
+}
+
+// NewChain creates a Chain of the specified middlewares.
+func NewChain(middlewares ...Middleware) Chain {
+	return Chain{middlewares: slices.Clone(middlewares)}
+}
+
+// Append returns a new Chain with the specified middlewares added after, and
+// thus inside, those of c. c is not modified.
+func (c Chain) Append(middlewares ...Middleware) Chain {
+	return Chain{middlewares: slices.Concat(c.middlewares, middlewares)}
+}
+
+// Then wraps h with the middlewares of the chain.
+func (c Chain) Then(h http.Handler) http.Handler {
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		h = c.middlewares[i](h)
+	}
+	return h
+}
+
+// ThenFunc wraps the handler function h with the middlewares of the chain.
+func (c Chain) ThenFunc(h func(http.ResponseWriter, *http.Request)) http.Handler {
+	return c.Then(http.HandlerFunc(h))
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	base := NewChain(record("first"), record("second"))
+	extended := base.Append(record("third"))
+	other := base.Append(record("other"))
+
+	tests := []struct {
+		name  string
+		chain Chain
+		want  []string
+	}{
+		{name: "empty", chain: NewChain(), want: []string{"handler"}},
+		{name: "base", chain: base, want: []string{"first", "second", "handler"}},
+		{name: "extended", chain: extended, want: []string{"first", "second", "third", "handler"}},
+		{name: "appended to the same base", chain: other, want: []string{"first", "second", "other", "handler"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			h := tt.chain.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, "handler")
+			})
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			if !slices.Equal(calls, tt.want) {
+				t.Errorf("got calls %v; want %v", calls, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// HTTPClient returns an HTTP client connecting over the tailnet so that the
+// application can call services on other nodes, such as
+// "https://api.prawn-universe.ts.net", with the identity of this node. The
+// proxies configured for the host network are never used. The same client
+// is returned on every call so that connections are reused. See Dial for how
+// connections are routed.
+func (s *Server) HTTPClient() *http.Client {
+	s.httpClientOnce.Do(func() {
+		s.httpClient = &http.Client{Transport: newTailnetTransport(s.Dial)}
+	})
+	return s.httpClient
+}
+
+// newTailnetTransport returns a transport with the defaults of
+// http.DefaultTransport dialing connections with dial.
+func newTailnetTransport(dial func(ctx context.Context, network, address string) (net.Conn, error)) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dial
+	return transport
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewTailnetTransport(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer backend.Close()
+
+	var dialed []string
+	transport := newTailnetTransport(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		var d net.Dialer
+		return d.DialContext(ctx, network, strings.TrimPrefix(backend.URL, "http://"))
+	})
+	if transport.Proxy != nil {
+		t.Error("transport uses a proxy")
+	}
+	client := &http.Client{Transport: transport}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get("http://api.prawn-universe.ts.net:8080/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "api.prawn-universe.ts.net:8080" {
+		t.Errorf("got host %q", body)
+	}
+	if len(dialed) != 1 || dialed[0] != "api.prawn-universe.ts.net:8080" {
+		t.Errorf("got dialed addresses %q", dialed)
+	}
+}
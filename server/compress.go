@@ -0,0 +1,208 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressionMinSize is the minimum size of a response to be
+// compressed if CompressionOptions.MinSize is not set.
+const DefaultCompressionMinSize = 1024
+
+// DefaultCompressibleTypes are the media types compressed if
+// CompressionOptions.ContentTypes is not set.
+var DefaultCompressibleTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// CompressionOptions configures the middleware returned by Compress.
+type CompressionOptions struct {
+	// MinSize is the minimum size of a response in bytes to be compressed
+	// as compressing small responses costs more than it saves. It defaults
+	// to DefaultCompressionMinSize.
+	MinSize int
+
+	// ContentTypes are the media types of responses to compress, such as
+	// "application/json" or "text/*". It defaults to
+	// DefaultCompressibleTypes. Event streams are never compressed so that
+	// every event reaches clients immediately.
+	ContentTypes []string
+
+	// Level is the gzip compression level. It defaults to
+	// gzip.DefaultCompression.
+	Level int
+}
+
+// Compress returns a middleware compressing responses with gzip for clients
+// accepting it. Responses are only compressed if they are large enough and
+// of a compressible type; responses which are already encoded and partial
+// content are sent as they are. Only gzip is supported as other encodings,
+// such as brotli and zstd, are not in the standard library. It panics if the
+// compression level is invalid.
+func Compress(opts CompressionOptions) Middleware {
+	if opts.MinSize == 0 {
+		opts.MinSize = DefaultCompressionMinSize
+	}
+	if len(opts.ContentTypes) == 0 {
+		opts.ContentTypes = DefaultCompressibleTypes
+	}
+	if opts.Level == 0 {
+		opts.Level = gzip.DefaultCompression
+	}
+	if _, err := gzip.NewWriterLevel(nil, opts.Level); err != nil {
+		panic(fmt.Sprintf("server: invalid compression level [%d]: %v", opts.Level, err))
+	}
+	pool := &sync.Pool{New: func() any {
+		gz, _ := gzip.NewWriterLevel(nil, opts.Level)
+		return gz
+	}}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if r.Method == http.MethodHead || r.Header.Get("Range") != "" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, opts: &opts, pool: pool}
+			defer cw.close()
+			h.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(item, ";")
+		coding = strings.TrimSpace(coding)
+		if !strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+		name, value, _ := strings.Cut(strings.TrimSpace(params), "=")
+		if strings.TrimSpace(name) == "q" {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressWriter buffers the beginning of a response until it knows whether
+// the response is worth compressing.
+type compressWriter struct {
+	http.ResponseWriter
+	opts *CompressionOptions
+	pool *sync.Pool
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 || (status >= 100 && status < 200) {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.opts.MinSize {
+			return len(b), nil
+		}
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide writes the header and the buffered content, compressed if the
+// response qualifies. Responses which are flushed are compressed regardless
+// of their size.
+func (w *compressWriter) decide(flushed bool) error {
+	w.decided = true
+	header := w.Header()
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	compress := (flushed || len(w.buf) >= w.opts.MinSize) &&
+		status != http.StatusNoContent && status != http.StatusNotModified && status != http.StatusPartialContent &&
+		header.Get("Content-Encoding") == "" &&
+		!strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") &&
+		allowedMediaType(w.opts.ContentTypes, header.Get("Content-Type"))
+	if compress {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		w.gz = w.pool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	_, err := w.Write(buf)
+	return err
+}
+
+// FlushError flushes the buffered and compressed content to the client so
+// that streamed responses are not held back.
+func (w *compressWriter) FlushError() error {
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			return err
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying http.ResponseWriter so that
+// http.ResponseController can reach features such as deadlines.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close writes what is still buffered and finishes the compressed stream.
+func (w *compressWriter) close() {
+	if !w.decided {
+		_ = w.decide(false)
+	}
+	if w.gz != nil {
+		_ = w.gz.Close()
+		w.gz.Reset(nil)
+		w.pool.Put(w.gz)
+		w.gz = nil
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"name":"value"},`, 100)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		wantGzip       bool
+	}{
+		{name: "large JSON", acceptEncoding: "gzip, deflate", contentType: "application/json", body: large, wantGzip: true},
+		{name: "sniffed text", acceptEncoding: "gzip", body: large, wantGzip: true},
+		{name: "small JSON", acceptEncoding: "gzip", contentType: "application/json", body: `{"name":"value"}`},
+		{name: "image", acceptEncoding: "gzip", contentType: "image/png", body: large},
+		{name: "gzip not accepted", acceptEncoding: "br", contentType: "application/json", body: large},
+		{name: "gzip refused", acceptEncoding: "gzip;q=0, *", contentType: "application/json", body: large},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.Header().Set("Content-Length", "1")
+				w.WriteHeader(http.StatusCreated)
+				for _, chunk := range strings.SplitAfter(tt.body, ",") {
+					io.WriteString(w, chunk)
+				}
+			})
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			w := httptest.NewRecorder()
+			Compress(CompressionOptions{})(h).ServeHTTP(w, r)
+
+			if w.Code != http.StatusCreated {
+				t.Errorf("got status %d; want %d", w.Code, http.StatusCreated)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("got Vary %q", got)
+			}
+			gzipped := w.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("got gzip %t; want %t", gzipped, tt.wantGzip)
+			}
+			body := w.Body.String()
+			if gzipped {
+				if w.Header().Get("Content-Length") != "" {
+					t.Error("Content-Length is kept")
+				}
+				reader, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = string(data)
+			}
+			if body != tt.body {
+				t.Errorf("got body %q", body)
+			}
+		})
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "first")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("failed to flush: %v", err)
+		}
+		io.WriteString(w, " second")
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	Compress(CompressionOptions{})(h).ServeHTTP(w, r)
+	if !w.Flushed || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got flushed %t and encoding %q", w.Flushed, w.Header().Get("Content-Encoding"))
+	}
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	if string(data) != "first second" {
+		t.Errorf("got body %q", data)
+	}
+}
@@ -0,0 +1,404 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a configuration loaded by LoadConfig. Besides the configuration
+// of the server, it carries the ports to listen on and the middleware and
+// authorization policy applied by Handler.
+type Config struct {
+	Server     *ServerConfig
+	HTTPSPorts []int
+	Middleware MiddlewareConfig
+
+	// Policy is the authorization policy of all requests. It is nil if the
+	// configuration file has no policy.
+	Policy *Policy
+}
+
+// MiddlewareConfig selects the middleware applied by Config.Handler.
+type MiddlewareConfig struct {
+	HSTS          bool
+	SecureHeaders bool
+	Compression   bool
+	AuditLog      bool
+	AccessLog     bool
+	RequestID     bool
+	Recover       bool
+	RateLimit     *RateLimitConfig
+
+	// AllowedIPs, if set, admits only requests from these IP addresses or
+	// CIDR ranges with AllowIPs.
+	AllowedIPs []string
+
+	// AccessLogFormat is the format of the records of AccessLog, either
+	// AccessLogFormatSlog, the default, or AccessLogFormatCombined.
+	AccessLogFormat string
+}
+
+// Formats of MiddlewareConfig.AccessLogFormat.
+const (
+	// AccessLogFormatSlog writes records to the default slog.Logger with
+	// NewSlogAccessLogSink.
+	AccessLogFormatSlog = "slog"
+	// AccessLogFormatCombined writes records to standard output in the
+	// combined log format with NewCombinedAccessLogSink.
+	AccessLogFormatCombined = "combined"
+)
+
+// Handler wraps the provided handler with the middleware and the policy of
+// the configuration, in the order documented by Chain.
+func (c *Config) Handler(srv *Server, h http.Handler) http.Handler {
+	var chain Chain
+	if c.Middleware.RequestID {
+		chain = chain.Append(RequestID)
+	}
+	chain = chain.Append(func(h http.Handler) http.Handler { return withHooks(&srv.hooks, h) })
+	chain = chain.Append(srv.WithMaintenance)
+	if len(c.Middleware.AllowedIPs) > 0 {
+		chain = chain.Append(AllowIPs(c.Middleware.AllowedIPs...))
+	}
+	chain = chain.Append(srv.WithIdentity)
+	if c.Middleware.Recover {
+		chain = chain.Append(Recover)
+	}
+	if c.Middleware.HSTS {
+		chain = chain.Append(srv.HSTS(HSTSOptions{}))
+	}
+	if c.Middleware.SecureHeaders {
+		chain = chain.Append(SecureHeaders(SecureHeadersOptions{}))
+	}
+	if c.Middleware.Compression {
+		chain = chain.Append(Compress(CompressionOptions{}))
+	}
+	if c.Middleware.AccessLog {
+		sink := NewSlogAccessLogSink(nil)
+		if c.Middleware.AccessLogFormat == AccessLogFormatCombined {
+			sink = NewCombinedAccessLogSink(nil)
+		}
+		chain = chain.Append(AccessLog(sink))
+	}
+	if c.Middleware.AuditLog {
+		chain = chain.Append(func(h http.Handler) http.Handler { return Audit(NewLogAuditSink(nil), h) })
+	}
+	if c.Policy != nil {
+		chain = chain.Append(func(h http.Handler) http.Handler { return Authorize(c.Policy, h) })
+	}
+	if rateLimit := c.Middleware.RateLimit; rateLimit != nil {
+		chain = chain.Append(func(h http.Handler) http.Handler { return RateLimitPerUser(*rateLimit, h) })
+	}
+	return chain.Then(h)
+}
+
+// configFile is the schema of configuration files.
+type configFile struct {
+	Server     serverSection     `json:"server" yaml:"server" toml:"server"`
+	Listeners  listenersSection  `json:"listeners" yaml:"listeners" toml:"listeners"`
+	Middleware middlewareSection `json:"middleware" yaml:"middleware" toml:"middleware"`
+	Policy     *policySection    `json:"policy" yaml:"policy" toml:"policy"`
+}
+
+type serverSection struct {
+	AuthKey                           string         `json:"auth_key" yaml:"auth_key" toml:"auth_key"`
+	Hostname                          string         `json:"hostname" yaml:"hostname" toml:"hostname"`
+	StateDirectory                    string         `json:"state_directory" yaml:"state_directory" toml:"state_directory"`
+	WarmCertificates                  bool           `json:"warm_certificates" yaml:"warm_certificates" toml:"warm_certificates"`
+	SelfSignedCertificate             bool           `json:"self_signed_certificate" yaml:"self_signed_certificate" toml:"self_signed_certificate"`
+	CertificateExpiryWarningThreshold duration       `json:"certificate_expiry_warning_threshold" yaml:"certificate_expiry_warning_threshold" toml:"certificate_expiry_warning_threshold"`
+	WhoIsCacheTTL                     duration       `json:"whois_cache_ttl" yaml:"whois_cache_ttl" toml:"whois_cache_ttl"`
+	Ephemeral                         bool           `json:"ephemeral" yaml:"ephemeral" toml:"ephemeral"`
+	AdvertiseTags                     []string       `json:"advertise_tags" yaml:"advertise_tags" toml:"advertise_tags"`
+	AdvertiseRoutes                   []string       `json:"advertise_routes" yaml:"advertise_routes" toml:"advertise_routes"`
+	ExitNode                          string         `json:"exit_node" yaml:"exit_node" toml:"exit_node"`
+	AcceptRoutes                      bool           `json:"accept_routes" yaml:"accept_routes" toml:"accept_routes"`
+	ControlURL                        string         `json:"control_url" yaml:"control_url" toml:"control_url"`
+	InMemoryState                     bool           `json:"in_memory_state" yaml:"in_memory_state" toml:"in_memory_state"`
+	KubernetesStateSecret             string         `json:"kubernetes_state_secret" yaml:"kubernetes_state_secret" toml:"kubernetes_state_secret"`
+	LogLevel                          slog.Level     `json:"log_level" yaml:"log_level" toml:"log_level"`
+	RunWebClient                      bool           `json:"run_web_client" yaml:"run_web_client" toml:"run_web_client"`
+	StatusPage                        bool           `json:"status_page" yaml:"status_page" toml:"status_page"`
+	Metrics                           bool           `json:"metrics" yaml:"metrics" toml:"metrics"`
+	HealthCheck                       bool           `json:"health_check" yaml:"health_check" toml:"health_check"`
+	DebugStatus                       *policySection `json:"debug_status" yaml:"debug_status" toml:"debug_status"`
+	Netcheck                          *policySection `json:"netcheck" yaml:"netcheck" toml:"netcheck"`
+	LocalMode                         bool           `json:"local_mode" yaml:"local_mode" toml:"local_mode"`
+	LocalAddress                      string         `json:"local_address" yaml:"local_address" toml:"local_address"`
+}
+
+type listenersSection struct {
+	HTTPSPorts []int `json:"https_ports" yaml:"https_ports" toml:"https_ports"`
+}
+
+type middlewareSection struct {
+	HSTS            bool              `json:"hsts" yaml:"hsts" toml:"hsts"`
+	SecureHeaders   bool              `json:"secure_headers" yaml:"secure_headers" toml:"secure_headers"`
+	Compression     bool              `json:"compression" yaml:"compression" toml:"compression"`
+	AuditLog        bool              `json:"audit_log" yaml:"audit_log" toml:"audit_log"`
+	AccessLog       bool              `json:"access_log" yaml:"access_log" toml:"access_log"`
+	RequestID       bool              `json:"request_id" yaml:"request_id" toml:"request_id"`
+	Recover         bool              `json:"recover" yaml:"recover" toml:"recover"`
+	RateLimit       *rateLimitSection `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
+	AllowedIPs      []string          `json:"allowed_ips" yaml:"allowed_ips" toml:"allowed_ips"`
+	AccessLogFormat string            `json:"access_log_format" yaml:"access_log_format" toml:"access_log_format"`
+}
+
+type rateLimitSection struct {
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second" toml:"requests_per_second"`
+	Burst             int     `json:"burst" yaml:"burst" toml:"burst"`
+}
+
+type policySection struct {
+	Allow []ruleSection `json:"allow" yaml:"allow" toml:"allow"`
+	Deny  []ruleSection `json:"deny" yaml:"deny" toml:"deny"`
+}
+
+type ruleSection struct {
+	LoginNames []string `json:"login_names" yaml:"login_names" toml:"login_names"`
+	Domains    []string `json:"domains" yaml:"domains" toml:"domains"`
+	NodeNames  []string `json:"node_names" yaml:"node_names" toml:"node_names"`
+	Tags       []string `json:"tags" yaml:"tags" toml:"tags"`
+	Prefixes   []string `json:"prefixes" yaml:"prefixes" toml:"prefixes"`
+	Groups     []string `json:"groups" yaml:"groups" toml:"groups"`
+}
+
+// duration is a time.Duration written as a string such as "30s".
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// LoadConfig loads the configuration file at path. The format is chosen by
+// the extension of the file, which is one of ".json", ".yaml", ".yml" and
+// ".toml". Unknown keys are rejected and errors name the offending key.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path is chosen by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	file, err := decodeConfigFile(filepath.Ext(path), data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse configuration file [%s]: %w", path, err)
+	}
+	config, err := file.config()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration file [%s]: %w", path, err)
+	}
+	return config, nil
+}
+
+func decodeConfigFile(extension string, data []byte) (*configFile, error) {
+	file := new(configFile)
+	switch strings.ToLower(extension) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(file); err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(file); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	case ".toml":
+		metadata, err := toml.Decode(string(data), file)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown key %s", undecoded[0])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported file extension [%s]; use .json, .yaml, .yml or .toml", extension)
+	}
+	return file, nil
+}
+
+// serverConfigKeys are the keys of the ServerConfig fields in configuration
+// files, which name the offending key of validation errors.
+var serverConfigKeys = map[string]string{
+	"TailscaleAuthKey":                  "server.auth_key",
+	"Hostname":                          "server.hostname",
+	"CertificateExpiryWarningThreshold": "server.certificate_expiry_warning_threshold",
+	"WhoIsCacheTTL":                     "server.whois_cache_ttl",
+	"AdvertiseTags":                     "server.advertise_tags",
+	"AdvertiseRoutes":                   "server.advertise_routes",
+	"ExitNode":                          "server.exit_node",
+	"AcceptRoutes":                      "server.accept_routes",
+	"ControlURL":                        "server.control_url",
+	"InMemoryState":                     "server.in_memory_state",
+	"KubernetesStateSecret":             "server.kubernetes_state_secret",
+	"RunWebClient":                      "server.run_web_client",
+	"LocalAddress":                      "server.local_address",
+}
+
+// config converts the file into a validated Config.
+func (f *configFile) config() (*Config, error) {
+	if f.Server.AuthKey == "" && !f.Server.LocalMode {
+		return nil, fmt.Errorf("server.auth_key: tailscale auth key cannot be empty")
+	}
+	if f.Server.Hostname == "" {
+		return nil, fmt.Errorf("server.hostname: hostname cannot be empty")
+	}
+	serverConfig := &ServerConfig{
+		TailscaleAuthKey:                  f.Server.AuthKey,
+		Hostname:                          f.Server.Hostname,
+		TailscaleStateDirectory:           f.Server.StateDirectory,
+		WarmCertificates:                  f.Server.WarmCertificates,
+		SelfSignedCertificate:             f.Server.SelfSignedCertificate,
+		CertificateExpiryWarningThreshold: time.Duration(f.Server.CertificateExpiryWarningThreshold),
+		WhoIsCacheTTL:                     time.Duration(f.Server.WhoIsCacheTTL),
+		Ephemeral:                         f.Server.Ephemeral,
+		AdvertiseTags:                     f.Server.AdvertiseTags,
+		AdvertiseRoutes:                   f.Server.AdvertiseRoutes,
+		ExitNode:                          f.Server.ExitNode,
+		AcceptRoutes:                      f.Server.AcceptRoutes,
+		ControlURL:                        f.Server.ControlURL,
+		InMemoryState:                     f.Server.InMemoryState,
+		KubernetesStateSecret:             f.Server.KubernetesStateSecret,
+		LogLevel:                          f.Server.LogLevel,
+		RunWebClient:                      f.Server.RunWebClient,
+		StatusPage:                        f.Server.StatusPage,
+		Metrics:                           f.Server.Metrics,
+		HealthCheck:                       f.Server.HealthCheck,
+		LocalMode:                         f.Server.LocalMode,
+		LocalAddress:                      f.Server.LocalAddress,
+	}
+	if f.Server.DebugStatus != nil {
+		policy, err := policyFromSection("server.debug_status", f.Server.DebugStatus)
+		if err != nil {
+			return nil, err
+		}
+		serverConfig.DebugStatus = policy
+	}
+	if f.Server.Netcheck != nil {
+		policy, err := policyFromSection("server.netcheck", f.Server.Netcheck)
+		if err != nil {
+			return nil, err
+		}
+		serverConfig.Netcheck = policy
+	}
+	if err := validateConfiguration(serverConfig); err != nil {
+		var fieldErr *configFieldError
+		if errors.As(err, &fieldErr) && serverConfigKeys[fieldErr.field] != "" {
+			return nil, fmt.Errorf("%s: %w", serverConfigKeys[fieldErr.field], err)
+		}
+		return nil, fmt.Errorf("server: %w", err)
+	}
+
+	if _, err := validateHTTPSPorts(f.Listeners.HTTPSPorts); err != nil {
+		var portErr *InvalidPortError
+		if errors.As(err, &portErr) {
+			return nil, fmt.Errorf("listeners.https_ports[%d]: %w", portErr.Index, err)
+		}
+		return nil, err
+	}
+
+	config := &Config{
+		Server:     serverConfig,
+		HTTPSPorts: f.Listeners.HTTPSPorts,
+		Middleware: MiddlewareConfig{
+			HSTS:            f.Middleware.HSTS,
+			SecureHeaders:   f.Middleware.SecureHeaders,
+			Compression:     f.Middleware.Compression,
+			AuditLog:        f.Middleware.AuditLog,
+			AccessLog:       f.Middleware.AccessLog,
+			RequestID:       f.Middleware.RequestID,
+			Recover:         f.Middleware.Recover,
+			AllowedIPs:      f.Middleware.AllowedIPs,
+			AccessLogFormat: f.Middleware.AccessLogFormat,
+		},
+	}
+	switch f.Middleware.AccessLogFormat {
+	case "", AccessLogFormatSlog, AccessLogFormatCombined:
+	default:
+		return nil, fmt.Errorf("middleware.access_log_format: unknown format [%s]; want %q or %q", f.Middleware.AccessLogFormat, AccessLogFormatSlog, AccessLogFormatCombined)
+	}
+	for i, entry := range f.Middleware.AllowedIPs {
+		if _, err := parseAllowedIP(entry); err != nil {
+			return nil, fmt.Errorf("middleware.allowed_ips[%d]: %w", i, err)
+		}
+	}
+	if rateLimit := f.Middleware.RateLimit; rateLimit != nil {
+		if rateLimit.RequestsPerSecond <= 0 {
+			return nil, fmt.Errorf("middleware.rate_limit.requests_per_second: rate must be positive")
+		}
+		if rateLimit.Burst < 0 {
+			return nil, fmt.Errorf("middleware.rate_limit.burst: burst cannot be negative")
+		}
+		config.Middleware.RateLimit = &RateLimitConfig{
+			RequestsPerSecond: rateLimit.RequestsPerSecond,
+			Burst:             rateLimit.Burst,
+		}
+	}
+
+	if f.Policy != nil {
+		policy, err := policyFromSection("policy", f.Policy)
+		if err != nil {
+			return nil, err
+		}
+		config.Policy = policy
+	}
+	return config, nil
+}
+
+func policyFromSection(key string, section *policySection) (*Policy, error) {
+	allow, err := rulesFromSections(key+".allow", section.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := rulesFromSections(key+".deny", section.Deny)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{Allow: allow, Deny: deny}, nil
+}
+
+func rulesFromSections(key string, sections []ruleSection) ([]Rule, error) {
+	rules := make([]Rule, 0, len(sections))
+	for i, section := range sections {
+		rule := Rule{
+			LoginNames: section.LoginNames,
+			Domains:    section.Domains,
+			NodeNames:  section.NodeNames,
+			Tags:       section.Tags,
+			Groups:     section.Groups,
+		}
+		for j, prefix := range section.Prefixes {
+			parsed, err := netip.ParsePrefix(prefix)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d].prefixes[%d]: %w", key, i, j, err)
+			}
+			rule.Prefixes = append(rule.Prefixes, parsed)
+		}
+		for j, tag := range section.Tags {
+			if !strings.HasPrefix(tag, "tag:") {
+				return nil, fmt.Errorf("%s[%d].tags[%d]: tag [%s] must start with \"tag:\"", key, i, j, tag)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{
+  "server": {"auth_key": "tskey-test", "hostname": "test-hostname", "whois_cache_ttl": "30s", "log_level": "warn"},
+  "listeners": {"https_ports": [443]},
+  "middleware": {"hsts": true, "secure_headers": true, "compression": true, "rate_limit": {"requests_per_second": 5, "burst": 10}, "allowed_ips": ["100.64.0.0/10"], "access_log": true, "access_log_format": "combined"},
+  "policy": {"allow": [{"domains": ["example.com"], "prefixes": ["100.64.0.0/10"]}]}
+}`,
+		"config.yaml": `
+server:
+  auth_key: tskey-test
+  hostname: test-hostname
+  whois_cache_ttl: 30s
+  log_level: warn
+  debug_status:
+    allow:
+      - tags: ["tag:inventory"]
+  netcheck:
+    allow:
+      - tags: ["tag:ops"]
+listeners:
+  https_ports: [443]
+middleware:
+  hsts: true
+  secure_headers: true
+  compression: true
+  allowed_ips: [100.64.0.0/10]
+  access_log: true
+  access_log_format: combined
+  rate_limit:
+    requests_per_second: 5
+    burst: 10
+policy:
+  allow:
+    - domains: [example.com]
+      prefixes: [100.64.0.0/10]
+`,
+		"config.toml": `
+[server]
+auth_key = "tskey-test"
+hostname = "test-hostname"
+whois_cache_ttl = "30s"
+log_level = "warn"
+
+[listeners]
+https_ports = [443]
+
+[middleware]
+hsts = true
+secure_headers = true
+compression = true
+allowed_ips = ["100.64.0.0/10"]
+access_log = true
+access_log_format = "combined"
+
+[middleware.rate_limit]
+requests_per_second = 5
+burst = 10
+
+[[policy.allow]]
+domains = ["example.com"]
+prefixes = ["100.64.0.0/10"]
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			config, err := LoadConfig(writeConfigFile(t, name, content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Server.Hostname != "test-hostname" || config.Server.WhoIsCacheTTL != 30*time.Second || config.Server.LogLevel != slog.LevelWarn {
+				t.Errorf("got server configuration %+v", config.Server)
+			}
+			if len(config.HTTPSPorts) != 1 || config.HTTPSPorts[0] != 443 {
+				t.Errorf("got HTTPS ports %v; want [443]", config.HTTPSPorts)
+			}
+			if !config.Middleware.HSTS || !config.Middleware.SecureHeaders || !config.Middleware.Compression || config.Middleware.RateLimit == nil || config.Middleware.RateLimit.Burst != 10 || len(config.Middleware.AllowedIPs) != 1 ||
+				!config.Middleware.AccessLog || config.Middleware.AccessLogFormat != AccessLogFormatCombined {
+				t.Errorf("got middleware %+v", config.Middleware)
+			}
+			if name == "config.yaml" && (config.Server.DebugStatus == nil || config.Server.DebugStatus.Allow[0].Tags[0] != "tag:inventory") {
+				t.Errorf("got debug status policy %+v", config.Server.DebugStatus)
+			}
+			if name == "config.yaml" && (config.Server.Netcheck == nil || config.Server.Netcheck.Allow[0].Tags[0] != "tag:ops") {
+				t.Errorf("got netcheck policy %+v", config.Server.Netcheck)
+			}
+			if config.Policy == nil || len(config.Policy.Allow) != 1 || len(config.Policy.Allow[0].Prefixes) != 1 {
+				t.Errorf("got policy %+v", config.Policy)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "unsupported extension",
+			file:    "config.ini",
+			content: "",
+			wantErr: "unsupported file extension",
+		},
+		{
+			name:    "unknown JSON key",
+			file:    "config.json",
+			content: `{"server": {"auth_key": "tskey-test", "hostname": "test-hostname", "hostnme": "x"}}`,
+			wantErr: "hostnme",
+		},
+		{
+			name:    "unknown TOML key",
+			file:    "config.toml",
+			content: "[server]\nauth_key = \"tskey-test\"\nhostname = \"test-hostname\"\nhostnme = \"x\"\n",
+			wantErr: "server.hostnme",
+		},
+		{
+			name:    "missing hostname",
+			file:    "config.yaml",
+			content: "server:\n  auth_key: tskey-test\n",
+			wantErr: "server.hostname",
+		},
+		{
+			name:    "invalid port",
+			file:    "config.yaml",
+			content: "server:\n  auth_key: tskey-test\n  hostname: test-hostname\nlisteners:\n  https_ports: [443, 70000]\n",
+			wantErr: "listeners.https_ports[1]",
+		},
+		{
+			name:    "invalid prefix",
+			file:    "config.yaml",
+			content: "server:\n  auth_key: tskey-test\n  hostname: test-hostname\npolicy:\n  deny:\n    - prefixes: [100.64.0.0]\n",
+			wantErr: "policy.deny[0].prefixes[0]",
+		},
+		{
+			name:    "invalid allowed IP",
+			file:    "config.yaml",
+			content: "server:\n  auth_key: tskey-test\n  hostname: test-hostname\nmiddleware:\n  allowed_ips: [100.64.0.0/33]\n",
+			wantErr: "middleware.allowed_ips[0]",
+		},
+		{
+			name:    "invalid debug status tag",
+			file:    "config.yaml",
+			content: "server:\n  auth_key: tskey-test\n  hostname: test-hostname\n  debug_status:\n    allow:\n      - tags: [ops]\n",
+			wantErr: "server.debug_status.allow[0].tags[0]",
+		},
+		{
+			name:    "local address without local mode",
+			file:    "config.yaml",
+			content: "server:\n  auth_key: tskey-test\n  hostname: test-hostname\n  local_address: 0.0.0.0\n",
+			wantErr: "server.local_address: local address requires local mode",
+		},
+		{
+			name:    "invalid hostname",
+			file:    "config.yaml",
+			content: "server:\n  auth_key: tskey-test\n  hostname: test_hostname\n",
+			wantErr: "server.hostname: ",
+		},
+		{
+			name:    "invalid advertised tag",
+			file:    "config.yaml",
+			content: "server:\n  auth_key: tskey-test\n  hostname: test-hostname\n  advertise_tags: [ops]\n",
+			wantErr: "server.advertise_tags: ",
+		},
+		{
+			name:    "exit node in local mode",
+			file:    "config.yaml",
+			content: "server:\n  hostname: test-hostname\n  local_mode: true\n  exit_node: exit-sg\n",
+			wantErr: "server.exit_node: ",
+		},
+		{
+			name:    "unknown access log format",
+			file:    "config.yaml",
+			content: "server:\n  auth_key: tskey-test\n  hostname: test-hostname\nmiddleware:\n  access_log_format: clf\n",
+			wantErr: "middleware.access_log_format",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfigFile(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v; want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCORSMaxAge is how long browsers cache the result of a preflight
+// request if CORSOptions.MaxAge is not set.
+const DefaultCORSMaxAge = 10 * time.Minute
+
+// CORSOptions configures the middleware returned by CORS.
+type CORSOptions struct {
+	// AllowedOrigins are the origins allowed to call the handler, such as
+	// "https://app.example.com". The first label of the host may be "*" to
+	// allow every subdomain, as in "https://*.example.com", and the port may
+	// be "*" to allow every port. "*" allows every origin.
+	AllowedOrigins []string
+
+	// AllowTailnetOrigins allows HTTPS origins of every node of the tailnet
+	// of the server on any port. It is only used by Server.CORS.
+	AllowTailnetOrigins bool
+
+	// AllowedMethods are the methods allowed in preflight requests. It
+	// defaults to GET, HEAD and POST.
+	AllowedMethods []string
+
+	// AllowedHeaders are the request headers allowed in preflight requests.
+	// It defaults to Accept, Authorization, Content-Type and
+	// X-Requested-With.
+	AllowedHeaders []string
+
+	// ExposedHeaders are the response headers scripts may read besides the
+	// CORS-safelisted ones.
+	ExposedHeaders []string
+
+	// AllowCredentials allows requests with cookies and other credentials.
+	// It cannot be combined with the origin "*".
+	AllowCredentials bool
+
+	// MaxAge is how long browsers cache the result of a preflight request.
+	// It defaults to DefaultCORSMaxAge.
+	MaxAge time.Duration
+}
+
+// CORS returns a middleware answering preflight requests and setting the
+// CORS headers on responses to requests from the allowed origins. Responses
+// to other origins have no CORS headers so browsers do not expose them to
+// scripts. It panics if the options are invalid.
+func CORS(opts CORSOptions) Middleware {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	if len(opts.AllowedHeaders) == 0 {
+		opts.AllowedHeaders = []string{"Accept", "Authorization", "Content-Type", "X-Requested-With"}
+	}
+	if opts.MaxAge == 0 {
+		opts.MaxAge = DefaultCORSMaxAge
+	}
+	allowAll := slices.Contains(opts.AllowedOrigins, "*")
+	if allowAll && opts.AllowCredentials {
+		panic("server: CORS origin \"*\" cannot be combined with credentials")
+	}
+	for _, pattern := range opts.AllowedOrigins {
+		if pattern == "*" {
+			continue
+		}
+		if _, _, _, ok := parseOrigin(pattern); !ok {
+			panic(fmt.Sprintf("server: invalid CORS origin [%s]", pattern))
+		}
+	}
+	allowedMethods := strings.Join(opts.AllowedMethods, ", ")
+	maxAge := strconv.Itoa(int(opts.MaxAge / time.Second))
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			header := w.Header()
+			header.Add("Vary", "Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if preflight {
+				header.Add("Vary", "Access-Control-Request-Method")
+				header.Add("Vary", "Access-Control-Request-Headers")
+			}
+			allowed := allowAll || slices.ContainsFunc(opts.AllowedOrigins, func(pattern string) bool {
+				return matchOrigin(pattern, origin)
+			})
+			if !allowed {
+				if preflight {
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if !preflight {
+				setAllowedOrigin(header, origin, allowAll, opts.AllowCredentials)
+				if len(opts.ExposedHeaders) > 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			method := r.Header.Get("Access-Control-Request-Method")
+			if !slices.Contains(opts.AllowedMethods, method) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			for _, requested := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				requested = strings.TrimSpace(requested)
+				if requested != "" && !slices.ContainsFunc(opts.AllowedHeaders, func(allowed string) bool {
+					return strings.EqualFold(allowed, requested)
+				}) {
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+			}
+			setAllowedOrigin(header, origin, allowAll, opts.AllowCredentials)
+			header.Set("Access-Control-Allow-Methods", allowedMethods)
+			header.Set("Access-Control-Allow-Headers", strings.Join(opts.AllowedHeaders, ", "))
+			header.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func setAllowedOrigin(header http.Header, origin string, allowAll, allowCredentials bool) {
+	if allowAll {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// CORS returns a middleware handling CORS as configured by opts. If
+// opts.AllowTailnetOrigins is set, the HTTPS origins of the nodes of the
+// tailnet of this node are allowed as well, so that a single page app served
+// by one node can call the APIs of its sibling nodes.
+func (s *Server) CORS(opts CORSOptions) Middleware {
+	if opts.AllowTailnetOrigins {
+		if _, tailnet, found := strings.Cut(s.fqdn, "."); found {
+			opts.AllowedOrigins = append(slices.Clone(opts.AllowedOrigins), "https://*."+tailnet+":*")
+		}
+	}
+	return CORS(opts)
+}
+
+// matchOrigin reports whether origin matches pattern. See
+// CORSOptions.AllowedOrigins.
+func matchOrigin(pattern, origin string) bool {
+	patternScheme, patternHost, patternPort, ok := parseOrigin(pattern)
+	if !ok {
+		return false
+	}
+	scheme, host, port, ok := parseOrigin(origin)
+	if !ok || scheme != patternScheme {
+		return false
+	}
+	if patternPort != "*" && patternPort != port {
+		return false
+	}
+	if domain, found := strings.CutPrefix(patternHost, "*."); found {
+		label, rest, _ := strings.Cut(host, ".")
+		return label != "" && rest == domain
+	}
+	return patternHost == host
+}
+
+// parseOrigin splits an origin, such as "https://app.example.com:8443", into
+// its lower case scheme, host and port. Unlike url.Parse, it accepts "*" as
+// the port of patterns.
+func parseOrigin(origin string) (scheme, host, port string, ok bool) {
+	scheme, hostPort, found := strings.Cut(strings.ToLower(strings.TrimSuffix(origin, "/")), "://")
+	if !found || scheme == "" || hostPort == "" || strings.ContainsAny(hostPort, "/?#@") {
+		return "", "", "", false
+	}
+	host = hostPort
+	if i := strings.LastIndex(hostPort, ":"); i >= 0 && !strings.HasSuffix(hostPort, "]") {
+		host, port = hostPort[:i], hostPort[i+1:]
+		if port == "" {
+			return "", "", "", false
+		}
+	}
+	return scheme, host, port, host != ""
+}
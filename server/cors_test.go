@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	opts := CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.prawn-universe.ts.net:*"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPut},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		AllowCredentials: true,
+	}
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		headers     map[string]string
+		wantCode    int
+		wantOrigin  string
+		wantHeaders map[string]string
+	}{
+		{name: "same origin", method: http.MethodGet, wantCode: http.StatusOK},
+		{
+			name:        "allowed origin",
+			method:      http.MethodGet,
+			origin:      "https://app.example.com",
+			wantCode:    http.StatusOK,
+			wantOrigin:  "https://app.example.com",
+			wantHeaders: map[string]string{"Access-Control-Expose-Headers": "X-Request-Id", "Access-Control-Allow-Credentials": "true"},
+		},
+		{
+			name:       "tailnet origin with port",
+			method:     http.MethodGet,
+			origin:     "https://dashboard.prawn-universe.ts.net:8443",
+			wantCode:   http.StatusOK,
+			wantOrigin: "https://dashboard.prawn-universe.ts.net:8443",
+		},
+		{name: "other origin", method: http.MethodGet, origin: "https://evil.example.com", wantCode: http.StatusOK},
+		{name: "nested subdomain", method: http.MethodGet, origin: "https://a.b.prawn-universe.ts.net", wantCode: http.StatusOK},
+		{name: "plaintext origin", method: http.MethodGet, origin: "http://app.example.com", wantCode: http.StatusOK},
+		{
+			name:       "preflight",
+			method:     http.MethodOptions,
+			origin:     "https://app.example.com",
+			headers:    map[string]string{"Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "content-type"},
+			wantCode:   http.StatusNoContent,
+			wantOrigin: "https://app.example.com",
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Methods": "GET, PUT",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:     "preflight of disallowed method",
+			method:   http.MethodOptions,
+			origin:   "https://app.example.com",
+			headers:  map[string]string{"Access-Control-Request-Method": "DELETE"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "preflight of disallowed header",
+			method:   http.MethodOptions,
+			origin:   "https://app.example.com",
+			headers:  map[string]string{"Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Secret"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "preflight from other origin",
+			method:   http.MethodOptions,
+			origin:   "https://evil.example.com",
+			headers:  map[string]string{"Access-Control-Request-Method": "GET"},
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			CORS(opts)(serveHandler()).ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got status %d; want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("got origin %q; want %q", got, tt.wantOrigin)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("got %s %q; want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSAllowAll(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Origin", "https://anything.example.com")
+	w := httptest.NewRecorder()
+	CORS(CORSOptions{AllowedOrigins: []string{"*"}})(serveHandler()).ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("got origin %q; want *", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("origin * with credentials does not panic")
+		}
+	}()
+	CORS(CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// DatabaseProxyConfig configures a proxy started by ServeDatabaseProxy.
+type DatabaseProxyConfig struct {
+	// Port is the port of the tailnet the proxy listens on, such as 5432 for
+	// PostgreSQL or 3306 for MySQL.
+	Port int
+
+	// Backend is the address of the database, such as "localhost:5432".
+	Backend string
+
+	// Policy authorizes the callers by their login names, tags or groups. It
+	// is required so that databases are never exposed to the whole tailnet
+	// by accident; the DeniedHandler of the policy is not used.
+	Policy *Policy
+
+	// Groups resolves the groups of callers for the rules of the policy with
+	// groups. It is required if any rule of the policy has groups.
+	Groups GroupResolver
+
+	// OnSession, if set, is called after each proxied connection is closed,
+	// for example to keep an audit trail of database access.
+	OnSession func(session DatabaseSession)
+}
+
+// DatabaseSession describes a connection proxied to a database.
+type DatabaseSession struct {
+	Caller     *CallerIdentity
+	RemoteAddr string
+	Backend    string
+	Start      time.Time
+	Duration   time.Duration
+
+	// BytesSent and BytesReceived are the bytes sent to and received from
+	// the database.
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// ServeDatabaseProxy listens on the configured port of the tailnet and
+// proxies connections of callers authorized by the policy to the database
+// until the server is closed. Every connection is logged with the identity of
+// its caller so that access to a database shared by a team is controlled and
+// attributed per person by their tailnet identity.
+func (s *Server) ServeDatabaseProxy(config *DatabaseProxyConfig) error {
+	if err := validateDatabaseProxyConfig(config); err != nil {
+		return err
+	}
+	listener, err := s.listen(fmt.Sprintf(":%d", config.Port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port [%d]: %w", config.Port, err)
+	}
+	return serveDatabaseProxy(listener, config, s.whoIs, s.logger)
+}
+
+func validateDatabaseProxyConfig(config *DatabaseProxyConfig) error {
+	if config == nil {
+		return fmt.Errorf("database proxy configuration is required")
+	}
+	if config.Port < 1 || config.Port > 65535 {
+		return fmt.Errorf("invalid tailnet port [%d]: port must be between 1 and 65535", config.Port)
+	}
+	if _, _, err := net.SplitHostPort(config.Backend); err != nil {
+		return fmt.Errorf("invalid database backend [%s]: %w", config.Backend, err)
+	}
+	if config.Policy == nil {
+		return fmt.Errorf("database proxy policy is required")
+	}
+	if config.Policy.usesGroups() && config.Groups == nil {
+		return fmt.Errorf("database proxy group resolver is required by policy with groups")
+	}
+	return nil
+}
+
+// serveDatabaseProxy accepts connections from listener until it is closed.
+func serveDatabaseProxy(listener net.Listener, config *DatabaseProxyConfig, whoIs whoIsFunc, logger *logger) error {
+	defer func() { _ = listener.Close() }()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go proxyDatabaseConnection(conn, config, whoIs, logger)
+	}
+}
+
+func proxyDatabaseConnection(conn net.Conn, config *DatabaseProxyConfig, whoIs whoIsFunc, logger *logger) {
+	defer func() { _ = conn.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), forwardDialTimeout)
+	defer cancel()
+	who, err := whoIs(ctx, conn.RemoteAddr().String())
+	if err != nil {
+		logger.logf(slog.LevelWarn, "rejecting database connection from [%s]: %v", conn.RemoteAddr(), err)
+		return
+	}
+	caller := NewCallerIdentity(who)
+	var groups []string
+	if config.Groups != nil && who.UserProfile != nil {
+		groups, err = config.Groups.Groups(ctx, who.UserProfile.LoginName)
+		if err != nil {
+			logger.logf(slog.LevelError, "rejecting database connection from [%s]: failed to resolve groups: %v", caller.LoginName, err)
+			return
+		}
+	}
+	if !config.Policy.AllowedWithGroups(who, groups) {
+		logger.logf(slog.LevelWarn, "rejecting database connection from [%s] on [%s]: caller is not authorized", caller.LoginName, caller.NodeName)
+		return
+	}
+	var dialer net.Dialer
+	backend, err := dialer.DialContext(ctx, Protocol, config.Backend)
+	if err != nil {
+		logger.logf(slog.LevelError, "failed to connect to database [%s]: %v", config.Backend, err)
+		return
+	}
+	defer func() { _ = backend.Close() }()
+
+	logger.logf(slog.LevelInfo, "[%s] on [%s] connected to database [%s]", caller.LoginName, caller.NodeName, config.Backend)
+	start := time.Now()
+	received, sent := pipe(conn, backend)
+	session := DatabaseSession{
+		Caller:        caller,
+		RemoteAddr:    conn.RemoteAddr().String(),
+		Backend:       config.Backend,
+		Start:         start,
+		Duration:      time.Since(start),
+		BytesSent:     sent,
+		BytesReceived: received,
+	}
+	logger.logf(slog.LevelInfo, "[%s] on [%s] disconnected from database [%s] after %s; sent %d bytes and received %d bytes",
+		caller.LoginName, caller.NodeName, config.Backend, session.Duration.Round(time.Millisecond), sent, received)
+	if config.OnSession != nil {
+		config.OnSession(session)
+	}
+}
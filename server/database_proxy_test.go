@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestValidateDatabaseProxyConfig(t *testing.T) {
+	policy := &Policy{Allow: []Rule{{Tags: []string{"tag:dba"}}}}
+	groupPolicy := &Policy{Allow: []Rule{{Groups: []string{"group:dba"}}}}
+	tests := []struct {
+		name    string
+		config  *DatabaseProxyConfig
+		wantErr bool
+	}{
+		{name: "valid", config: &DatabaseProxyConfig{Port: 5432, Backend: "localhost:5432", Policy: policy}},
+		{name: "nil", wantErr: true},
+		{name: "invalid port", config: &DatabaseProxyConfig{Port: 0, Backend: "localhost:5432", Policy: policy}, wantErr: true},
+		{name: "backend without port", config: &DatabaseProxyConfig{Port: 5432, Backend: "localhost", Policy: policy}, wantErr: true},
+		{name: "missing policy", config: &DatabaseProxyConfig{Port: 5432, Backend: "localhost:5432"}, wantErr: true},
+		{name: "groups without resolver", config: &DatabaseProxyConfig{Port: 5432, Backend: "localhost:5432", Policy: groupPolicy}, wantErr: true},
+		{name: "groups with resolver", config: &DatabaseProxyConfig{Port: 5432, Backend: "localhost:5432", Policy: groupPolicy, Groups: StaticGroups{}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateDatabaseProxyConfig(test.config); (err != nil) != test.wantErr {
+				t.Errorf("validateDatabaseProxyConfig() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestServeDatabaseProxy(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				data, _ := io.ReadAll(conn)
+				conn.Write(append([]byte("rows: "), data...))
+			}()
+		}
+	}()
+
+	tests := []struct {
+		name        string
+		who         *apitype.WhoIsResponse
+		wantData    string
+		wantMessage string
+	}{
+		{
+			name:        "allowed",
+			who:         newTestWhoIs("alice@example.com"),
+			wantData:    "rows: select 1",
+			wantMessage: "[alice@example.com] on [test-node.prawn-universe.ts.net] disconnected from database",
+		},
+		{
+			name:        "denied",
+			who:         newTestWhoIs("bob@example.com"),
+			wantMessage: "rejecting database connection from [bob@example.com]",
+		},
+		{
+			name:        "denied by group",
+			who:         newTestWhoIs("carol@example.com"),
+			wantMessage: "rejecting database connection from [carol@example.com]",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var messages []string
+			logger := newLogger(func(format string, args ...any) {
+				mu.Lock()
+				defer mu.Unlock()
+				messages = append(messages, fmt.Sprintf(format, args...))
+			}, slog.LevelInfo)
+			sessions := make(chan DatabaseSession, 1)
+			config := &DatabaseProxyConfig{
+				Port:    5432,
+				Backend: backend.Addr().String(),
+				Policy: &Policy{
+					Allow: []Rule{{LoginNames: []string{"alice@example.com", "carol@example.com"}}},
+					Deny:  []Rule{{Groups: []string{"group:contractors"}}},
+				},
+				Groups:    StaticGroups{"group:contractors": {"carol@example.com"}},
+				OnSession: func(session DatabaseSession) { sessions <- session },
+			}
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			whoIs := func(context.Context, string) (*apitype.WhoIsResponse, error) {
+				return test.who, nil
+			}
+			go serveDatabaseProxy(listener, config, whoIs, logger)
+			defer listener.Close()
+
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.Write([]byte("select 1"))
+			conn.(*net.TCPConn).CloseWrite()
+			data, _ := io.ReadAll(conn)
+			if string(data) != test.wantData {
+				t.Errorf("got %q, want %q", data, test.wantData)
+			}
+			if test.wantData != "" {
+				session := <-sessions
+				if session.Caller.LoginName != "alice@example.com" || session.BytesSent != 8 || session.BytesReceived != 14 {
+					t.Errorf("got session %+v", session)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !strings.Contains(strings.Join(messages, "\n"), test.wantMessage) {
+				t.Errorf("got messages %q; want one containing %q", messages, test.wantMessage)
+			}
+		})
+	}
+}
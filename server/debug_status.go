@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// DebugStatusPath is the path HandleDebugStatus serves DebugStatus on.
+const DebugStatusPath = "/debug/status"
+
+// modulePath is the path of the module of this package.
+const modulePath = "github.com/alexhokl/privateserver"
+
+// DebugStatus describes the node of a server for inventory tooling.
+type DebugStatus struct {
+	Hostname       string       `json:"hostname"`
+	FQDN           string       `json:"fqdn"`
+	TailscaleIPs   []netip.Addr `json:"tailscale_ips"`
+	BackendState   string       `json:"backend_state"`
+	ListeningPorts []int        `json:"listening_ports"`
+
+	// KeyExpiry is when the node key expires. It is nil if key expiry is
+	// disabled for the node.
+	KeyExpiry *time.Time `json:"key_expiry,omitempty"`
+
+	Version DebugVersion `json:"version"`
+}
+
+// DebugVersion holds the versions of the software of a server.
+type DebugVersion struct {
+	Tailscale     string `json:"tailscale"`
+	Go            string `json:"go"`
+	PrivateServer string `json:"privateserver,omitempty"`
+
+	// Main is the module path and version of the program, such as
+	// "example.com/app@v1.2.3".
+	Main string `json:"main,omitempty"`
+}
+
+// HandleDebugStatus registers DebugStatusHandler for "GET /debug/status" on
+// the router of the server for the callers authorized by policy only, such
+// as the tags of fleet tooling. The path stays reachable in maintenance mode.
+// It panics if policy is nil.
+func (s *Server) HandleDebugStatus(policy *Policy) {
+	if policy == nil {
+		panic("server: debug status requires a policy")
+	}
+	s.maintenance.addExempt(DebugStatusPath)
+	s.router.Handle("GET "+DebugStatusPath, policy, s.DebugStatusHandler())
+}
+
+// DebugStatusHandler returns a handler writing the DebugStatus of the server
+// as JSON.
+func (s *Server) DebugStatusHandler() http.Handler {
+	return debugStatusHandler(s.DebugStatus)
+}
+
+func debugStatusHandler(status func(ctx context.Context) (DebugStatus, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		debugStatus, err := status(r.Context())
+		if err != nil {
+			logf(slog.LevelError, "failed to get debug status of node: %v", err)
+			http.Error(w, "failed to get status of node", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, debugStatus)
+	})
+}
+
+// DebugStatus returns the FQDN, Tailscale IPs, backend state, key expiry,
+// listening ports and versions of the server.
+func (s *Server) DebugStatus(ctx context.Context) (DebugStatus, error) {
+	if s.localMode() {
+		return DebugStatus{}, ErrLocalMode
+	}
+	status, err := s.tsClient.StatusWithoutPeers(ctx)
+	if err != nil {
+		return DebugStatus{}, err
+	}
+	debugStatus := DebugStatus{
+		Hostname:       s.hostname,
+		FQDN:           s.fqdn,
+		TailscaleIPs:   status.TailscaleIPs,
+		BackendState:   status.BackendState,
+		ListeningPorts: s.listeningPorts(),
+		Version:        buildVersion(status.Version),
+	}
+	if status.Self != nil && status.Self.KeyExpiry != nil && !status.Self.KeyExpiry.IsZero() {
+		debugStatus.KeyExpiry = status.Self.KeyExpiry
+	}
+	return debugStatus, nil
+}
+
+// buildVersion returns the versions of Go and of the modules built into the
+// program, reported by a tailscaled of the specified version.
+func buildVersion(tailscale string) DebugVersion {
+	version := DebugVersion{
+		Tailscale: tailscale,
+		Go:        runtime.Version(),
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	if info.Main.Path != "" {
+		version.Main = strings.TrimSuffix(info.Main.Path+"@"+info.Main.Version, "@")
+	}
+	if info.Main.Path == modulePath {
+		version.PrivateServer = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			version.PrivateServer = dep.Version
+		}
+	}
+	return version
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestDebugStatus(t *testing.T) {
+	keyExpiry := time.Date(2027, time.January, 2, 3, 4, 5, 0, time.UTC)
+	localAPI := http.NewServeMux()
+	localAPI.HandleFunc("GET /localapi/v0/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"BackendState": "Running",
+			"Version":      "1.92.5",
+			"TailscaleIPs": []string{"100.64.0.10", "fd7a:115c:a1e0::a"},
+			"Self":         map[string]any{"KeyExpiry": keyExpiry},
+		})
+	})
+	inventory := newTestWhoIs("tagged-devices")
+	inventory.Node.Tags = []string{"tag:inventory"}
+	identities := map[string]*apitype.WhoIsResponse{
+		"100.64.0.1:1234": newTestWhoIs("alice@example.com"),
+		"100.64.0.2:1234": inventory,
+	}
+	s := &Server{
+		logger:   newLogger(t.Logf, slog.LevelInfo),
+		tsClient: newTestLocalClient(localAPI),
+		hostname: "web",
+		fqdn:     "web.prawn-universe.ts.net",
+		router: newRouter(func(r *http.Request) (*apitype.WhoIsResponse, error) {
+			return identities[r.RemoteAddr], nil
+		}),
+	}
+	s.recordListeningPorts(443, 80)
+	s.HandleDebugStatus(&Policy{Allow: []Rule{{Tags: []string{"tag:inventory"}}}})
+	h := s.Handler()
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", DebugStatusPath, nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	if w := serve("100.64.0.1:1234"); w.Code != http.StatusForbidden {
+		t.Errorf("got %d for an unauthorized caller; want %d", w.Code, http.StatusForbidden)
+	}
+	w := serve("100.64.0.2:1234")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d; want %d", w.Code, http.StatusOK)
+	}
+	var got DebugStatus
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Hostname != "web" || got.FQDN != "web.prawn-universe.ts.net" || got.BackendState != "Running" {
+		t.Errorf("got status %+v", got)
+	}
+	wantIPs := []netip.Addr{netip.MustParseAddr("100.64.0.10"), netip.MustParseAddr("fd7a:115c:a1e0::a")}
+	if !slices.Equal(got.TailscaleIPs, wantIPs) || !slices.Equal(got.ListeningPorts, []int{80, 443}) {
+		t.Errorf("got IPs %v and ports %v", got.TailscaleIPs, got.ListeningPorts)
+	}
+	if got.KeyExpiry == nil || !got.KeyExpiry.Equal(keyExpiry) {
+		t.Errorf("got key expiry %v; want %v", got.KeyExpiry, keyExpiry)
+	}
+	if got.Version.Tailscale != "1.92.5" || got.Version.Go != runtime.Version() {
+		t.Errorf("got version %+v", got.Version)
+	}
+
+	s.SetMaintenance(true, "")
+	if w := serve("100.64.0.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("got %d in maintenance mode; want %d", w.Code, http.StatusOK)
+	}
+}
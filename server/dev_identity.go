@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// HeaderDevIdentity is the request header selecting the login name of the
+// caller when DevIdentityProvider.AllowHeader is set.
+const HeaderDevIdentity = "X-Dev-Identity"
+
+// DevIdentityProvider is a RequestIdentityProvider for development which
+// returns a configured identity instead of asking Tailscale. It lets handlers
+// depending on IdentityFromContext run locally without a tailnet. It must
+// never be used in production as any caller is trusted to be the configured
+// user.
+type DevIdentityProvider struct {
+	// LoginName is the login name of every caller, such as
+	// "alice@example.com".
+	LoginName string
+
+	// DisplayName is the display name of every caller.
+	DisplayName string
+
+	// NodeName is the node name of every caller. It defaults to "localhost".
+	NodeName string
+
+	// Tags are the ACL tags of the node of every caller.
+	Tags []string
+
+	// AllowHeader lets callers impersonate other users by setting
+	// HeaderDevIdentity to a login name.
+	AllowHeader bool
+}
+
+// WhoIs returns the configured identity.
+func (p *DevIdentityProvider) WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	return p.identity(p.LoginName)
+}
+
+// IdentifyRequest returns the configured identity or the identity selected by
+// HeaderDevIdentity if AllowHeader is set.
+func (p *DevIdentityProvider) IdentifyRequest(r *http.Request) (*apitype.WhoIsResponse, error) {
+	loginName := p.LoginName
+	if p.AllowHeader {
+		if header := r.Header.Get(HeaderDevIdentity); header != "" {
+			loginName = header
+		}
+	}
+	return p.identity(loginName)
+}
+
+func (p *DevIdentityProvider) identity(loginName string) (*apitype.WhoIsResponse, error) {
+	if loginName == "" {
+		return nil, local.ErrPeerNotFound
+	}
+	nodeName := p.NodeName
+	if nodeName == "" {
+		nodeName = "localhost"
+	}
+	displayName := p.DisplayName
+	if displayName == "" {
+		displayName, _, _ = strings.Cut(loginName, "@")
+	}
+	return &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			Name: nodeName,
+			Tags: p.Tags,
+		},
+		UserProfile: &tailcfg.UserProfile{
+			LoginName:   loginName,
+			DisplayName: displayName,
+		},
+	}, nil
+}
+
+// WithIdentityProvider wraps the provided handler and stores the identity of
+// the caller returned by provider in the request context like
+// Server.WithIdentity does. It does not require a Server so that handlers can
+// be run locally with a DevIdentityProvider.
+func WithIdentityProvider(provider IdentityProvider, h http.Handler) http.Handler {
+	return withIdentity(identifyWith(provider), h)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDevIdentityProvider(t *testing.T) {
+	tests := []struct {
+		name        string
+		provider    *DevIdentityProvider
+		header      string
+		wantCode    int
+		wantLogin   string
+		wantDisplay string
+	}{
+		{
+			name:        "configured identity",
+			provider:    &DevIdentityProvider{LoginName: "alice@example.com"},
+			wantCode:    http.StatusOK,
+			wantLogin:   "alice@example.com",
+			wantDisplay: "alice",
+		},
+		{
+			name:        "header ignored",
+			provider:    &DevIdentityProvider{LoginName: "alice@example.com"},
+			header:      "bob@example.com",
+			wantCode:    http.StatusOK,
+			wantLogin:   "alice@example.com",
+			wantDisplay: "alice",
+		},
+		{
+			name:        "header allowed",
+			provider:    &DevIdentityProvider{LoginName: "alice@example.com", AllowHeader: true},
+			header:      "bob@example.com",
+			wantCode:    http.StatusOK,
+			wantLogin:   "bob@example.com",
+			wantDisplay: "bob",
+		},
+		{
+			name:     "no identity",
+			provider: &DevIdentityProvider{AllowHeader: true},
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotLogin, gotDisplay string
+			h := WithIdentityProvider(tt.provider, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				who, _ := IdentityFromContext(r.Context())
+				gotLogin = who.UserProfile.LoginName
+				gotDisplay = who.UserProfile.DisplayName
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set(HeaderDevIdentity, tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+			if gotLogin != tt.wantLogin || gotDisplay != tt.wantDisplay {
+				t.Errorf("got %q (%q); want %q (%q)", gotLogin, gotDisplay, tt.wantLogin, tt.wantDisplay)
+			}
+		})
+	}
+}
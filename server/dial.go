@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/util/dnsname"
+)
+
+// Dial connects to the address over the tailnet with the identity of this
+// node. The network is one of "tcp", "tcp4", "tcp6", "udp", "udp4" and
+// "udp6". The host of address may be an IP address, a MagicDNS name such as
+// "db" or "db.prawn-universe.ts.net", or a name resolved by DNS. It has the
+// signature of net.Dialer.DialContext so it can be used by clients of
+// databases and message queues. Connections to addresses outside the
+// tailnet egress through the exit node if ServerConfig.ExitNode is set, and
+// addresses in subnet routes of other nodes are reached through them if
+// ServerConfig.AcceptRoutes is set. In local mode, it dials directly from the
+// host.
+func (s *Server) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	default:
+		return nil, fmt.Errorf("network [%s] is not supported", network)
+	}
+	if s.localMode() {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address [%s]: %w", address, err)
+	}
+	if _, err := netip.ParseAddr(host); err != nil {
+		status, err := s.tsClient.Status(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tailscale status: %w", err)
+		}
+		if addr, found := resolvePeer(status, host, network); found {
+			address = net.JoinHostPort(addr.String(), port)
+		}
+	}
+	return s.tsServer.Dial(ctx, network, address)
+}
+
+// resolvePeer returns the Tailscale IP address of the node named host, which
+// is either its MagicDNS name or its fully qualified domain name, in the
+// address family of network.
+func resolvePeer(status *ipnstate.Status, host, network string) (netip.Addr, bool) {
+	name := strings.TrimSuffix(host, ".")
+	peers := make([]*ipnstate.PeerStatus, 0, len(status.Peer)+1)
+	if status.Self != nil {
+		peers = append(peers, status.Self)
+	}
+	for _, peer := range status.Peer {
+		peers = append(peers, peer)
+	}
+	for _, peer := range peers {
+		fqdn := strings.TrimSuffix(peer.DNSName, ".")
+		if fqdn == "" {
+			continue
+		}
+		if !strings.EqualFold(name, fqdn) && !strings.EqualFold(name, dnsname.TrimSuffix(fqdn, status.MagicDNSSuffix)) {
+			continue
+		}
+		for _, addr := range peer.TailscaleIPs {
+			switch {
+			case strings.HasSuffix(network, "4") && !addr.Is4():
+			case strings.HasSuffix(network, "6") && !addr.Is6():
+			default:
+				return addr, true
+			}
+		}
+		return netip.Addr{}, false
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestResolvePeer(t *testing.T) {
+	status := &ipnstate.Status{
+		MagicDNSSuffix: "prawn-universe.ts.net",
+		Self: &ipnstate.PeerStatus{
+			DNSName:      "web.prawn-universe.ts.net.",
+			TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+		},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {
+				DNSName:      "db.prawn-universe.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.2"), netip.MustParseAddr("fd7a:115c:a1e0::2")},
+			},
+			key.NewNode().Public(): {
+				DNSName:      "queue.prawn-universe.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.3")},
+			},
+		},
+	}
+	tests := []struct {
+		name    string
+		host    string
+		network string
+		want    netip.Addr
+	}{
+		{name: "short name", host: "db", network: "tcp", want: netip.MustParseAddr("100.64.0.2")},
+		{name: "fully qualified name", host: "DB.prawn-universe.ts.net.", network: "udp", want: netip.MustParseAddr("100.64.0.2")},
+		{name: "IPv6", host: "db", network: "tcp6", want: netip.MustParseAddr("fd7a:115c:a1e0::2")},
+		{name: "this node", host: "web", network: "tcp4", want: netip.MustParseAddr("100.64.0.1")},
+		{name: "no address of family", host: "queue", network: "tcp6"},
+		{name: "name outside tailnet", host: "example.com", network: "tcp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := resolvePeer(status, tt.host, tt.network)
+			if found != tt.want.IsValid() || got != tt.want {
+				t.Errorf("resolvePeer() = %v, %t; want %v", got, found, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables read by ConfigFromEnv. The Tailscale ones match those
+// of the official Tailscale container images.
+const (
+	EnvAuthKey                           = "TS_AUTHKEY"
+	EnvAuthKeyAlternative                = "TS_AUTH_KEY"
+	EnvHostname                          = "TS_HOSTNAME"
+	EnvStateDirectory                    = "TS_STATE_DIR"
+	EnvWarmCertificates                  = "PRIVATESERVER_WARM_CERTIFICATES"
+	EnvSelfSignedCertificate             = "PRIVATESERVER_SELF_SIGNED_CERTIFICATE"
+	EnvCertificateExpiryWarningThreshold = "PRIVATESERVER_CERTIFICATE_EXPIRY_WARNING_THRESHOLD"
+	EnvWhoIsCacheTTL                     = "PRIVATESERVER_WHOIS_CACHE_TTL"
+	EnvEphemeral                         = "PRIVATESERVER_EPHEMERAL"
+	EnvAdvertiseTags                     = "PRIVATESERVER_ADVERTISE_TAGS"
+	EnvAdvertiseRoutes                   = "PRIVATESERVER_ADVERTISE_ROUTES"
+	EnvExitNode                          = "PRIVATESERVER_EXIT_NODE"
+	EnvAcceptRoutes                      = "PRIVATESERVER_ACCEPT_ROUTES"
+	EnvControlURL                        = "PRIVATESERVER_CONTROL_URL"
+	EnvInMemoryState                     = "PRIVATESERVER_IN_MEMORY_STATE"
+	EnvKubernetesStateSecret             = "PRIVATESERVER_KUBERNETES_STATE_SECRET"
+	EnvLogLevel                          = "PRIVATESERVER_LOG_LEVEL"
+	EnvRunWebClient                      = "PRIVATESERVER_RUN_WEB_CLIENT"
+	EnvStatusPage                        = "PRIVATESERVER_STATUS_PAGE"
+	EnvMetrics                           = "PRIVATESERVER_METRICS"
+	EnvHealthCheck                       = "PRIVATESERVER_HEALTH_CHECK"
+	EnvLocalMode                         = "PRIVATESERVER_LOCAL_MODE"
+	EnvLocalAddress                      = "PRIVATESERVER_LOCAL_ADDRESS"
+)
+
+// NewServerFromEnv creates a Server configured by environment variables. See
+// ConfigFromEnv for the variables read.
+func NewServerFromEnv() (*Server, error) {
+	config, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewServer(config)
+}
+
+// ConfigFromEnv returns the configuration specified by the environment
+// variables TS_AUTHKEY (or TS_AUTH_KEY), TS_HOSTNAME, TS_STATE_DIR,
+// PRIVATESERVER_WARM_CERTIFICATES, PRIVATESERVER_SELF_SIGNED_CERTIFICATE,
+// PRIVATESERVER_CERTIFICATE_EXPIRY_WARNING_THRESHOLD,
+// PRIVATESERVER_WHOIS_CACHE_TTL, PRIVATESERVER_EPHEMERAL,
+// PRIVATESERVER_ADVERTISE_TAGS, PRIVATESERVER_ADVERTISE_ROUTES,
+// PRIVATESERVER_EXIT_NODE, PRIVATESERVER_ACCEPT_ROUTES,
+// PRIVATESERVER_CONTROL_URL,
+// PRIVATESERVER_IN_MEMORY_STATE, PRIVATESERVER_KUBERNETES_STATE_SECRET,
+// PRIVATESERVER_LOG_LEVEL, PRIVATESERVER_RUN_WEB_CLIENT,
+// PRIVATESERVER_STATUS_PAGE, PRIVATESERVER_METRICS,
+// PRIVATESERVER_HEALTH_CHECK, PRIVATESERVER_LOCAL_MODE and
+// PRIVATESERVER_LOCAL_ADDRESS. Durations are in the format of
+// time.ParseDuration, such as "30s", log levels are debug, info, warn or
+// error, and lists are comma-separated.
+func ConfigFromEnv() (*ServerConfig, error) {
+	return configFromEnv(os.LookupEnv)
+}
+
+func configFromEnv(lookupEnv func(string) (string, bool)) (*ServerConfig, error) {
+	env := envReader{lookupEnv: lookupEnv}
+	config := &ServerConfig{
+		TailscaleAuthKey:                  env.string(EnvAuthKey),
+		Hostname:                          env.string(EnvHostname),
+		TailscaleStateDirectory:           env.string(EnvStateDirectory),
+		WarmCertificates:                  env.bool(EnvWarmCertificates),
+		SelfSignedCertificate:             env.bool(EnvSelfSignedCertificate),
+		CertificateExpiryWarningThreshold: env.duration(EnvCertificateExpiryWarningThreshold),
+		WhoIsCacheTTL:                     env.duration(EnvWhoIsCacheTTL),
+		Ephemeral:                         env.bool(EnvEphemeral),
+		AdvertiseTags:                     env.list(EnvAdvertiseTags),
+		AdvertiseRoutes:                   env.list(EnvAdvertiseRoutes),
+		ExitNode:                          env.string(EnvExitNode),
+		AcceptRoutes:                      env.bool(EnvAcceptRoutes),
+		ControlURL:                        env.string(EnvControlURL),
+		InMemoryState:                     env.bool(EnvInMemoryState),
+		KubernetesStateSecret:             env.string(EnvKubernetesStateSecret),
+		LogLevel:                          env.level(EnvLogLevel),
+		RunWebClient:                      env.bool(EnvRunWebClient),
+		StatusPage:                        env.bool(EnvStatusPage),
+		Metrics:                           env.bool(EnvMetrics),
+		HealthCheck:                       env.bool(EnvHealthCheck),
+		LocalMode:                         env.bool(EnvLocalMode),
+		LocalAddress:                      env.string(EnvLocalAddress),
+	}
+	if config.TailscaleAuthKey == "" {
+		config.TailscaleAuthKey = env.string(EnvAuthKeyAlternative)
+	}
+	if env.err != nil {
+		return nil, env.err
+	}
+	if err := validateConfiguration(config); err != nil {
+		return nil, fmt.Errorf("invalid configuration from environment: %w", err)
+	}
+	return config, nil
+}
+
+// envReader reads typed environment variables and keeps the first error.
+type envReader struct {
+	lookupEnv func(string) (string, bool)
+	err       error
+}
+
+func (e *envReader) string(name string) string {
+	value, _ := e.lookupEnv(name)
+	return value
+}
+
+func (e *envReader) list(name string) []string {
+	value, _ := e.lookupEnv(name)
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func (e *envReader) bool(name string) bool {
+	value, found := e.lookupEnv(name)
+	if !found || value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("invalid boolean [%s] in %s: %w", value, name, err)
+	}
+	return b
+}
+
+func (e *envReader) duration(name string) time.Duration {
+	value, found := e.lookupEnv(name)
+	if !found || value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("invalid duration [%s] in %s: %w", value, name, err)
+	}
+	return d
+}
+
+func (e *envReader) level(name string) slog.Level {
+	value, found := e.lookupEnv(name)
+	if !found || value == "" {
+		return slog.LevelInfo
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil && e.err == nil {
+		e.err = fmt.Errorf("invalid log level [%s] in %s: %w", value, name, err)
+	}
+	return level
+}
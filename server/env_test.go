@@ -0,0 +1,164 @@
+package server
+
+import (
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    ServerConfig
+		wantErr bool
+	}{
+		{
+			name: "minimal",
+			env: map[string]string{
+				EnvAuthKey:  "tskey-test",
+				EnvHostname: "test-hostname",
+			},
+			want: ServerConfig{
+				TailscaleAuthKey: "tskey-test",
+				Hostname:         "test-hostname",
+			},
+		},
+		{
+			name: "all options",
+			env: map[string]string{
+				EnvAuthKeyAlternative:                "tskey-test",
+				EnvHostname:                          "test-hostname",
+				EnvStateDirectory:                    "/var/lib/tailscale",
+				EnvWarmCertificates:                  "true",
+				EnvSelfSignedCertificate:             "1",
+				EnvCertificateExpiryWarningThreshold: "168h",
+				EnvWhoIsCacheTTL:                     "30s",
+				EnvEphemeral:                         "true",
+				EnvAdvertiseTags:                     "tag:web, tag:internal",
+				EnvAdvertiseRoutes:                   "192.168.1.0/24,fd00::/64",
+				EnvExitNode:                          "exit-sg",
+				EnvAcceptRoutes:                      "true",
+				EnvInMemoryState:                     "true",
+				EnvLogLevel:                          "debug",
+				EnvRunWebClient:                      "true",
+				EnvStatusPage:                        "true",
+				EnvMetrics:                           "true",
+				EnvHealthCheck:                       "true",
+			},
+			want: ServerConfig{
+				TailscaleAuthKey:                  "tskey-test",
+				Hostname:                          "test-hostname",
+				TailscaleStateDirectory:           "/var/lib/tailscale",
+				WarmCertificates:                  true,
+				SelfSignedCertificate:             true,
+				CertificateExpiryWarningThreshold: 168 * time.Hour,
+				WhoIsCacheTTL:                     30 * time.Second,
+				Ephemeral:                         true,
+				AdvertiseTags:                     []string{"tag:web", "tag:internal"},
+				AdvertiseRoutes:                   []string{"192.168.1.0/24", "fd00::/64"},
+				ExitNode:                          "exit-sg",
+				AcceptRoutes:                      true,
+				InMemoryState:                     true,
+				LogLevel:                          slog.LevelDebug,
+				RunWebClient:                      true,
+				StatusPage:                        true,
+				Metrics:                           true,
+				HealthCheck:                       true,
+			},
+		},
+		{
+			name: "local mode",
+			env: map[string]string{
+				EnvHostname:     "test-hostname",
+				EnvLocalMode:    "true",
+				EnvLocalAddress: "0.0.0.0",
+			},
+			want: ServerConfig{
+				Hostname:     "test-hostname",
+				LocalMode:    true,
+				LocalAddress: "0.0.0.0",
+			},
+		},
+		{
+			name: "local address without local mode",
+			env: map[string]string{
+				EnvAuthKey:      "tskey-test",
+				EnvHostname:     "test-hostname",
+				EnvLocalAddress: "0.0.0.0",
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing auth key",
+			env: map[string]string{
+				EnvHostname: "test-hostname",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid boolean",
+			env: map[string]string{
+				EnvAuthKey:          "tskey-test",
+				EnvHostname:         "test-hostname",
+				EnvWarmCertificates: "sometimes",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid log level",
+			env: map[string]string{
+				EnvAuthKey:  "tskey-test",
+				EnvHostname: "test-hostname",
+				EnvLogLevel: "verbose",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid duration",
+			env: map[string]string{
+				EnvAuthKey:       "tskey-test",
+				EnvHostname:      "test-hostname",
+				EnvWhoIsCacheTTL: "30",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := configFromEnv(func(name string) (string, bool) {
+				value, found := tt.env[name]
+				return value, found
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("configFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if config.TailscaleAuthKey != tt.want.TailscaleAuthKey ||
+				config.Hostname != tt.want.Hostname ||
+				config.TailscaleStateDirectory != tt.want.TailscaleStateDirectory ||
+				config.WarmCertificates != tt.want.WarmCertificates ||
+				config.SelfSignedCertificate != tt.want.SelfSignedCertificate ||
+				config.CertificateExpiryWarningThreshold != tt.want.CertificateExpiryWarningThreshold ||
+				config.WhoIsCacheTTL != tt.want.WhoIsCacheTTL ||
+				config.Ephemeral != tt.want.Ephemeral ||
+				config.ExitNode != tt.want.ExitNode ||
+				config.AcceptRoutes != tt.want.AcceptRoutes ||
+				config.InMemoryState != tt.want.InMemoryState ||
+				config.LogLevel != tt.want.LogLevel ||
+				config.RunWebClient != tt.want.RunWebClient ||
+				config.StatusPage != tt.want.StatusPage ||
+				config.Metrics != tt.want.Metrics ||
+				config.HealthCheck != tt.want.HealthCheck ||
+				config.LocalMode != tt.want.LocalMode ||
+				config.LocalAddress != tt.want.LocalAddress ||
+				!slices.Equal(config.AdvertiseTags, tt.want.AdvertiseTags) ||
+				!slices.Equal(config.AdvertiseRoutes, tt.want.AdvertiseRoutes) {
+				t.Errorf("got %+v; want %+v", config, tt.want)
+			}
+		})
+	}
+}
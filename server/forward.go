@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// forwardDialTimeout is the time allowed to connect to the target of a
+// forwarded connection.
+const forwardDialTimeout = 10 * time.Second
+
+// ForwardTCP listens on the specified port of the tailnet and forwards every
+// connection to target, such as "localhost:5432" or "10.0.0.5:5900", until
+// the server is closed. Connections from callers which are not known tailnet
+// peers are closed immediately. If policy is not nil, only callers authorized
+// by it are forwarded; the DeniedHandler of the policy is not used.
+func (s *Server) ForwardTCP(tailnetPort int, target string, policy *Policy) error {
+	if tailnetPort < 1 || tailnetPort > 65535 {
+		return fmt.Errorf("invalid tailnet port [%d]: port must be between 1 and 65535", tailnetPort)
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return fmt.Errorf("invalid forwarding target [%s]: %w", target, err)
+	}
+	listener, err := s.listen(fmt.Sprintf(":%d", tailnetPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port [%d]: %w", tailnetPort, err)
+	}
+	return forwardTCP(listener, target, policy, s.whoIs, s.logger)
+}
+
+// forwardTCP accepts connections from listener until it is closed and
+// forwards connections from authorized callers to target.
+func forwardTCP(listener net.Listener, target string, policy *Policy, whoIs whoIsFunc, logger *logger) error {
+	defer func() { _ = listener.Close() }()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go forwardConnection(conn, target, policy, whoIs, logger)
+	}
+}
+
+func forwardConnection(conn net.Conn, target string, policy *Policy, whoIs whoIsFunc, logger *logger) {
+	defer func() { _ = conn.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), forwardDialTimeout)
+	defer cancel()
+	who, err := whoIs(ctx, conn.RemoteAddr().String())
+	if err != nil {
+		logger.logf(slog.LevelWarn, "rejecting connection from [%s] to [%s]: %v", conn.RemoteAddr(), target, err)
+		return
+	}
+	if policy != nil && !policy.Allowed(who) {
+		logger.logf(slog.LevelWarn, "rejecting connection from [%s] to [%s]: caller is not authorized", conn.RemoteAddr(), target)
+		return
+	}
+	var dialer net.Dialer
+	upstream, err := dialer.DialContext(ctx, Protocol, target)
+	if err != nil {
+		logger.logf(slog.LevelError, "failed to connect to [%s]: %v", target, err)
+		return
+	}
+	defer func() { _ = upstream.Close() }()
+	pipe(conn, upstream)
+}
+
+// pipe copies data between a and b in both directions until both directions
+// are finished and returns the number of bytes written to each. The write
+// side of each connection is closed once the other connection reaches EOF.
+func pipe(a, b net.Conn) (toA, toB int64) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		toA = copyAndCloseWrite(a, b)
+	}()
+	go func() {
+		defer wg.Done()
+		toB = copyAndCloseWrite(b, a)
+	}()
+	wg.Wait()
+	return toA, toB
+}
+
+func copyAndCloseWrite(dst, src net.Conn) int64 {
+	n, _ := io.Copy(dst, src)
+	if closer, ok := dst.(interface{ CloseWrite() error }); ok {
+		// the connection is closed by its owner if half-closing fails
+		_ = closer.CloseWrite()
+		return n
+	}
+	_ = dst.Close()
+	return n
+}
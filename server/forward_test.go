@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestForwardTCP(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				data, _ := io.ReadAll(conn)
+				conn.Write(append([]byte("echo: "), data...))
+			}()
+		}
+	}()
+
+	tests := []struct {
+		name     string
+		who      *apitype.WhoIsResponse
+		err      error
+		policy   *Policy
+		wantData string
+	}{
+		{name: "known peer", who: newTestWhoIs("alice@example.com"), wantData: "echo: hello"},
+		{name: "unknown peer", err: local.ErrPeerNotFound},
+		{
+			name:     "allowed by policy",
+			who:      newTestWhoIs("alice@example.com"),
+			policy:   &Policy{Allow: []Rule{{LoginNames: []string{"alice@example.com"}}}},
+			wantData: "echo: hello",
+		},
+		{
+			name:   "denied by policy",
+			who:    newTestWhoIs("bob@example.com"),
+			policy: &Policy{Allow: []Rule{{LoginNames: []string{"alice@example.com"}}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			whoIs := func(context.Context, string) (*apitype.WhoIsResponse, error) {
+				return test.who, test.err
+			}
+			go forwardTCP(listener, upstream.Addr().String(), test.policy, whoIs, newLogger(t.Logf, slog.LevelInfo))
+			defer listener.Close()
+
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.Write([]byte("hello"))
+			conn.(*net.TCPConn).CloseWrite()
+			data, _ := io.ReadAll(conn)
+			if string(data) != test.wantData {
+				t.Errorf("got %q, want %q", data, test.wantData)
+			}
+		})
+	}
+}
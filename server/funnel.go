@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tsnet"
+)
+
+// ErrFunnelRequest is returned when the identity of a caller is requested for
+// a request which arrived over Tailscale Funnel from the public internet.
+var ErrFunnelRequest = errors.New("caller identity is not available for requests over Tailscale Funnel")
+
+// funnelContextKey is the context key of the source address of a connection
+// which arrived over Tailscale Funnel.
+type funnelContextKey struct{}
+
+// ListenFunnel listens on the specified port both on the tailnet and on the
+// public internet using Tailscale Funnel and returns a TLS listener. Funnel
+// only supports ports 443, 8443 and 10000 and has to be allowed in the
+// tailnet policy file. Set ConnContext on the http.Server serving the
+// listener to tell Funnel requests apart from tailnet requests.
+func (s *Server) ListenFunnel(port int) (net.Listener, error) {
+	if s.localMode() {
+		return nil, ErrLocalMode
+	}
+	if len(s.certDomains) == 0 {
+		return nil, errHTTPSNotEnabled
+	}
+	addr := fmt.Sprintf(":%d", port)
+	listener, err := s.tsServer.ListenFunnel(Protocol, addr, tsnet.FunnelTLSConfig(s.tlsConfig))
+	if err != nil {
+		s.listenerError(addr, err)
+		return nil, fmt.Errorf("failed to listen on Funnel at [%s]: %w", addr, err)
+	}
+	s.recordListeningPorts(port)
+	s.logger.log(slog.LevelInfo, "listening", fmt.Sprintf("listening on Funnel on port %d", port), "port", port, "funnel", true)
+	return listener, nil
+}
+
+// ConnContext marks the context of connections which arrived over Tailscale
+// Funnel. Assign it to http.Server.ConnContext so that IsFunnelRequest,
+// RequireTailnet and WithIdentity can tell Funnel requests apart from tailnet
+// requests.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if funnelConn, ok := conn.(*ipn.FunnelConn); ok {
+		return context.WithValue(ctx, funnelContextKey{}, funnelConn.Src)
+	}
+	return ctx
+}
+
+// FunnelSourceFromContext returns the public internet address of the client
+// of a connection which arrived over Tailscale Funnel. It requires
+// ConnContext to be set on the http.Server.
+func FunnelSourceFromContext(ctx context.Context) (netip.AddrPort, bool) {
+	src, ok := ctx.Value(funnelContextKey{}).(netip.AddrPort)
+	return src, ok
+}
+
+// IsFunnelRequest reports whether the request arrived over Tailscale Funnel
+// from the public internet rather than from the tailnet. It requires
+// ConnContext to be set on the http.Server.
+func IsFunnelRequest(r *http.Request) bool {
+	_, ok := FunnelSourceFromContext(r.Context())
+	return ok
+}
+
+// RequireTailnet wraps the provided handler and rejects requests which
+// arrived over Tailscale Funnel with status 403. It requires ConnContext to
+// be set on the http.Server.
+func RequireTailnet(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsFunnelRequest(r) {
+			authDenied(r, "funnel request to a tailnet-only resource")
+			http.Error(w, "this resource is only available on the tailnet", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
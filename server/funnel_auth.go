@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultFunnelAuthRealm is the realm announced to Funnel callers if
+// FunnelAuthOptions.Realm is not set.
+const DefaultFunnelAuthRealm = "privateserver"
+
+// FunnelAuthOptions configures the middleware returned by Server.FunnelAuth.
+// At least a username and password or a token has to be set.
+type FunnelAuthOptions struct {
+	// Username and Password are the credentials Funnel callers can supply
+	// with HTTP basic authentication.
+	Username string
+	Password string
+
+	// Tokens are the bearer tokens Funnel callers can supply in the
+	// Authorization header instead of basic authentication.
+	Tokens []string
+
+	// Realm is the realm of the WWW-Authenticate challenge. It defaults to
+	// DefaultFunnelAuthRealm.
+	Realm string
+}
+
+// funnelUserContextKey is the context key of the name of a Funnel caller
+// authenticated by FunnelAuth.
+type funnelUserContextKey struct{}
+
+// FunnelAuth returns a middleware serving both the tailnet and the public
+// internet over Tailscale Funnel. Tailnet requests are identified by their
+// device as with WithIdentity, whereas Funnel requests, whose callers cannot
+// be identified, have to supply the configured username and password with
+// basic authentication or one of the configured bearer tokens. Funnel
+// requests without valid credentials are answered with status 401. It
+// requires ConnContext to be set on the http.Server. It panics if the
+// credentials are incomplete or an empty token is configured.
+func (s *Server) FunnelAuth(opts FunnelAuthOptions) Middleware {
+	return funnelAuth(s.identify, opts)
+}
+
+// funnelAuth returns the middleware of FunnelAuth identifying tailnet callers
+// with identify.
+func funnelAuth(identify identifyFunc, opts FunnelAuthOptions) Middleware {
+	if opts.Username == "" && opts.Password == "" && len(opts.Tokens) == 0 {
+		panic("server: funnel auth requires either a username and password or a token")
+	}
+	if (opts.Username == "") != (opts.Password == "") {
+		panic("server: funnel auth requires both a username and a password")
+	}
+	for _, token := range opts.Tokens {
+		if token == "" {
+			panic("server: funnel auth token cannot be empty")
+		}
+	}
+	if opts.Realm == "" {
+		opts.Realm = DefaultFunnelAuthRealm
+	}
+	challenge := fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", opts.Realm)
+	if len(opts.Tokens) > 0 {
+		challenge = fmt.Sprintf("Bearer realm=%q", opts.Realm)
+		if opts.Username != "" {
+			challenge = fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\", Bearer realm=%q", opts.Realm, opts.Realm)
+		}
+	}
+	return func(h http.Handler) http.Handler {
+		tailnet := withIdentity(identify, h)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !IsFunnelRequest(r) {
+				tailnet.ServeHTTP(w, r)
+				return
+			}
+			user, ok := authenticateFunnelRequest(r, &opts)
+			if !ok {
+				authDenied(r, "funnel request without valid credentials")
+				w.Header().Set("WWW-Authenticate", challenge)
+				http.Error(w, "authentication is required for requests over Tailscale Funnel", http.StatusUnauthorized)
+				return
+			}
+			r.Header.Del("Authorization")
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), funnelUserContextKey{}, user)))
+		})
+	}
+}
+
+// authenticateFunnelRequest checks the credentials of a Funnel request and
+// returns the username, or an empty name for bearer tokens.
+func authenticateFunnelRequest(r *http.Request, opts *FunnelAuthOptions) (string, bool) {
+	if username, password, ok := r.BasicAuth(); ok {
+		if opts.Username == "" {
+			return "", false
+		}
+		// Both comparisons are always made so that the time taken does not
+		// reveal which of them failed.
+		userMatch := secretsEqual(username, opts.Username)
+		passwordMatch := secretsEqual(password, opts.Password)
+		return username, userMatch && passwordMatch
+	}
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	matched := false
+	for _, t := range opts.Tokens {
+		if secretsEqual(token, t) {
+			matched = true
+		}
+	}
+	return "", matched
+}
+
+// secretsEqual compares secrets in constant time. The secrets are hashed first
+// so that their lengths are not revealed either.
+func secretsEqual(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// FunnelUserFromContext returns the username of a Funnel caller authenticated
+// by Server.FunnelAuth. The username is empty for callers authenticated with a
+// bearer token. It reports false for tailnet requests, whose identity is
+// returned by IdentityFromContext instead.
+func FunnelUserFromContext(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(funnelUserContextKey{}).(string)
+	return user, ok
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+)
+
+func TestFunnelAuth(t *testing.T) {
+	funnelCtx := ConnContext(context.Background(), &ipn.FunnelConn{Src: netip.MustParseAddrPort("203.0.113.1:1234")})
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		username      string
+		password      string
+		authorization string
+		wantCode      int
+		wantUser      string
+		wantLogin     string
+	}{
+		{
+			name:      "tailnet request",
+			ctx:       context.Background(),
+			wantCode:  http.StatusOK,
+			wantLogin: "alice@example.com",
+		},
+		{
+			name:     "funnel request without credentials",
+			ctx:      funnelCtx,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "funnel request with valid password",
+			ctx:      funnelCtx,
+			username: "bob",
+			password: "secret",
+			wantCode: http.StatusOK,
+			wantUser: "bob",
+		},
+		{
+			name:     "funnel request with wrong password",
+			ctx:      funnelCtx,
+			username: "bob",
+			password: "wrong",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "funnel request with wrong username",
+			ctx:      funnelCtx,
+			username: "eve",
+			password: "secret",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:          "funnel request with valid token",
+			ctx:           funnelCtx,
+			authorization: "Bearer token-2",
+			wantCode:      http.StatusOK,
+		},
+		{
+			name:          "funnel request with invalid token",
+			ctx:           funnelCtx,
+			authorization: "Bearer token-3",
+			wantCode:      http.StatusUnauthorized,
+		},
+	}
+	whoIs := func(context.Context, string) (*apitype.WhoIsResponse, error) {
+		return newTestWhoIs("alice@example.com"), nil
+	}
+	mw := funnelAuth(identifyByRemoteAddr(whoIs), FunnelAuthOptions{
+		Username: "bob",
+		Password: "secret",
+		Tokens:   []string{"token-1", "token-2"},
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUser, gotLogin, gotAuthorization string
+			h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser, _ = FunnelUserFromContext(r.Context())
+				if who, found := IdentityFromContext(r.Context()); found {
+					gotLogin = who.UserProfile.LoginName
+				}
+				gotAuthorization = r.Header.Get("Authorization")
+			}))
+			r := httptest.NewRequest("GET", "/", nil).WithContext(tt.ctx)
+			if tt.username != "" {
+				r.SetBasicAuth(tt.username, tt.password)
+			}
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got %d; want %d", w.Code, tt.wantCode)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("got no WWW-Authenticate header")
+			}
+			if gotUser != tt.wantUser {
+				t.Errorf("got user %q; want %q", gotUser, tt.wantUser)
+			}
+			if gotLogin != tt.wantLogin {
+				t.Errorf("got login %q; want %q", gotLogin, tt.wantLogin)
+			}
+			if gotAuthorization != "" {
+				t.Errorf("got Authorization header %q passed to the handler", gotAuthorization)
+			}
+		})
+	}
+}
+
+func TestFunnelAuthRequiresCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		opts FunnelAuthOptions
+	}{
+		{name: "no credentials", opts: FunnelAuthOptions{}},
+		{name: "username without password", opts: FunnelAuthOptions{Username: "bob"}},
+		{name: "empty token", opts: FunnelAuthOptions{Tokens: []string{""}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("got no panic")
+				}
+			}()
+			funnelAuth(nil, tt.opts)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+)
+
+func TestConnContext(t *testing.T) {
+	src := netip.MustParseAddrPort("203.0.113.1:1234")
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	tests := []struct {
+		name       string
+		conn       net.Conn
+		wantFunnel bool
+	}{
+		{
+			name:       "tailnet connection",
+			conn:       server,
+			wantFunnel: false,
+		},
+		{
+			name:       "funnel connection",
+			conn:       &ipn.FunnelConn{Conn: server, Src: src},
+			wantFunnel: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := ConnContext(context.Background(), tt.conn)
+			got, isFunnel := FunnelSourceFromContext(ctx)
+			if isFunnel != tt.wantFunnel {
+				t.Fatalf("got funnel %t; want %t", isFunnel, tt.wantFunnel)
+			}
+			if isFunnel && got != src {
+				t.Errorf("got source %s; want %s", got, src)
+			}
+		})
+	}
+}
+
+func TestRequireTailnet(t *testing.T) {
+	funnelConn := &ipn.FunnelConn{Src: netip.MustParseAddrPort("203.0.113.1:1234")}
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		wantCode int
+	}{
+		{
+			name:     "tailnet request",
+			ctx:      context.Background(),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "funnel request",
+			ctx:      ConnContext(context.Background(), funnelConn),
+			wantCode: http.StatusForbidden,
+		},
+	}
+	whoIs := func(context.Context, string) (*apitype.WhoIsResponse, error) {
+		return newTestWhoIs("alice@example.com"), nil
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, h := range []http.Handler{RequireTailnet(serveHandler()), withIdentity(identifyByRemoteAddr(whoIs), serveHandler())} {
+				r := httptest.NewRequest("GET", "/", nil).WithContext(tt.ctx)
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != tt.wantCode {
+					t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+				}
+			}
+		})
+	}
+}
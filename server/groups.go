@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale"
+)
+
+// groupsContextKey is the context key of the groups of the caller stored by
+// WithGroups.
+type groupsContextKey struct{}
+
+// GroupResolver resolves the groups of users.
+type GroupResolver interface {
+	// Groups returns the groups the user with the specified login name
+	// belongs to.
+	Groups(ctx context.Context, loginName string) ([]string, error)
+}
+
+// StaticGroups is a GroupResolver backed by a mapping of group names, such
+// as "group:admins", to the login names of their members. It has the same
+// shape as the groups section of the tailnet policy file.
+type StaticGroups map[string][]string
+
+// Groups returns the groups whose members include loginName.
+func (g StaticGroups) Groups(ctx context.Context, loginName string) ([]string, error) {
+	var groups []string
+	for group, members := range g {
+		if containsFold(members, loginName) {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// TailnetGroups is a GroupResolver which reads the groups from the tailnet
+// policy file using the Tailscale API. The policy file is cached and fetched
+// again once it is older than the refresh interval. Using the Tailscale API
+// requires tailscale.I_Acknowledge_This_API_Is_Unstable to be set.
+type TailnetGroups struct {
+	client  *tailscale.Client
+	refresh time.Duration
+
+	mu      sync.Mutex
+	groups  StaticGroups
+	fetched time.Time
+}
+
+// NewTailnetGroups creates a TailnetGroups using the specified Tailscale API
+// client. The policy file is fetched at most once per refresh interval.
+func NewTailnetGroups(client *tailscale.Client, refresh time.Duration) *TailnetGroups {
+	return &TailnetGroups{
+		client:  client,
+		refresh: refresh,
+	}
+}
+
+// Groups returns the groups of the tailnet policy file whose members include
+// loginName.
+func (g *TailnetGroups) Groups(ctx context.Context, loginName string) ([]string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.groups == nil || time.Since(g.fetched) >= g.refresh {
+		acl, err := g.client.ACL(ctx)
+		if err != nil {
+			if g.groups == nil {
+				return nil, fmt.Errorf("failed to fetch tailnet policy file: %w", err)
+			}
+			logf(slog.LevelWarn, "failed to refresh tailnet policy file; using cached groups: %v", err)
+		} else {
+			g.groups = StaticGroups(acl.ACL.Groups)
+			g.fetched = time.Now()
+		}
+	}
+	return g.groups.Groups(ctx, loginName)
+}
+
+// WithGroups wraps the provided handler and resolves the groups of the
+// caller using resolver. The groups are stored in the request context so
+// that Authorize and RequireGroups can evaluate group rules. The handler has
+// to be wrapped by Server.WithIdentity as well.
+func WithGroups(resolver GroupResolver, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who, found := IdentityFromContext(r.Context())
+		if !found || who.UserProfile == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		groups, err := resolver.Groups(r.Context(), who.UserProfile.LoginName)
+		if err != nil {
+			logf(slog.LevelError, "failed to resolve groups of [%s]: %v", who.UserProfile.LoginName, err)
+			http.Error(w, "failed to resolve groups of caller", http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(r.Context(), groupsContextKey{}, groups)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GroupsFromContext returns the groups of the caller stored in ctx by
+// WithGroups.
+func GroupsFromContext(ctx context.Context) ([]string, bool) {
+	groups, ok := ctx.Value(groupsContextKey{}).([]string)
+	return groups, ok
+}
+
+// InGroup reports whether the caller of the request belongs to the specified
+// group according to WithGroups.
+func InGroup(r *http.Request, group string) bool {
+	groups, _ := GroupsFromContext(r.Context())
+	return slices.ContainsFunc(groups, func(g string) bool { return strings.EqualFold(g, group) })
+}
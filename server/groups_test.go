@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestStaticGroups(t *testing.T) {
+	groups := StaticGroups{
+		"group:admins": {"alice@example.com"},
+		"group:devs":   {"alice@example.com", "bob@example.com"},
+	}
+	tests := []struct {
+		loginName string
+		want      []string
+	}{
+		{
+			loginName: "Alice@example.com",
+			want:      []string{"group:admins", "group:devs"},
+		},
+		{
+			loginName: "bob@example.com",
+			want:      []string{"group:devs"},
+		},
+		{
+			loginName: "mallory@example.com",
+			want:      nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.loginName, func(t *testing.T) {
+			got, err := groups.Groups(context.Background(), tt.loginName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireGroups(t *testing.T) {
+	resolver := StaticGroups{"group:admins": {"alice@example.com"}}
+	tests := []struct {
+		loginName string
+		wantCode  int
+	}{
+		{
+			loginName: "alice@example.com",
+			wantCode:  http.StatusOK,
+		},
+		{
+			loginName: "bob@example.com",
+			wantCode:  http.StatusForbidden,
+		},
+	}
+	h := WithGroups(resolver, RequireGroups([]string{"group:admins"}, serveHandler()))
+	for _, tt := range tests {
+		t.Run(tt.loginName, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r = r.WithContext(ContextWithIdentity(r.Context(), newTestWhoIs(tt.loginName)))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRequireGroupsWithoutGroups(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic")
+		}
+	}()
+	RequireGroups(nil, serveHandler())
+}
+
+func TestAuthorizeDenyGroup(t *testing.T) {
+	resolver := StaticGroups{"group:contractors": {"bob@example.com"}}
+	policy := &Policy{
+		Allow: []Rule{{Domains: []string{"example.com"}}},
+		Deny:  []Rule{{Groups: []string{"group:contractors"}}},
+	}
+	tests := []struct {
+		loginName string
+		wantCode  int
+	}{
+		{
+			loginName: "alice@example.com",
+			wantCode:  http.StatusOK,
+		},
+		{
+			loginName: "bob@example.com",
+			wantCode:  http.StatusForbidden,
+		},
+	}
+	h := WithGroups(resolver, Authorize(policy, serveHandler()))
+	for _, tt := range tests {
+		t.Run(tt.loginName, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r = r.WithContext(ContextWithIdentity(r.Context(), newTestWhoIs(tt.loginName)))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
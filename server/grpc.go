@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"tailscale.com/client/local"
+)
+
+// GRPCCredentials returns transport credentials which terminate TLS with the
+// certificate of this node. It can be passed to grpc.Creds for gRPC servers
+// that are not created with NewGRPCServer.
+func (s *Server) GRPCCredentials() credentials.TransportCredentials {
+	config := s.tlsConfig.Clone()
+	config.NextProtos = []string{"h2"}
+	return credentials.NewTLS(config)
+}
+
+// NewGRPCServer returns a gRPC server which terminates TLS with the
+// certificate of this node and looks up the identity of the caller of every
+// RPC with UnaryIdentityInterceptor and StreamIdentityInterceptor.
+func (s *Server) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.Creds(s.GRPCCredentials()),
+		grpc.ChainUnaryInterceptor(s.UnaryIdentityInterceptor()),
+		grpc.ChainStreamInterceptor(s.StreamIdentityInterceptor()),
+	}, opts...)
+	return grpc.NewServer(opts...)
+}
+
+// UnaryIdentityInterceptor returns an interceptor which looks up the identity
+// of the peer of every unary RPC, the gRPC counterpart of WithIdentity. The
+// identity can be retrieved with IdentityFromContext or
+// CallerIdentityFromContext. RPCs from unknown peers are rejected with
+// codes.PermissionDenied.
+func (s *Server) UnaryIdentityInterceptor() grpc.UnaryServerInterceptor {
+	return unaryIdentityInterceptor(s.whoIs, s.logger)
+}
+
+// StreamIdentityInterceptor returns an interceptor which looks up the
+// identity of the peer of every streaming RPC. See UnaryIdentityInterceptor.
+func (s *Server) StreamIdentityInterceptor() grpc.StreamServerInterceptor {
+	return streamIdentityInterceptor(s.whoIs, s.logger)
+}
+
+// UnaryAuthorizeInterceptor returns an interceptor which only admits unary
+// RPCs from callers authorized by the policy, the gRPC counterpart of
+// Authorize. Other RPCs are rejected with codes.PermissionDenied. It has to
+// be chained after UnaryIdentityInterceptor. The DeniedHandler of the policy
+// is not used.
+func UnaryAuthorizeInterceptor(policy *Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := authorizeRPC(ctx, policy, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthorizeInterceptor returns an interceptor which only admits
+// streaming RPCs from callers authorized by the policy. See
+// UnaryAuthorizeInterceptor.
+func StreamAuthorizeInterceptor(policy *Policy) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorizeRPC(ss.Context(), policy, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// authorizeRPC returns a status error unless the caller stored in ctx is
+// authorized by the policy.
+func authorizeRPC(ctx context.Context, policy *Policy, method string) error {
+	who, found := IdentityFromContext(ctx)
+	if !found {
+		logf(slog.LevelWarn, "denying RPC [%s] without caller identity; is the identity interceptor missing?", method)
+	}
+	groups, resolved := GroupsFromContext(ctx)
+	usesGroups := policy.usesGroups()
+	if found && usesGroups && !resolved {
+		logf(slog.LevelWarn, "denying RPC [%s] without caller groups", method)
+	}
+	if !found || (usesGroups && !resolved) || !policy.AllowedWithGroups(who, groups) {
+		return status.Error(codes.PermissionDenied, "caller is not authorized")
+	}
+	return nil
+}
+
+// ServeGRPC listens on the specified port of the tailnet and serves g until
+// it is stopped. The server g should be created with NewGRPCServer or use
+// GRPCCredentials as TLS is not added to the listener.
+func (s *Server) ServeGRPC(g *grpc.Server, port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid gRPC port [%d]: port must be between 1 and 65535", port)
+	}
+	listener, err := s.listen(fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port [%d]: %w", port, err)
+	}
+	return g.Serve(listener)
+}
+
+// unaryIdentityInterceptor returns an interceptor storing the identity of the
+// caller of unary RPCs in the context.
+func unaryIdentityInterceptor(whoIs whoIsFunc, logger *logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := grpcIdentity(ctx, whoIs, logger)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// streamIdentityInterceptor returns an interceptor storing the identity of
+// the caller of streaming RPCs in the context.
+func streamIdentityInterceptor(whoIs whoIsFunc, logger *logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := grpcIdentity(ss.Context(), whoIs, logger)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// grpcIdentity returns a copy of ctx carrying the identity of the peer of the
+// RPC.
+func grpcIdentity(ctx context.Context, whoIs whoIsFunc, logger *logger) (context.Context, error) {
+	if _, found := IdentityFromContext(ctx); found {
+		return ctx, nil
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil, status.Error(codes.Internal, "failed to get caller address")
+	}
+	who, err := whoIs(ctx, p.Addr.String())
+	if errors.Is(err, local.ErrPeerNotFound) {
+		return nil, status.Error(codes.PermissionDenied, "caller is not a known tailnet peer")
+	}
+	if err != nil {
+		logger.logf(slog.LevelError, "failed to get caller identity of [%s]: %v", p.Addr, err)
+		return nil, status.Error(codes.Internal, "failed to get caller identity")
+	}
+	return ContextWithIdentity(ctx, who), nil
+}
+
+// identityServerStream overrides the context of a server stream.
+type identityServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestNewGRPCServer(t *testing.T) {
+	alice := newTestWhoIs("alice@example.com")
+	tests := []struct {
+		name     string
+		who      *apitype.WhoIsResponse
+		err      error
+		wantCode codes.Code
+	}{
+		{name: "known peer", who: alice, wantCode: codes.OK},
+		{name: "unknown peer", err: local.ErrPeerNotFound, wantCode: codes.PermissionDenied},
+		{name: "lookup failure", err: net.ErrClosed, wantCode: codes.Internal},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Server{
+				tlsConfig: &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t)}},
+				whoIs: func(context.Context, string) (*apitype.WhoIsResponse, error) {
+					return test.who, test.err
+				},
+				logger: newLogger(t.Logf, slog.LevelInfo),
+			}
+			var got *apitype.WhoIsResponse
+			g := s.NewGRPCServer(grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				got, _ = IdentityFromContext(ctx)
+				return handler(ctx, req)
+			}))
+			healthpb.RegisterHealthServer(g, health.NewServer())
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go g.Serve(listener)
+			defer g.Stop()
+
+			creds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
+			conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(creds))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+			if code := status.Code(err); code != test.wantCode {
+				t.Fatalf("got code %v, want %v (err: %v)", code, test.wantCode, err)
+			}
+			if test.wantCode == codes.OK && got != alice {
+				t.Errorf("got identity %v, want %v", got, alice)
+			}
+		})
+	}
+}
+
+func TestUnaryAuthorizeInterceptor(t *testing.T) {
+	policy := &Policy{Allow: []Rule{{LoginNames: []string{"alice@example.com"}}}}
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		wantCode codes.Code
+	}{
+		{name: "allowed", ctx: ContextWithIdentity(context.Background(), newTestWhoIs("alice@example.com")), wantCode: codes.OK},
+		{name: "denied", ctx: ContextWithIdentity(context.Background(), newTestWhoIs("bob@example.com")), wantCode: codes.PermissionDenied},
+		{name: "without identity", ctx: context.Background(), wantCode: codes.PermissionDenied},
+	}
+	interceptor := UnaryAuthorizeInterceptor(policy)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			called := false
+			_, err := interceptor(test.ctx, nil, info, func(context.Context, any) (any, error) {
+				called = true
+				return nil, nil
+			})
+			if code := status.Code(err); code != test.wantCode {
+				t.Fatalf("got code %v, want %v", code, test.wantCode)
+			}
+			if called != (test.wantCode == codes.OK) {
+				t.Errorf("handler called = %v", called)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// HeaderIdentityProvider is a RequestIdentityProvider which trusts identity
+// headers set by a proxy in front of this server, such as "tailscale serve"
+// or another server wrapped by InjectIdentityHeaders. Only requests from
+// TrustedProxies are identified; requests from any other address are treated
+// as unknown peers.
+type HeaderIdentityProvider struct {
+	// TrustedProxies are the addresses of the proxies allowed to set identity
+	// headers.
+	TrustedProxies []netip.Prefix
+
+	// LoginHeader is the header carrying the login name of the caller. It
+	// defaults to HeaderTailscaleUserLogin.
+	LoginHeader string
+
+	// NameHeader is the header carrying the display name of the caller. It
+	// defaults to HeaderTailscaleUserName.
+	NameHeader string
+}
+
+// WhoIs returns an error as identity headers are only available on requests.
+func (p *HeaderIdentityProvider) WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	return nil, fmt.Errorf("identity of [%s] can only be read from request headers", remoteAddr)
+}
+
+// IdentifyRequest returns the identity in the headers of r if it comes from
+// a trusted proxy.
+func (p *HeaderIdentityProvider) IdentifyRequest(r *http.Request) (*apitype.WhoIsResponse, error) {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remote address [%s]: %w", r.RemoteAddr, err)
+	}
+	trusted := slices.ContainsFunc(p.TrustedProxies, func(prefix netip.Prefix) bool {
+		return prefix.Contains(addrPort.Addr().Unmap())
+	})
+	if !trusted {
+		return nil, local.ErrPeerNotFound
+	}
+
+	loginName := r.Header.Get(headerOrDefault(p.LoginHeader, HeaderTailscaleUserLogin))
+	if loginName == "" {
+		return nil, local.ErrPeerNotFound
+	}
+	return &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{},
+		UserProfile: &tailcfg.UserProfile{
+			LoginName:   loginName,
+			DisplayName: r.Header.Get(headerOrDefault(p.NameHeader, HeaderTailscaleUserName)),
+		},
+	}, nil
+}
+
+func headerOrDefault(header, defaultHeader string) string {
+	if header == "" {
+		return defaultHeader
+	}
+	return header
+}
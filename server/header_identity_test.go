@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/client/local"
+)
+
+func TestHeaderIdentityProvider(t *testing.T) {
+	provider := &HeaderIdentityProvider{
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		LoginHeader:    HeaderWebauthUser,
+	}
+	var _ RequestIdentityProvider = provider
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		login      string
+		wantLogin  string
+		wantErr    error
+	}{
+		{
+			name:       "trusted proxy",
+			remoteAddr: "100.64.0.1:1234",
+			login:      "alice@example.com",
+			wantLogin:  "alice@example.com",
+		},
+		{
+			name:       "untrusted address",
+			remoteAddr: "100.64.0.2:1234",
+			login:      "alice@example.com",
+			wantErr:    local.ErrPeerNotFound,
+		},
+		{
+			name:       "missing header",
+			remoteAddr: "100.64.0.1:1234",
+			wantErr:    local.ErrPeerNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.login != "" {
+				r.Header.Set(HeaderWebauthUser, tt.login)
+			}
+			who, err := provider.IdentifyRequest(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if err == nil && who.UserProfile.LoginName != tt.wantLogin {
+				t.Errorf("got login %q; want %q", who.UserProfile.LoginName, tt.wantLogin)
+			}
+		})
+	}
+}
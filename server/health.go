@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+// Health summarizes whether a server can serve requests.
+type Health struct {
+	// Healthy is true if the node is running on the tailnet and none of its
+	// certificates has expired.
+	Healthy bool `json:"healthy"`
+
+	// BackendState is the state of the node on the tailnet, such as
+	// "Running" or "NeedsLogin".
+	BackendState string `json:"backend_state"`
+
+	// Warnings are the current warnings of the health subsystem of
+	// Tailscale, such as a lost connection to the coordination server.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Certificates are the certificates served so far by domain.
+	Certificates []CertificateHealth `json:"certificates,omitempty"`
+}
+
+// CertificateHealth describes the validity of a certificate.
+type CertificateHealth struct {
+	Domain   string    `json:"domain"`
+	NotAfter time.Time `json:"not_after"`
+	Expired  bool      `json:"expired"`
+
+	// ExpiringSoon is true if the certificate expires within
+	// ServerConfig.CertificateExpiryWarningThreshold.
+	ExpiringSoon bool `json:"expiring_soon"`
+}
+
+// Health returns the state of the node on the tailnet, the warnings of the
+// health subsystem of Tailscale and the validity of the certificates of the
+// server, for programmatic checks. HealthHandler serves it. In local mode,
+// the backend state is "Local" and only the certificate is checked.
+func (s *Server) Health(ctx context.Context) (Health, error) {
+	health := Health{Healthy: true, BackendState: localBackendState}
+	if !s.localMode() {
+		status, err := s.tsClient.StatusWithoutPeers(ctx)
+		if err != nil {
+			return Health{}, err
+		}
+		health = Health{
+			Healthy:      status.BackendState == ipn.Running.String(),
+			BackendState: status.BackendState,
+			Warnings:     status.Health,
+		}
+	}
+	if s.certificates == nil {
+		return health, nil
+	}
+	now := time.Now()
+	notAfter := s.certificates.snapshot()
+	for _, domain := range slices.Sorted(maps.Keys(notAfter)) {
+		certificate := CertificateHealth{
+			Domain:       domain,
+			NotAfter:     notAfter[domain],
+			Expired:      !now.Before(notAfter[domain]),
+			ExpiringSoon: notAfter[domain].Sub(now) < s.certificates.threshold,
+		}
+		if certificate.Expired {
+			health.Healthy = false
+		}
+		health.Certificates = append(health.Certificates, certificate)
+	}
+	return health, nil
+}
+
+// HealthHandler returns a handler writing the Health of the server as JSON,
+// with status 200 if it is healthy and 503 otherwise, for liveness probes of
+// orchestrators. It is served for "GET /healthz" on the router of the server
+// if ServerConfig.HealthCheck is set, and stays reachable in maintenance
+// mode.
+func (s *Server) HealthHandler() http.Handler {
+	return healthHandler(s.Health)
+}
+
+func healthHandler(health func(ctx context.Context) (Health, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, err := health(r.Context())
+		w.Header().Set("Cache-Control", "no-store")
+		if err != nil {
+			logf(slog.LevelError, "failed to get health of node: %v", err)
+			http.Error(w, "failed to get health of node", http.StatusServiceUnavailable)
+			return
+		}
+		status := http.StatusOK
+		if !h.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, h)
+	})
+}
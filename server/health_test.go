@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestHealthServer(t *testing.T, backendState string) *Server {
+	localAPI := http.NewServeMux()
+	localAPI.HandleFunc("GET /localapi/v0/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"BackendState": backendState,
+			"Health":       []string{"not connected to home DERP region 1"},
+		})
+	})
+	logger := newLogger(t.Logf, slog.LevelInfo)
+	return &Server{
+		logger:       logger,
+		router:       newRouter(nil),
+		tsClient:     newTestLocalClient(localAPI),
+		certificates: newCertificateTracker(DefaultCertificateExpiryWarningThreshold, logger),
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s := newTestHealthServer(t, "Running")
+	s.certificates.record("web.prawn-universe.ts.net", time.Now().Add(60*24*time.Hour))
+	s.certificates.record("api.prawn-universe.ts.net", time.Now().Add(24*time.Hour))
+
+	health, err := s.Health(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Healthy || health.BackendState != "Running" || !slices.Equal(health.Warnings, []string{"not connected to home DERP region 1"}) {
+		t.Errorf("got health %+v", health)
+	}
+	if len(health.Certificates) != 2 {
+		t.Fatalf("got certificates %+v", health.Certificates)
+	}
+	if c := health.Certificates[0]; c.Domain != "api.prawn-universe.ts.net" || c.Expired || !c.ExpiringSoon {
+		t.Errorf("got certificate %+v; want api expiring soon", c)
+	}
+	if c := health.Certificates[1]; c.Domain != "web.prawn-universe.ts.net" || c.Expired || c.ExpiringSoon {
+		t.Errorf("got certificate %+v; want web valid", c)
+	}
+
+	s.certificates.record("api.prawn-universe.ts.net", time.Now().Add(-time.Minute))
+	if health, _ := s.Health(context.Background()); health.Healthy || !health.Certificates[0].Expired {
+		t.Errorf("got health %+v with an expired certificate", health)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		backendState string
+		wantCode     int
+	}{
+		{backendState: "Running", wantCode: http.StatusOK},
+		{backendState: "NeedsLogin", wantCode: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backendState, func(t *testing.T) {
+			s := newTestHealthServer(t, tt.backendState)
+			s.Handle("GET "+HealthCheckPath, nil, s.HealthHandler())
+			s.SetMaintenance(true, "")
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, httptest.NewRequest("GET", HealthCheckPath, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("got %d; want %d", w.Code, tt.wantCode)
+			}
+			var health Health
+			if err := json.NewDecoder(w.Body).Decode(&health); err != nil {
+				t.Fatal(err)
+			}
+			if health.BackendState != tt.backendState {
+				t.Errorf("got backend state %q; want %q", health.BackendState, tt.backendState)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+)
+
+// Hooks are functions a Server calls on events, such as for alerting or
+// bookkeeping. Every hook is optional and has to be safe for concurrent use,
+// and should return quickly as it runs on the path of the event.
+type Hooks struct {
+	// OnRequest is called after each request served by Server.Handler or
+	// Config.Handler. The caller is known if its identity has been looked up
+	// on the way, such as for a route with a policy.
+	OnRequest func(record AccessLogRecord)
+
+	// OnAuthDenied is called when a request served by Server.Handler or
+	// Config.Handler is rejected by the middleware of this package for its
+	// caller, such as by a policy, with the identity of the caller if it is
+	// known and the reason given to the caller.
+	OnAuthDenied func(r *http.Request, who *apitype.WhoIsResponse, reason string)
+
+	// OnListenerError is called when a listener on the tailnet cannot be
+	// created or fails to accept a connection, with its address such as
+	// ":443".
+	OnListenerError func(addr string, err error)
+
+	// OnTailnetStateChange is called with the state of the node on the
+	// tailnet once the server is up and whenever it changes, for example to
+	// ipn.NeedsLogin when the node key has expired.
+	OnTailnetStateChange func(state ipn.State)
+}
+
+// hooksContextKey is the context key of the Hooks of the server serving a
+// request.
+type hooksContextKey struct{}
+
+// withHooks wraps the provided handler and calls the request hooks for its
+// requests.
+func withHooks(hooks *Hooks, h http.Handler) http.Handler {
+	if hooks.OnRequest == nil && hooks.OnAuthDenied == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, found := r.Context().Value(hooksContextKey{}).(*Hooks); found {
+			// Config.Handler wrapping Server.Handler calls the hooks once
+			h.ServeHTTP(w, r)
+			return
+		}
+		r, info := withRequestInfo(r.WithContext(context.WithValue(r.Context(), hooksContextKey{}, hooks)))
+		if hooks.OnRequest == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		recorder := newStatusRecorder(w)
+		defer func() {
+			who := info.caller
+			if who == nil {
+				who, _ = IdentityFromContext(r.Context())
+			}
+			hooks.OnRequest(newAccessLogRecord(r, recorder, start, who))
+		}()
+		h.ServeHTTP(recorder, r)
+	})
+}
+
+// authDenied calls the OnAuthDenied hook of the server serving the request,
+// if any, for a request rejected for reason.
+func authDenied(r *http.Request, reason string) {
+	hooks, found := r.Context().Value(hooksContextKey{}).(*Hooks)
+	if !found || hooks.OnAuthDenied == nil {
+		return
+	}
+	who, _ := IdentityFromContext(r.Context())
+	hooks.OnAuthDenied(r, who, reason)
+}
+
+// listenerError calls the OnListenerError hook, if any.
+func (s *Server) listenerError(addr string, err error) {
+	if s.hooks.OnListenerError != nil {
+		s.hooks.OnListenerError(addr, err)
+	}
+}
+
+// watchTailnetState calls onChange with the state of the node and whenever it
+// changes. It returns when ctx is cancelled.
+func watchTailnetState(ctx context.Context, client *local.Client, onChange func(ipn.State), logger *logger) {
+	var last ipn.State = -1
+	for ctx.Err() == nil {
+		if err := watchStateChanges(ctx, client, &last, onChange); err != nil && ctx.Err() == nil {
+			logger.logf(slog.LevelError, "failed to watch tailnet state: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+func watchStateChanges(ctx context.Context, client *local.Client, last *ipn.State, onChange func(ipn.State)) error {
+	watcher, err := client.WatchIPNBus(ctx, ipn.NotifyInitialState)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = watcher.Close() }()
+	for {
+		n, err := watcher.Next()
+		if err != nil {
+			return err
+		}
+		if n.State == nil || *n.State == *last {
+			continue
+		}
+		*last = *n.State
+		onChange(*n.State)
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+)
+
+func TestHooksRequests(t *testing.T) {
+	identities := map[string]*apitype.WhoIsResponse{
+		"100.64.0.1:1234": newTestWhoIs("alice@example.com"),
+		"100.64.0.2:1234": newTestWhoIs("bob@example.com"),
+	}
+	identify := func(r *http.Request) (*apitype.WhoIsResponse, error) {
+		return identities[r.RemoteAddr], nil
+	}
+	var records []AccessLogRecord
+	var denied []string
+	s := &Server{
+		logger:   newLogger(t.Logf, slog.LevelInfo),
+		identify: identify,
+		router:   newRouter(identify),
+		hooks: Hooks{
+			OnRequest: func(record AccessLogRecord) {
+				records = append(records, record)
+			},
+			OnAuthDenied: func(r *http.Request, who *apitype.WhoIsResponse, reason string) {
+				denied = append(denied, who.UserProfile.LoginName+": "+reason)
+			},
+		},
+	}
+	s.Handle("/admin/", &Policy{Allow: []Rule{{LoginNames: []string{"alice@example.com"}}}}, serveHandler())
+	// Config.Handler around Server.Handler calls the hooks once
+	h := new(Config).Handler(s, s.Handler())
+
+	for _, remoteAddr := range []string{"100.64.0.1:1234", "100.64.0.2:1234"} {
+		r := httptest.NewRequest("GET", "/admin/users", nil)
+		r.RemoteAddr = remoteAddr
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("got %d records; want 2", len(records))
+	}
+	for i, want := range []struct {
+		login  string
+		status int
+	}{
+		{login: "alice@example.com", status: http.StatusOK},
+		{login: "bob@example.com", status: http.StatusForbidden},
+	} {
+		if records[i].LoginName != want.login || records[i].Status != want.status || records[i].Path != "/admin/users" {
+			t.Errorf("got record %+v; want %s with status %d", records[i], want.login, want.status)
+		}
+	}
+	if !slices.Equal(denied, []string{"bob@example.com: denied by policy"}) {
+		t.Errorf("got denied callers %q", denied)
+	}
+}
+
+// failingListener fails to accept connections with err.
+type failingListener struct {
+	net.Listener
+	err error
+}
+
+func (l failingListener) Accept() (net.Conn, error) {
+	return nil, l.err
+}
+
+func TestHooksListenerError(t *testing.T) {
+	var errs []string
+	tracker := connectionTracker{onError: func(addr string, err error) {
+		errs = append(errs, addr+": "+err.Error())
+	}}
+	listener := tracker.track(":443", failingListener{err: errors.New("too many open files")})
+	if _, err := listener.Accept(); err == nil {
+		t.Fatal("got no error")
+	}
+	closed := tracker.track(":8443", failingListener{err: net.ErrClosed})
+	if _, err := closed.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("got error %v; want %v", err, net.ErrClosed)
+	}
+	if !slices.Equal(errs, []string{":443: too many open files"}) {
+		t.Errorf("got errors %q", errs)
+	}
+}
+
+func TestWatchTailnetState(t *testing.T) {
+	localAPI := http.NewServeMux()
+	localAPI.HandleFunc("GET /localapi/v0/watch-ipn-bus", func(w http.ResponseWriter, r *http.Request) {
+		enc := json.NewEncoder(w)
+		for _, state := range []ipn.State{ipn.Starting, ipn.Starting, ipn.Running} {
+			_ = enc.Encode(ipn.Notify{State: &state})
+			_ = enc.Encode(ipn.Notify{Version: "1.92.5"})
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var states []ipn.State
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchTailnetState(ctx, newTestLocalClient(localAPI), func(state ipn.State) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, state)
+			if len(states) == 2 {
+				cancel()
+			}
+		}, newLogger(t.Logf, slog.LevelInfo))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher did not return")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(states, []ipn.State{ipn.Starting, ipn.Running}) {
+		t.Errorf("got states %v", states)
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/util/dnsname"
+)
+
+// DefaultHSTSMaxAge is the max-age of the Strict-Transport-Security header if
+// HSTSOptions.MaxAge is not set.
+const DefaultHSTSMaxAge = 365 * 24 * time.Hour
+
+// HSTSOptions configures the Strict-Transport-Security header.
+type HSTSOptions struct {
+	// MaxAge is how long browsers remember to only use HTTPS. It defaults to
+	// DefaultHSTSMaxAge.
+	MaxAge time.Duration
+
+	// IncludeSubDomains applies the policy to the subdomains of the host.
+	IncludeSubDomains bool
+
+	// Preload allows the host to be included in the HSTS preload lists of
+	// browsers, which requires IncludeSubDomains and a MaxAge of at least a
+	// year.
+	Preload bool
+
+	// Hosts, if set, are the only host names the header is sent for.
+	// Otherwise, it is sent for every fully qualified domain name but not
+	// for short names, such as MagicDNS names without the tailnet suffix,
+	// nor for IP addresses.
+	Hosts []string
+}
+
+// HSTS wraps the provided handler and sets the Strict-Transport-Security
+// header with the default options on responses to requests which arrived over
+// TLS on a fully qualified domain name.
+func HSTS(h http.Handler) http.Handler {
+	return HSTSWithOptions(HSTSOptions{})(h)
+}
+
+// HSTSWithOptions returns a middleware setting the Strict-Transport-Security
+// header configured by opts on responses to requests which arrived over TLS.
+// Browsers ignore the header on plaintext responses, and sending it on a name
+// which cannot have a certificate would lock them out of it.
+func HSTSWithOptions(opts HSTSOptions) Middleware {
+	value := hstsHeaderValue(opts)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil && hstsHost(opts.Hosts, r.Host) {
+				w.Header().Set("Strict-Transport-Security", value)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HSTS returns a middleware setting the Strict-Transport-Security header
+// configured by opts on responses to requests which arrived over TLS on the
+// FQDN of the node or another domain of its certificates. See
+// HSTSWithOptions.
+func (s *Server) HSTS(opts HSTSOptions) Middleware {
+	if len(opts.Hosts) == 0 {
+		opts.Hosts = append([]string{s.fqdn}, s.certDomains...)
+	}
+	return HSTSWithOptions(opts)
+}
+
+func hstsHeaderValue(opts HSTSOptions) string {
+	maxAge := opts.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultHSTSMaxAge
+	}
+	value := fmt.Sprintf("max-age=%d", int64(maxAge/time.Second))
+	if opts.IncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if opts.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// hstsHost reports whether the header is sent for requests to host, which may
+// have a port.
+func hstsHost(hosts []string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+	if len(hosts) > 0 {
+		return slices.ContainsFunc(hosts, func(allowed string) bool {
+			return strings.EqualFold(strings.TrimSuffix(allowed, "."), host)
+		})
+	}
+	if net.ParseIP(host) != nil {
+		return false
+	}
+	fqdn, err := dnsname.ToFQDN(host)
+	return err == nil && fqdn.NumLabels() > 1
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHSTS(t *testing.T) {
+	tests := []struct {
+		host       string
+		tls        bool
+		expectHsts bool
+	}{
+		{host: "test-hostname", tls: true, expectHsts: false},
+		{host: "test-hostname.prawn-universe.ts.net", tls: true, expectHsts: true},
+		{host: "test-hostname.prawn-universe.ts.net:8443", tls: true, expectHsts: true},
+		{host: "test-hostname.prawn-universe.ts.net", tls: false, expectHsts: false},
+		{host: "100.64.0.1", tls: true, expectHsts: false},
+	}
+	for _, tt := range tests {
+		name := "host:[" + tt.host + "]"
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Host = tt.host
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			w := httptest.NewRecorder()
+			HSTS(serveHandler()).ServeHTTP(w, r)
+			_, found := w.Header()["Strict-Transport-Security"]
+			if found != tt.expectHsts {
+				t.Errorf("HSTS expectation: domain %s want: %t got: %t", tt.host, tt.expectHsts, found)
+			}
+		})
+	}
+}
+
+func TestHSTSWithOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts HSTSOptions
+		host string
+		want string
+	}{
+		{
+			name: "defaults",
+			host: "test-hostname.prawn-universe.ts.net",
+			want: "max-age=31536000",
+		},
+		{
+			name: "all options",
+			opts: HSTSOptions{MaxAge: 2 * 365 * 24 * time.Hour, IncludeSubDomains: true, Preload: true},
+			host: "test-hostname.prawn-universe.ts.net",
+			want: "max-age=63072000; includeSubDomains; preload",
+		},
+		{
+			name: "node FQDN",
+			opts: HSTSOptions{MaxAge: time.Hour, Hosts: []string{"test-hostname.prawn-universe.ts.net"}},
+			host: "TEST-HOSTNAME.prawn-universe.ts.net",
+			want: "max-age=3600",
+		},
+		{
+			name: "other host",
+			opts: HSTSOptions{Hosts: []string{"test-hostname.prawn-universe.ts.net"}},
+			host: "other.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Host = tt.host
+			r.TLS = &tls.ConnectionState{}
+			w := httptest.NewRecorder()
+			HSTSWithOptions(tt.opts)(serveHandler()).ServeHTTP(w, r)
+			if got := w.Header().Get("Strict-Transport-Security"); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
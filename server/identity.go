@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// identityContextKey is the context key of the caller identity stored by
+// WithIdentity.
+type identityContextKey struct{}
+
+// IdentityProvider looks up the identity of callers. The Tailscale local
+// client is used by default. Alternative implementations can be set in
+// ServerConfig.IdentityProvider, for example a fake provider in unit tests.
+type IdentityProvider interface {
+	// WhoIs returns the identity of the owner of remoteAddr, which is an IP
+	// address or IP:port. It returns local.ErrPeerNotFound if the owner is
+	// unknown.
+	WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+}
+
+// RequestIdentityProvider is an IdentityProvider which identifies callers of
+// HTTP requests by the request rather than by its remote address, for
+// example by headers set by a trusted proxy. WithIdentity and
+// GetCallerIdentity use IdentifyRequest if the provider implements it.
+type RequestIdentityProvider interface {
+	IdentityProvider
+
+	// IdentifyRequest returns the identity of the caller of r.
+	IdentifyRequest(r *http.Request) (*apitype.WhoIsResponse, error)
+}
+
+// IdentityProviderFunc is an adapter to use an ordinary function as an
+// IdentityProvider.
+type IdentityProviderFunc func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+
+// WhoIs calls f(ctx, remoteAddr).
+func (f IdentityProviderFunc) WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	return f(ctx, remoteAddr)
+}
+
+// whoIsFunc looks up the identity of the owner of the specified address.
+type whoIsFunc func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+
+// identifyFunc looks up the identity of the caller of a request.
+type identifyFunc func(r *http.Request) (*apitype.WhoIsResponse, error)
+
+// identifyByRemoteAddr returns an identifyFunc which looks up callers by the
+// remote address of requests.
+func identifyByRemoteAddr(whoIs whoIsFunc) identifyFunc {
+	return func(r *http.Request) (*apitype.WhoIsResponse, error) {
+		ctx, span := startChildSpan(r.Context(), "tailscale.WhoIs", trace.SpanKindClient,
+			attribute.String("client.address", remoteIP(r)))
+		defer span.End()
+		who, err := whoIs(ctx, r.RemoteAddr)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return who, err
+	}
+}
+
+// identifyWith returns an identifyFunc using IdentifyRequest of provider if
+// it is a RequestIdentityProvider, or its WhoIs with the remote address of
+// requests otherwise.
+func identifyWith(provider IdentityProvider) identifyFunc {
+	if requestIdentityProvider, ok := provider.(RequestIdentityProvider); ok {
+		return requestIdentityProvider.IdentifyRequest
+	}
+	return identifyByRemoteAddr(provider.WhoIs)
+}
+
+// WithIdentity wraps the provided handler and looks up the identity of the
+// caller once per request. The identity is stored in the request context and
+// can be retrieved with IdentityFromContext. Requests from unknown peers and
+// requests over Tailscale Funnel are rejected with status 403.
+func (s *Server) WithIdentity(h http.Handler) http.Handler {
+	return withIdentity(s.identify, h)
+}
+
+// withIdentity wraps the provided handler and stores the identity returned by
+// identify in the request context.
+func withIdentity(identify identifyFunc, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, found := IdentityFromContext(r.Context()); found {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if IsFunnelRequest(r) {
+			authDenied(r, "funnel request without tailnet identity")
+			http.Error(w, ErrFunnelRequest.Error(), http.StatusForbidden)
+			return
+		}
+		who, err := identify(r)
+		if errors.Is(err, local.ErrPeerNotFound) {
+			authDenied(r, "unknown tailnet peer")
+			http.Error(w, "caller is not a known tailnet peer", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			logf(slog.LevelError, "failed to get caller identity of [%s] for request%s: %v", r.RemoteAddr, requestIDLabel(r.Context()), err)
+			http.Error(w, "failed to get caller identity", http.StatusInternalServerError)
+			return
+		}
+		trace.SpanFromContext(r.Context()).SetAttributes(identityAttributes(who)...)
+		recordCaller(r, who)
+		h.ServeHTTP(w, r.WithContext(ContextWithIdentity(r.Context(), who)))
+	})
+}
+
+// ContextWithIdentity returns a copy of ctx carrying the specified caller
+// identity.
+func ContextWithIdentity(ctx context.Context, who *apitype.WhoIsResponse) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, who)
+}
+
+// IdentityFromContext returns the caller identity stored in ctx by
+// WithIdentity.
+func IdentityFromContext(ctx context.Context) (*apitype.WhoIsResponse, bool) {
+	who, ok := ctx.Value(identityContextKey{}).(*apitype.WhoIsResponse)
+	return who, ok && who != nil
+}
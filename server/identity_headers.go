@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+)
+
+// Headers set by InjectIdentityHeaders. They follow the headers set by
+// "tailscale serve" and the auth proxy headers of Grafana and Gitea.
+const (
+	HeaderTailscaleUserLogin      = "Tailscale-User-Login"
+	HeaderTailscaleUserName       = "Tailscale-User-Name"
+	HeaderTailscaleUserProfilePic = "Tailscale-User-Profile-Pic"
+	HeaderWebauthUser             = "X-Webauth-User"
+)
+
+// identityHeaders are the headers which are removed from inbound requests by
+// InjectIdentityHeaders so that callers cannot impersonate other users.
+var identityHeaders = []string{
+	HeaderTailscaleUserLogin,
+	HeaderTailscaleUserName,
+	HeaderTailscaleUserProfilePic,
+	HeaderWebauthUser,
+}
+
+// InjectIdentityHeaders wraps the provided handler, typically a reverse proxy
+// to an application such as Grafana or Gitea, and replaces the identity
+// headers of requests with the identity of the caller. Headers supplied by
+// the caller are always removed. Requests from tagged nodes are forwarded
+// without identity headers as they are not owned by a user. The handler has
+// to be wrapped by Server.WithIdentity as well.
+func InjectIdentityHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+		setIdentityHeaders(r)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// setIdentityHeaders replaces the identity headers of r with the identity of
+// the caller in its context.
+func setIdentityHeaders(r *http.Request) {
+	for _, header := range identityHeaders {
+		r.Header.Del(header)
+	}
+	who, found := IdentityFromContext(r.Context())
+	if found && who.Node != nil && !who.Node.IsTagged() && who.UserProfile != nil {
+		r.Header.Set(HeaderTailscaleUserLogin, who.UserProfile.LoginName)
+		r.Header.Set(HeaderTailscaleUserName, who.UserProfile.DisplayName)
+		if who.UserProfile.ProfilePicURL != "" {
+			r.Header.Set(HeaderTailscaleUserProfilePic, who.UserProfile.ProfilePicURL)
+		}
+		r.Header.Set(HeaderWebauthUser, who.UserProfile.LoginName)
+	}
+}
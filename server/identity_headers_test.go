@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestInjectIdentityHeaders(t *testing.T) {
+	alice := newTestWhoIs("alice@example.com")
+	alice.UserProfile.DisplayName = "Alice"
+	tagged := newTestWhoIs("tagged-devices")
+	tagged.Node.Tags = []string{"tag:ci"}
+
+	tests := []struct {
+		name      string
+		who       *apitype.WhoIsResponse
+		wantLogin string
+		wantName  string
+	}{
+		{
+			name:      "user node",
+			who:       alice,
+			wantLogin: "alice@example.com",
+			wantName:  "Alice",
+		},
+		{
+			name: "tagged node",
+			who:  tagged,
+		},
+		{
+			name: "no identity",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			h := InjectIdentityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set(HeaderTailscaleUserLogin, "mallory@example.com")
+			r.Header.Set(HeaderWebauthUser, "mallory@example.com")
+			if tt.who != nil {
+				r = r.WithContext(ContextWithIdentity(r.Context(), tt.who))
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if login := got.Get(HeaderTailscaleUserLogin); login != tt.wantLogin {
+				t.Errorf("got %s %q; want %q", HeaderTailscaleUserLogin, login, tt.wantLogin)
+			}
+			if user := got.Get(HeaderWebauthUser); user != tt.wantLogin {
+				t.Errorf("got %s %q; want %q", HeaderWebauthUser, user, tt.wantLogin)
+			}
+			if name := got.Get(HeaderTailscaleUserName); name != tt.wantName {
+				t.Errorf("got %s %q; want %q", HeaderTailscaleUserName, name, tt.wantName)
+			}
+		})
+	}
+}
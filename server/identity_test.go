@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+func newTestWhoIs(loginName string) *apitype.WhoIsResponse {
+	return &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "test-node.prawn-universe.ts.net."},
+		UserProfile: &tailcfg.UserProfile{LoginName: loginName},
+	}
+}
+
+func TestWithIdentity(t *testing.T) {
+	alice := newTestWhoIs("alice@example.com")
+	tests := []struct {
+		name      string
+		who       *apitype.WhoIsResponse
+		err       error
+		wantCode  int
+		wantLogin string
+	}{
+		{
+			name:      "known peer",
+			who:       alice,
+			wantCode:  http.StatusOK,
+			wantLogin: "alice@example.com",
+		},
+		{
+			name:     "unknown peer",
+			err:      local.ErrPeerNotFound,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "lookup failure",
+			err:      errors.New("connection refused"),
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			whoIs := func(context.Context, string) (*apitype.WhoIsResponse, error) {
+				calls++
+				return tt.who, tt.err
+			}
+			var gotLogin string
+			h := withIdentity(identifyByRemoteAddr(whoIs), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				who, found := IdentityFromContext(r.Context())
+				if !found {
+					t.Fatal("identity is not found in context")
+				}
+				gotLogin = who.UserProfile.LoginName
+			}))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+			if gotLogin != tt.wantLogin {
+				t.Errorf("got login %q; want %q", gotLogin, tt.wantLogin)
+			}
+			if calls != 1 {
+				t.Errorf("got %d lookups; want 1", calls)
+			}
+		})
+	}
+}
+
+func TestIdentityFromContext(t *testing.T) {
+	if _, found := IdentityFromContext(context.Background()); found {
+		t.Error("identity is found in empty context")
+	}
+	who := newTestWhoIs("alice@example.com")
+	got, found := IdentityFromContext(ContextWithIdentity(context.Background(), who))
+	if !found || got != who {
+		t.Errorf("got %v, %t; want %v, true", got, found, who)
+	}
+}
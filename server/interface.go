@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// Interface is the part of Server applications build on: listening, serving
+// routes, the FQDN of the node and the identity of callers. Applications
+// depending on Interface rather than *Server can be unit tested with
+// servertest.Fake without a tailnet.
+type Interface interface {
+	// Listen starts listening on the specified HTTPS ports. See
+	// Server.Listen.
+	Listen(httpsPorts []int) (listeners []net.Listener, nonHTTPSListener net.Listener, nonHTTPSHandler http.Handler, err error)
+
+	// Handle registers the handler for the specified pattern with the
+	// specified policy. See Router.Handle.
+	Handle(pattern string, policy *Policy, h http.Handler, middlewares ...Middleware)
+
+	// HandleFunc registers the handler function for the specified pattern
+	// with the specified policy.
+	HandleFunc(pattern string, policy *Policy, h func(http.ResponseWriter, *http.Request), middlewares ...Middleware)
+
+	// Use adds middlewares to the routes registered afterwards. See
+	// Router.Use.
+	Use(middlewares ...Middleware)
+
+	// Handler returns the handler of the registered routes, to be served on
+	// the listeners returned by Listen.
+	Handler() http.Handler
+
+	// FQDN returns the fully qualified domain name of the node, such as
+	// "web.prawn-universe.ts.net".
+	FQDN() string
+
+	// GetCallerIdentity returns the identity of the caller of the request.
+	GetCallerIdentity(r *http.Request) (*CallerIdentity, error)
+
+	// GetCallerIdentityFromRemoteIPAddress returns the identity of the owner
+	// of the specified IP address.
+	GetCallerIdentityFromRemoteIPAddress(ctx context.Context, ipAddress string) (*apitype.WhoIsResponse, error)
+
+	// WithIdentity wraps the provided handler and stores the identity of
+	// the caller in the request context. See Server.WithIdentity.
+	WithIdentity(h http.Handler) http.Handler
+
+	// Close stops the node.
+	Close() error
+}
+
+var _ Interface = (*Server)(nil)
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/ipn"
+)
+
+func TestAllowIPs(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowed    []string
+		remoteAddr string
+		ctx        context.Context
+		wantCode   int
+	}{
+		{
+			name:       "tailscale range",
+			allowed:    []string{TailscaleIPv4Range, TailscaleIPv6Range},
+			remoteAddr: "100.101.102.103:1234",
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:       "tailscale ipv6 range",
+			allowed:    []string{TailscaleIPv4Range, TailscaleIPv6Range},
+			remoteAddr: "[fd7a:115c:a1e0::1]:1234",
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:       "outside range",
+			allowed:    []string{TailscaleIPv4Range},
+			remoteAddr: "192.168.1.10:1234",
+			wantCode:   http.StatusForbidden,
+		},
+		{
+			name:       "specific node",
+			allowed:    []string{"100.101.102.103"},
+			remoteAddr: "100.101.102.103:1234",
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:       "other node",
+			allowed:    []string{"100.101.102.103"},
+			remoteAddr: "100.101.102.104:1234",
+			wantCode:   http.StatusForbidden,
+		},
+		{
+			name:       "ipv4-mapped address",
+			allowed:    []string{"100.101.102.103"},
+			remoteAddr: "[::ffff:100.101.102.103]:1234",
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:       "funnel request checked by public address",
+			allowed:    []string{TailscaleIPv4Range},
+			remoteAddr: "100.101.102.103:1234",
+			ctx:        ConnContext(context.Background(), &ipn.FunnelConn{Src: netip.MustParseAddrPort("203.0.113.1:1234")}),
+			wantCode:   http.StatusForbidden,
+		},
+		{
+			name:       "unparsable address",
+			allowed:    []string{TailscaleIPv4Range},
+			remoteAddr: "pipe",
+			wantCode:   http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := AllowIPs(tt.allowed...)(serveHandler())
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.ctx != nil {
+				r = r.WithContext(tt.ctx)
+			}
+			r.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAllowIPsInvalidEntry(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic")
+		}
+	}()
+	AllowIPs("100.64.0.0/33")
+}
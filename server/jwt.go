@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// jwtKeySize is the size of RSA keys generated for signing tokens.
+const jwtKeySize = 2048
+
+// errInvalidToken is returned when a token is malformed or its signature
+// cannot be verified.
+var errInvalidToken = errors.New("invalid token")
+
+// jwtSigner signs and verifies JSON web tokens with RS256, the algorithm all
+// OpenID Connect relying parties support.
+type jwtSigner struct {
+	key   *rsa.PrivateKey
+	keyID string
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// jsonWebKey is the public part of a RSA signing key as defined by RFC 7517.
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// newJWTSigner creates a jwtSigner using key or a newly generated key if key
+// is nil. The key ID is derived from the public key.
+func newJWTSigner(key *rsa.PrivateKey) (*jwtSigner, error) {
+	if key == nil {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, jwtKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+	}
+	fingerprint := sha256.Sum256(key.N.Bytes())
+	return &jwtSigner{
+		key:   key,
+		keyID: base64.RawURLEncoding.EncodeToString(fingerprint[:8]),
+	}, nil
+}
+
+// sign returns a signed token carrying claims.
+func (s *jwtSigner) sign(claims any) (string, error) {
+	header, err := json.Marshal(jwtHeader{Algorithm: "RS256", Type: "JWT", KeyID: s.keyID})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token header: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token claims: %w", err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// verify verifies the signature of token and decodes its claims into
+// claims. Validating the claims, such as the expiry, is up to the caller.
+func (s *jwtSigner) verify(token string, claims any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errInvalidToken
+	}
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return errInvalidToken
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerData, &header); err != nil || header.Algorithm != "RS256" || header.KeyID != s.keyID {
+		return errInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&s.key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return errInvalidToken
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return errInvalidToken
+	}
+	return nil
+}
+
+// jwks returns the key set for verifying tokens signed by s.
+func (s *jwtSigner) jwks() jsonWebKeySet {
+	return jsonWebKeySet{
+		Keys: []jsonWebKey{
+			{
+				KeyType:   "RSA",
+				Use:       "sig",
+				Algorithm: "RS256",
+				KeyID:     s.keyID,
+				Modulus:   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+				Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+			},
+		},
+	}
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"fmt"
+
+	"tailscale.com/ipn/store/kubestore"
+)
+
+// NewKubernetesSecretStore creates an ipn.StateStore keeping the node state in
+// the Kubernetes Secret with the specified name, so pods keep their node
+// identity across restarts without a PersistentVolume. It is the store of
+// tailscaled in Kubernetes, which finds the API server, the namespace and the
+// credentials from the service account of the pod the process runs in. The
+// service account needs permission to get, create and patch the Secret. The
+// messages of the store are written to logf, or discarded if it is nil.
+func NewKubernetesSecretStore(name string, logf func(format string, args ...any)) (*kubestore.Store, error) {
+	if name == "" {
+		return nil, fmt.Errorf("kubernetes secret name cannot be empty")
+	}
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+	store, err := kubestore.New(logf, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state store of kubernetes secret [%s]: %w", name, err)
+	}
+	return store, nil
+}
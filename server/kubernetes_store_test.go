@@ -0,0 +1,27 @@
+package server
+
+import (
+	"testing"
+
+	"tailscale.com/kube/kubeclient"
+)
+
+func TestNewKubernetesSecretStore(t *testing.T) {
+	kubeclient.SetRootPathForTesting(t.TempDir())
+	defer kubeclient.SetRootPathForTesting("")
+
+	tests := []struct {
+		name       string
+		secretName string
+	}{
+		{name: "empty name", secretName: ""},
+		{name: "not in a cluster", secretName: "web-tailscale-state"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKubernetesSecretStore(tt.secretName, t.Logf); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
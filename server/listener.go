@@ -0,0 +1,177 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// listen listens on the specified address on the tailnet, or at the local
+// address in local mode, records its port for the status page and tracks the
+// connections accepted from the listener.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	var listener net.Listener
+	var err error
+	if s.localMode() {
+		listener, err = s.listenLocal(addr)
+	} else {
+		listener, err = s.tsServer.Listen(Protocol, addr)
+	}
+	if err != nil {
+		s.listenerError(addr, err)
+		return nil, err
+	}
+	if _, port, err := net.SplitHostPort(addr); err == nil {
+		if port, err := strconv.Atoi(port); err == nil {
+			s.recordListeningPorts(port)
+		}
+	}
+	return s.connections.track(addr, listener), nil
+}
+
+// ListenerStats counts the connections of a listener.
+type ListenerStats struct {
+	// Accepted is the number of connections accepted since the server
+	// started.
+	Accepted int64 `json:"accepted"`
+	// Active is the number of connections which are open.
+	Active int64 `json:"active"`
+	// Closed is the number of connections accepted and closed since.
+	Closed int64 `json:"closed"`
+}
+
+// Stats holds the traffic counters of a server.
+type Stats struct {
+	// Listeners are the connection counts by listening address, such as
+	// ":443", of the tailnet listeners created by Listen and its variants.
+	// Listeners on Tailscale Funnel are not tracked.
+	Listeners map[string]ListenerStats `json:"listeners"`
+}
+
+// Stats returns the traffic counters of the server, for example to tell
+// which ports carry traffic. They are exported by MetricsHandler as well.
+func (s *Server) Stats() Stats {
+	return Stats{Listeners: s.connections.stats()}
+}
+
+// connectionTracker counts the connections of the listeners of a server by
+// listening address.
+type connectionTracker struct {
+	mu        sync.Mutex
+	counts    map[string]*connectionCounts
+	listeners []net.Listener
+
+	// onError, if set, is called when a listener fails to accept a
+	// connection.
+	onError func(addr string, err error)
+}
+
+// connectionCounts counts the connections of the listeners of an address.
+type connectionCounts struct {
+	accepted atomic.Int64
+	closed   atomic.Int64
+}
+
+func (c *connectionCounts) stats() ListenerStats {
+	// loading closed first keeps active from going negative
+	closed := c.closed.Load()
+	accepted := c.accepted.Load()
+	return ListenerStats{Accepted: accepted, Active: accepted - closed, Closed: closed}
+}
+
+// track wraps the listener so that its connections are counted under addr.
+func (t *connectionTracker) track(addr string, listener net.Listener) net.Listener {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.counts == nil {
+		t.counts = make(map[string]*connectionCounts)
+	}
+	counts, found := t.counts[addr]
+	if !found {
+		counts = new(connectionCounts)
+		t.counts[addr] = counts
+	}
+	tracked := &trackedListener{Listener: listener, addr: addr, counts: counts, onError: t.onError}
+	t.listeners = append(t.listeners, tracked)
+	return tracked
+}
+
+// closeListeners closes the tracked listeners so that no more connections
+// are accepted. Their open connections are left alone.
+func (t *connectionTracker) closeListeners() {
+	t.mu.Lock()
+	listeners := t.listeners
+	t.listeners = nil
+	t.mu.Unlock()
+	for _, listener := range listeners {
+		// listeners closed by their owners already fail again
+		_ = listener.Close()
+	}
+}
+
+// active returns the number of open connections of all listeners.
+func (t *connectionTracker) active() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var active int64
+	for _, counts := range t.counts {
+		active += counts.stats().Active
+	}
+	return active
+}
+
+// stats returns the connection counts by listening address.
+func (t *connectionTracker) stats() map[string]ListenerStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := make(map[string]ListenerStats, len(t.counts))
+	for addr, counts := range t.counts {
+		stats[addr] = counts.stats()
+	}
+	return stats
+}
+
+type trackedListener struct {
+	net.Listener
+	addr    string
+	counts  *connectionCounts
+	onError func(addr string, err error)
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		if l.onError != nil && !errors.Is(err, net.ErrClosed) {
+			l.onError(l.addr, err)
+		}
+		return nil, err
+	}
+	l.counts.accepted.Add(1)
+	return &trackedConn{Conn: conn, counts: l.counts}, nil
+}
+
+// trackedConn is a connection counted by a trackedListener until it is
+// closed.
+type trackedConn struct {
+	net.Conn
+	counts    *connectionCounts
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() { c.counts.closed.Add(1) })
+	return c.Conn.Close()
+}
+
+// CloseWrite shuts down the writing side of the connection if the wrapped
+// connection supports it, such as a TCP connection, so that proxies can pass
+// on half-closes.
+func (c *trackedConn) CloseWrite() error {
+	closer, ok := c.Conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return closer.CloseWrite()
+}
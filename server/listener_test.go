@@ -0,0 +1,88 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	s := &Server{}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked := s.connections.track(":443", listener)
+	defer func() { _ = tracked.Close() }()
+	idle, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trackedIdle := s.connections.track(":8443", idle)
+	defer func() { _ = trackedIdle.Close() }()
+
+	var conns []net.Conn
+	for range 3 {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = client.Close() }()
+		conn, err := tracked.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	_ = conns[0].Close()
+	_ = conns[0].Close()
+
+	stats := s.Stats()
+	if got, want := stats.Listeners[":443"], (ListenerStats{Accepted: 3, Active: 2, Closed: 1}); got != want {
+		t.Errorf("got stats %+v of :443; want %+v", got, want)
+	}
+	if got, found := stats.Listeners[":8443"]; !found || got != (ListenerStats{}) {
+		t.Errorf("got stats %+v of :8443, found %t; want no connections", got, found)
+	}
+}
+
+func TestTrackedConnCloseWrite(t *testing.T) {
+	s := &Server{}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked := s.connections.track(":443", listener)
+	defer func() { _ = tracked.Close() }()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	conn, err := tracked.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	closer, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		t.Fatal("got a connection without CloseWrite")
+	}
+	if _, err := conn.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := closer.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(client); err != nil || string(got) != "bye" {
+		t.Fatalf("got %q, error %v; want \"bye\" until EOF", got, err)
+	}
+	if _, err := client.Write([]byte("still open")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len("still open"))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "still open" {
+		t.Errorf("got %q, error %v; want the reading side still open", got, err)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+)
+
+// DefaultLocalAddress is the address listeners bind in local mode unless
+// ServerConfig.LocalAddress is set.
+const DefaultLocalAddress = "127.0.0.1"
+
+// LocalLoginName is the login name of every caller in local mode unless
+// ServerConfig.IdentityProvider is set or HeaderDevIdentity selects another on
+// a loopback address.
+const LocalLoginName = "local@localhost"
+
+// localFQDN is the FQDN of a server in local mode.
+const localFQDN = "localhost"
+
+// localBackendState is the backend state reported by Health in local mode.
+const localBackendState = "Local"
+
+// ErrLocalMode is returned by the features which need a tailnet, such as
+// ListenFunnel and Routes, in local mode.
+var ErrLocalMode = errors.New("not available in local mode")
+
+// validateLocalMode checks that config does not ask for changes of the
+// tailnet, which local mode does not join.
+func validateLocalMode(config *ServerConfig) error {
+	switch {
+	case len(config.AdvertiseRoutes) > 0:
+		return fieldErrorf("AdvertiseRoutes", "advertised routes cannot be specified in local mode")
+	case config.ExitNode != "":
+		return fieldErrorf("ExitNode", "exit node cannot be specified in local mode")
+	case config.AcceptRoutes:
+		return fieldErrorf("AcceptRoutes", "accepting routes cannot be specified in local mode")
+	case config.RunWebClient:
+		return fieldErrorf("RunWebClient", "web client cannot be run in local mode")
+	}
+	if config.LocalAddress != "" {
+		if _, err := netip.ParseAddr(config.LocalAddress); err != nil {
+			return fieldErrorf("LocalAddress", "local address [%s] must be an IP address", config.LocalAddress)
+		}
+	}
+	return nil
+}
+
+// localMode reports whether the server runs in local mode.
+func (s *Server) localMode() bool {
+	return s.localAddress != ""
+}
+
+// startLocal prepares the server for local mode in place of joining the
+// tailnet and returns the provider of the identity of callers.
+func (s *Server) startLocal(config *ServerConfig) (IdentityProvider, error) {
+	s.localAddress = config.LocalAddress
+	if s.localAddress == "" {
+		s.localAddress = DefaultLocalAddress
+	}
+	s.fqdn = localFQDN
+	identityProvider := config.IdentityProvider
+	if identityProvider == nil {
+		// callers beyond the host must not impersonate other users
+		identityProvider = &DevIdentityProvider{
+			LoginName:   LocalLoginName,
+			NodeName:    localFQDN,
+			AllowHeader: netip.MustParseAddr(s.localAddress).IsLoopback(),
+		}
+	}
+	s.logger.log(slog.LevelWarn, "insecure_identity", "WARNING: local mode is in use; callers are not authenticated")
+	s.whoIs = identityProvider.WhoIs
+	if config.WhoIsCacheTTL > 0 {
+		s.whoIsCache = newWhoIsCache(config.WhoIsCacheTTL)
+		s.whoIs = s.whoIsCache.wrap(identityProvider.WhoIs)
+	}
+
+	ipAddresses := []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.IPv6Loopback()}
+	if addr := netip.MustParseAddr(s.localAddress); !addr.IsUnspecified() && !addr.IsLoopback() {
+		ipAddresses = append(ipAddresses, addr)
+	}
+	cert, err := newSelfSignedCertificate([]string{localFQDN, config.Hostname}, ipAddresses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+	}
+	s.selfSignedCertificate = cert
+	s.certificates = newCertificateTracker(config.CertificateExpiryWarningThreshold, s.logger)
+	s.tlsConfig = tlsConfigFromTemplate(config.TLSConfig, s.certificates.observe(chainGetCertificate(config.GetCertificate, s.getSelfSignedCertificate)))
+	applyClientCertificateConfig(s.tlsConfig, config.ClientCAs, config.ClientCertificateOptional)
+
+	s.logger = s.logger.with("fqdn", s.fqdn)
+	s.logger.log(slog.LevelInfo, "ready", fmt.Sprintf("this service runs in local mode on [%s]", s.localAddress), "local_address", s.localAddress)
+	return identityProvider, nil
+}
+
+// listenLocal listens on the port of addr, such as ":443", at the local
+// address of the server.
+func (s *Server) listenLocal(addr string) (net.Listener, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address [%s]: %w", addr, err)
+	}
+	return net.Listen(Protocol, net.JoinHostPort(s.localAddress, port))
+}
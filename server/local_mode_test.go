@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// freePort returns a TCP port of the loopback interface which is not in use.
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestLocalMode(t *testing.T) {
+	config := newConfig("", "app", WithLocalMode(""), WithHealthCheck())
+	config.UserLogf = t.Logf
+	srv, err := NewServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = srv.Close() }()
+	if got := srv.FQDN(); got != "localhost" {
+		t.Errorf("got FQDN %q; want localhost", got)
+	}
+	srv.HandleFunc("GET /admin", &Policy{Allow: []Rule{{LoginNames: []string{LocalLoginName}}}}, func(w http.ResponseWriter, r *http.Request) {
+		who, err := srv.GetCallerIdentity(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(w, who.LoginName)
+	})
+
+	port := freePort(t)
+	listeners, nonHTTPSListener, _, err := srv.Listen([]int{port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listeners[0].Addr().String(), "127.0.0.1:"+strconv.Itoa(port); got != want || nonHTTPSListener != nil {
+		t.Fatalf("got listener on %s and %v; want %s only", got, nonHTTPSListener, want)
+	}
+	go func() { _ = http.Serve(listeners[0], srv.Handler()) }()
+	defer func() { _ = listeners[0].Close() }()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // #nosec G402 -- self-signed test certificate
+	}}
+	get := func(path, identity string) (int, string) {
+		t.Helper()
+		r, err := http.NewRequest("GET", "https://localhost:"+strconv.Itoa(port)+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity != "" {
+			r.Header.Set(HeaderDevIdentity, identity)
+		}
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := get("/admin", ""); code != http.StatusOK || body != LocalLoginName {
+		t.Errorf("got %d with body %q; want %d with %q", code, body, http.StatusOK, LocalLoginName)
+	}
+	if code, _ := get("/admin", "bob@example.com"); code != http.StatusForbidden {
+		t.Errorf("got %d for another identity; want %d", code, http.StatusForbidden)
+	}
+	if code, _ := get(HealthCheckPath, ""); code != http.StatusOK {
+		t.Errorf("got %d for the health check; want %d", code, http.StatusOK)
+	}
+
+	otherPort := freePort(t)
+	listener, err := srv.listen(":" + strconv.Itoa(otherPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = listener.Close()
+	if got, want := srv.listeningPorts(), []int{min(port, otherPort), max(port, otherPort)}; !slices.Equal(got, want) {
+		t.Errorf("got listening ports %v; want %v", got, want)
+	}
+
+	health, err := srv.Health(context.Background())
+	if err != nil || !health.Healthy || health.BackendState != localBackendState {
+		t.Errorf("got health %+v, error %v", health, err)
+	}
+	if _, err := srv.Routes(context.Background()); !errors.Is(err, ErrLocalMode) {
+		t.Errorf("got error %v; want %v", err, ErrLocalMode)
+	}
+	if _, err := srv.ListenFunnel(443); !errors.Is(err, ErrLocalMode) {
+		t.Errorf("got error %v; want %v", err, ErrLocalMode)
+	}
+}
+
+func TestLocalModeIdentityHeader(t *testing.T) {
+	tests := []struct {
+		address         string
+		wantAllowHeader bool
+	}{
+		{address: "", wantAllowHeader: true},
+		{address: "::1", wantAllowHeader: true},
+		{address: "0.0.0.0", wantAllowHeader: false},
+		{address: "172.17.0.2", wantAllowHeader: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			srv := &Server{logger: newLogger(t.Logf, slog.LevelInfo)}
+			identityProvider, err := srv.startLocal(newConfig("", "app", WithLocalMode(tt.address)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := identityProvider.(*DevIdentityProvider).AllowHeader; got != tt.wantAllowHeader {
+				t.Errorf("got AllowHeader %t; want %t", got, tt.wantAllowHeader)
+			}
+		})
+	}
+}
+
+func TestValidateLocalMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *ServerConfig
+		wantErr bool
+	}{
+		{name: "without auth key", config: newConfig("", "app", WithLocalMode(""))},
+		{name: "address", config: newConfig("", "app", WithLocalMode("0.0.0.0"))},
+		{name: "invalid address", config: newConfig("", "app", WithLocalMode("localhost")), wantErr: true},
+		{name: "advertised routes", config: newConfig("", "app", WithLocalMode(""), WithAdvertiseRoutes("192.168.1.0/24")), wantErr: true},
+		{name: "exit node", config: newConfig("", "app", WithLocalMode(""), WithExitNode("exit-sg")), wantErr: true},
+		{name: "web client", config: newConfig("", "app", WithLocalMode(""), WithWebClient()), wantErr: true},
+		{name: "address without local mode", config: &ServerConfig{TailscaleAuthKey: "tskey-test", Hostname: "app", LocalAddress: "0.0.0.0"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateConfiguration(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfiguration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
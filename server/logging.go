@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// packageLogf is the logger of messages not tied to a Server, such as those
+// of middleware. It is nil unless SetLogf is called.
+var packageLogf atomic.Pointer[func(format string, args ...any)]
+
+// packageLogger is the structured logger of messages not tied to a Server.
+// slog.Default is used while it is nil.
+var packageLogger atomic.Pointer[slog.Logger]
+
+// packageLogLevel is the minimum level of messages not tied to a Server.
+var packageLogLevel slog.LevelVar
+
+// SetLogger sets the structured logger of messages of this package which are
+// not tied to a Server, such as failures in middleware. They are written to
+// slog.Default by default. Messages of a Server are written to
+// ServerConfig.Logger instead.
+func SetLogger(logger *slog.Logger) {
+	packageLogf.Store(nil)
+	packageLogger.Store(logger)
+}
+
+// SetLogf sets a printf-style logger of messages of this package which are
+// not tied to a Server in place of the logger set by SetLogger. Messages are
+// discarded if f is nil.
+func SetLogf(f func(format string, args ...any)) {
+	if f == nil {
+		f = func(string, ...any) {}
+	}
+	packageLogf.Store(&f)
+}
+
+// SetLogLevel sets the minimum level of messages of this package which are
+// not tied to a Server. It defaults to slog.LevelInfo. Messages of a Server
+// are governed by ServerConfig.LogLevel instead.
+func SetLogLevel(level slog.Level) {
+	packageLogLevel.Set(level)
+}
+
+// packageLog writes a message with the logger set by SetLogf or SetLogger.
+// The attributes, specified as for slog.Logger.Log, are written by structured
+// loggers only.
+func packageLog(level slog.Level, msg string, attrs ...any) {
+	if f := packageLogf.Load(); f != nil {
+		(*f)("%s", msg)
+		return
+	}
+	defaultLogger().Log(context.Background(), level, msg, attrs...)
+}
+
+// defaultLogger returns the logger set by SetLogger or slog.Default.
+func defaultLogger() *slog.Logger {
+	if logger := packageLogger.Load(); logger != nil {
+		return logger
+	}
+	return slog.Default()
+}
+
+// logf writes a message at level with the package logger if level is at
+// least the level set by SetLogLevel.
+func logf(level slog.Level, format string, args ...any) {
+	if level >= packageLogLevel.Level() {
+		packageLog(level, fmt.Sprintf(format, args...))
+	}
+}
+
+// logger writes the messages of a Server which are at least of its level,
+// either to a structured logger with the attributes of the server and of the
+// message, or as plain messages to a printf-style function. Without either,
+// messages go to the package logger.
+type logger struct {
+	printf func(format string, args ...any)
+	slog   *slog.Logger
+	level  slog.Level
+}
+
+func newLogger(printf func(format string, args ...any), level slog.Level) *logger {
+	return &logger{printf: printf, level: level}
+}
+
+func newSlogLogger(l *slog.Logger, level slog.Level) *logger {
+	return &logger{slog: l, level: level}
+}
+
+// with returns a logger adding the attributes to every structured message.
+func (l *logger) with(attrs ...any) *logger {
+	if l.slog == nil {
+		return l
+	}
+	return &logger{slog: l.slog.With(attrs...), level: l.level}
+}
+
+func (l *logger) logf(level slog.Level, format string, args ...any) {
+	l.log(level, "", fmt.Sprintf(format, args...))
+}
+
+// log writes a message describing an event, such as "listening", with the
+// attributes specified as for slog.Logger.Log. The event and the attributes
+// are dropped by printf-style loggers, so msg should stand on its own.
+func (l *logger) log(level slog.Level, event, msg string, attrs ...any) {
+	if level < l.level {
+		return
+	}
+	msg = strings.TrimSuffix(msg, "\n")
+	if l.printf != nil {
+		l.printf("%s", msg)
+		return
+	}
+	if event != "" {
+		attrs = append([]any{"event", event}, attrs...)
+	}
+	if l.slog != nil {
+		l.slog.Log(context.Background(), level, msg, attrs...)
+		return
+	}
+	packageLog(level, msg, attrs...)
+}
+
+// SlogLogf returns a logging function, such as for ServerConfig.Logf or
+// SetLogf, which writes messages to logger at level.
+func SlogLogf(logger *slog.Logger, level slog.Level) func(format string, args ...any) {
+	return func(format string, args ...any) {
+		logger.Log(context.Background(), level, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestSetLogf(t *testing.T) {
+	defer packageLogf.Store(nil)
+
+	var messages []string
+	SetLogf(func(format string, args ...any) {
+		messages = append(messages, fmt.Sprintf(format, args...))
+	})
+	handler := withIdentity(func(*http.Request) (*apitype.WhoIsResponse, error) {
+		return nil, fmt.Errorf("local API unavailable")
+	}, serveHandler())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(messages) != 1 || !strings.Contains(messages[0], "local API unavailable") {
+		t.Errorf("got messages %q", messages)
+	}
+
+	SetLogf(nil)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(messages) != 1 {
+		t.Errorf("got messages %q after silencing", messages)
+	}
+}
+
+func TestSlogLogf(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	SlogLogf(logger, slog.LevelDebug)("magicsock: %d endpoints\n", 3)
+	got := buf.String()
+	if !strings.Contains(got, "level=DEBUG") || !strings.Contains(got, `msg="magicsock: 3 endpoints"`) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var messages []string
+	l := newLogger(func(format string, args ...any) {
+		messages = append(messages, fmt.Sprintf(format, args...))
+	}, slog.LevelWarn)
+	l.logf(slog.LevelInfo, "certificate is ready")
+	l.logf(slog.LevelWarn, "certificate expires soon")
+	l.logf(slog.LevelError, "failed to watch")
+	if !slices.Equal(messages, []string{"certificate expires soon", "failed to watch"}) {
+		t.Errorf("got messages %q", messages)
+	}
+}
+
+func TestLoggerStructured(t *testing.T) {
+	var buf bytes.Buffer
+	l := newSlogLogger(slog.New(slog.NewTextHandler(&buf, nil)), slog.LevelInfo).with("fqdn", "web.prawn-universe.ts.net")
+	l.log(slog.LevelDebug, "listening", "listening HTTPS on port 8443", "port", 8443)
+	l.log(slog.LevelInfo, "listening", "listening HTTPS on port 443", "port", 443)
+	l.logf(slog.LevelWarn, "failed to watch: %v", "closed")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %q", lines)
+	}
+	if want := `level=INFO msg="listening HTTPS on port 443" fqdn=web.prawn-universe.ts.net event=listening port=443`; !strings.HasSuffix(lines[0], want) {
+		t.Errorf("got %q; want suffix %q", lines[0], want)
+	}
+	if want := `level=WARN msg="failed to watch: closed" fqdn=web.prawn-universe.ts.net`; !strings.HasSuffix(lines[1], want) {
+		t.Errorf("got %q; want suffix %q", lines[1], want)
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	defer packageLogger.Store(nil)
+
+	var buf bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	logf(slog.LevelWarn, "denying request from [%s]", "100.64.0.1")
+	newLogger(nil, slog.LevelInfo).log(slog.LevelInfo, "ready", "ready", "port", 443)
+	got := buf.String()
+	if !strings.Contains(got, `level=WARN msg="denying request from [100.64.0.1]"`) {
+		t.Errorf("got %q", got)
+	}
+	if !strings.Contains(got, "msg=ready event=ready port=443") {
+		t.Errorf("got %q; want the messages of servers without a logger", got)
+	}
+}
+
+func TestSetLogLevel(t *testing.T) {
+	defer packageLogf.Store(nil)
+	defer SetLogLevel(slog.LevelInfo)
+
+	var messages []string
+	SetLogf(func(format string, args ...any) {
+		messages = append(messages, fmt.Sprintf(format, args...))
+	})
+	SetLogLevel(slog.LevelError)
+	logf(slog.LevelWarn, "denying request")
+	logf(slog.LevelError, "failed to sign token")
+	if !slices.Equal(messages, []string{"failed to sign token"}) {
+		t.Errorf("got messages %q", messages)
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthCheckPath is the path of the health check of the server, which is
+// served even in maintenance mode so that orchestrators do not restart a
+// server under maintenance.
+const HealthCheckPath = "/healthz"
+
+// DefaultMaintenanceMessage is shown in maintenance mode if no message is
+// specified.
+const DefaultMaintenanceMessage = "This service is undergoing maintenance. Please try again later."
+
+// maintenancePageTemplate is the page served in maintenance mode.
+var maintenancePageTemplate = template.Must(NewLayout().Parse(`{{define "title"}}Under maintenance{{end}}
+{{define "content"}}<div style="max-width: 32rem; margin: 4rem auto; text-align: center;">
+<h1>Under maintenance</h1>
+<p>{{.Data.Message}}</p>
+{{if not .Data.Since.IsZero}}<p style="color: #71717a; font-size: 0.875rem;">Since {{.Data.Since.Format "2006-01-02 15:04 MST"}}</p>{{end}}
+</div>{{end}}`))
+
+// MaintenanceStatus is the state of maintenance mode.
+type MaintenanceStatus struct {
+	Enabled bool      `json:"enabled"`
+	Message string    `json:"message,omitempty"`
+	Since   time.Time `json:"since,omitzero"`
+}
+
+// maintenanceMode holds the state of maintenance mode of a server.
+type maintenanceMode struct {
+	mu     sync.RWMutex
+	status MaintenanceStatus
+
+	// exempt are the paths served in maintenance mode besides
+	// HealthCheckPath, such as that of the admin API.
+	exempt []string
+}
+
+func (m *maintenanceMode) isExempt(path string) bool {
+	if path == HealthCheckPath {
+		return true
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Contains(m.exempt, path)
+}
+
+func (m *maintenanceMode) addExempt(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exempt = append(m.exempt, path)
+}
+
+func (m *maintenanceMode) get() MaintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// set changes the state and reports whether it has been toggled.
+func (m *maintenanceMode) set(on bool, message string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	toggled := m.status.Enabled != on
+	if !on {
+		m.status = MaintenanceStatus{}
+		return toggled
+	}
+	if message == "" {
+		message = DefaultMaintenanceMessage
+	}
+	m.status.Message = message
+	if toggled {
+		m.status.Enabled = true
+		m.status.Since = time.Now()
+	}
+	return toggled
+}
+
+// SetMaintenance turns maintenance mode on or off at runtime, for example
+// during database migrations. In maintenance mode, every request handled by
+// Handler or wrapped by WithMaintenance, except those of HealthCheckPath and
+// of the admin API registered by HandleMaintenance, is answered with status
+// 503 and a page showing message, or DefaultMaintenanceMessage if it is
+// empty.
+func (s *Server) SetMaintenance(on bool, message string) {
+	if !s.maintenance.set(on, message) {
+		return
+	}
+	if on {
+		message := s.maintenance.get().Message
+		s.logger.log(slog.LevelWarn, "maintenance_on", "maintenance mode is on: "+message, "message", message)
+		return
+	}
+	s.logger.log(slog.LevelInfo, "maintenance_off", "maintenance mode is off")
+}
+
+// Maintenance returns the state of maintenance mode.
+func (s *Server) Maintenance() MaintenanceStatus {
+	return s.maintenance.get()
+}
+
+// WithMaintenance wraps the provided handler and answers requests with status
+// 503 while maintenance mode is on. Handler and Config.Handler apply it
+// already.
+func (s *Server) WithMaintenance(h http.Handler) http.Handler {
+	return withMaintenance(&s.maintenance, h)
+}
+
+func withMaintenance(m *maintenanceMode, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := m.get()
+		if !status.Enabled || m.isExempt(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Error(w, status.Message, http.StatusServiceUnavailable)
+			return
+		}
+		var b bytes.Buffer
+		if err := maintenancePageTemplate.ExecuteTemplate(&b, "layout", PageData{Data: status}); err != nil {
+			logf(slog.LevelError, "failed to render maintenance page: %v", err)
+			http.Error(w, status.Message, http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = b.WriteTo(w)
+	})
+}
+
+// HandleMaintenance registers the admin API of maintenance mode for path,
+// such as "/admin/maintenance", on the router of the server with a policy
+// which should admit administrators only. The path stays reachable in
+// maintenance mode so that it can be turned off again. GET returns the
+// MaintenanceStatus as JSON, and PUT or POST with a JSON body of the form
+// {"enabled": true, "message": "..."} toggles maintenance mode and returns
+// the new status.
+func (s *Server) HandleMaintenance(path string, policy *Policy) {
+	s.maintenance.addExempt(path)
+	s.router.Handle(path, policy, maintenanceHandler(s.SetMaintenance, s.Maintenance))
+}
+
+func maintenanceHandler(set func(on bool, message string), get func() MaintenanceStatus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut, http.MethodPost:
+			var req struct {
+				Enabled bool   `json:"enabled"`
+				Message string `json:"message"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+				http.Error(w, "invalid maintenance request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			set(req.Enabled, req.Message)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, get())
+	})
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaintenance(t *testing.T) {
+	s := &Server{
+		logger: newLogger(t.Logf, slog.LevelInfo),
+		router: newRouter(nil),
+	}
+	s.HandleFunc("/app", nil, func(w http.ResponseWriter, r *http.Request) {})
+	s.HandleFunc(HealthCheckPath, nil, func(w http.ResponseWriter, r *http.Request) {})
+	s.HandleMaintenance("/admin/maintenance", nil)
+	h := s.Handler()
+
+	serve := func(method, path, accept, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve("GET", "/app", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("got %d before maintenance; want %d", w.Code, http.StatusOK)
+	}
+
+	w := serve("PUT", "/admin/maintenance", "", `{"enabled": true, "message": "migrating the database"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d enabling maintenance; want %d", w.Code, http.StatusOK)
+	}
+	var status MaintenanceStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.Message != "migrating the database" || status.Since.IsZero() {
+		t.Errorf("got status %+v", status)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		accept   string
+		wantCode int
+		wantType string
+	}{
+		{name: "browser", path: "/app", accept: "text/html,*/*", wantCode: http.StatusServiceUnavailable, wantType: "text/html; charset=utf-8"},
+		{name: "api client", path: "/app", wantCode: http.StatusServiceUnavailable, wantType: "text/plain; charset=utf-8"},
+		{name: "health check", path: HealthCheckPath, wantCode: http.StatusOK},
+		{name: "admin api", path: "/admin/maintenance", wantCode: http.StatusOK, wantType: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve("GET", tt.path, tt.accept, "")
+			if w.Code != tt.wantCode {
+				t.Fatalf("got %d; want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("Content-Type"); tt.wantType != "" && got != tt.wantType {
+				t.Errorf("got Content-Type %q; want %q", got, tt.wantType)
+			}
+			if tt.wantCode == http.StatusServiceUnavailable && !strings.Contains(w.Body.String(), "migrating the database") {
+				t.Errorf("got body %q without the message", w.Body.String())
+			}
+		})
+	}
+
+	if w := serve("POST", "/admin/maintenance", "", `{"enabled": false}`); w.Code != http.StatusOK {
+		t.Fatalf("got %d disabling maintenance; want %d", w.Code, http.StatusOK)
+	}
+	if got := s.Maintenance(); got.Enabled {
+		t.Errorf("got status %+v after disabling maintenance", got)
+	}
+	if w := serve("GET", "/app", "", ""); w.Code != http.StatusOK {
+		t.Errorf("got %d after maintenance; want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestMaintenanceDefaultMessage(t *testing.T) {
+	s := &Server{logger: newLogger(t.Logf, slog.LevelInfo)}
+	s.SetMaintenance(true, "")
+	if got := s.Maintenance().Message; got != DefaultMaintenanceMessage {
+		t.Errorf("got message %q; want %q", got, DefaultMaintenanceMessage)
+	}
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// MetricsPath is the path MetricsHandler is served on if
+// ServerConfig.Metrics is set.
+const MetricsPath = "/metrics"
+
+// httpRequests holds the metrics of the requests served by handlers wrapped
+// by Metrics.
+var httpRequests = &requestMetrics{
+	counts:    make(map[requestMetricsKey]int64),
+	durations: make(map[string]*durationHistogram),
+}
+
+// durationBuckets are the upper bounds in seconds of the buckets of the
+// request duration histograms, which are those of the Prometheus clients.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requestMetricsKey identifies a series of request counts.
+type requestMetricsKey struct {
+	route  string
+	method string
+	code   int
+}
+
+// durationHistogram counts the requests of a route by duration bucket, and
+// holds their total duration and number.
+type durationHistogram struct {
+	// buckets counts the requests in each of durationBuckets, not
+	// cumulatively.
+	buckets []int64
+	sum     float64
+	count   int64
+}
+
+func (h *durationHistogram) observe(seconds float64) {
+	if i, _ := slices.BinarySearch(durationBuckets, seconds); i < len(durationBuckets) {
+		h.buckets[i]++
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// requestMetrics counts requests by route, method and status code and
+// records their durations by route.
+type requestMetrics struct {
+	mu        sync.Mutex
+	counts    map[requestMetricsKey]int64
+	durations map[string]*durationHistogram
+}
+
+func (m *requestMetrics) observe(route, method string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[requestMetricsKey{route: route, method: metricsMethod(method), code: code}]++
+	histogram, found := m.durations[route]
+	if !found {
+		histogram = &durationHistogram{buckets: make([]int64, len(durationBuckets))}
+		m.durations[route] = histogram
+	}
+	histogram.observe(d.Seconds())
+}
+
+func (m *requestMetrics) write(p *promWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p.header("privateserver_http_requests_total", "counter", "Requests served by route, method and status code.")
+	keys := slices.SortedFunc(maps.Keys(m.counts), func(a, b requestMetricsKey) int {
+		return cmp.Or(strings.Compare(a.route, b.route), strings.Compare(a.method, b.method), cmp.Compare(a.code, b.code))
+	})
+	for _, key := range keys {
+		p.sample("privateserver_http_requests_total", float64(m.counts[key]), "route", key.route, "method", key.method, "code", strconv.Itoa(key.code))
+	}
+	p.header("privateserver_http_request_duration_seconds", "histogram", "Duration of requests by route.")
+	for _, route := range slices.Sorted(maps.Keys(m.durations)) {
+		histogram := m.durations[route]
+		var cumulative int64
+		for i, bound := range durationBuckets {
+			cumulative += histogram.buckets[i]
+			p.sample("privateserver_http_request_duration_seconds_bucket", float64(cumulative), "route", route, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		p.sample("privateserver_http_request_duration_seconds_bucket", float64(histogram.count), "route", route, "le", "+Inf")
+		p.sample("privateserver_http_request_duration_seconds_sum", histogram.sum, "route", route)
+		p.sample("privateserver_http_request_duration_seconds_count", float64(histogram.count), "route", route)
+	}
+}
+
+// metricsRoute returns the route label of a pattern, such as "/items/{id}"
+// for "GET /items/{id}". The method is dropped as requests are labelled by
+// method separately.
+func metricsRoute(pattern string) string {
+	if method, path, found := strings.Cut(pattern, " "); found && !strings.Contains(method, "/") {
+		return strings.TrimLeft(path, " \t")
+	}
+	return pattern
+}
+
+// metricsMethod returns the method as a metrics label, folding non-standard
+// methods into "other" so that callers cannot create arbitrary series.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// Metrics wraps the provided handler and records the number and the duration
+// of its requests for MetricsHandler. Requests are labelled with the route
+// pattern, without the method, matched by a Router anywhere in the wrapped
+// handler, or by an http.ServeMux which is the wrapped handler or is reached
+// without cloning the request. Requests matching no route, whose paths could
+// be anything, share the empty route so that the number of series stays
+// bounded. Server.Handler applies it if ServerConfig.Metrics is set.
+func Metrics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := newStatusRecorder(w)
+		r, info := withRequestInfo(r)
+		h.ServeHTTP(recorder, r)
+		httpRequests.observe(metricsRoute(info.pattern(r)), r.Method, recorder.Status(), time.Since(start))
+	})
+}
+
+// requestInfo holds what handlers deeper in the chain learn about a request,
+// the pattern of the route of a Router matching it and the identity of the
+// caller, so that it stays available to middleware wrapping them even if the
+// request is cloned on the way.
+type requestInfo struct {
+	route  string
+	caller *apitype.WhoIsResponse
+}
+
+// requestInfoContextKey is the context key of the requestInfo of a request.
+type requestInfoContextKey struct{}
+
+// withRequestInfo returns the request with a requestInfo in its context,
+// reusing that of an outer middleware.
+func withRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	if info, found := r.Context().Value(requestInfoContextKey{}).(*requestInfo); found {
+		return r, info
+	}
+	info := new(requestInfo)
+	return r.WithContext(context.WithValue(r.Context(), requestInfoContextKey{}, info)), info
+}
+
+// recordRoute records pattern as the route of the request in its
+// requestInfo, if any.
+func recordRoute(r *http.Request, pattern string) {
+	if info, found := r.Context().Value(requestInfoContextKey{}).(*requestInfo); found {
+		info.route = pattern
+	}
+}
+
+// recordCaller records the identity of the caller of the request in its
+// requestInfo, if any.
+func recordCaller(r *http.Request, who *apitype.WhoIsResponse) {
+	if info, found := r.Context().Value(requestInfoContextKey{}).(*requestInfo); found {
+		info.caller = who
+	}
+}
+
+// pattern returns the recorded route pattern, or that set on r by an
+// http.ServeMux.
+func (info *requestInfo) pattern(r *http.Request) string {
+	if info.route != "" {
+		return info.route
+	}
+	return r.Pattern
+}
+
+// MetricsHandler returns a handler exporting metrics in the Prometheus text
+// format: the requests recorded by Metrics, the accepted, open and closed
+// connections of each listener of the server, the hits and misses of the
+// WhoIs cache, the state of the node on the tailnet, the client metrics of
+// tsnet such as the bytes sent and received over WireGuard and DERP,
+// maintenance mode, certificate expiry, rate limiting and recovered panics.
+// It is served for "GET /metrics" on the router of the server if
+// ServerConfig.Metrics is set, and should otherwise be registered with a
+// policy admitting the scrapers only.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b bytes.Buffer
+		p := &promWriter{w: &b}
+		httpRequests.write(p)
+		writePackageMetrics(p)
+		s.writeMetrics(r.Context(), p)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = b.WriteTo(w)
+	})
+}
+
+// writePackageMetrics writes the process-wide metrics exported as expvars.
+func writePackageMetrics(p *promWriter) {
+	p.header("privateserver_recovered_panics_total", "counter", "Handler panics recovered by Recover.")
+	p.sample("privateserver_recovered_panics_total", float64(recoveredPanics.Value()))
+	p.header("privateserver_rate_limited_requests_total", "counter", "Requests rejected by rate limiting by kind of limit.")
+	rateLimitedRequests.Do(func(kv expvar.KeyValue) {
+		p.sample("privateserver_rate_limited_requests_total", expvarValue(kv.Value), "kind", kv.Key)
+	})
+	p.header("privateserver_certificate_not_after_seconds", "gauge", "Expiry of served certificates in Unix seconds by domain.")
+	certificateNotAfter.Do(func(kv expvar.KeyValue) {
+		p.sample("privateserver_certificate_not_after_seconds", expvarValue(kv.Value), "domain", kv.Key)
+	})
+}
+
+// writeMetrics writes the metrics of the server.
+func (s *Server) writeMetrics(ctx context.Context, p *promWriter) {
+	listeners := s.connections.stats()
+	addrs := slices.Sorted(maps.Keys(listeners))
+	p.header("privateserver_listener_accepted_connections_total", "counter", "Connections accepted by listening address.")
+	for _, addr := range addrs {
+		p.sample("privateserver_listener_accepted_connections_total", float64(listeners[addr].Accepted), "listener", addr)
+	}
+	p.header("privateserver_listener_active_connections", "gauge", "Open connections by listening address.")
+	for _, addr := range addrs {
+		p.sample("privateserver_listener_active_connections", float64(listeners[addr].Active), "listener", addr)
+	}
+	p.header("privateserver_listener_closed_connections_total", "counter", "Connections closed by listening address.")
+	for _, addr := range addrs {
+		p.sample("privateserver_listener_closed_connections_total", float64(listeners[addr].Closed), "listener", addr)
+	}
+
+	if s.whoIsCache != nil {
+		p.header("privateserver_whois_cache_hits_total", "counter", "WhoIs lookups served from the cache.")
+		p.sample("privateserver_whois_cache_hits_total", float64(s.whoIsCache.hits.Load()))
+		p.header("privateserver_whois_cache_misses_total", "counter", "WhoIs lookups not found in the cache.")
+		p.sample("privateserver_whois_cache_misses_total", float64(s.whoIsCache.misses.Load()))
+		p.header("privateserver_whois_cache_entries", "gauge", "Identities in the WhoIs cache.")
+		p.sample("privateserver_whois_cache_entries", float64(s.whoIsCache.size()))
+	}
+
+	maintenance := 0.0
+	if s.Maintenance().Enabled {
+		maintenance = 1
+	}
+	p.header("privateserver_maintenance_mode", "gauge", "Whether maintenance mode is on.")
+	p.sample("privateserver_maintenance_mode", maintenance)
+
+	if s.tsClient == nil {
+		return
+	}
+	if status, err := s.tsClient.StatusWithoutPeers(ctx); err != nil {
+		s.logger.logf(slog.LevelError, "failed to get tailnet state for metrics: %v", err)
+	} else {
+		p.header("privateserver_tailnet_state", "gauge", "Backend state of the node on the tailnet.")
+		p.sample("privateserver_tailnet_state", 1, "state", status.BackendState)
+	}
+
+	// the client metrics of tsnet, such as the bytes sent over WireGuard and
+	// through DERP, come in the text format already
+	userMetrics, err := s.tsClient.UserMetrics(ctx)
+	if err != nil {
+		s.logger.logf(slog.LevelError, "failed to get tailnet client metrics: %v", err)
+		return
+	}
+	p.raw(userMetrics)
+}
+
+func expvarValue(v expvar.Var) float64 {
+	f, _ := strconv.ParseFloat(v.String(), 64)
+	return f
+}
+
+// promWriter writes metrics in the Prometheus text exposition format.
+type promWriter struct {
+	w io.Writer
+}
+
+// promLabelEscaper escapes label values as the text format requires.
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (p *promWriter) header(name, typ, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// raw writes metrics which are in the text format already.
+func (p *promWriter) raw(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	_, _ = p.w.Write(b)
+	if b[len(b)-1] != '\n' {
+		_, _ = io.WriteString(p.w, "\n")
+	}
+}
+
+// sample writes a sample with the labels specified as name and value pairs.
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, labels[i], promLabelEscaper.Replace(labels[i+1]))
+	}
+	if len(labels) > 1 {
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(p.w, "%s %s\n", b.String(), strconv.FormatFloat(value, 'g', -1, 64))
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestMetrics(t *testing.T) {
+	s := &Server{
+		logger:     newLogger(t.Logf, slog.LevelInfo),
+		router:     newRouter(nil),
+		metrics:    true,
+		whoIsCache: newWhoIsCache(time.Minute),
+	}
+	s.HandleFunc("GET /metrics-test/{id}", nil, func(w http.ResponseWriter, r *http.Request) {})
+	s.Handle("GET "+MetricsPath, nil, s.MetricsHandler())
+	h := s.Handler()
+
+	whoIs := s.whoIsCache.wrap(func(context.Context, string) (*apitype.WhoIsResponse, error) {
+		return newTestWhoIs("alice@example.com"), nil
+	})
+	for range 2 {
+		if _, err := whoIs(context.Background(), "100.64.0.1:1234"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked := s.connections.track(":8443", listener)
+	defer func() { _ = tracked.Close() }()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	conn, err := tracked.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/metrics-test/1", "/metrics-test/2", "/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/metrics-test/1", nil))
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", MetricsPath, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d; want %d", w.Code, http.StatusOK)
+		}
+		return w.Body.String()
+	}
+	body := scrape()
+	for _, want := range []string{
+		`privateserver_http_requests_total{route="/metrics-test/{id}",method="GET",code="200"} 2`,
+		`privateserver_http_requests_total{route="",method="GET",code="404"} 1`,
+		`privateserver_http_requests_total{route="",method="other",code="405"} 1`,
+		"# TYPE privateserver_http_request_duration_seconds histogram",
+		`privateserver_http_request_duration_seconds_bucket{route="/metrics-test/{id}",le="10"} 2`,
+		`privateserver_http_request_duration_seconds_bucket{route="/metrics-test/{id}",le="+Inf"} 2`,
+		`privateserver_http_request_duration_seconds_count{route="/metrics-test/{id}"} 2`,
+		`privateserver_listener_accepted_connections_total{listener=":8443"} 1`,
+		`privateserver_listener_active_connections{listener=":8443"} 1`,
+		`privateserver_listener_closed_connections_total{listener=":8443"} 0`,
+		"privateserver_whois_cache_hits_total 1",
+		"privateserver_whois_cache_misses_total 1",
+		"privateserver_whois_cache_entries 1",
+		"privateserver_maintenance_mode 0",
+		"# TYPE privateserver_recovered_panics_total counter",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("got metrics without %q:\n%s", want, body)
+		}
+	}
+
+	_ = conn.Close()
+	_ = conn.Close()
+	body = scrape()
+	for _, want := range []string{
+		`privateserver_listener_accepted_connections_total{listener=":8443"} 1`,
+		`privateserver_listener_active_connections{listener=":8443"} 0`,
+		`privateserver_listener_closed_connections_total{listener=":8443"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("got metrics without %q after closing the connection:\n%s", want, body)
+		}
+	}
+}
+
+func TestPromWriterEscapesLabels(t *testing.T) {
+	var b strings.Builder
+	p := &promWriter{w: &b}
+	p.sample("test_metric", 1.5, "label", "a \"quoted\"\\value\n")
+	want := `test_metric{label="a \"quoted\"\\value\n"} 1.5` + "\n"
+	if got := b.String(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+// localAPITransport serves the requests of a local.Client with a handler in
+// place of tailscaled.
+type localAPITransport struct {
+	h http.Handler
+}
+
+func (t localAPITransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	t.h.ServeHTTP(w, r)
+	return w.Result(), nil
+}
+
+func newTestLocalClient(h http.Handler) *local.Client {
+	return &local.Client{Transport: localAPITransport{h: h}, OmitAuth: true}
+}
+
+func TestMetricsTailnet(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /localapi/v0/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"BackendState": "Running"})
+	})
+	mux.HandleFunc("GET /localapi/v0/usermetrics", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "# TYPE tailscaled_outbound_bytes_total counter\n"+
+			`tailscaled_outbound_bytes_total{path="direct_ipv4"} 1024`+"\n"+
+			`tailscaled_outbound_bytes_total{path="derp"} 512`)
+	})
+	s := &Server{
+		logger:   newLogger(t.Logf, slog.LevelInfo),
+		tsClient: newTestLocalClient(mux),
+	}
+	w := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", MetricsPath, nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`privateserver_tailnet_state{state="Running"} 1` + "\n",
+		`tailscaled_outbound_bytes_total{path="direct_ipv4"} 1024` + "\n",
+		`tailscaled_outbound_bytes_total{path="derp"} 512` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("got metrics without %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsRouteThroughClonedRequests(t *testing.T) {
+	router := newRouter(nil)
+	router.HandleFunc("POST /metrics-clone/{id}/items/{item}", nil, func(w http.ResponseWriter, r *http.Request) {})
+	cloning := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), struct{}{}, "cloned")))
+		})
+	}
+	h := Metrics(cloning(router))
+	for _, path := range []string{"/metrics-clone/1/items/2", "/metrics-clone/3/items/4"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", path, nil))
+	}
+
+	var b strings.Builder
+	httpRequests.write(&promWriter{w: &b})
+	want := `privateserver_http_requests_total{route="/metrics-clone/{id}/items/{item}",method="POST",code="200"} 2` + "\n"
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("got metrics without %q:\n%s", want, b.String())
+	}
+}
+
+func TestMetricsRoute(t *testing.T) {
+	tests := map[string]string{
+		"":                         "",
+		"/static/":                 "/static/",
+		"GET /items/{id}":          "/items/{id}",
+		"GET  /items/{id...}":      "/items/{id...}",
+		"example.com/":             "example.com/",
+		"DELETE example.com/{key}": "example.com/{key}",
+	}
+	for pattern, want := range tests {
+		if got := metricsRoute(pattern); got != want {
+			t.Errorf("metricsRoute(%q) = %q; want %q", pattern, got, want)
+		}
+	}
+}
+
+func TestDurationHistogram(t *testing.T) {
+	h := &durationHistogram{buckets: make([]int64, len(durationBuckets))}
+	for _, seconds := range []float64{0.001, 0.005, 0.3, 42} {
+		h.observe(seconds)
+	}
+	want := make([]int64, len(durationBuckets))
+	want[0] = 2 // 0.001 and the bound 0.005 itself
+	want[6] = 1 // 0.3 in (0.25, 0.5]
+	if !slices.Equal(h.buckets, want) || h.count != 4 {
+		t.Errorf("got buckets %v and count %d; want %v and 4", h.buckets, h.count, want)
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/net/netmon"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/eventbus"
+)
+
+// NetcheckPath is the path HandleNetcheck serves NetcheckReport on.
+const NetcheckPath = "/debug/netcheck"
+
+// Connections of peers in PeerConnection.Connection.
+const (
+	ConnectionDirect    = "direct"
+	ConnectionPeerRelay = "peer_relay"
+	ConnectionDERP      = "derp"
+)
+
+// NetcheckReport describes the network conditions of the host of a server,
+// the latencies to the DERP relay regions and how its peers are reached.
+type NetcheckReport struct {
+	Time time.Time `json:"time"`
+
+	// UDP, IPv4 and IPv6 are whether a STUN round trip completed over UDP,
+	// IPv4 and IPv6. Without UDP, all traffic is relayed through DERP.
+	UDP  bool `json:"udp"`
+	IPv4 bool `json:"ipv4"`
+	IPv6 bool `json:"ipv6"`
+
+	// GlobalV4 and GlobalV6 are the public addresses of the host as seen by
+	// the STUN servers.
+	GlobalV4 string `json:"global_v4,omitempty"`
+	GlobalV6 string `json:"global_v6,omitempty"`
+
+	// PreferredDERP is the code of the DERP region with the lowest latency,
+	// such as "sin".
+	PreferredDERP string `json:"preferred_derp,omitempty"`
+
+	// DERPRegions are the DERP regions by increasing latency, followed by
+	// those which could not be reached.
+	DERPRegions []DERPRegionLatency `json:"derp_regions"`
+
+	// Peers are how the peers of the node are reached.
+	Peers []PeerConnection `json:"peers"`
+}
+
+// DERPRegionLatency is the latency to a DERP region.
+type DERPRegionLatency struct {
+	ID        int     `json:"id"`
+	Code      string  `json:"code"`
+	Name      string  `json:"name"`
+	Reachable bool    `json:"reachable"`
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+}
+
+// PeerConnection describes how a peer is reached.
+type PeerConnection struct {
+	Name   string `json:"name"`
+	Online bool   `json:"online"`
+
+	// Active is whether traffic was exchanged with the peer recently. The
+	// connection of an idle peer is that it would start with.
+	Active bool `json:"active"`
+
+	// Connection is ConnectionDirect, ConnectionPeerRelay or
+	// ConnectionDERP, or empty if the peer cannot be reached.
+	Connection string `json:"connection,omitempty"`
+
+	// Address is the address of the peer for direct connections and of the
+	// relay for peer relay connections.
+	Address string `json:"address,omitempty"`
+
+	// DERPRegion is the code of the home DERP region of the peer, which
+	// relays the traffic of DERP connections.
+	DERPRegion string `json:"derp_region,omitempty"`
+}
+
+// HandleNetcheck registers NetcheckHandler for "GET /debug/netcheck" on the
+// router of the server for the callers authorized by policy only. It panics
+// if policy is nil.
+func (s *Server) HandleNetcheck(policy *Policy) {
+	if policy == nil {
+		panic("server: netcheck requires a policy")
+	}
+	s.router.Handle("GET "+NetcheckPath, policy, s.NetcheckHandler())
+}
+
+// NetcheckHandler returns a handler running Netcheck and writing its report
+// as JSON. It takes a few seconds to respond.
+func (s *Server) NetcheckHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, err := s.Netcheck(r.Context())
+		if err != nil {
+			logf(slog.LevelError, "failed to run netcheck: %v", err)
+			http.Error(w, "failed to run netcheck", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, report)
+	})
+}
+
+// Netcheck probes the network of the host like "tailscale netcheck", with
+// the DERP map of the node, and reports the latencies to the DERP regions
+// together with whether each peer is reached directly or relayed, for
+// example to find out why a server is slow from some place.
+func (s *Server) Netcheck(ctx context.Context) (NetcheckReport, error) {
+	if s.localMode() {
+		return NetcheckReport{}, ErrLocalMode
+	}
+	derpMap, err := s.tsClient.CurrentDERPMap(ctx)
+	if err != nil {
+		return NetcheckReport{}, fmt.Errorf("failed to get DERP map: %w", err)
+	}
+	report, err := runNetcheck(ctx, derpMap)
+	if err != nil {
+		return NetcheckReport{}, err
+	}
+	status, err := s.tsClient.Status(ctx)
+	if err != nil {
+		return NetcheckReport{}, fmt.Errorf("failed to get tailscale status: %w", err)
+	}
+	return newNetcheckReport(derpMap, report, status), nil
+}
+
+// runNetcheck probes the network with sockets of its own, as tailscaled does
+// not expose its reports.
+func runNetcheck(ctx context.Context, derpMap *tailcfg.DERPMap) (*netcheck.Report, error) {
+	discard := func(string, ...any) {}
+	bus := eventbus.New()
+	defer bus.Close()
+	netMon, err := netmon.New(bus, discard)
+	if err != nil {
+		return nil, fmt.Errorf("failed to monitor network: %w", err)
+	}
+	defer func() { _ = netMon.Close() }()
+	client := &netcheck.Client{NetMon: netMon, Logf: discard}
+	if err := client.Standalone(ctx, ""); err != nil {
+		// without UDP the report tells that everything is relayed
+		logf(slog.LevelWarn, "netcheck cannot probe over UDP: %v", err)
+	}
+	report, err := client.GetReport(ctx, derpMap, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run netcheck: %w", err)
+	}
+	return report, nil
+}
+
+func newNetcheckReport(derpMap *tailcfg.DERPMap, report *netcheck.Report, status *ipnstate.Status) NetcheckReport {
+	result := NetcheckReport{
+		Time:        report.Now,
+		UDP:         report.UDP,
+		IPv4:        report.IPv4,
+		IPv6:        report.IPv6,
+		DERPRegions: []DERPRegionLatency{},
+		Peers:       []PeerConnection{},
+	}
+	if report.GlobalV4.IsValid() {
+		result.GlobalV4 = report.GlobalV4.String()
+	}
+	if report.GlobalV6.IsValid() {
+		result.GlobalV6 = report.GlobalV6.String()
+	}
+	for id, region := range derpMap.Regions {
+		latency, reachable := report.RegionLatency[id]
+		result.DERPRegions = append(result.DERPRegions, DERPRegionLatency{
+			ID:        id,
+			Code:      region.RegionCode,
+			Name:      region.RegionName,
+			Reachable: reachable,
+			LatencyMS: float64(latency) / float64(time.Millisecond),
+		})
+		if id == report.PreferredDERP {
+			result.PreferredDERP = region.RegionCode
+		}
+	}
+	slices.SortFunc(result.DERPRegions, func(a, b DERPRegionLatency) int {
+		if a.Reachable != b.Reachable {
+			if a.Reachable {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(cmp.Compare(a.LatencyMS, b.LatencyMS), cmp.Compare(a.ID, b.ID))
+	})
+
+	for _, peer := range status.Peer {
+		connection := PeerConnection{
+			Name:       strings.TrimSuffix(peer.DNSName, "."),
+			Online:     peer.Online,
+			Active:     peer.Active,
+			DERPRegion: peer.Relay,
+		}
+		switch {
+		case peer.CurAddr != "":
+			connection.Connection = ConnectionDirect
+			connection.Address = peer.CurAddr
+		case peer.PeerRelay != "":
+			connection.Connection = ConnectionPeerRelay
+			connection.Address = peer.PeerRelay
+		case peer.Relay != "":
+			connection.Connection = ConnectionDERP
+		}
+		result.Peers = append(result.Peers, connection)
+	}
+	slices.SortFunc(result.Peers, func(a, b PeerConnection) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result
+}
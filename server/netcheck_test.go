@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestNewNetcheckReport(t *testing.T) {
+	now := time.Date(2026, time.October, 15, 8, 0, 0, 0, time.UTC)
+	derpMap := &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{
+		1:  {RegionID: 1, RegionCode: "nyc", RegionName: "New York City"},
+		2:  {RegionID: 2, RegionCode: "sin", RegionName: "Singapore"},
+		3:  {RegionID: 3, RegionCode: "hkg", RegionName: "Hong Kong"},
+		10: {RegionID: 10, RegionCode: "sea", RegionName: "Seattle"},
+	}}
+	report := &netcheck.Report{
+		Now:           now,
+		UDP:           true,
+		IPv4:          true,
+		GlobalV4:      netip.MustParseAddrPort("203.0.113.7:41641"),
+		PreferredDERP: 3,
+		RegionLatency: map[int]time.Duration{
+			1: 230 * time.Millisecond,
+			2: 35 * time.Millisecond,
+			3: 4500 * time.Microsecond,
+		},
+	}
+	status := &ipnstate.Status{Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+		key.NewNode().Public(): {DNSName: "laptop.prawn-universe.ts.net.", Online: true, Active: true, CurAddr: "198.51.100.4:41641", Relay: "hkg"},
+		key.NewNode().Public(): {DNSName: "phone.prawn-universe.ts.net.", Online: true, Active: true, Relay: "sin"},
+		key.NewNode().Public(): {DNSName: "nas.prawn-universe.ts.net.", Online: true, PeerRelay: "100.64.0.9:40000", Relay: "hkg"},
+		key.NewNode().Public(): {DNSName: "old.prawn-universe.ts.net."},
+	}}
+
+	want := NetcheckReport{
+		Time:          now,
+		UDP:           true,
+		IPv4:          true,
+		GlobalV4:      "203.0.113.7:41641",
+		PreferredDERP: "hkg",
+		DERPRegions: []DERPRegionLatency{
+			{ID: 3, Code: "hkg", Name: "Hong Kong", Reachable: true, LatencyMS: 4.5},
+			{ID: 2, Code: "sin", Name: "Singapore", Reachable: true, LatencyMS: 35},
+			{ID: 1, Code: "nyc", Name: "New York City", Reachable: true, LatencyMS: 230},
+			{ID: 10, Code: "sea", Name: "Seattle"},
+		},
+		Peers: []PeerConnection{
+			{Name: "laptop.prawn-universe.ts.net", Online: true, Active: true, Connection: ConnectionDirect, Address: "198.51.100.4:41641", DERPRegion: "hkg"},
+			{Name: "nas.prawn-universe.ts.net", Online: true, Connection: ConnectionPeerRelay, Address: "100.64.0.9:40000", DERPRegion: "hkg"},
+			{Name: "old.prawn-universe.ts.net"},
+			{Name: "phone.prawn-universe.ts.net", Online: true, Active: true, Connection: ConnectionDERP, DERPRegion: "sin"},
+		},
+	}
+	if got := newNetcheckReport(derpMap, report, status); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestHandleNetcheckWithoutPolicy(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic")
+		}
+	}()
+	s := &Server{router: newRouter(nil)}
+	s.HandleNetcheck(nil)
+}
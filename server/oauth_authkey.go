@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/clientcredentials"
+	"tailscale.com/client/tailscale"
+)
+
+// DefaultOAuthAuthKeyExpiry is the validity of auth keys minted by an
+// OAuthAuthKeySource. The keys are used immediately so they only need to
+// live long enough for the node to log in.
+const DefaultOAuthAuthKeyExpiry = 5 * time.Minute
+
+// OAuthAuthKeyConfig configures an OAuthAuthKeySource.
+type OAuthAuthKeyConfig struct {
+	// ClientID and ClientSecret are the credentials of a Tailscale OAuth
+	// client with the auth_keys scope.
+	ClientID     string
+	ClientSecret string
+
+	// Tags are applied to the nodes registered with the minted keys. They
+	// are required as nodes registered by OAuth clients have to be tagged,
+	// and the OAuth client has to own them.
+	Tags []string
+
+	// Ephemeral mints keys registering ephemeral nodes.
+	Ephemeral bool
+
+	// Preauthorized mints keys registering nodes which do not need to be
+	// approved when device approval is enabled on the tailnet.
+	Preauthorized bool
+
+	// Expiry is the validity of the minted keys. It defaults to
+	// DefaultOAuthAuthKeyExpiry.
+	Expiry time.Duration
+
+	// BaseURL is the URL of the Tailscale API. It defaults to
+	// https://api.tailscale.com.
+	BaseURL string
+}
+
+// OAuthAuthKeySource is an AuthKeySource which mints a single-use auth key
+// using a Tailscale OAuth client whenever a key is needed, so no long-lived
+// reusable key has to be deployed. Using the Tailscale API requires
+// tailscale.I_Acknowledge_This_API_Is_Unstable to be set.
+type OAuthAuthKeySource struct {
+	config OAuthAuthKeyConfig
+}
+
+// NewOAuthAuthKeySource creates an OAuthAuthKeySource using the specified
+// configuration.
+func NewOAuthAuthKeySource(config OAuthAuthKeyConfig) (*OAuthAuthKeySource, error) {
+	if config.ClientSecret == "" {
+		return nil, fmt.Errorf("oauth client secret cannot be empty")
+	}
+	if len(config.Tags) == 0 {
+		return nil, fmt.Errorf("tags must be specified for auth keys minted by an oauth client")
+	}
+	for _, tag := range config.Tags {
+		if !isValidTag(tag) {
+			return nil, fmt.Errorf("tag [%s] must be in the form of tag:name", tag)
+		}
+	}
+	if config.Expiry < 0 {
+		return nil, fmt.Errorf("auth key expiry cannot be negative")
+	}
+	if config.Expiry == 0 {
+		config.Expiry = DefaultOAuthAuthKeyExpiry
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = "https://api.tailscale.com"
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	return &OAuthAuthKeySource{config: config}, nil
+}
+
+// Get mints a new auth key.
+func (s *OAuthAuthKeySource) Get(ctx context.Context) (string, error) {
+	credentials := clientcredentials.Config{
+		ClientID:     s.config.ClientID,
+		ClientSecret: s.config.ClientSecret,
+		TokenURL:     s.config.BaseURL + "/api/v2/oauth/token",
+	}
+	client := tailscale.NewClient("-", nil)
+	client.BaseURL = s.config.BaseURL
+	client.HTTPClient = credentials.Client(ctx)
+
+	caps := tailscale.KeyCapabilities{
+		Devices: tailscale.KeyDeviceCapabilities{
+			Create: tailscale.KeyDeviceCreateCapabilities{
+				Ephemeral:     s.config.Ephemeral,
+				Preauthorized: s.config.Preauthorized,
+				Tags:          s.config.Tags,
+			},
+		},
+	}
+	key, _, err := client.CreateKeyWithExpiry(ctx, caps, s.config.Expiry)
+	if err != nil {
+		return "", fmt.Errorf("failed to mint auth key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale"
+)
+
+func TestNewOAuthAuthKeySource(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  OAuthAuthKeyConfig
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			config: OAuthAuthKeyConfig{
+				ClientID:     "client",
+				ClientSecret: "tskey-client-secret",
+				Tags:         []string{"tag:web"},
+			},
+		},
+		{
+			name: "empty secret",
+			config: OAuthAuthKeyConfig{
+				ClientID: "client",
+				Tags:     []string{"tag:web"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "no tags",
+			config: OAuthAuthKeyConfig{
+				ClientID:     "client",
+				ClientSecret: "tskey-client-secret",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid tag",
+			config: OAuthAuthKeyConfig{
+				ClientID:     "client",
+				ClientSecret: "tskey-client-secret",
+				Tags:         []string{"web"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative expiry",
+			config: OAuthAuthKeyConfig{
+				ClientID:     "client",
+				ClientSecret: "tskey-client-secret",
+				Tags:         []string{"tag:web"},
+				Expiry:       -time.Minute,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewOAuthAuthKeySource(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewOAuthAuthKeySource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOAuthAuthKeySourceGet(t *testing.T) {
+	tailscale.I_Acknowledge_This_API_Is_Unstable = true
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v2/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "tskey-client-secret" {
+			http.Error(w, "invalid client", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"access-token","token_type":"bearer","expires_in":3600}`))
+	})
+	mux.HandleFunc("POST /api/v2/tailnet/-/keys", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Capabilities  tailscale.KeyCapabilities `json:"capabilities"`
+			ExpirySeconds int64                     `json:"expirySeconds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		create := request.Capabilities.Devices.Create
+		if !slices.Equal(create.Tags, []string{"tag:web"}) || !create.Ephemeral || create.Reusable || request.ExpirySeconds != 300 {
+			http.Error(w, "unexpected key request", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"k123","key":"tskey-auth-minted"}`))
+	})
+	api := httptest.NewServer(mux)
+	defer api.Close()
+
+	source, err := NewOAuthAuthKeySource(OAuthAuthKeyConfig{
+		ClientID:     "client",
+		ClientSecret: "tskey-client-secret",
+		Tags:         []string{"tag:web"},
+		Ephemeral:    true,
+		BaseURL:      api.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := source.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "tskey-auth-minted" {
+		t.Errorf("got %q; want %q", got, "tskey-auth-minted")
+	}
+}
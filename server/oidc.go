@@ -0,0 +1,375 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// DefaultOIDCTokenLifetime is the lifetime of tokens issued by OIDCProvider
+// unless OIDCConfig.TokenLifetime is set.
+const DefaultOIDCTokenLifetime = time.Hour
+
+// oidcAccessTokenType is the type claim of access tokens, which tells them
+// apart from ID tokens signed by the same key.
+const oidcAccessTokenType = "at+jwt"
+
+// oidcCodeLifetime is the time a relying party has to redeem an
+// authorization code.
+const oidcCodeLifetime = time.Minute
+
+// OIDCClient is a relying party, such as Grafana or Argo CD, allowed to
+// authenticate users against an OIDCProvider.
+type OIDCClient struct {
+	ID           string
+	Secret       string
+	RedirectURIs []string
+}
+
+// OIDCConfig is the configuration of an OIDCProvider.
+type OIDCConfig struct {
+	// Issuer is the URL of the provider. It defaults to the HTTPS URL of the
+	// fully qualified domain name of the server.
+	Issuer string
+
+	// Clients are the relying parties allowed to use the provider.
+	Clients []OIDCClient
+
+	// SigningKey is the key signing tokens. A key is generated if it is nil,
+	// which invalidates issued tokens whenever the server restarts.
+	SigningKey *rsa.PrivateKey
+
+	// TokenLifetime is the lifetime of issued tokens. It defaults to
+	// DefaultOIDCTokenLifetime.
+	TokenLifetime time.Duration
+}
+
+// OIDCProvider is a minimal OpenID Connect identity provider which
+// authenticates users by their tailnet identity. It supports the
+// authorization code flow with optional PKCE. Mount it at the root of a
+// server since the paths of its endpoints are fixed.
+type OIDCProvider struct {
+	issuer        string
+	clients       map[string]OIDCClient
+	tokenLifetime time.Duration
+	signer        *jwtSigner
+	mux           *http.ServeMux
+
+	mu    sync.Mutex
+	codes map[string]oidcAuthorizationCode
+}
+
+type oidcAuthorizationCode struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	claims        oidcClaims
+	expires       time.Time
+}
+
+// oidcClaims are the claims of ID tokens and access tokens.
+type oidcClaims struct {
+	Issuer            string `json:"iss"`
+	Type              string `json:"typ,omitempty"`
+	Subject           string `json:"sub"`
+	Audience          string `json:"aud"`
+	Expiry            int64  `json:"exp"`
+	IssuedAt          int64  `json:"iat"`
+	Nonce             string `json:"nonce,omitempty"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username"`
+	Picture           string `json:"picture,omitempty"`
+}
+
+// NewOIDCProvider creates an OIDCProvider for this server.
+func (s *Server) NewOIDCProvider(config *OIDCConfig) (*OIDCProvider, error) {
+	issuer := config.Issuer
+	if issuer == "" {
+		issuer = "https://" + s.fqdn
+	}
+	return newOIDCProvider(s.identify, issuer, config)
+}
+
+func newOIDCProvider(identify identifyFunc, issuer string, config *OIDCConfig) (*OIDCProvider, error) {
+	if len(config.Clients) == 0 {
+		return nil, fmt.Errorf("at least one OIDC client must be specified")
+	}
+	signer, err := newJWTSigner(config.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	p := &OIDCProvider{
+		issuer:        strings.TrimSuffix(issuer, "/"),
+		clients:       make(map[string]OIDCClient, len(config.Clients)),
+		tokenLifetime: config.TokenLifetime,
+		signer:        signer,
+		mux:           http.NewServeMux(),
+		codes:         make(map[string]oidcAuthorizationCode),
+	}
+	if p.tokenLifetime == 0 {
+		p.tokenLifetime = DefaultOIDCTokenLifetime
+	}
+	for _, client := range config.Clients {
+		if client.ID == "" || client.Secret == "" || len(client.RedirectURIs) == 0 {
+			return nil, fmt.Errorf("OIDC client [%s] must have an ID, a secret and redirect URIs", client.ID)
+		}
+		p.clients[client.ID] = client
+	}
+
+	p.mux.HandleFunc("GET /.well-known/openid-configuration", p.serveDiscovery)
+	p.mux.HandleFunc("GET /jwks", p.serveJWKS)
+	p.mux.Handle("GET /authorize", withIdentity(identify, http.HandlerFunc(p.serveAuthorize)))
+	p.mux.HandleFunc("POST /token", p.serveToken)
+	p.mux.HandleFunc("GET /userinfo", p.serveUserInfo)
+	return p, nil
+}
+
+// ServeHTTP serves the endpoints of the provider.
+func (p *OIDCProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+func (p *OIDCProvider) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"userinfo_endpoint":                     p.issuer + "/userinfo",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"claims_supported":                      []string{"iss", "sub", "aud", "exp", "iat", "nonce", "email", "email_verified", "name", "preferred_username", "picture"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *OIDCProvider) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.signer.jwks())
+}
+
+func (p *OIDCProvider) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	client, found := p.clients[query.Get("client_id")]
+	if !found {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+	redirectURI := query.Get("redirect_uri")
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		http.Error(w, "redirect URI is not registered", http.StatusBadRequest)
+		return
+	}
+	redirectURL, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect URI", http.StatusBadRequest)
+		return
+	}
+	redirect := func(params url.Values) {
+		params.Set("state", query.Get("state"))
+		u := *redirectURL
+		merged := u.Query()
+		for key, values := range params {
+			merged[key] = values
+		}
+		u.RawQuery = merged.Encode()
+		http.Redirect(w, r, u.String(), http.StatusFound)
+	}
+	if query.Get("response_type") != "code" {
+		redirect(url.Values{"error": {"unsupported_response_type"}})
+		return
+	}
+	if !slices.Contains(strings.Fields(query.Get("scope")), "openid") {
+		redirect(url.Values{"error": {"invalid_scope"}})
+		return
+	}
+	codeChallenge := query.Get("code_challenge")
+	if codeChallenge != "" && query.Get("code_challenge_method") != "S256" {
+		redirect(url.Values{"error": {"invalid_request"}, "error_description": {"only S256 code challenges are supported"}})
+		return
+	}
+
+	who, _ := IdentityFromContext(r.Context())
+	if who == nil || who.Node == nil || who.UserProfile == nil {
+		redirect(url.Values{"error": {"access_denied"}, "error_description": {"caller identity is incomplete"}})
+		return
+	}
+	if who.Node.IsTagged() {
+		redirect(url.Values{"error": {"access_denied"}, "error_description": {"tagged nodes cannot sign in"}})
+		return
+	}
+	code, err := randomToken()
+	if err != nil {
+		logf(slog.LevelError, "failed to generate authorization code: %v", err)
+		redirect(url.Values{"error": {"server_error"}})
+		return
+	}
+	loginName := who.UserProfile.LoginName
+	username, _, _ := strings.Cut(loginName, "@")
+	claims := oidcClaims{
+		Issuer:            p.issuer,
+		Subject:           oidcSubject(who),
+		Audience:          client.ID,
+		Nonce:             query.Get("nonce"),
+		Email:             loginName,
+		EmailVerified:     true,
+		Name:              who.UserProfile.DisplayName,
+		PreferredUsername: username,
+		Picture:           who.UserProfile.ProfilePicURL,
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	for c, authorization := range p.codes {
+		if !now.Before(authorization.expires) {
+			delete(p.codes, c)
+		}
+	}
+	p.codes[code] = oidcAuthorizationCode{
+		clientID:      client.ID,
+		redirectURI:   redirectURI,
+		codeChallenge: codeChallenge,
+		claims:        claims,
+		expires:       now.Add(oidcCodeLifetime),
+	}
+	p.mu.Unlock()
+	redirect(url.Values{"code": {code}})
+}
+
+func (p *OIDCProvider) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	clientID, clientSecret, found := r.BasicAuth()
+	if !found {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, found := p.clients[clientID]
+	if !found || subtle.ConstantTimeCompare([]byte(client.Secret), []byte(clientSecret)) != 1 {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	authorization, found := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	if !found || !time.Now().Before(authorization.expires) ||
+		authorization.clientID != client.ID ||
+		authorization.redirectURI != r.PostForm.Get("redirect_uri") ||
+		!verifyCodeChallenge(authorization.codeChallenge, r.PostForm.Get("code_verifier")) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := authorization.claims
+	claims.IssuedAt = now.Unix()
+	claims.Expiry = now.Add(p.tokenLifetime).Unix()
+	idToken, err := p.signer.sign(claims)
+	if err != nil {
+		logf(slog.LevelError, "failed to sign ID token: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	claims.Nonce = ""
+	claims.Type = oidcAccessTokenType
+	accessToken, err := p.signer.sign(claims)
+	if err != nil {
+		logf(slog.LevelError, "failed to sign access token: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"id_token":     idToken,
+		"token_type":   "Bearer",
+		"expires_in":   int64(p.tokenLifetime / time.Second),
+	})
+}
+
+func (p *OIDCProvider) serveUserInfo(w http.ResponseWriter, r *http.Request) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	var claims oidcClaims
+	if !found || p.signer.verify(token, &claims) != nil || claims.Type != oidcAccessTokenType || claims.Issuer != p.issuer || time.Now().Unix() >= claims.Expiry {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid access token", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sub":                claims.Subject,
+		"email":              claims.Email,
+		"email_verified":     claims.EmailVerified,
+		"name":               claims.Name,
+		"preferred_username": claims.PreferredUsername,
+		"picture":            claims.Picture,
+	})
+}
+
+// oidcSubject returns the subject of the tokens of the caller, which is the
+// Tailscale user ID. Identity providers such as DevIdentityProvider set no
+// user ID, so the login name is the subject of their callers instead.
+func oidcSubject(who *apitype.WhoIsResponse) string {
+	if who.UserProfile.ID == 0 {
+		return who.UserProfile.LoginName
+	}
+	return strconv.FormatInt(int64(who.UserProfile.ID), 10)
+}
+
+// verifyCodeChallenge reports whether verifier matches the S256 PKCE
+// challenge. It returns true if no challenge has been issued.
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if challenge == "" {
+		return true
+	}
+	digest := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(digest[:])), []byte(challenge)) == 1
+}
+
+// randomToken returns a random URL-safe token.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+// writeJSON writes v as the JSON response with the specified status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logf(slog.LevelError, "failed to write response: %v", err)
+	}
+}
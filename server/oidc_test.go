@@ -0,0 +1,218 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestOIDCProvider(t *testing.T) {
+	who := newTestWhoIs("alice@example.com")
+	who.UserProfile.ID = 42
+	who.UserProfile.DisplayName = "Alice"
+	identify := func(*http.Request) (*apitype.WhoIsResponse, error) { return who, nil }
+	provider, err := newOIDCProvider(identify, "https://idp.prawn-universe.ts.net", &OIDCConfig{
+		Clients: []OIDCClient{{
+			ID:           "grafana",
+			Secret:       "secret",
+			RedirectURIs: []string{"https://grafana.prawn-universe.ts.net/login/generic_oauth"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := "test-code-verifier"
+	digest := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"grafana"},
+		"redirect_uri":          {"https://grafana.prawn-universe.ts.net/login/generic_oauth"},
+		"scope":                 {"openid email"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(digest[:])},
+		"code_challenge_method": {"S256"},
+	}
+	w := httptest.NewRecorder()
+	provider.ServeHTTP(w, httptest.NewRequest("GET", "/authorize?"+query.Encode(), nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("authorize: got %d; want %d", w.Code, http.StatusFound)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Query().Get("state") != "xyz" {
+		t.Errorf("got state %q; want %q", location.Query().Get("state"), "xyz")
+	}
+	code := location.Query().Get("code")
+
+	redeem := func(code, verifier string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {"https://grafana.prawn-universe.ts.net/login/generic_oauth"},
+			"code_verifier": {verifier},
+		}
+		r := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth("grafana", "secret")
+		w := httptest.NewRecorder()
+		provider.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := redeem(code, "wrong-verifier"); w.Code != http.StatusBadRequest {
+		t.Errorf("token with wrong verifier: got %d; want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = httptest.NewRecorder()
+	provider.ServeHTTP(w, httptest.NewRequest("GET", "/authorize?"+query.Encode(), nil))
+	location, _ = url.Parse(w.Header().Get("Location"))
+	code = location.Query().Get("code")
+	w = redeem(code, verifier)
+	if w.Code != http.StatusOK {
+		t.Fatalf("token: got %d; want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&tokens); err != nil {
+		t.Fatal(err)
+	}
+	var claims oidcClaims
+	if err := provider.signer.verify(tokens.IDToken, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "42" || claims.Email != "alice@example.com" || claims.Nonce != "n-0S6" || claims.Audience != "grafana" {
+		t.Errorf("got claims %+v", claims)
+	}
+
+	if w := redeem(code, verifier); w.Code != http.StatusBadRequest {
+		t.Errorf("token with redeemed code: got %d; want %d", w.Code, http.StatusBadRequest)
+	}
+
+	r := httptest.NewRequest("GET", "/userinfo", nil)
+	r.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	w = httptest.NewRecorder()
+	provider.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("userinfo: got %d; want %d", w.Code, http.StatusOK)
+	}
+	var userInfo map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&userInfo); err != nil {
+		t.Fatal(err)
+	}
+	if userInfo["preferred_username"] != "alice" {
+		t.Errorf("got preferred_username %v; want alice", userInfo["preferred_username"])
+	}
+
+	r = httptest.NewRequest("GET", "/userinfo", nil)
+	r.Header.Set("Authorization", "Bearer "+tokens.IDToken)
+	w = httptest.NewRecorder()
+	provider.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("userinfo with ID token: got %d; want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestOIDCProviderAuthorize(t *testing.T) {
+	withoutUserProfile := newTestWhoIs("alice@example.com")
+	withoutUserProfile.UserProfile = nil
+	tests := []struct {
+		name        string
+		who         *apitype.WhoIsResponse
+		redirectURI string
+		wantQuery   url.Values
+		wantSubject string
+	}{
+		{
+			name:        "subject from login name without user ID",
+			who:         newTestWhoIs("alice@example.com"),
+			redirectURI: "https://grafana.example.com/cb",
+			wantSubject: "alice@example.com",
+		},
+		{
+			name:        "redirect URI with query",
+			who:         newTestWhoIs("alice@example.com"),
+			redirectURI: "https://grafana.example.com/cb?tenant=ops",
+			wantQuery:   url.Values{"tenant": {"ops"}, "state": {"xyz"}},
+			wantSubject: "alice@example.com",
+		},
+		{
+			name:        "without user profile",
+			who:         withoutUserProfile,
+			redirectURI: "https://grafana.example.com/cb",
+			wantQuery:   url.Values{"error": {"access_denied"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identify := func(*http.Request) (*apitype.WhoIsResponse, error) { return tt.who, nil }
+			provider, err := newOIDCProvider(identify, "https://idp.prawn-universe.ts.net", &OIDCConfig{
+				Clients: []OIDCClient{{ID: "grafana", Secret: "secret", RedirectURIs: []string{tt.redirectURI}}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := url.Values{
+				"response_type": {"code"},
+				"client_id":     {"grafana"},
+				"redirect_uri":  {tt.redirectURI},
+				"scope":         {"openid"},
+				"state":         {"xyz"},
+			}
+			w := httptest.NewRecorder()
+			provider.ServeHTTP(w, httptest.NewRequest("GET", "/authorize?"+query.Encode(), nil))
+			if w.Code != http.StatusFound {
+				t.Fatalf("got %d; want %d", w.Code, http.StatusFound)
+			}
+			location, err := url.Parse(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key := range tt.wantQuery {
+				if got, want := location.Query().Get(key), tt.wantQuery.Get(key); got != want {
+					t.Errorf("got %s %q in %s; want %q", key, got, location, want)
+				}
+			}
+			if tt.wantSubject == "" {
+				return
+			}
+			code := location.Query().Get("code")
+			if got := provider.codes[code].claims.Subject; got != tt.wantSubject {
+				t.Errorf("got subject %q; want %q", got, tt.wantSubject)
+			}
+		})
+	}
+}
+
+func TestOIDCProviderAuthorizeRejectsUnknownRedirect(t *testing.T) {
+	identify := func(*http.Request) (*apitype.WhoIsResponse, error) { return newTestWhoIs("alice@example.com"), nil }
+	provider, err := newOIDCProvider(identify, "https://idp.prawn-universe.ts.net", &OIDCConfig{
+		Clients: []OIDCClient{{ID: "grafana", Secret: "secret", RedirectURIs: []string{"https://grafana.example.com/cb"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {"grafana"},
+		"redirect_uri":  {"https://evil.example.com/cb"},
+		"scope":         {"openid"},
+	}
+	w := httptest.NewRecorder()
+	provider.ServeHTTP(w, httptest.NewRequest("GET", "/authorize?"+query.Encode(), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got %d; want %d", w.Code, http.StatusBadRequest)
+	}
+}
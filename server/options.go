@@ -0,0 +1,157 @@
+package server
+
+import (
+	"log/slog"
+
+	"tailscale.com/ipn"
+)
+
+// Option configures a Server created by New.
+type Option func(*ServerConfig)
+
+// New creates and initializes a new Server registered with authKey under
+// hostname and configured by opts. It is equivalent to NewServer with a
+// ServerConfig, which stays available for settings without an Option.
+func New(authKey, hostname string, opts ...Option) (*Server, error) {
+	return NewServer(newConfig(authKey, hostname, opts...))
+}
+
+func newConfig(authKey, hostname string, opts ...Option) *ServerConfig {
+	config := &ServerConfig{
+		TailscaleAuthKey: authKey,
+		Hostname:         hostname,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// WithStateDir sets the directory of the node state and logs.
+func WithStateDir(dir string) Option {
+	return func(c *ServerConfig) {
+		c.TailscaleStateDirectory = dir
+	}
+}
+
+// WithEphemeral registers the node as ephemeral. See ServerConfig.Ephemeral.
+func WithEphemeral() Option {
+	return func(c *ServerConfig) {
+		c.Ephemeral = true
+	}
+}
+
+// WithControlURL sets the URL of the coordination server, such as a
+// self-hosted Headscale server.
+func WithControlURL(url string) Option {
+	return func(c *ServerConfig) {
+		c.ControlURL = url
+	}
+}
+
+// WithAdvertiseTags sets the ACL tags requested by the node.
+func WithAdvertiseTags(tags ...string) Option {
+	return func(c *ServerConfig) {
+		c.AdvertiseTags = tags
+	}
+}
+
+// WithAdvertiseRoutes sets the subnet routes advertised by the node.
+func WithAdvertiseRoutes(routes ...string) Option {
+	return func(c *ServerConfig) {
+		c.AdvertiseRoutes = routes
+	}
+}
+
+// WithExitNode sets the exit node through which outbound connections egress.
+func WithExitNode(node string) Option {
+	return func(c *ServerConfig) {
+		c.ExitNode = node
+	}
+}
+
+// WithAcceptRoutes makes the subnet routes of other nodes reachable.
+func WithAcceptRoutes() Option {
+	return func(c *ServerConfig) {
+		c.AcceptRoutes = true
+	}
+}
+
+// WithWebClient serves the Tailscale web client on port 5252 of the node.
+func WithWebClient() Option {
+	return func(c *ServerConfig) {
+		c.RunWebClient = true
+	}
+}
+
+// WithStatusPage serves StatusPage for "GET /{$}" on the router of the server.
+func WithStatusPage() Option {
+	return func(c *ServerConfig) {
+		c.StatusPage = true
+	}
+}
+
+// WithMetrics serves MetricsHandler for "GET /metrics" on the router of the
+// server and records the requests of Server.Handler.
+func WithMetrics() Option {
+	return func(c *ServerConfig) {
+		c.Metrics = true
+	}
+}
+
+// WithHealthCheck serves HealthHandler for "GET /healthz" on the router of
+// the server.
+func WithHealthCheck() Option {
+	return func(c *ServerConfig) {
+		c.HealthCheck = true
+	}
+}
+
+// WithLocalMode runs the server without joining a tailnet, with listeners
+// bound to address, or DefaultLocalAddress if it is empty. See
+// ServerConfig.LocalMode.
+func WithLocalMode(address string) Option {
+	return func(c *ServerConfig) {
+		c.LocalMode = true
+		c.LocalAddress = address
+	}
+}
+
+// WithStateStore keeps the node state in store, such as an S3Store.
+func WithStateStore(store ipn.StateStore) Option {
+	return func(c *ServerConfig) {
+		c.StateStore = store
+	}
+}
+
+// WithDebugStatus serves the DebugStatus of the node as JSON for
+// "GET /debug/status" for the callers authorized by policy.
+func WithDebugStatus(policy *Policy) Option {
+	return func(c *ServerConfig) {
+		c.DebugStatus = policy
+	}
+}
+
+// WithNetcheck serves the NetcheckReport of the node as JSON for
+// "GET /debug/netcheck" for the callers authorized by policy.
+func WithNetcheck(policy *Policy) Option {
+	return func(c *ServerConfig) {
+		c.Netcheck = policy
+	}
+}
+
+// WithHooks calls hooks on the events of the server.
+func WithHooks(hooks Hooks) Option {
+	return func(c *ServerConfig) {
+		c.Hooks = hooks
+	}
+}
+
+// WithLogger writes the messages intended for the user to logger as
+// structured records, and the logs of the Tailscale backend at debug level
+// if ServerConfig.LogLevel is slog.LevelDebug.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *ServerConfig) {
+		c.Logger = logger
+	}
+}
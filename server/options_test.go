@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn/store/mem"
+)
+
+func TestNewConfig(t *testing.T) {
+	var buf bytes.Buffer
+	store := new(mem.Store)
+	config := newConfig("tskey-test", "test-hostname",
+		WithStateDir("/var/lib/tailscale"),
+		WithEphemeral(),
+		WithControlURL("https://headscale.example.com"),
+		WithAdvertiseTags("tag:web"),
+		WithAdvertiseRoutes("192.168.1.0/24"),
+		WithExitNode("exit-sg"),
+		WithAcceptRoutes(),
+		WithWebClient(),
+		WithStatusPage(),
+		WithMetrics(),
+		WithHealthCheck(),
+		WithDebugStatus(&Policy{Allow: []Rule{{Tags: []string{"tag:inventory"}}}}),
+		WithNetcheck(&Policy{Allow: []Rule{{Tags: []string{"tag:ops"}}}}),
+		WithHooks(Hooks{OnRequest: func(AccessLogRecord) {}}),
+		WithStateStore(store),
+		WithLogger(slog.New(slog.NewTextHandler(&buf, nil))),
+	)
+
+	if config.TailscaleAuthKey != "tskey-test" ||
+		config.Hostname != "test-hostname" ||
+		config.TailscaleStateDirectory != "/var/lib/tailscale" ||
+		!config.Ephemeral ||
+		config.ExitNode != "exit-sg" ||
+		!config.AcceptRoutes ||
+		config.ControlURL != "https://headscale.example.com" ||
+		!config.RunWebClient ||
+		!config.StatusPage ||
+		!config.Metrics ||
+		!config.HealthCheck ||
+		!slices.Equal(config.AdvertiseTags, []string{"tag:web"}) ||
+		!slices.Equal(config.AdvertiseRoutes, []string{"192.168.1.0/24"}) ||
+		config.StateStore != store ||
+		config.Logger == nil ||
+		config.DebugStatus == nil || config.DebugStatus.Allow[0].Tags[0] != "tag:inventory" ||
+		config.Netcheck == nil || config.Netcheck.Allow[0].Tags[0] != "tag:ops" ||
+		config.Hooks.OnRequest == nil {
+		t.Errorf("got %+v", config)
+	}
+	if err := validateConfiguration(config); err != nil {
+		t.Errorf("validateConfiguration() error = %v", err)
+	}
+
+	config.Logger.Info("login at https://login.example.com")
+	if got := buf.String(); !strings.Contains(got, "login at https://login.example.com") {
+		t.Errorf("user message not logged; got %q", got)
+	}
+}
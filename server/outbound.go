@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+// configureOutbound routes the outbound connections of this node through the
+// exit node and the subnet routes of other nodes as configured.
+func (s *Server) configureOutbound(ctx context.Context, exitNode string, acceptRoutes bool) error {
+	status, err := s.tsClient.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get tailscale status: %w", err)
+	}
+	prefs, err := outboundPrefs(exitNode, acceptRoutes, status)
+	if err != nil {
+		return err
+	}
+	if _, err := s.tsClient.EditPrefs(ctx, prefs); err != nil {
+		return fmt.Errorf("failed to configure outbound routing: %w", err)
+	}
+	if exitNode != "" {
+		s.logger.logf(slog.LevelInfo, "using exit node [%s] at [%s]", exitNode, prefs.ExitNodeIP)
+	}
+	if acceptRoutes {
+		s.logger.logf(slog.LevelInfo, "accepting subnet routes advertised by other nodes")
+	}
+	return nil
+}
+
+// outboundPrefs returns the preferences using exitNode, which is either a
+// Tailscale IP address or a MagicDNS name, and accepting the subnet routes of
+// other nodes if acceptRoutes is set.
+func outboundPrefs(exitNode string, acceptRoutes bool, status *ipnstate.Status) (*ipn.MaskedPrefs, error) {
+	prefs := &ipn.MaskedPrefs{
+		Prefs:       ipn.Prefs{RouteAll: acceptRoutes},
+		RouteAllSet: true,
+	}
+	if exitNode != "" {
+		if err := prefs.SetExitNodeIP(exitNode, status); err != nil {
+			return nil, fmt.Errorf("invalid exit node [%s]: %w", exitNode, err)
+		}
+		prefs.ExitNodeIPSet = true
+	}
+	return prefs, nil
+}
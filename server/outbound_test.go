@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestOutboundPrefs(t *testing.T) {
+	exitNodeIP := netip.MustParseAddr("100.64.0.2")
+	status := &ipnstate.Status{
+		BackendState:   "Running",
+		TailscaleIPs:   []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+		MagicDNSSuffix: "prawn-universe.ts.net",
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {
+				DNSName:        "exit.prawn-universe.ts.net.",
+				TailscaleIPs:   []netip.Addr{exitNodeIP},
+				ExitNodeOption: true,
+			},
+			key.NewNode().Public(): {
+				DNSName:      "laptop.prawn-universe.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.3")},
+			},
+		},
+	}
+	tests := []struct {
+		name         string
+		exitNode     string
+		acceptRoutes bool
+		wantExitNode netip.Addr
+		wantErr      bool
+	}{
+		{name: "accept routes", acceptRoutes: true},
+		{name: "exit node by name", exitNode: "exit", wantExitNode: exitNodeIP},
+		{name: "exit node by address", exitNode: "100.64.0.2", wantExitNode: exitNodeIP},
+		{name: "node which is not an exit node", exitNode: "laptop", wantErr: true},
+		{name: "unknown node", exitNode: "desktop", wantErr: true},
+		{name: "this node", exitNode: "100.64.0.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs, err := outboundPrefs(tt.exitNode, tt.acceptRoutes, status)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("outboundPrefs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !prefs.RouteAllSet || prefs.RouteAll != tt.acceptRoutes {
+				t.Errorf("got RouteAll %t (set %t); want %t", prefs.RouteAll, prefs.RouteAllSet, tt.acceptRoutes)
+			}
+			if prefs.ExitNodeIP != tt.wantExitNode || prefs.ExitNodeIPSet != tt.wantExitNode.IsValid() {
+				t.Errorf("got exit node %v (set %t); want %v", prefs.ExitNodeIP, prefs.ExitNodeIPSet, tt.wantExitNode)
+			}
+		})
+	}
+}
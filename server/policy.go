@@ -0,0 +1,265 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// Rule matches callers by their tailnet identity. A caller matches a rule if
+// it matches every non-empty field of the rule, and it matches a field if it
+// matches any of the values of the field. A rule without any field set
+// matches every caller.
+type Rule struct {
+	// LoginNames are the login names of users, such as "alice@example.com".
+	LoginNames []string
+
+	// Domains are the domains of the login names of users, such as
+	// "example.com".
+	Domains []string
+
+	// NodeNames are the names of nodes, either the machine name such as
+	// "laptop" or the fully qualified domain name such as
+	// "laptop.prawn-universe.ts.net".
+	NodeNames []string
+
+	// Tags are the ACL tags of nodes, such as "tag:ci".
+	Tags []string
+
+	// Prefixes are the ranges of Tailscale IP addresses of nodes.
+	Prefixes []netip.Prefix
+
+	// Groups are the groups of users, such as "group:admins". Groups are
+	// resolved by WithGroups so that rules with groups never match if the
+	// handler is not wrapped by it.
+	Groups []string
+}
+
+// Policy decides whether a caller is authorized. Deny rules take precedence
+// over allow rules and callers matching no allow rule are denied.
+type Policy struct {
+	Allow []Rule
+	Deny  []Rule
+
+	// DeniedHandler, if set, serves requests which are not authorized. By
+	// default, a response with status 403 is returned.
+	DeniedHandler http.Handler
+}
+
+// Allowed reports whether the caller with the specified identity is
+// authorized by the policy.
+func (p *Policy) Allowed(who *apitype.WhoIsResponse) bool {
+	return p.AllowedWithGroups(who, nil)
+}
+
+// AllowedWithGroups reports whether the caller with the specified identity
+// and groups is authorized by the policy.
+func (p *Policy) AllowedWithGroups(who *apitype.WhoIsResponse, groups []string) bool {
+	if who == nil || who.Node == nil || who.UserProfile == nil {
+		return false
+	}
+	for _, rule := range p.Deny {
+		if rule.matches(who, groups) {
+			return false
+		}
+	}
+	for _, rule := range p.Allow {
+		if rule.matches(who, groups) {
+			return true
+		}
+	}
+	return false
+}
+
+// usesGroups reports whether any allow or deny rule of the policy has groups.
+func (p *Policy) usesGroups() bool {
+	hasGroups := func(rule Rule) bool { return len(rule.Groups) > 0 }
+	return slices.ContainsFunc(p.Allow, hasGroups) || slices.ContainsFunc(p.Deny, hasGroups)
+}
+
+// Matches reports whether the caller with the specified identity matches the
+// rule.
+func (r Rule) Matches(who *apitype.WhoIsResponse) bool {
+	return r.matches(who, nil)
+}
+
+func (r Rule) matches(who *apitype.WhoIsResponse, groups []string) bool {
+	if who == nil || who.Node == nil || who.UserProfile == nil {
+		return false
+	}
+	loginName := who.UserProfile.LoginName
+	if len(r.LoginNames) > 0 && !containsFold(r.LoginNames, loginName) {
+		return false
+	}
+	if len(r.Domains) > 0 && !containsFold(r.Domains, loginDomain(loginName)) {
+		return false
+	}
+	if len(r.NodeNames) > 0 && !matchesNodeName(r.NodeNames, who.Node.Name) {
+		return false
+	}
+	if len(r.Tags) > 0 && !slices.ContainsFunc(r.Tags, func(tag string) bool { return slices.Contains(who.Node.Tags, tag) }) {
+		return false
+	}
+	if len(r.Prefixes) > 0 && !matchesPrefixes(r.Prefixes, who.Node.Addresses) {
+		return false
+	}
+	if len(r.Groups) > 0 && !slices.ContainsFunc(r.Groups, func(group string) bool { return slices.Contains(groups, group) }) {
+		return false
+	}
+	return true
+}
+
+// Authorize wraps the provided handler and only admits callers authorized by
+// the policy. The identity of the caller is read from the request context so
+// the handler has to be wrapped by Server.WithIdentity as well. If any rule
+// of the policy has groups, the handler has to be wrapped by WithGroups too
+// and requests without the groups of the caller are denied.
+func Authorize(policy *Policy, h http.Handler) http.Handler {
+	usesGroups := policy.usesGroups()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who, found := IdentityFromContext(r.Context())
+		if !found {
+			logf(slog.LevelWarn, "denying request from [%s] without caller identity; is WithIdentity missing?", r.RemoteAddr)
+		}
+		groups, resolved := GroupsFromContext(r.Context())
+		if found && usesGroups && !resolved {
+			logf(slog.LevelWarn, "denying request from [%s] without caller groups; is WithGroups missing?", r.RemoteAddr)
+		}
+		if !found || (usesGroups && !resolved) || !policy.AllowedWithGroups(who, groups) {
+			authDenied(r, "denied by policy")
+			deny(policy.DeniedHandler, w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RequireTags wraps the provided handler and only admits requests from nodes
+// carrying any of the specified ACL tags, such as "tag:ci". It is meant for
+// service-to-service endpoints where the user identity is irrelevant. The
+// handler has to be wrapped by Server.WithIdentity as well. It panics if no
+// tags are specified.
+func RequireTags(tags []string, h http.Handler) http.Handler {
+	if len(tags) == 0 {
+		panic("server: RequireTags requires at least one tag")
+	}
+	return Authorize(&Policy{Allow: []Rule{{Tags: tags}}}, h)
+}
+
+// RequireUsers wraps the provided handler and only admits the users with the
+// specified login names, such as "alice@example.com". The handler has to be
+// wrapped by Server.WithIdentity as well. It panics if no login names are
+// specified.
+func RequireUsers(h http.Handler, loginNames ...string) http.Handler {
+	if len(loginNames) == 0 {
+		panic("server: RequireUsers requires at least one login name")
+	}
+	return Authorize(&Policy{Allow: []Rule{{LoginNames: loginNames}}}, h)
+}
+
+// RequireLoginDomain wraps the provided handler and only admits users whose
+// login names belong to any of the specified domains, such as "example.com".
+// The handler has to be wrapped by Server.WithIdentity as well. It panics if
+// no domains are specified.
+func RequireLoginDomain(h http.Handler, domains ...string) http.Handler {
+	if len(domains) == 0 {
+		panic("server: RequireLoginDomain requires at least one domain")
+	}
+	return Authorize(&Policy{Allow: []Rule{{Domains: domains}}}, h)
+}
+
+// RequireGroups wraps the provided handler and only admits users in any of
+// the specified groups, such as "group:admins". The handler has to be
+// wrapped by Server.WithIdentity and WithGroups as well. It panics if no
+// groups are specified.
+func RequireGroups(groups []string, h http.Handler) http.Handler {
+	if len(groups) == 0 {
+		panic("server: RequireGroups requires at least one group")
+	}
+	return Authorize(&Policy{Allow: []Rule{{Groups: groups}}}, h)
+}
+
+// DenyTaggedNodes wraps the provided handler and rejects requests from tagged
+// nodes, such as servers and CI runners, with status 403 so that only nodes
+// owned by users are admitted. The handler has to be wrapped by
+// Server.WithIdentity as well.
+func DenyTaggedNodes(h http.Handler) http.Handler {
+	return denyNodes(func(node *tailcfg.Node) bool { return node.IsTagged() }, "requests from tagged nodes are not allowed", h)
+}
+
+// DenyEphemeralNodes wraps the provided handler and rejects requests from
+// ephemeral nodes with status 403. Tailscale does not report whether a peer
+// is ephemeral so isEphemeral decides it, for example by a tag or hostname
+// convention used when registering ephemeral nodes. The handler has to be
+// wrapped by Server.WithIdentity as well.
+func DenyEphemeralNodes(isEphemeral func(*tailcfg.Node) bool, h http.Handler) http.Handler {
+	return denyNodes(isEphemeral, "requests from ephemeral nodes are not allowed", h)
+}
+
+// denyNodes wraps the provided handler and rejects requests from nodes
+// matching denied with status 403 and the specified message.
+func denyNodes(denied func(*tailcfg.Node) bool, message string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who, found := IdentityFromContext(r.Context())
+		if !found || who.Node == nil {
+			authDenied(r, "missing caller identity")
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		if denied(who.Node) {
+			authDenied(r, message)
+			http.Error(w, message, http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// deny serves a request which is not authorized with deniedHandler or a
+// response with status 403 if deniedHandler is nil.
+func deny(deniedHandler http.Handler, w http.ResponseWriter, r *http.Request) {
+	if deniedHandler != nil {
+		deniedHandler.ServeHTTP(w, r)
+		return
+	}
+	http.Error(w, "access denied", http.StatusForbidden)
+}
+
+// containsFold reports whether values contains s under case-folding.
+func containsFold(values []string, s string) bool {
+	return slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, s) })
+}
+
+// loginDomain returns the domain of a login name.
+func loginDomain(loginName string) string {
+	_, domain, _ := strings.Cut(loginName, "@")
+	return domain
+}
+
+// matchesNodeName reports whether nodeName, a fully qualified domain name
+// possibly with a trailing dot, matches any of names.
+func matchesNodeName(names []string, nodeName string) bool {
+	fqdn := strings.TrimSuffix(nodeName, ".")
+	machineName, _, _ := strings.Cut(fqdn, ".")
+	return slices.ContainsFunc(names, func(name string) bool {
+		name = strings.TrimSuffix(name, ".")
+		return strings.EqualFold(name, fqdn) || strings.EqualFold(name, machineName)
+	})
+}
+
+// matchesPrefixes reports whether any of addresses is within any of prefixes.
+func matchesPrefixes(prefixes, addresses []netip.Prefix) bool {
+	for _, address := range addresses {
+		for _, prefix := range prefixes {
+			if prefix.Contains(address.Addr()) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+func TestPolicyAllowed(t *testing.T) {
+	alice := &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			Name:      "laptop.prawn-universe.ts.net.",
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		},
+		UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+	}
+	ci := &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			Name:      "runner.prawn-universe.ts.net.",
+			Tags:      []string{"tag:ci"},
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.1.1/32")},
+		},
+		UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+	}
+
+	tests := []struct {
+		name   string
+		policy *Policy
+		who    *apitype.WhoIsResponse
+		want   bool
+	}{
+		{
+			name:   "no rules",
+			policy: &Policy{},
+			who:    alice,
+			want:   false,
+		},
+		{
+			name:   "login name",
+			policy: &Policy{Allow: []Rule{{LoginNames: []string{"Alice@example.com"}}}},
+			who:    alice,
+			want:   true,
+		},
+		{
+			name:   "domain",
+			policy: &Policy{Allow: []Rule{{Domains: []string{"example.com"}}}},
+			who:    alice,
+			want:   true,
+		},
+		{
+			name:   "machine name",
+			policy: &Policy{Allow: []Rule{{NodeNames: []string{"laptop"}}}},
+			who:    alice,
+			want:   true,
+		},
+		{
+			name:   "fully qualified node name",
+			policy: &Policy{Allow: []Rule{{NodeNames: []string{"laptop.prawn-universe.ts.net"}}}},
+			who:    alice,
+			want:   true,
+		},
+		{
+			name:   "tag",
+			policy: &Policy{Allow: []Rule{{Tags: []string{"tag:prod", "tag:ci"}}}},
+			who:    ci,
+			want:   true,
+		},
+		{
+			name:   "missing tag",
+			policy: &Policy{Allow: []Rule{{Tags: []string{"tag:ci"}}}},
+			who:    alice,
+			want:   false,
+		},
+		{
+			name:   "prefix",
+			policy: &Policy{Allow: []Rule{{Prefixes: []netip.Prefix{netip.MustParsePrefix("100.64.0.0/24")}}}},
+			who:    alice,
+			want:   true,
+		},
+		{
+			name:   "all fields of a rule have to match",
+			policy: &Policy{Allow: []Rule{{Domains: []string{"example.com"}, Tags: []string{"tag:ci"}}}},
+			who:    alice,
+			want:   false,
+		},
+		{
+			name: "deny takes precedence",
+			policy: &Policy{
+				Allow: []Rule{{Domains: []string{"example.com"}}},
+				Deny:  []Rule{{NodeNames: []string{"laptop"}}},
+			},
+			who:  alice,
+			want: false,
+		},
+		{
+			name:   "incomplete identity",
+			policy: &Policy{Allow: []Rule{{}}},
+			who:    &apitype.WhoIsResponse{},
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Allowed(tt.who); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	policy := &Policy{Allow: []Rule{{LoginNames: []string{"alice@example.com"}}}}
+	customDenied := &Policy{
+		Allow: policy.Allow,
+		DeniedHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}),
+	}
+	denyGroup := &Policy{
+		Allow: []Rule{{Domains: []string{"example.com"}}},
+		Deny:  []Rule{{Groups: []string{"group:contractors"}}},
+	}
+
+	tests := []struct {
+		name     string
+		policy   *Policy
+		who      *apitype.WhoIsResponse
+		wantCode int
+	}{
+		{
+			name:     "allowed",
+			policy:   policy,
+			who:      newTestWhoIs("alice@example.com"),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "denied",
+			policy:   policy,
+			who:      newTestWhoIs("bob@example.com"),
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "no identity",
+			policy:   policy,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "custom denied handler",
+			policy:   customDenied,
+			who:      newTestWhoIs("bob@example.com"),
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "deny by group without groups",
+			policy:   denyGroup,
+			who:      newTestWhoIs("alice@example.com"),
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.who != nil {
+				r = r.WithContext(ContextWithIdentity(context.Background(), tt.who))
+			}
+			w := httptest.NewRecorder()
+			Authorize(tt.policy, serveHandler()).ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRequireTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []string
+		wantCode int
+	}{
+		{
+			name:     "untagged node",
+			tags:     nil,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "node with other tag",
+			tags:     []string{"tag:dev"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "node with required tag",
+			tags:     []string{"tag:dev", "tag:prod"},
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			who := newTestWhoIs("tagged-devices")
+			who.Node.Tags = tt.tags
+			r := httptest.NewRequest("GET", "/", nil)
+			r = r.WithContext(ContextWithIdentity(r.Context(), who))
+			w := httptest.NewRecorder()
+			RequireTags([]string{"tag:ci", "tag:prod"}, serveHandler()).ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRequireTagsWithoutTags(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic")
+		}
+	}()
+	RequireTags(nil, serveHandler())
+}
+
+func TestDenyNodes(t *testing.T) {
+	isEphemeral := func(node *tailcfg.Node) bool {
+		return strings.HasPrefix(node.Name, "ephemeral-")
+	}
+	tests := []struct {
+		name     string
+		nodeName string
+		tags     []string
+		wantCode int
+	}{
+		{
+			name:     "user node",
+			nodeName: "laptop.prawn-universe.ts.net.",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "tagged node",
+			nodeName: "runner.prawn-universe.ts.net.",
+			tags:     []string{"tag:ci"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "ephemeral node",
+			nodeName: "ephemeral-1.prawn-universe.ts.net.",
+			wantCode: http.StatusForbidden,
+		},
+	}
+	h := DenyTaggedNodes(DenyEphemeralNodes(isEphemeral, serveHandler()))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			who := newTestWhoIs("alice@example.com")
+			who.Node.Name = tt.nodeName
+			who.Node.Tags = tt.tags
+			r := httptest.NewRequest("GET", "/", nil)
+			r = r.WithContext(ContextWithIdentity(r.Context(), who))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRequireUsersAndLoginDomain(t *testing.T) {
+	tests := []struct {
+		name      string
+		handler   http.Handler
+		loginName string
+		wantCode  int
+	}{
+		{
+			name:      "listed user",
+			handler:   RequireUsers(serveHandler(), "alice@example.com", "bob@example.com"),
+			loginName: "bob@example.com",
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "unlisted user",
+			handler:   RequireUsers(serveHandler(), "alice@example.com"),
+			loginName: "mallory@example.com",
+			wantCode:  http.StatusForbidden,
+		},
+		{
+			name:      "user of login domain",
+			handler:   RequireLoginDomain(serveHandler(), "example.com"),
+			loginName: "mallory@example.com",
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "user of other login domain",
+			handler:   RequireLoginDomain(serveHandler(), "example.com"),
+			loginName: "mallory@example.org",
+			wantCode:  http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r = r.WithContext(ContextWithIdentity(r.Context(), newTestWhoIs(tt.loginName)))
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRequireUsersAndLoginDomainWithoutValues(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{
+			name: "users",
+			fn:   func() { RequireUsers(serveHandler()) },
+		},
+		{
+			name: "login domains",
+			fn:   func() { RequireLoginDomain(serveHandler()) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("got no panic")
+				}
+			}()
+			tt.fn()
+		})
+	}
+}
@@ -0,0 +1,41 @@
+// Package pprofhandler serves the profiles of net/http/pprof on the router of
+// a server. It is a separate package as importing net/http/pprof registers
+// its handlers on http.DefaultServeMux, so only applications opting in to
+// profiling import it.
+package pprofhandler
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/alexhokl/privateserver/server"
+)
+
+// Path is the path under which Handle serves the profiles of net/http/pprof.
+const Path = "/debug/pprof/"
+
+// Handle registers the profiling endpoints of net/http/pprof under Path on
+// the router of s, such as "/debug/pprof/heap" and
+// "/debug/pprof/profile?seconds=30", for the callers authorized by policy
+// only. Profiles reveal the internals of the process, so policy cannot be
+// nil and should admit a few users or tags, such as "tag:ops". It panics if
+// policy is nil.
+func Handle(s server.Interface, policy *server.Policy) {
+	if policy == nil {
+		panic("pprofhandler: Handle requires a policy")
+	}
+	s.Handle(Path, policy, Handler())
+}
+
+// Handler dispatches the requests under Path to net/http/pprof, whose Index
+// serves the named profiles such as heap and goroutine. It does not authorize
+// callers; use Handle to serve it with a policy.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(Path, pprof.Index)
+	mux.HandleFunc(Path+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(Path+"profile", pprof.Profile)
+	mux.HandleFunc(Path+"symbol", pprof.Symbol)
+	mux.HandleFunc(Path+"trace", pprof.Trace)
+	return mux
+}
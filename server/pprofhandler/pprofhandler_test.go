@@ -0,0 +1,58 @@
+package pprofhandler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexhokl/privateserver/server"
+	"github.com/alexhokl/privateserver/server/servertest"
+)
+
+func TestHandle(t *testing.T) {
+	fake := servertest.NewFake("")
+	defer func() { _ = fake.Close() }()
+	fake.SetIdentity("100.64.0.1", servertest.Identity("alice@example.com"))
+	fake.SetIdentity("100.64.0.2", servertest.Identity("bob@example.com", "tag:ops"))
+	Handle(fake, &server.Policy{Allow: []server.Rule{{Tags: []string{"tag:ops"}}}})
+	h := fake.Handler()
+
+	tests := []struct {
+		name       string
+		path       string
+		remoteAddr string
+		wantCode   int
+		wantBody   string
+	}{
+		{name: "index", path: "/debug/pprof/", remoteAddr: "100.64.0.2:1234", wantCode: http.StatusOK, wantBody: "goroutine"},
+		{name: "named profile", path: "/debug/pprof/goroutine?debug=1", remoteAddr: "100.64.0.2:1234", wantCode: http.StatusOK, wantBody: "goroutine profile"},
+		{name: "cmdline", path: "/debug/pprof/cmdline", remoteAddr: "100.64.0.2:1234", wantCode: http.StatusOK},
+		{name: "unauthorized member", path: "/debug/pprof/heap", remoteAddr: "100.64.0.1:1234", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got %d; want %d", w.Code, tt.wantCode)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("got body without %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHandleRequiresPolicy(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic for a nil policy")
+		}
+	}()
+	fake := servertest.NewFake("")
+	defer func() { _ = fake.Close() }()
+	Handle(fake, nil)
+}
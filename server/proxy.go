@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// ProxyRoute maps the requests matching a pattern to an upstream server.
+type ProxyRoute struct {
+	// Pattern is a pattern of http.ServeMux, such as "/grafana/" or
+	// "grafana.example.ts.net/" to route by host.
+	Pattern string
+
+	// Upstream is the URL of the upstream server, such as
+	// "http://localhost:3000". Its path is prepended to the request path.
+	// Upstreams listening on a unix socket are specified by the path of the
+	// socket, such as "unix:///var/run/app.sock", and receive requests for
+	// host localhost.
+	Upstream string
+
+	// StripPrefix is removed from the request path before it is forwarded,
+	// such as "/grafana" for an application served at the root path.
+	StripPrefix string
+
+	// PreserveHost forwards the Host header of the request instead of the
+	// host of Upstream.
+	PreserveHost bool
+
+	// IdleTimeout closes connections to the upstream server which carry no
+	// data for this long, including WebSocket connections and event
+	// streams. Zero means no timeout.
+	IdleTimeout time.Duration
+}
+
+// ReverseProxy is a http.Handler forwarding requests to upstream servers by
+// route. Forwarded requests carry X-Forwarded-For, X-Forwarded-Host and
+// X-Forwarded-Proto headers and, like InjectIdentityHeaders, the identity
+// headers of the caller. Responses are streamed to the caller as they
+// arrive, and WebSocket connections and event streams are exempt from the
+// read and write timeouts of the http.Server so that they can stay open.
+// The proxy has to be wrapped by Server.WithIdentity to forward identities.
+// Requests traced by Tracing are traced to the upstream server as well.
+type ReverseProxy struct {
+	mux *http.ServeMux
+}
+
+// NewReverseProxy creates a ReverseProxy serving the specified routes.
+func NewReverseProxy(routes ...ProxyRoute) (*ReverseProxy, error) {
+	p := &ReverseProxy{mux: http.NewServeMux()}
+	for i, route := range routes {
+		h, err := newProxyHandler(route)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route [%s] at index %d: %w", route.Pattern, i, err)
+		}
+		p.mux.Handle(route.Pattern, h)
+	}
+	return p, nil
+}
+
+// ServeHTTP forwards the request to the upstream server of the matching
+// route.
+func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+func newProxyHandler(route ProxyRoute) (http.Handler, error) {
+	if route.Pattern == "" {
+		return nil, fmt.Errorf("pattern cannot be empty")
+	}
+	upstream, err := url.Parse(route.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse upstream: %w", err)
+	}
+	var socketPath string
+	switch {
+	case upstream.Scheme == "unix":
+		if upstream.Path == "" {
+			return nil, fmt.Errorf("upstream [%s] must specify the path of a unix socket", route.Upstream)
+		}
+		socketPath = upstream.Path
+		upstream = &url.URL{Scheme: "http", Host: "localhost"}
+	case (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "":
+		return nil, fmt.Errorf("upstream [%s] must be an absolute http, https or unix URL", route.Upstream)
+	}
+	if route.IdleTimeout < 0 {
+		return nil, fmt.Errorf("idle timeout cannot be negative")
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Transport: tracingTransport{next: newProxyTransport(socketPath, route.IdleTimeout)},
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if route.StripPrefix != "" {
+				pr.Out.URL.Path = ensureLeadingSlash(strings.TrimPrefix(pr.Out.URL.Path, route.StripPrefix))
+				pr.Out.URL.RawPath = ""
+			}
+			pr.SetURL(upstream)
+			pr.SetXForwarded()
+			if route.PreserveHost {
+				pr.Out.Host = pr.In.Host
+			}
+			setIdentityHeaders(pr.Out)
+		},
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logf(slog.LevelError, "failed to proxy request%s for [%s] to [%s]: %v", requestIDLabel(r.Context()), r.URL.Path, route.Upstream, err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStreamingRequest(r) {
+			clearDeadlines(w)
+		}
+		proxy.ServeHTTP(w, r)
+	}), nil
+}
+
+// isStreamingRequest reports whether r opens a long-lived connection, either
+// a protocol upgrade such as WebSocket or an event stream.
+func isStreamingRequest(r *http.Request) bool {
+	return httpguts.HeaderValuesContainsToken(r.Header["Connection"], "upgrade") ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// newProxyTransport returns a transport connecting to the unix socket at
+// socketPath, if it is not empty, and closing connections idle for longer
+// than idleTimeout, if it is not zero. It returns nil, the default
+// transport, if neither is needed.
+func newProxyTransport(socketPath string, idleTimeout time.Duration) http.RoundTripper {
+	if socketPath == "" && idleTimeout == 0 {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dial := transport.DialContext
+	if socketPath != "" {
+		transport.Proxy = nil
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+	}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil || idleTimeout == 0 {
+			return conn, err
+		}
+		return &idleTimeoutConn{Conn: conn, timeout: idleTimeout}, nil
+	}
+	return transport
+}
+
+// idleTimeoutConn is a connection failing reads and writes once it has
+// carried no data for the timeout.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+func ensureLeadingSlash(path string) string {
+	if strings.HasPrefix(path, "/") {
+		return path
+	}
+	return "/" + path
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newEchoUpstream returns a server responding with the path, host and
+// headers of the requests it receives.
+func newEchoUpstream(t *testing.T) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"path":   r.URL.Path,
+			"host":   r.Host,
+			"header": r.Header,
+		})
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+type echoedRequest struct {
+	Path   string      `json:"path"`
+	Host   string      `json:"host"`
+	Header http.Header `json:"header"`
+}
+
+func TestReverseProxy(t *testing.T) {
+	upstream := newEchoUpstream(t)
+	proxy, err := NewReverseProxy(
+		ProxyRoute{Pattern: "/grafana/", Upstream: upstream.URL, StripPrefix: "/grafana"},
+		ProxyRoute{Pattern: "/app/", Upstream: upstream.URL + "/base", PreserveHost: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		wantCode  int
+		wantPath  string
+		wantHost  string
+		wantLogin string
+	}{
+		{
+			name:      "stripped prefix",
+			path:      "/grafana/dashboards",
+			wantCode:  http.StatusOK,
+			wantPath:  "/dashboards",
+			wantHost:  upstream.Listener.Addr().String(),
+			wantLogin: "alice@example.com",
+		},
+		{
+			name:      "upstream path and preserved host",
+			path:      "/app/items",
+			wantCode:  http.StatusOK,
+			wantPath:  "/base/app/items",
+			wantHost:  "web.prawn-universe.ts.net",
+			wantLogin: "alice@example.com",
+		},
+		{
+			name:     "unknown route",
+			path:     "/unknown",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://web.prawn-universe.ts.net"+tt.path, nil)
+			req.Header.Set(HeaderTailscaleUserLogin, "mallory@example.com")
+			req = req.WithContext(ContextWithIdentity(req.Context(), newTestWhoIs("alice@example.com")))
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d; want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var got echoedRequest
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Path != tt.wantPath {
+				t.Errorf("got path %q; want %q", got.Path, tt.wantPath)
+			}
+			if got.Host != tt.wantHost {
+				t.Errorf("got host %q; want %q", got.Host, tt.wantHost)
+			}
+			if login := got.Header.Get(HeaderTailscaleUserLogin); login != tt.wantLogin {
+				t.Errorf("got login %q; want %q", login, tt.wantLogin)
+			}
+			if got.Header.Get("X-Forwarded-Host") != "web.prawn-universe.ts.net" || got.Header.Get("X-Forwarded-Proto") != "https" || got.Header.Get("X-Forwarded-For") == "" {
+				t.Errorf("got forwarded headers %v", got.Header)
+			}
+		})
+	}
+}
+
+func TestReverseProxyUnavailableUpstream(t *testing.T) {
+	upstream := newEchoUpstream(t)
+	upstream.Close()
+	proxy, err := NewReverseProxy(ProxyRoute{Pattern: "/", Upstream: upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusBadGateway {
+		body, _ := io.ReadAll(rec.Body)
+		t.Errorf("got status %d (%s); want %d", rec.Code, body, http.StatusBadGateway)
+	}
+}
+
+func TestNewReverseProxyInvalidRoute(t *testing.T) {
+	routes := []ProxyRoute{
+		{Pattern: "", Upstream: "http://localhost:3000"},
+		{Pattern: "/", Upstream: "localhost:3000"},
+		{Pattern: "/", Upstream: "ftp://localhost"},
+		{Pattern: "/", Upstream: "unix://"},
+	}
+	for _, route := range routes {
+		if _, err := NewReverseProxy(route); err == nil {
+			t.Errorf("NewReverseProxy(%+v) succeeded", route)
+		}
+	}
+}
+
+func TestReverseProxyUnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "app.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Skipf("unix sockets are not supported: %v", err)
+	}
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Host+r.URL.Path)
+	}))
+	upstream.Listener = listener
+	upstream.Start()
+	defer upstream.Close()
+
+	proxy, err := NewReverseProxy(ProxyRoute{Pattern: "/", Upstream: "unix://" + socketPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "localhost/status" {
+		t.Errorf("got status %d and body %q", rec.Code, rec.Body.String())
+	}
+}
+
+// newUpgradeUpstream returns a server switching every request to an echo
+// protocol, like a WebSocket server would.
+func newUpgradeUpstream(t *testing.T) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = rw.Flush()
+		_, _ = io.Copy(conn, rw)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// dialUpgrade opens a connection to the echo protocol through the proxy at
+// addr.
+func dialUpgrade(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_, _ = io.WriteString(conn, "GET /socket HTTP/1.1\r\nHost: web\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d; want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	return conn, reader
+}
+
+func echo(conn net.Conn, reader *bufio.Reader, message string) (string, error) {
+	if _, err := io.WriteString(conn, message+"\n"); err != nil {
+		return "", err
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := reader.ReadString('\n')
+	return strings.TrimSuffix(line, "\n"), err
+}
+
+func TestReverseProxyUpgrade(t *testing.T) {
+	upstream := newUpgradeUpstream(t)
+	proxy, err := NewReverseProxy(ProxyRoute{Pattern: "/", Upstream: upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := httptest.NewUnstartedServer(proxy)
+	front.Config.ReadTimeout = 100 * time.Millisecond
+	front.Config.WriteTimeout = 100 * time.Millisecond
+	front.Start()
+	defer front.Close()
+
+	conn, reader := dialUpgrade(t, front.Listener.Addr().String())
+	for _, message := range []string{"hello", "after server timeouts"} {
+		got, err := echo(conn, reader, message)
+		if err != nil || got != message {
+			t.Fatalf("got %q, %v; want %q", got, err, message)
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+}
+
+func TestReverseProxyEventStream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range 3 {
+			_, _ = fmt.Fprintf(w, "data: %d\n\n", i)
+			_ = http.NewResponseController(w).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer upstream.Close()
+	proxy, err := NewReverseProxy(ProxyRoute{Pattern: "/", Upstream: upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := httptest.NewUnstartedServer(proxy)
+	front.Config.WriteTimeout = 150 * time.Millisecond
+	front.Start()
+	defer front.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, front.URL+"/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := front.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("stream interrupted after %q: %v", body, err)
+	}
+	if string(body) != "data: 0\n\ndata: 1\n\ndata: 2\n\n" {
+		t.Errorf("got %q", body)
+	}
+}
+
+func TestReverseProxyIdleTimeout(t *testing.T) {
+	upstream := newUpgradeUpstream(t)
+	proxy, err := NewReverseProxy(ProxyRoute{Pattern: "/", Upstream: upstream.URL, IdleTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	conn, reader := dialUpgrade(t, front.Listener.Addr().String())
+	if got, err := echo(conn, reader, "hello"); err != nil || got != "hello" {
+		t.Fatalf("got %q, %v; want %q", got, err, "hello")
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("got %v from idle connection; want EOF", err)
+	}
+}
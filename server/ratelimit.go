@@ -0,0 +1,174 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiterIdleTimeout is the time after which the limiter of a key which
+// has not been used is discarded.
+const rateLimiterIdleTimeout = 10 * time.Minute
+
+// rateLimitedRequests exports the number of requests rejected by rate
+// limiting keyed by the kind of limit, which is global, node or user.
+var rateLimitedRequests = expvar.NewMap("privateserver_rate_limited_requests_total")
+
+// RateLimitConfig is the configuration of rate limiting middleware.
+type RateLimitConfig struct {
+	// RequestsPerSecond is the sustained rate of requests allowed. It must be
+	// positive.
+	RequestsPerSecond float64
+
+	// Burst is the number of requests allowed at once. It defaults to 1 and
+	// cannot be negative.
+	Burst int
+}
+
+// RateLimitPerUser wraps the provided handler and limits the rate of requests
+// of each user. Callers are keyed by login name, falling back to the node of
+// tagged nodes and to the IP address of callers without identity. Requests
+// over the limit are rejected with status 429 and a Retry-After header. The
+// handler has to be wrapped by Server.WithIdentity as well. It panics if the
+// configuration is invalid.
+func RateLimitPerUser(config RateLimitConfig, h http.Handler) http.Handler {
+	return rateLimit("user", newKeyedRateLimiter(config), userRateLimitKey, h)
+}
+
+// RateLimitPerNode wraps the provided handler and limits the rate of requests
+// of each node, so that a runaway script on one device cannot starve the
+// other devices of the same user. Callers are keyed by node, falling back to
+// the IP address of callers without identity. The handler has to be wrapped
+// by Server.WithIdentity as well. It panics if the configuration is invalid.
+func RateLimitPerNode(config RateLimitConfig, h http.Handler) http.Handler {
+	return rateLimit("node", newKeyedRateLimiter(config), nodeRateLimitKey, h)
+}
+
+// RateLimitGlobal wraps the provided handler and limits the rate of all
+// requests regardless of their callers, for example to protect a small
+// database behind the handler. It panics if the configuration is invalid.
+func RateLimitGlobal(config RateLimitConfig, h http.Handler) http.Handler {
+	return rateLimit("global", newKeyedRateLimiter(config), func(*http.Request) string { return "" }, h)
+}
+
+// rateLimit wraps the provided handler and rejects requests over the limit of
+// the key returned by key. Rejections are counted by kind.
+func rateLimit(kind string, limiter *keyedRateLimiter, key func(*http.Request) string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if delay := limiter.reserve(key(r)); delay > 0 {
+			rateLimitedRequests.Add(kind, 1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// userRateLimitKey returns the key of the caller of the request for per-user
+// rate limiting.
+func userRateLimitKey(r *http.Request) string {
+	if who, found := IdentityFromContext(r.Context()); found {
+		if who.Node != nil && who.Node.IsTagged() {
+			return "node:" + strings.TrimSuffix(who.Node.Name, ".")
+		}
+		if who.UserProfile != nil && who.UserProfile.LoginName != "" {
+			return "user:" + who.UserProfile.LoginName
+		}
+	}
+	return "ip:" + remoteIP(r)
+}
+
+// nodeRateLimitKey returns the key of the node of the caller of the request
+// for per-node rate limiting.
+func nodeRateLimitKey(r *http.Request) string {
+	if who, found := IdentityFromContext(r.Context()); found && who.Node != nil {
+		return "node:" + strings.TrimSuffix(who.Node.Name, ".")
+	}
+	return "ip:" + remoteIP(r)
+}
+
+// remoteIP returns the IP address of the remote address of the request.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// keyedRateLimiter keeps a token bucket per key.
+type keyedRateLimiter struct {
+	limit rate.Limit
+	burst int
+	now   func() time.Time
+
+	mu        sync.Mutex
+	limiters  map[string]*keyedRateLimiterEntry
+	lastSweep time.Time
+}
+
+type keyedRateLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+func newKeyedRateLimiter(config RateLimitConfig) *keyedRateLimiter {
+	if !(config.RequestsPerSecond > 0) {
+		panic(fmt.Sprintf("server: rate limit must be positive, got [%v] requests per second", config.RequestsPerSecond))
+	}
+	if config.Burst < 0 {
+		panic(fmt.Sprintf("server: rate limit burst cannot be negative, got [%d]", config.Burst))
+	}
+	burst := config.Burst
+	if burst == 0 {
+		burst = 1
+	}
+	return &keyedRateLimiter{
+		limit:    rate.Limit(config.RequestsPerSecond),
+		burst:    burst,
+		now:      time.Now,
+		limiters: make(map[string]*keyedRateLimiterEntry),
+	}
+}
+
+// reserve takes a token of key and returns zero, or returns the time until a
+// token becomes available without taking it.
+func (l *keyedRateLimiter) reserve(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) >= rateLimiterIdleTimeout {
+		for k, entry := range l.limiters {
+			if now.Sub(entry.lastUsed) >= rateLimiterIdleTimeout {
+				delete(l.limiters, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	entry, found := l.limiters[key]
+	if !found {
+		entry = &keyedRateLimiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = entry
+	}
+	entry.lastUsed = now
+
+	reservation := entry.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return rateLimiterIdleTimeout
+	}
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		reservation.CancelAt(now)
+	}
+	return delay
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitPerUser(t *testing.T) {
+	h := RateLimitPerUser(RateLimitConfig{RequestsPerSecond: 0.001, Burst: 2}, serveHandler())
+	serve := func(loginName string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		if loginName != "" {
+			r = r.WithContext(ContextWithIdentity(r.Context(), newTestWhoIs(loginName)))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	steps := []struct {
+		loginName string
+		wantCode  int
+	}{
+		{loginName: "alice@example.com", wantCode: http.StatusOK},
+		{loginName: "alice@example.com", wantCode: http.StatusOK},
+		{loginName: "alice@example.com", wantCode: http.StatusTooManyRequests},
+		{loginName: "bob@example.com", wantCode: http.StatusOK},
+		{loginName: "", wantCode: http.StatusOK},
+	}
+	for i, step := range steps {
+		w := serve(step.loginName)
+		if w.Code != step.wantCode {
+			t.Errorf("request %d of %q: got %d; want %d", i, step.loginName, w.Code, step.wantCode)
+		}
+		if step.wantCode == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("request %d of %q: Retry-After is not set", i, step.loginName)
+		}
+	}
+}
+
+func TestRateLimitPerNode(t *testing.T) {
+	h := RateLimitPerNode(RateLimitConfig{RequestsPerSecond: 0.001, Burst: 1}, serveHandler())
+	serve := func(nodeName, remoteAddr string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		if nodeName != "" {
+			who := newTestWhoIs("alice@example.com")
+			who.Node.Name = nodeName
+			r = r.WithContext(ContextWithIdentity(r.Context(), who))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	steps := []struct {
+		nodeName   string
+		remoteAddr string
+		wantCode   int
+	}{
+		{nodeName: "laptop.prawn-universe.ts.net.", remoteAddr: "100.64.0.1:1234", wantCode: http.StatusOK},
+		{nodeName: "laptop.prawn-universe.ts.net.", remoteAddr: "100.64.0.1:1235", wantCode: http.StatusTooManyRequests},
+		{nodeName: "phone.prawn-universe.ts.net.", remoteAddr: "100.64.0.2:1234", wantCode: http.StatusOK},
+		{remoteAddr: "100.64.0.3:1234", wantCode: http.StatusOK},
+		{remoteAddr: "100.64.0.3:1235", wantCode: http.StatusTooManyRequests},
+	}
+	for i, step := range steps {
+		if got := serve(step.nodeName, step.remoteAddr); got != step.wantCode {
+			t.Errorf("request %d from %q: got %d; want %d", i, step.remoteAddr, got, step.wantCode)
+		}
+	}
+}
+
+func TestRateLimitGlobal(t *testing.T) {
+	before := rateLimitedRequests.Get("global")
+	h := RateLimitGlobal(RateLimitConfig{RequestsPerSecond: 0.001, Burst: 2}, serveHandler())
+	var codes []int
+	for _, loginName := range []string{"alice@example.com", "bob@example.com", "carol@example.com"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(ContextWithIdentity(r.Context(), newTestWhoIs(loginName)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("got status codes %v", codes)
+	}
+	after := rateLimitedRequests.Get("global")
+	if after == nil || (before != nil && after.String() == before.String()) {
+		t.Errorf("rejected request is not counted; got %v", after)
+	}
+}
+
+func TestRateLimitInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RateLimitConfig
+	}{
+		{name: "zero rate", config: RateLimitConfig{Burst: 1}},
+		{name: "negative rate", config: RateLimitConfig{RequestsPerSecond: -1}},
+		{name: "negative burst", config: RateLimitConfig{RequestsPerSecond: 1, Burst: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("got no panic")
+				}
+			}()
+			RateLimitGlobal(tt.config, serveHandler())
+		})
+	}
+}
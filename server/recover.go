@@ -0,0 +1,40 @@
+package server
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoveredPanics exports the number of handler panics recovered by Recover.
+var recoveredPanics = expvar.NewInt("privateserver_recovered_panics_total")
+
+// Recover wraps the provided handler and recovers from its panics so that a
+// single bad request cannot take down the server. The panic is logged with
+// its stack and the request ID, if RequestID wraps the handler, and the
+// request is answered with status 500 unless a response has been started.
+// The number of recovered panics is exported as the expvar
+// privateserver_recovered_panics_total. Panics with http.ErrAbortHandler are
+// passed on so that net/http aborts the response as intended.
+func Recover(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := newStatusRecorder(w)
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			recoveredPanics.Add(1)
+			logf(slog.LevelError, "recovered from panic serving request%s for [%s %s]: %v\n%s",
+				requestIDLabel(r.Context()), r.Method, r.URL.Path, err, debug.Stack())
+			if recorder.status == 0 {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+		h.ServeHTTP(recorder, r)
+	})
+}
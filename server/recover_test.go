@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		wantCode int
+	}{
+		{
+			name:     "no panic",
+			handler:  func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) },
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "panic",
+			handler:  func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name: "panic after response started",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				panic("boom")
+			},
+			wantCode: http.StatusAccepted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := recoveredPanics.Value()
+			w := httptest.NewRecorder()
+			Recover(tt.handler).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("got status %d; want %d", w.Code, tt.wantCode)
+			}
+			recovered := recoveredPanics.Value() - before
+			if wantRecovered := tt.name != "no panic"; recovered != 0 != wantRecovered {
+				t.Errorf("got %d recovered panics", recovered)
+			}
+		})
+	}
+}
+
+func TestRecoverAbortHandler(t *testing.T) {
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("got panic %v; want http.ErrAbortHandler", err)
+		}
+	}()
+	Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
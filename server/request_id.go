@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader is the header carrying the ID of a request between
+// services.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of an inbound request ID which is
+// honored.
+const maxRequestIDLength = 128
+
+type requestIDContextKey struct{}
+
+// RequestID wraps the provided handler and assigns an ID to every request.
+// An ID in the X-Request-ID header of the request is honored if it is
+// reasonable; otherwise a random one is generated. The ID is stored in the
+// request context, set on the request header so that Proxy forwards it to
+// upstreams, sent in the response header, and included in access logs and
+// error messages.
+func RequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		r = r.WithContext(ContextWithRequestID(r.Context(), id))
+		r.Header.Set(RequestIDHeader, id)
+		w.Header().Set(RequestIDHeader, id)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID, for
+// example to propagate it to outbound requests made by a handler.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx by RequestID.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDContextKey{}).(string)
+	return id, ok
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never fails
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether id is short and only has characters which
+// are safe to log and to send in headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDLabel returns the request ID in ctx formatted to follow the word
+// "request" in log messages, or an empty string if there is none.
+func requestIDLabel(ctx context.Context) string {
+	if id, found := RequestIDFromContext(ctx); found {
+		return " [" + id + "]"
+	}
+	return ""
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name    string
+		inbound string
+		wantID  string
+	}{
+		{name: "generated"},
+		{name: "inbound", inbound: "trace-1234:span-5", wantID: "trace-1234:span-5"},
+		{name: "invalid inbound", inbound: "bad id\n"},
+		{name: "long inbound", inbound: strings.Repeat("a", 129)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var contextID, headerID string
+			h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contextID, _ = RequestIDFromContext(r.Context())
+				headerID = r.Header.Get(RequestIDHeader)
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.inbound != "" {
+				r.Header.Set(RequestIDHeader, tt.inbound)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			responseID := w.Header().Get(RequestIDHeader)
+			if contextID == "" || contextID != headerID || contextID != responseID {
+				t.Fatalf("got IDs %q in context, %q in request and %q in response", contextID, headerID, responseID)
+			}
+			if tt.wantID != "" && contextID != tt.wantID {
+				t.Errorf("got ID %q; want %q", contextID, tt.wantID)
+			}
+			if tt.wantID == "" && len(contextID) != 32 {
+				t.Errorf("got generated ID %q", contextID)
+			}
+		})
+	}
+}
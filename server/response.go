@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// statusRecorder is a http.ResponseWriter which records the status code and
+// the size of the response written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w}
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// Status returns the status code of the response. It returns 200 if the
+// handler has not written anything as that is what net/http sends.
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Unwrap returns the underlying http.ResponseWriter so that
+// http.ResponseController can reach features such as flushing.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// clearDeadlines lifts the read and write timeouts of the http.Server for the
+// connection of a long-lived response. Errors are ignored as not every
+// ResponseWriter supports deadlines, in which case there is no timeout to
+// lift.
+func clearDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+}
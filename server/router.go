@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net/http"
+)
+
+// Middleware wraps a handler, such as InjectIdentityHeaders or a middleware
+// created by a function like Audit with its configuration bound.
+type Middleware func(http.Handler) http.Handler
+
+// Router is a http.Handler dispatching requests to handlers by the patterns
+// of http.ServeMux where each route declares its own authorization policy.
+type Router struct {
+	mux         *http.ServeMux
+	identify    identifyFunc
+	middlewares []Middleware
+}
+
+// NewRouter creates a Router which looks up the identity of callers of
+// routes with a policy.
+func (s *Server) NewRouter() *Router {
+	return newRouter(s.identify)
+}
+
+// NewRouterWithIdentityProvider creates a Router which looks up the identity
+// of callers of routes with a policy with provider instead of the Tailscale
+// API, for implementations of Interface other than Server.
+func NewRouterWithIdentityProvider(provider IdentityProvider) *Router {
+	return newRouter(identifyWith(provider))
+}
+
+func newRouter(identify identifyFunc) *Router {
+	return &Router{
+		mux:      http.NewServeMux(),
+		identify: identify,
+	}
+}
+
+// Use adds middlewares wrapping the handlers of routes registered afterwards,
+// such as access logging or auditing. On routes with a policy, they run
+// after the identity of the caller is looked up and before the policy is
+// applied, so they see the caller of every request including those which are
+// denied. On routes without a policy the identity is not looked up, so they
+// see no caller unless Server.WithIdentity is added by Use before them.
+func (rt *Router) Use(middlewares ...Middleware) {
+	rt.middlewares = append(rt.middlewares, middlewares...)
+}
+
+// Handle registers the handler for the specified pattern, such as
+// "GET /admin/", which admits only callers authorized by policy. Routes with
+// a nil policy are public to every peer which can reach the server. Requests
+// go through the identity lookup, for routes with a policy only, the
+// middlewares added by Use, the middlewares of the route in the specified
+// order, and the policy before reaching the handler.
+func (rt *Router) Handle(pattern string, policy *Policy, h http.Handler, middlewares ...Middleware) {
+	if policy != nil {
+		h = Authorize(policy, h)
+	}
+	h = NewChain(rt.middlewares...).Append(middlewares...).Then(h)
+	if policy != nil {
+		h = withIdentity(rt.identify, h)
+	}
+	next := h
+	rt.mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recordRoute(r, pattern)
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// HandleFunc registers the handler function for the specified pattern with
+// the specified policy.
+func (rt *Router) HandleFunc(pattern string, policy *Policy, h func(http.ResponseWriter, *http.Request), middlewares ...Middleware) {
+	rt.Handle(pattern, policy, http.HandlerFunc(h), middlewares...)
+}
+
+// ServeHTTP dispatches the request to the handler of the matching route.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// Handle registers the handler for the specified pattern on the router of
+// the server, which is served by Handler. See Router.Handle.
+func (s *Server) Handle(pattern string, policy *Policy, h http.Handler, middlewares ...Middleware) {
+	s.router.Handle(pattern, policy, h, middlewares...)
+}
+
+// HandleFunc registers the handler function for the specified pattern on the
+// router of the server.
+func (s *Server) HandleFunc(pattern string, policy *Policy, h func(http.ResponseWriter, *http.Request), middlewares ...Middleware) {
+	s.router.HandleFunc(pattern, policy, h, middlewares...)
+}
+
+// Use adds middlewares to the router of the server. See Router.Use.
+func (s *Server) Use(middlewares ...Middleware) {
+	s.router.Use(middlewares...)
+}
+
+// Handler returns the router of the server holding the routes registered by
+// Server.Handle, to be served on the listeners returned by Listen. Requests
+// are answered with status 503 while maintenance mode is on, and recorded
+// by Metrics if ServerConfig.Metrics is set. ServerConfig.Hooks are called
+// for its requests.
+func (s *Server) Handler() http.Handler {
+	h := withHooks(&s.hooks, s.WithMaintenance(s.router))
+	if s.metrics {
+		h = Metrics(h)
+	}
+	return h
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestRouter(t *testing.T) {
+	ci := newTestWhoIs("tagged-devices")
+	ci.Node.Tags = []string{"tag:ci"}
+	identities := map[string]*apitype.WhoIsResponse{
+		"100.64.0.1:1234": newTestWhoIs("alice@example.com"),
+		"100.64.0.2:1234": newTestWhoIs("bob@example.com"),
+		"100.64.0.3:1234": ci,
+	}
+	lookups := 0
+	router := newRouter(func(r *http.Request) (*apitype.WhoIsResponse, error) {
+		lookups++
+		return identities[r.RemoteAddr], nil
+	})
+	router.Handle("/admin/", &Policy{Allow: []Rule{{LoginNames: []string{"alice@example.com"}}}}, serveHandler())
+	router.Handle("/api/", &Policy{Allow: []Rule{{Tags: []string{"tag:ci"}}}}, serveHandler())
+	router.Handle("/", nil, serveHandler())
+
+	tests := []struct {
+		path       string
+		remoteAddr string
+		wantCode   int
+	}{
+		{path: "/admin/users", remoteAddr: "100.64.0.1:1234", wantCode: http.StatusOK},
+		{path: "/admin/users", remoteAddr: "100.64.0.2:1234", wantCode: http.StatusForbidden},
+		{path: "/api/builds", remoteAddr: "100.64.0.3:1234", wantCode: http.StatusOK},
+		{path: "/api/builds", remoteAddr: "100.64.0.1:1234", wantCode: http.StatusForbidden},
+		{path: "/", remoteAddr: "100.64.0.2:1234", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" from "+tt.remoteAddr, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+	if lookups != 4 {
+		t.Errorf("got %d identity lookups; want 4", lookups)
+	}
+}
+
+func TestRouterMiddlewares(t *testing.T) {
+	router := newRouter(func(r *http.Request) (*apitype.WhoIsResponse, error) {
+		return newTestWhoIs("bob@example.com"), nil
+	})
+	var calls []string
+	record := func(name string) Middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				who, _ := IdentityFromContext(r.Context())
+				login := ""
+				if who != nil {
+					login = who.UserProfile.LoginName
+				}
+				calls = append(calls, name+":"+login)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	router.Use(record("access-log"))
+	router.Handle("/admin/", &Policy{Allow: []Rule{{LoginNames: []string{"alice@example.com"}}}}, serveHandler(), record("audit"))
+	router.Handle("/", nil, serveHandler(), record("audit"))
+
+	tests := []struct {
+		path      string
+		wantCode  int
+		wantCalls []string
+	}{
+		{path: "/admin/users", wantCode: http.StatusForbidden, wantCalls: []string{"access-log:bob@example.com", "audit:bob@example.com"}},
+		{path: "/", wantCode: http.StatusOK, wantCalls: []string{"access-log:", "audit:"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			calls = nil
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+			if !slices.Equal(calls, tt.wantCalls) {
+				t.Errorf("got calls %q; want %q", calls, tt.wantCalls)
+			}
+		})
+	}
+}
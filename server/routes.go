@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+
+	"tailscale.com/ipn"
+)
+
+// RouteStatus describes a subnet route advertised by this node.
+type RouteStatus struct {
+	// Prefix is the advertised subnet.
+	Prefix netip.Prefix
+
+	// Approved reports whether the route has been approved in the admin
+	// console or by autoApprovers of the tailnet policy file. Peers only
+	// route traffic of approved routes to this node.
+	Approved bool
+
+	// Primary reports whether this node is the router currently serving the
+	// route, which differs from Approved if other nodes advertise it as well.
+	Primary bool
+}
+
+// parseRoutes parses subnet routes in CIDR notation, such as "192.168.1.0/24".
+func parseRoutes(routes []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(routes))
+	for _, route := range routes {
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			return nil, fmt.Errorf("invalid advertised route [%s]: %w", route, err)
+		}
+		if prefix != prefix.Masked() {
+			return nil, fmt.Errorf("advertised route [%s] has host bits set; use [%s] instead", route, prefix.Masked())
+		}
+		if prefix.Bits() == 0 {
+			return nil, fmt.Errorf("advertised route [%s] is a default route which is only served by exit nodes", route)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// advertiseRoutes advertises the routes from this node and logs the ones
+// waiting for approval.
+func (s *Server) advertiseRoutes(ctx context.Context, routes []netip.Prefix) error {
+	_, err := s.tsClient.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs:              ipn.Prefs{AdvertiseRoutes: routes},
+		AdvertiseRoutesSet: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to advertise routes: %w", err)
+	}
+	statuses, err := s.Routes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.Approved {
+			s.logger.log(slog.LevelInfo, "route_approved", fmt.Sprintf("advertising approved route [%s]", status.Prefix), "route", status.Prefix.String())
+		} else {
+			s.logger.log(slog.LevelWarn, "route_unapproved", fmt.Sprintf("advertised route [%s] is not approved yet; approve it in the admin console", status.Prefix), "route", status.Prefix.String())
+		}
+	}
+	return nil
+}
+
+// Routes returns the subnet routes advertised by this node and whether they
+// are approved.
+func (s *Server) Routes(ctx context.Context) ([]RouteStatus, error) {
+	if s.localMode() {
+		return nil, ErrLocalMode
+	}
+	prefs, err := s.tsClient.GetPrefs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tailscale preferences: %w", err)
+	}
+	status, err := s.tsClient.StatusWithoutPeers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tailscale status: %w", err)
+	}
+	var allowed, primary []netip.Prefix
+	if status.Self != nil {
+		if status.Self.AllowedIPs != nil {
+			allowed = status.Self.AllowedIPs.AsSlice()
+		}
+		if status.Self.PrimaryRoutes != nil {
+			primary = status.Self.PrimaryRoutes.AsSlice()
+		}
+	}
+	return routeStatuses(prefs.AdvertiseRoutes, allowed, primary), nil
+}
+
+// routeStatuses returns the status of the advertised routes given the
+// prefixes peers are allowed to route to this node and the routes it is the
+// primary router of.
+func routeStatuses(advertised, allowed, primary []netip.Prefix) []RouteStatus {
+	statuses := make([]RouteStatus, 0, len(advertised))
+	for _, prefix := range advertised {
+		statuses = append(statuses, RouteStatus{
+			Prefix:   prefix,
+			Approved: slices.Contains(allowed, prefix),
+			Primary:  slices.Contains(primary, prefix),
+		})
+	}
+	return statuses
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestParseRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  []string
+		want    []netip.Prefix
+		wantErr bool
+	}{
+		{
+			name:   "subnets",
+			routes: []string{"192.168.1.0/24", "fd00::/64"},
+			want:   []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24"), netip.MustParsePrefix("fd00::/64")},
+		},
+		{name: "no route", want: []netip.Prefix{}},
+		{name: "address", routes: []string{"192.168.1.1"}, wantErr: true},
+		{name: "host bits", routes: []string{"192.168.1.1/24"}, wantErr: true},
+		{name: "default route", routes: []string{"0.0.0.0/0"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRoutes(tt.routes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRoutes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteStatuses(t *testing.T) {
+	lab := netip.MustParsePrefix("192.168.1.0/24")
+	office := netip.MustParsePrefix("10.0.0.0/16")
+	allowed := []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32"), lab, office}
+	primary := []netip.Prefix{lab}
+
+	got := routeStatuses([]netip.Prefix{lab, office, netip.MustParsePrefix("172.16.0.0/12")}, allowed, primary)
+	want := []RouteStatus{
+		{Prefix: lab, Approved: true, Primary: true},
+		{Prefix: office, Approved: true},
+		{Prefix: netip.MustParsePrefix("172.16.0.0/12")},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"tailscale.com/ipn"
+)
+
+// s3RequestTimeout bounds each request to the object storage.
+const s3RequestTimeout = 30 * time.Second
+
+// S3StoreConfig configures an S3Store.
+type S3StoreConfig struct {
+	// Bucket and Key locate the object holding the state.
+	Bucket string
+	Key    string
+
+	// Region of the bucket. It defaults to the region of the default AWS
+	// configuration, such as the AWS_REGION environment variable.
+	Region string
+
+	// Endpoint is the URL of an S3-compatible object storage, such as MinIO
+	// or Cloudflare R2. It defaults to Amazon S3 in Region.
+	Endpoint string
+
+	// Credentials sign the requests. They default to the default AWS
+	// credential chain, such as environment variables, shared configuration
+	// files and instance roles.
+	Credentials aws.CredentialsProvider
+
+	// HTTPClient sends the requests. It defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// S3Store is an ipn.StateStore keeping the node state in a single object of
+// Amazon S3 or an S3-compatible object storage, so the node identity
+// survives the loss of the host. Set it as ServerConfig.StateStore.
+type S3Store struct {
+	config S3StoreConfig
+	signer *v4.Signer
+
+	mu    sync.Mutex
+	state map[string][]byte
+}
+
+// NewS3Store creates an S3Store and loads the state in the object if it
+// exists.
+func NewS3Store(ctx context.Context, config S3StoreConfig) (*S3Store, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket cannot be empty")
+	}
+	if config.Key == "" {
+		return nil, fmt.Errorf("s3 object key cannot be empty")
+	}
+	if config.Credentials == nil || config.Region == "" {
+		awsConfig, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+		}
+		if config.Credentials == nil {
+			config.Credentials = awsConfig.Credentials
+		}
+		if config.Region == "" {
+			config.Region = awsConfig.Region
+		}
+	}
+	if config.Region == "" {
+		return nil, fmt.Errorf("s3 region cannot be empty")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+
+	s := &S3Store{
+		config: config,
+		signer: v4.NewSigner(),
+		state:  make(map[string][]byte),
+	}
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// String returns the location of the object.
+func (s *S3Store) String() string {
+	return fmt.Sprintf("s3 object [%s/%s]", s.config.Bucket, s.config.Key)
+}
+
+// ReadState implements ipn.StateStore.
+func (s *S3Store) ReadState(id ipn.StateKey) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, found := s.state[string(id)]
+	if !found {
+		return nil, ipn.ErrStateNotExist
+	}
+	return bytes.Clone(value), nil
+}
+
+// WriteState implements ipn.StateStore. The whole state is written to the
+// object on every change.
+func (s *S3Store) WriteState(id ipn.StateKey, bs []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := make(map[string][]byte, len(s.state)+1)
+	for k, v := range s.state {
+		state[k] = v
+	}
+	state[string(id)] = bytes.Clone(bs)
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, http.MethodPut, data)
+	if err != nil {
+		return fmt.Errorf("failed to write state to %s: %w", s, err)
+	}
+	_ = resp.Body.Close()
+	s.state = state
+	return nil
+}
+
+// load reads the state in the object into memory.
+func (s *S3Store) load(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s3RequestTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, http.MethodGet, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load state from %s: %w", s, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&s.state); err != nil {
+		return fmt.Errorf("failed to parse state from %s: %w", s, err)
+	}
+	return nil
+}
+
+// do sends a signed request for the object. A response of a missing object
+// is returned to GET requests and other failures are returned as errors.
+func (s *S3Store) do(ctx context.Context, method string, body []byte) (*http.Response, error) {
+	objectURL := fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(s.config.Endpoint, "/"), url.PathEscape(s.config.Bucket), escapeObjectKey(s.config.Key))
+	req, err := http.NewRequestWithContext(ctx, method, objectURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	payloadHash := sha256.Sum256(body)
+	payloadHashHex := hex.EncodeToString(payloadHash[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHashHex)
+
+	credentials, err := s.config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	if err := s.signer.SignHTTP(ctx, credentials, req, payloadHashHex, "s3", s.config.Region, time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	resp, err := s.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK || (resp.StatusCode == http.StatusNotFound && method == http.MethodGet) {
+		return resp, nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, fmt.Errorf("object storage returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+}
+
+// escapeObjectKey escapes each segment of an object key.
+func escapeObjectKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"tailscale.com/ipn"
+)
+
+func TestS3Store(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		objects = make(map[string][]byte)
+	)
+	objectStorage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			http.Error(w, "unsigned request", http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			object, found := objects[r.URL.Path]
+			if !found {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(object)
+		case http.MethodPut:
+			object, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = object
+		default:
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+		}
+	}))
+	defer objectStorage.Close()
+
+	config := S3StoreConfig{
+		Bucket:   "state",
+		Key:      "nodes/web.json",
+		Region:   "us-east-1",
+		Endpoint: objectStorage.URL,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}, nil
+		}),
+	}
+	store, err := NewS3Store(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadState(ipn.MachineKeyStateKey); !errors.Is(err, ipn.ErrStateNotExist) {
+		t.Fatalf("ReadState() error = %v; want ErrStateNotExist", err)
+	}
+	if err := store.WriteState(ipn.MachineKeyStateKey, []byte("machine-key")); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := objects["/state/nodes/web.json"]; !found {
+		t.Fatalf("object not written; got %v", objects)
+	}
+
+	reloaded, err := NewS3Store(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reloaded.ReadState(ipn.MachineKeyStateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "machine-key" {
+		t.Errorf("got %q; want %q", got, "machine-key")
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import "net/http"
+
+// OmitHeader is set in a field of SecureHeadersOptions to omit the header.
+const OmitHeader = "-"
+
+// Default values of the headers set by SecureHeaders.
+const (
+	DefaultContentSecurityPolicy = "default-src 'self'; style-src 'self' 'unsafe-inline'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'; object-src 'none'"
+	DefaultReferrerPolicy        = "strict-origin-when-cross-origin"
+	DefaultFrameOptions          = "DENY"
+	DefaultPermissionsPolicy     = "camera=(), microphone=(), geolocation=(), payment=(), usb=()"
+)
+
+// SecureHeadersOptions overrides the headers set by SecureHeaders. Empty
+// fields use the defaults and fields set to OmitHeader omit the header.
+type SecureHeadersOptions struct {
+	// ContentSecurityPolicy defaults to DefaultContentSecurityPolicy, which
+	// only allows resources from the same origin, besides the inline styles
+	// of the pages of NewLayout.
+	ContentSecurityPolicy string
+
+	// ContentTypeOptions is the X-Content-Type-Options header. It defaults
+	// to "nosniff".
+	ContentTypeOptions string
+
+	// ReferrerPolicy defaults to DefaultReferrerPolicy so that paths of
+	// internal apps are not revealed to other origins.
+	ReferrerPolicy string
+
+	// FrameOptions is the X-Frame-Options header for browsers which do not
+	// support frame-ancestors. It defaults to DefaultFrameOptions.
+	FrameOptions string
+
+	// PermissionsPolicy defaults to DefaultPermissionsPolicy, which disables
+	// powerful browser features.
+	PermissionsPolicy string
+}
+
+// SecureHeaders returns a middleware setting hardening headers on responses.
+// The headers are set before the wrapped handler is called so that it can
+// still replace them for individual responses.
+func SecureHeaders(opts SecureHeadersOptions) Middleware {
+	headers := secureHeaders(opts)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, value := range headers {
+				w.Header().Set(name, value)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// secureHeaders returns the headers configured by opts.
+func secureHeaders(opts SecureHeadersOptions) map[string]string {
+	headers := make(map[string]string)
+	for _, header := range []struct {
+		name, value, fallback string
+	}{
+		{"Content-Security-Policy", opts.ContentSecurityPolicy, DefaultContentSecurityPolicy},
+		{"X-Content-Type-Options", opts.ContentTypeOptions, "nosniff"},
+		{"Referrer-Policy", opts.ReferrerPolicy, DefaultReferrerPolicy},
+		{"X-Frame-Options", opts.FrameOptions, DefaultFrameOptions},
+		{"Permissions-Policy", opts.PermissionsPolicy, DefaultPermissionsPolicy},
+	} {
+		switch header.value {
+		case OmitHeader:
+		case "":
+			headers[header.name] = header.fallback
+		default:
+			headers[header.name] = header.value
+		}
+	}
+	return headers
+}
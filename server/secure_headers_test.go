@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		opts SecureHeadersOptions
+		want map[string]string
+	}{
+		{
+			name: "defaults",
+			want: map[string]string{
+				"Content-Security-Policy": DefaultContentSecurityPolicy,
+				"X-Content-Type-Options":  "nosniff",
+				"Referrer-Policy":         DefaultReferrerPolicy,
+				"X-Frame-Options":         DefaultFrameOptions,
+				"Permissions-Policy":      DefaultPermissionsPolicy,
+			},
+		},
+		{
+			name: "overrides",
+			opts: SecureHeadersOptions{
+				ContentSecurityPolicy: "default-src 'self' https://cdn.example.com",
+				FrameOptions:          OmitHeader,
+				PermissionsPolicy:     OmitHeader,
+			},
+			want: map[string]string{
+				"Content-Security-Policy": "default-src 'self' https://cdn.example.com",
+				"X-Content-Type-Options":  "nosniff",
+				"Referrer-Policy":         DefaultReferrerPolicy,
+				"X-Frame-Options":         "",
+				"Permissions-Policy":      "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			SecureHeaders(tt.opts)(serveHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			for name, want := range tt.want {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("got %s %q; want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSecureHeadersOverriddenByHandler(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	})
+	w := httptest.NewRecorder()
+	SecureHeaders(SecureHeadersOptions{})(h).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if got := w.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+		t.Errorf("got X-Frame-Options %q; want SAMEORIGIN", got)
+	}
+}
+
+func TestSecureHeadersWithLayout(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Render(w, r, maintenancePageTemplate, "layout", MaintenanceStatus{Enabled: true, Message: DefaultMaintenanceMessage, Since: time.Now()})
+	})
+	w := httptest.NewRecorder()
+	SecureHeaders(SecureHeadersOptions{})(h).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, "<style>") || !strings.Contains(body, "style=") {
+		t.Fatalf("got body without inline styles: %s", body)
+	}
+	var styleSources []string
+	for directive := range strings.SplitSeq(w.Header().Get("Content-Security-Policy"), ";") {
+		if fields := strings.Fields(directive); len(fields) > 0 && fields[0] == "style-src" {
+			styleSources = fields[1:]
+		}
+	}
+	if !slices.Contains(styleSources, "'unsafe-inline'") {
+		t.Errorf("got style sources %q; want inline styles allowed", styleSources)
+	}
+}
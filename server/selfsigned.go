@@ -0,0 +1,71 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"time"
+)
+
+// SelfSignedCertificateValidity is the validity period of the certificate
+// generated when ServerConfig.SelfSignedCertificate is set.
+const SelfSignedCertificateValidity = 90 * 24 * time.Hour
+
+// newSelfSignedCertificate generates an ephemeral self-signed certificate
+// for the specified DNS names and IP addresses. The private key is only kept
+// in memory.
+func newSelfSignedCertificate(dnsNames []string, ipAddresses []netip.Addr) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(SelfSignedCertificateValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+	}
+	if len(dnsNames) > 0 {
+		template.Subject = pkix.Name{CommonName: dnsNames[0]}
+	}
+	for _, ip := range ipAddresses {
+		template.IPAddresses = append(template.IPAddresses, ip.AsSlice())
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// getSelfSignedCertificate returns the self-signed certificate of this node.
+func (s *Server) getSelfSignedCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if s.selfSignedCertificate == nil {
+		return nil, fmt.Errorf("self-signed certificate is not ready")
+	}
+	return s.selfSignedCertificate, nil
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"crypto/x509"
+	"net/netip"
+	"testing"
+)
+
+func TestNewSelfSignedCertificate(t *testing.T) {
+	dnsNames := []string{"test-hostname.prawn-universe.ts.net", "test-hostname"}
+	ipAddresses := []netip.Addr{netip.MustParseAddr("100.64.0.1")}
+
+	cert, err := newSelfSignedCertificate(dnsNames, ipAddresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf == nil {
+		t.Fatal("leaf certificate is not set")
+	}
+	if cert.Leaf.Subject.CommonName != dnsNames[0] {
+		t.Errorf("got common name %q; want %q", cert.Leaf.Subject.CommonName, dnsNames[0])
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	for _, name := range append(dnsNames, "100.64.0.1") {
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots}); err != nil {
+			t.Errorf("failed to verify certificate for [%s]: %v", name, err)
+		}
+	}
+}
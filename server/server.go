@@ -1,20 +1,33 @@
 // Package server package provides functionality to create a server instance
+//
+// Functions returning a Middleware and the methods registering handlers
+// panic on invalid arguments, as http.ServeMux does, since those are
+// programming errors; the panic messages start with "server: ". Functions
+// which open files or parse configuration supplied at run time, such as
+// StaticHandler and LoadConfig, return errors instead.
 package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"tailscale.com/client/local"
 	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
 	"tailscale.com/tsnet"
-	"tailscale.com/util/dnsname"
 )
 
 const (
@@ -23,15 +36,219 @@ const (
 )
 
 type Server struct {
-	tsServer *tsnet.Server
-	tsClient *local.Client
-	fqdn     string
+	tsServer    *tsnet.Server
+	tsClient    *local.Client
+	fqdn        string
+	certDomains []string
+	tlsConfig   *tls.Config
+
+	certificates *certificateTracker
+
+	selfSignedCertificate *tls.Certificate
+
+	whoIs    whoIsFunc
+	identify identifyFunc
+	cancel   context.CancelFunc
+
+	logger *logger
+	router *Router
+
+	hostname string
+	portsMu  sync.Mutex
+	ports    []int
+
+	httpClientOnce sync.Once
+	httpClient     *http.Client
+
+	maintenance maintenanceMode
+	connections connectionTracker
+	whoIsCache  *whoIsCache
+	metrics     bool
+	hooks       Hooks
+
+	// localAddress is the address listeners bind in local mode. It is empty
+	// on the tailnet.
+	localAddress string
 }
 
 type ServerConfig struct {
+	// TailscaleAuthKey is the auth key registering this node. It is read from
+	// a file if it starts with AuthKeyFilePrefix, such as
+	// "file:/run/secrets/ts-authkey", in which case the file is read again
+	// whenever the node has to log in again so that the key can be rotated.
 	TailscaleAuthKey        string
 	Hostname                string
 	TailscaleStateDirectory string
+
+	// TLSConfig is an optional template for the TLS listeners created by
+	// Listen. Settings such as MinVersion, CipherSuites, CurvePreferences and
+	// NextProtos are kept while certificates are always obtained from
+	// Tailscale.
+	TLSConfig *tls.Config
+
+	// ClientCAs, if set, makes TLS listeners request client certificates and
+	// verify them against this pool of certificate authorities.
+	ClientCAs *x509.CertPool
+
+	// ClientCertificateOptional allows TLS clients to connect without a
+	// certificate when ClientCAs is set. Certificates presented are still
+	// verified. Use RequireClientCertificate to enforce certificates on
+	// specific handlers.
+	ClientCertificateOptional bool
+
+	// GetCertificate, if set, is consulted first to select the certificate of
+	// a TLS handshake, for example to serve a certificate of an internal CA
+	// for a CNAME of this node. Returning a nil certificate and a nil error
+	// falls back to the Tailscale certificate of this node.
+	GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	// WarmCertificates makes NewServer fetch the TLS certificates of this node
+	// before returning so that the first HTTPS request does not have to wait
+	// for a certificate to be issued.
+	WarmCertificates bool
+
+	// CertificateExpiryWarningThreshold is the remaining validity of a
+	// certificate below which a warning is logged. It defaults to
+	// DefaultCertificateExpiryWarningThreshold.
+	CertificateExpiryWarningThreshold time.Duration
+
+	// SelfSignedCertificate makes TLS listeners serve an ephemeral
+	// self-signed certificate instead of obtaining one from Tailscale. It is
+	// meant for test environments and control servers without HTTPS support,
+	// such as Headscale. Clients have to trust the certificate explicitly.
+	SelfSignedCertificate bool
+
+	// WhoIsCacheTTL, if positive, caches caller identities for the specified
+	// duration to avoid calling the Tailscale API on every request. The cache
+	// is cleared whenever the network map of this node changes.
+	WhoIsCacheTTL time.Duration
+
+	// IdentityProvider, if set, replaces the Tailscale API for looking up the
+	// identity of callers.
+	IdentityProvider IdentityProvider
+
+	// Ephemeral registers this node as an ephemeral node which is removed
+	// from the tailnet automatically shortly after it goes offline. It suits
+	// CI jobs and autoscaled replicas.
+	Ephemeral bool
+
+	// AdvertiseTags are the ACL tags this node requests, such as "tag:web".
+	// The auth key has to be allowed to apply them by the tagOwners of the
+	// tailnet policy file.
+	AdvertiseTags []string
+
+	// AdvertiseRoutes are the subnets, such as "192.168.1.0/24", this node
+	// routes to from the tailnet so that it doubles as a subnet router of a
+	// small network. The routes have to be approved in the admin console or
+	// by autoApprovers of the tailnet policy file; Server.Routes reports
+	// whether they are. Routes advertised earlier are kept if it is empty.
+	AdvertiseRoutes []string
+
+	// ExitNode is the Tailscale IP address or MagicDNS name, such as
+	// "exit-sg", of the exit node through which connections made with
+	// Server.Dial and Server.HTTPClient egress to the internet.
+	ExitNode string
+
+	// AcceptRoutes makes the subnets advertised by other nodes reachable
+	// with Server.Dial and Server.HTTPClient.
+	AcceptRoutes bool
+
+	// ControlURL is the URL of the coordination server, such as a
+	// self-hosted Headscale server. It defaults to the Tailscale control
+	// plane.
+	ControlURL string
+
+	// InMemoryState keeps the node state, such as its keys, in memory instead
+	// of writing it to TailscaleStateDirectory. The node is registered anew
+	// on every start so it requires Ephemeral. TailscaleStateDirectory is
+	// still used for logs and has to be writable, such as a tmpfs mount.
+	InMemoryState bool
+
+	// KubernetesStateSecret keeps the node state in the Kubernetes Secret
+	// with this name in the namespace of the pod instead of writing it to
+	// TailscaleStateDirectory. See NewKubernetesSecretStore.
+	KubernetesStateSecret string
+
+	// RunWebClient serves the Tailscale web client for managing this node
+	// on port 5252 of its Tailscale address, such as for debugging from the
+	// tailnet. Managing the node requires the caller to be its owner or an
+	// admin of the tailnet.
+	RunWebClient bool
+
+	// StatusPage serves StatusPage for "GET /{$}" on the router of the
+	// server, so registering another handler for that pattern panics.
+	StatusPage bool
+
+	// Metrics serves MetricsHandler for "GET /metrics" on the router of the
+	// server and records the requests of Handler with Metrics.
+	Metrics bool
+
+	// HealthCheck serves HealthHandler for "GET /healthz" on the router of
+	// the server.
+	HealthCheck bool
+
+	// DebugStatus, if set, serves the DebugStatus of the node as JSON for
+	// "GET /debug/status" on the router of the server for the callers
+	// authorized by the policy. See HandleDebugStatus.
+	DebugStatus *Policy
+
+	// Netcheck, if set, serves the NetcheckReport of the node as JSON for
+	// "GET /debug/netcheck" on the router of the server for the callers
+	// authorized by the policy. See HandleNetcheck.
+	Netcheck *Policy
+
+	// Hooks are called on requests, denied callers, listener failures and
+	// changes of the state of the node on the tailnet.
+	Hooks Hooks
+
+	// StateStore keeps the node state instead of TailscaleStateDirectory,
+	// such as an S3Store. TailscaleStateDirectory is still used for logs.
+	StateStore ipn.StateStore
+
+	// Logf receives the verbose logs of the Tailscale backend. By default
+	// they are written at debug level to Logger or UserLogf if LogLevel is
+	// slog.LevelDebug and discarded otherwise.
+	Logf func(format string, args ...any)
+
+	// Logger receives the messages intended for the user, such as the login
+	// URL and the state of certificates, as structured records carrying the
+	// fqdn of the server and attributes such as event and port. It takes
+	// precedence over UserLogf.
+	Logger *slog.Logger
+
+	// UserLogf receives the messages intended for the user as plain text if
+	// Logger is not set. Pass a function doing nothing to silence them. By
+	// default they are written to the logger set by SetLogger or SetLogf,
+	// which is slog.Default unless changed.
+	UserLogf func(format string, args ...any)
+
+	// LogLevel is the minimum level of the messages written to Logger or
+	// UserLogf, which defaults to slog.LevelInfo. Messages of middleware,
+	// which are not tied to a Server, are governed by SetLogLevel instead.
+	LogLevel slog.Level
+
+	// AuthKeySource obtains the auth key instead of TailscaleAuthKey. It is
+	// asked again whenever the node has to log in again.
+	AuthKeySource AuthKeySource
+
+	// LocalMode runs the server without joining a tailnet, so that
+	// applications can be tested and smoke tested without network access or
+	// auth keys. Listen binds ordinary listeners on LocalAddress serving a
+	// self-signed certificate, the FQDN is "localhost", and callers are
+	// identified by IdentityProvider, which defaults to a DevIdentityProvider
+	// of LocalLoginName honouring HeaderDevIdentity only if LocalAddress is a
+	// loopback address. Health reports the
+	// backend state "Local", and the features which need a tailnet, such as
+	// Funnel and the status page, fail with ErrLocalMode. Callers are not
+	// authenticated, so it must never be used in production.
+	LocalMode bool
+
+	// LocalAddress is the IP address listeners bind in local mode. It
+	// defaults to DefaultLocalAddress. Any other address exposes the
+	// unauthenticated server beyond the host, so it should be the address of
+	// a private network, such as one shared with other containers, and never
+	// a public one.
+	LocalAddress string
 }
 
 // NewServer creates and initializes a new Server instance based on the provided
@@ -42,10 +259,84 @@ func NewServer(config *ServerConfig) (*Server, error) {
 	}
 
 	srv := new(Server)
+	backgroundCtx, cancel := context.WithCancel(context.Background())
+	srv.cancel = cancel
+	// releases the background goroutines and the node unless the server is
+	// created
+	created := false
+	defer func() {
+		if created {
+			return
+		}
+		srv.cancel()
+		if srv.tsServer != nil {
+			_ = srv.tsServer.Close()
+		}
+	}()
+	srv.hooks = config.Hooks
+	srv.connections.onError = srv.listenerError
+	srv.logger = newLogger(config.UserLogf, config.LogLevel)
+	if config.Logger != nil {
+		srv.logger = newSlogLogger(config.Logger, config.LogLevel)
+	}
+	// the backend logs before the fqdn is known and keeps the logger without it
+	userLogger := srv.logger
+	backendLogf := config.Logf
+	if backendLogf == nil && config.LogLevel <= slog.LevelDebug {
+		backendLogf = func(format string, args ...any) {
+			userLogger.logf(slog.LevelDebug, format, args...)
+		}
+	}
+
+	if config.LocalMode {
+		identityProvider, err := srv.startLocal(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := srv.setUpRoutes(config, identityProvider); err != nil {
+			return nil, err
+		}
+		go srv.checkCertificates(backgroundCtx, certificateCheckInterval)
+		created = true
+		return srv, nil
+	}
+
+	authKey := config.TailscaleAuthKey
+	authKeySource := authKeySourceFromConfig(config)
+	if authKeySource != nil {
+		key, err := authKeySource.Get(backgroundCtx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain auth key: %w", err)
+		}
+		authKey = key
+	}
 	srv.tsServer = &tsnet.Server{
-		AuthKey:  config.TailscaleAuthKey,
-		Hostname: config.Hostname,
-		Dir:      config.TailscaleStateDirectory,
+		AuthKey:       authKey,
+		Hostname:      config.Hostname,
+		Dir:           config.TailscaleStateDirectory,
+		Ephemeral:     config.Ephemeral,
+		AdvertiseTags: config.AdvertiseTags,
+		ControlURL:    config.ControlURL,
+		RunWebClient:  config.RunWebClient,
+		Logf:          backendLogf,
+		UserLogf: func(format string, args ...any) {
+			userLogger.log(slog.LevelInfo, "tailscale", fmt.Sprintf(format, args...))
+		},
+	}
+	if config.InMemoryState {
+		srv.tsServer.Store = new(mem.Store)
+	}
+	if config.KubernetesStateSecret != "" {
+		store, err := NewKubernetesSecretStore(config.KubernetesStateSecret, func(format string, args ...any) {
+			userLogger.logf(slog.LevelDebug, format, args...)
+		})
+		if err != nil {
+			return nil, err
+		}
+		srv.tsServer.Store = store
+	}
+	if config.StateStore != nil {
+		srv.tsServer.Store = config.StateStore
 	}
 
 	// creates client to talk to Tailscale API
@@ -54,49 +345,169 @@ func NewServer(config *ServerConfig) (*Server, error) {
 		return nil, fmt.Errorf("failed to create local client to talk to tailscale API: %w", err)
 	}
 	srv.tsClient = tsClient
+	identityProvider := config.IdentityProvider
+	if identityProvider == nil {
+		identityProvider = tsClient
+	}
+	if _, ok := identityProvider.(*DevIdentityProvider); ok {
+		srv.logger.log(slog.LevelWarn, "insecure_identity", "WARNING: development identity provider is in use; callers are not authenticated")
+	}
+	srv.whoIs = identityProvider.WhoIs
+	srv.certificates = newCertificateTracker(config.CertificateExpiryWarningThreshold, srv.logger)
+	fallbackGetCertificate := tsClient.GetCertificate
+	if config.SelfSignedCertificate {
+		fallbackGetCertificate = srv.getSelfSignedCertificate
+	}
+	srv.tlsConfig = tlsConfigFromTemplate(config.TLSConfig, srv.certificates.observe(chainGetCertificate(config.GetCertificate, fallbackGetCertificate)))
+	applyClientCertificateConfig(srv.tlsConfig, config.ClientCAs, config.ClientCertificateOptional)
 
 	// loop until the Tailscale node is fully up and running
 out:
 	for {
-		upCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+		upCtx, cancelUp := context.WithTimeout(context.Background(), 10*time.Second)
 		status, err := srv.tsServer.Up(upCtx)
+		cancelUp()
 		if err == nil && status != nil {
 			break out
 		}
 	}
 
 	// talks to Tailscale API to retrieve status of this node in tailnet
-	statusCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	statusCtx, cancelStatus := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelStatus()
 	status, err := tsClient.Status(statusCtx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tailscale status: %w", err)
 	}
 	srv.fqdn = strings.TrimSuffix(status.Self.DNSName, ".")
-	log.Printf("this service will be available on [%s]", srv.fqdn)
+	srv.certDomains = status.CertDomains
+	srv.logger = srv.logger.with("fqdn", srv.fqdn)
+	srv.logger.log(slog.LevelInfo, "ready", fmt.Sprintf("this service will be available on [%s]", srv.fqdn))
 
+	if len(config.AdvertiseRoutes) > 0 {
+		routes, _ := parseRoutes(config.AdvertiseRoutes)
+		routesCtx, cancelRoutes := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelRoutes()
+		if err := srv.advertiseRoutes(routesCtx, routes); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.ExitNode != "" || config.AcceptRoutes {
+		outboundCtx, cancelOutbound := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelOutbound()
+		if err := srv.configureOutbound(outboundCtx, config.ExitNode, config.AcceptRoutes); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.SelfSignedCertificate {
+		cert, err := newSelfSignedCertificate([]string{srv.fqdn, config.Hostname}, status.TailscaleIPs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+		}
+		srv.selfSignedCertificate = cert
+		srv.logger.log(slog.LevelInfo, "self_signed_certificate", fmt.Sprintf("serving self-signed certificate for [%s]", srv.fqdn))
+	}
+
+	if config.WhoIsCacheTTL > 0 {
+		cache := newWhoIsCache(config.WhoIsCacheTTL)
+		srv.whoIs = cache.wrap(identityProvider.WhoIs)
+		srv.whoIsCache = cache
+	}
+
+	if err := srv.setUpRoutes(config, identityProvider); err != nil {
+		return nil, err
+	}
+
+	if srv.whoIsCache != nil {
+		go srv.whoIsCache.invalidateOnNetMapChange(backgroundCtx, tsClient, srv.logger)
+	}
+	if authKeySource != nil {
+		go reauthenticate(backgroundCtx, tsClient, authKeySource, srv.logger)
+	}
+	if config.Hooks.OnTailnetStateChange != nil {
+		go watchTailnetState(backgroundCtx, tsClient, config.Hooks.OnTailnetStateChange, srv.logger)
+	}
+	if srv.selfSignedCertificate != nil || len(srv.certDomains) > 0 {
+		go srv.checkCertificates(backgroundCtx, certificateCheckInterval)
+	}
+	created = true
 	return srv, nil
 }
 
+// setUpRoutes creates the router of the server looking up callers with
+// identityProvider and registers the built-in routes enabled by config,
+// both on the tailnet and in local mode.
+func (s *Server) setUpRoutes(config *ServerConfig, identityProvider IdentityProvider) error {
+	s.identify = identifyByRemoteAddr(s.whoIs)
+	if requestIdentityProvider, ok := identityProvider.(RequestIdentityProvider); ok {
+		s.identify = requestIdentityProvider.IdentifyRequest
+	}
+	s.router = newRouter(s.identify)
+	s.hostname = config.Hostname
+	if config.StatusPage {
+		s.router.Handle("GET /{$}", nil, s.StatusPage())
+	}
+	if config.Metrics {
+		s.metrics = true
+		s.router.Handle("GET "+MetricsPath, nil, s.MetricsHandler())
+	}
+	if config.HealthCheck {
+		s.router.Handle("GET "+HealthCheckPath, nil, s.HealthHandler())
+	}
+	if config.DebugStatus != nil {
+		s.HandleDebugStatus(config.DebugStatus)
+	}
+	if config.Netcheck != nil {
+		s.HandleNetcheck(config.Netcheck)
+	}
+
+	if config.WarmCertificates {
+		warmCtx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if err := s.WarmCertificates(warmCtx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Listen starts listening on the specified ports and returns the TLS listeners.
 // If port 443 is among the specified ports, it also sets up a non-TLS listener
-// on port 80 that redirects all HTTP requests to HTTPS.
+// on port 80 that redirects all HTTP requests to HTTPS. Duplicate ports are
+// listened on once, and an *InvalidPortError is returned for ports which
+// cannot be used for HTTPS.
 func (s *Server) Listen(httpsPorts []int) (listeners []net.Listener, nonHTTPSListener net.Listener, nonHTTPSHandler http.Handler, err error) {
+	httpsPorts, err = validateHTTPSPorts(httpsPorts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	listeners = make([]net.Listener, 0, len(httpsPorts))
+	closeListeners := func() {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+		if nonHTTPSListener != nil {
+			_ = nonHTTPSListener.Close()
+		}
+	}
 
 	for _, port := range httpsPorts {
 		addr := fmt.Sprintf(":%d", port)
-		listener, err := s.tsServer.ListenTLS(Protocol, addr)
+		listener, err := s.listenTLS(addr)
 		if err != nil {
+			closeListeners()
 			return nil, nil, nil, fmt.Errorf("failed to listen TLS at [%s]: %w", addr, err)
 		}
 		listeners = append(listeners, listener)
+		s.logger.log(slog.LevelInfo, "listening", fmt.Sprintf("listening HTTPS on port %d", port), "port", port)
 
 		if port == 443 {
 			nonHTTPSHandler = nonHTTPSHandlerFromHostname(s.fqdn)
-			nonHTTPSListener, err = s.tsServer.Listen(Protocol, HTTPAddress)
+			nonHTTPSListener, err = s.listen(HTTPAddress)
 			if err != nil {
+				closeListeners()
 				return nil, nil, nil, fmt.Errorf("failed to listen non-TLS at [%s]: %w", HTTPAddress, err)
 			}
 		}
@@ -104,18 +515,91 @@ func (s *Server) Listen(httpsPorts []int) (listeners []net.Listener, nonHTTPSLis
 	return listeners, nonHTTPSListener, nonHTTPSHandler, nil
 }
 
-// Close shuts down the tailscale server.
+// InvalidPortError reports a port passed to Listen which cannot be used for
+// HTTPS.
+type InvalidPortError struct {
+	// Index is the position of the port in the slice passed to Listen.
+	Index int
+	Port  int
+	// Reason describes what is wrong with the port.
+	Reason string
+}
+
+func (e *InvalidPortError) Error() string {
+	return fmt.Sprintf("invalid HTTPS port [%d] at index %d: %s", e.Port, e.Index, e.Reason)
+}
+
+// validateHTTPSPorts checks that ports can be used for HTTPS and returns them
+// with duplicates removed.
+func validateHTTPSPorts(ports []int) ([]int, error) {
+	unique := make([]int, 0, len(ports))
+	seen := make(map[int]bool, len(ports))
+	for i, port := range ports {
+		switch {
+		case port < 1 || port > 65535:
+			return nil, &InvalidPortError{Index: i, Port: port, Reason: "port must be between 1 and 65535"}
+		case port == 80:
+			return nil, &InvalidPortError{Index: i, Port: port, Reason: "port 80 is reserved for redirecting HTTP to HTTPS when port 443 is listened on"}
+		}
+		if seen[port] {
+			continue
+		}
+		seen[port] = true
+		unique = append(unique, port)
+	}
+	return unique, nil
+}
+
+// Close shuts down the tailscale server. In local mode, it only stops the
+// background work of the server.
 func (s *Server) Close() error {
-	if s.tsServer == nil {
+	if s.tsServer == nil && !s.localMode() {
 		return fmt.Errorf("server is not initialized")
 	}
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.localMode() {
+		return nil
+	}
 	return s.tsServer.Close()
 }
 
+// shutdownPollInterval is the interval at which Shutdown checks whether the
+// open connections are closed.
+const shutdownPollInterval = 50 * time.Millisecond
+
+// Shutdown closes the server gracefully. It stops accepting connections on
+// the listeners created by Listen and its variants, such as ServeSSH, waits
+// until their open connections are closed or ctx is done, and closes the
+// server. Idle keep-alive connections count as open, so http.Server.Shutdown
+// should be called on the HTTP servers serving the listeners first. It
+// returns the error of ctx if connections were still open when ctx was done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.connections.closeListeners()
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	var err error
+	for err == nil && s.connections.active() > 0 {
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("connections are still open: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return errors.Join(err, s.Close())
+}
+
 // GetCallerIndentity retrieves the identity of the caller from the Tailscale
-// API
+// API. It returns ErrFunnelRequest for requests which arrived over Tailscale
+// Funnel.
+//
+// Deprecated: Use GetCallerIdentity instead.
 func (s *Server) GetCallerIndentity(r *http.Request) (*apitype.WhoIsResponse, error) {
-	who, err := s.tsClient.WhoIs(r.Context(), r.RemoteAddr)
+	if IsFunnelRequest(r) {
+		return nil, ErrFunnelRequest
+	}
+	who, err := s.identify(r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get caller identity from tailscale API: %w", err)
 	}
@@ -123,7 +607,7 @@ func (s *Server) GetCallerIndentity(r *http.Request) (*apitype.WhoIsResponse, er
 }
 
 func (s *Server) GetCallerIdentityFromRemoteIPAddress(ctx context.Context, ipAddress string) (*apitype.WhoIsResponse, error) {
-	who, err := s.tsClient.WhoIs(ctx, ipAddress)
+	who, err := s.whoIs(ctx, ipAddress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get caller identity from tailscale API: %w", err)
 	}
@@ -149,38 +633,167 @@ func nonHTTPSHandlerFromHostname(hostname string) http.Handler {
 	})
 }
 
-// HSTS wraps the provided handler and sets Strict-Transport-Security header on
-// responses. It inspects the Host header to ensure we do not specify HSTS
-// response on non fully qualified domain name origins.
-func HSTS(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		host, found := r.Header["Host"]
-		if found {
-			host := host[0]
-			fqdn, err := dnsname.ToFQDN(host)
-			if err == nil {
-				segCount := fqdn.NumLabels()
-				if segCount > 1 {
-					w.Header().Set("Strict-Transport-Security", "max-age=31536000")
-				}
-			}
-		}
-		h.ServeHTTP(w, r)
-	})
+// DefaultConfig returns the configuration in effect for fields which are not
+// set, such as the state directory tsnet derives from the program name. The
+// auth key and hostname have no defaults and have to be set before use.
+func DefaultConfig() *ServerConfig {
+	return &ServerConfig{
+		TailscaleStateDirectory:           defaultStateDirectory(),
+		CertificateExpiryWarningThreshold: DefaultCertificateExpiryWarningThreshold,
+		ControlURL:                        ipn.DefaultControlURL,
+		LogLevel:                          slog.LevelInfo,
+	}
+}
+
+// defaultStateDirectory returns the state directory tsnet uses when none is
+// specified, or an empty string if it cannot be determined.
+func defaultStateDirectory() string {
+	configDirectory, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	program := strings.TrimSuffix(strings.ToLower(filepath.Base(executable)), ".exe")
+	return filepath.Join(configDirectory, "tsnet-"+program)
+}
+
+// Validate checks the configuration without joining the tailnet, such as for
+// tools verifying a configuration before deploying it.
+func (config *ServerConfig) Validate() error {
+	return validateConfiguration(config)
 }
 
 // validateConfiguration checks if the provided configuration is valid.
 func validateConfiguration(config *ServerConfig) error {
-	if config.TailscaleAuthKey == "" {
-		return fmt.Errorf("tailscale auth key cannot be empty")
+	if config.LocalMode {
+		if err := validateLocalMode(config); err != nil {
+			return err
+		}
+	} else if config.LocalAddress != "" {
+		return fieldErrorf("LocalAddress", "local address requires local mode")
+	} else if config.TailscaleAuthKey == "" && config.AuthKeySource == nil {
+		return fieldErrorf("TailscaleAuthKey", "tailscale auth key cannot be empty")
+	}
+	if config.TailscaleAuthKey != "" && config.AuthKeySource != nil {
+		return fmt.Errorf("tailscale auth key and auth key source cannot both be specified")
 	}
 
-	if config.Hostname == "" {
-		return fmt.Errorf("hostname cannot be empty")
+	if err := validateHostname(config.Hostname); err != nil {
+		return &configFieldError{field: "Hostname", err: err}
 	}
-	if strings.ContainsAny(config.Hostname, " ./") {
-		return fmt.Errorf("hostname cannot contain space, dot, or slash")
+
+	if config.TLSConfig != nil {
+		minVersion := config.TLSConfig.MinVersion
+		maxVersion := config.TLSConfig.MaxVersion
+		if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+			return fmt.Errorf("TLS minimum version cannot be greater than maximum version")
+		}
 	}
 
+	if config.ClientCertificateOptional && config.ClientCAs == nil {
+		return fmt.Errorf("client certificate authorities must be specified when client certificate is optional")
+	}
+
+	if config.CertificateExpiryWarningThreshold < 0 {
+		return fieldErrorf("CertificateExpiryWarningThreshold", "certificate expiry warning threshold cannot be negative")
+	}
+
+	if config.InMemoryState && !config.Ephemeral {
+		return fieldErrorf("InMemoryState", "in-memory state requires an ephemeral node")
+	}
+
+	if config.StateStore != nil && (config.InMemoryState || config.KubernetesStateSecret != "") {
+		return fmt.Errorf("state store cannot be specified with in-memory state or kubernetes state secret")
+	}
+
+	if config.KubernetesStateSecret != "" {
+		if config.InMemoryState {
+			return fieldErrorf("KubernetesStateSecret", "in-memory state and kubernetes state secret cannot both be specified")
+		}
+		if strings.Contains(config.KubernetesStateSecret, "/") {
+			return fieldErrorf("KubernetesStateSecret", "kubernetes state secret [%s] must be a name in the namespace of the pod", config.KubernetesStateSecret)
+		}
+	}
+
+	for _, tag := range config.AdvertiseTags {
+		if !isValidTag(tag) {
+			return fieldErrorf("AdvertiseTags", "advertised tag [%s] must be in the form of tag:name", tag)
+		}
+	}
+
+	if _, err := parseRoutes(config.AdvertiseRoutes); err != nil {
+		return &configFieldError{field: "AdvertiseRoutes", err: err}
+	}
+
+	if config.ControlURL != "" {
+		u, err := url.Parse(config.ControlURL)
+		if err != nil {
+			return fieldErrorf("ControlURL", "invalid control URL: %w", err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fieldErrorf("ControlURL", "control URL [%s] must be an absolute HTTP or HTTPS URL", config.ControlURL)
+		}
+	}
+
+	if config.WhoIsCacheTTL < 0 {
+		return fieldErrorf("WhoIsCacheTTL", "WhoIs cache TTL cannot be negative")
+	}
+
+	return nil
+}
+
+// configFieldError is an invalid value of the ServerConfig field named field,
+// which LoadConfig reports under the key of the field.
+type configFieldError struct {
+	field string
+	err   error
+}
+
+func (e *configFieldError) Error() string {
+	return e.err.Error()
+}
+
+func (e *configFieldError) Unwrap() error {
+	return e.err
+}
+
+// fieldErrorf returns a configFieldError of field formatted as fmt.Errorf
+// does.
+func fieldErrorf(field, format string, args ...any) error {
+	return &configFieldError{field: field, err: fmt.Errorf(format, args...)}
+}
+
+// isValidTag reports whether tag is an ACL tag in the form of tag:name.
+func isValidTag(tag string) bool {
+	return strings.HasPrefix(tag, "tag:") && len(tag) > len("tag:")
+}
+
+// maxHostnameLength is the maximum length of a DNS label.
+const maxHostnameLength = 63
+
+// validateHostname checks that hostname is a valid DNS label as required by
+// the control plane, reporting exactly what is wrong otherwise.
+func validateHostname(hostname string) error {
+	if hostname == "" {
+		return fmt.Errorf("hostname cannot be empty")
+	}
+	if len(hostname) > maxHostnameLength {
+		return fmt.Errorf("hostname [%s] is %d characters long; it cannot be longer than %d characters", hostname, len(hostname), maxHostnameLength)
+	}
+	for i, r := range hostname {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+		case r == '.':
+			return fmt.Errorf("hostname [%s] cannot contain dot at position %d; it has to be a single DNS label", hostname, i+1)
+		default:
+			return fmt.Errorf("hostname [%s] cannot contain %q at position %d; only letters, digits and hyphens are allowed", hostname, r, i+1)
+		}
+	}
+	if strings.HasPrefix(hostname, "-") || strings.HasSuffix(hostname, "-") {
+		return fmt.Errorf("hostname [%s] cannot start or end with a hyphen", hostname)
+	}
 	return nil
 }
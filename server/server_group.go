@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+// ServerGroup runs several servers, each being a node with its own hostname,
+// in one process so that the components of an application get distinct
+// tailnet names. The metrics of requests, rate limiting and certificates are
+// process-wide, so the MetricsHandler of any server of the group exports them
+// for all servers, whereas Stats reports the connections of each server.
+type ServerGroup struct {
+	servers []*Server
+}
+
+// NewServerGroup creates a server for each configuration concurrently and
+// returns once all of them are up. The options, such as WithLogger, are
+// applied to every configuration, and the messages of each server are
+// prefixed with its hostname, or carry it as the hostname attribute of
+// structured records. Hostnames and state directories have to be
+// distinct. If any server fails to start, the others are closed.
+func NewServerGroup(configs []*ServerConfig, opts ...Option) (*ServerGroup, error) {
+	groupConfigs, err := newGroupConfigs(configs, opts...)
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]*Server, len(groupConfigs))
+	errs := make([]error, len(groupConfigs))
+	var wg sync.WaitGroup
+	for i, config := range groupConfigs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			servers[i], errs[i] = NewServer(config)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("failed to create server [%s]: %w", config.Hostname, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		for _, srv := range servers {
+			if srv != nil {
+				_ = srv.Close()
+			}
+		}
+		return nil, err
+	}
+	return &ServerGroup{servers: servers}, nil
+}
+
+// newGroupConfigs returns copies of configs with opts applied and log
+// messages tagged with the hostname.
+func newGroupConfigs(configs []*ServerConfig, opts ...Option) ([]*ServerConfig, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("at least one server configuration is required")
+	}
+	hostnames := make(map[string]bool, len(configs))
+	directories := make(map[string]bool, len(configs))
+	groupConfigs := make([]*ServerConfig, 0, len(configs))
+	for i, config := range configs {
+		if config == nil {
+			return nil, fmt.Errorf("server configuration [%d] is nil", i)
+		}
+		c := *config
+		for _, opt := range opts {
+			opt(&c)
+		}
+		if hostnames[c.Hostname] {
+			return nil, fmt.Errorf("hostname [%s] is used by more than one server", c.Hostname)
+		}
+		hostnames[c.Hostname] = true
+		if c.TailscaleStateDirectory == "" {
+			return nil, fmt.Errorf("server [%s] requires a state directory as servers of a group cannot share the default one", c.Hostname)
+		}
+		directory := filepath.Clean(c.TailscaleStateDirectory)
+		if directories[directory] {
+			return nil, fmt.Errorf("state directory [%s] is used by more than one server", c.TailscaleStateDirectory)
+		}
+		directories[directory] = true
+
+		switch {
+		case c.Logger != nil:
+			c.Logger = c.Logger.With("hostname", c.Hostname)
+		case c.UserLogf != nil:
+			c.UserLogf = prefixLogf(c.Hostname, c.UserLogf)
+		case packageLogf.Load() != nil:
+			c.UserLogf = prefixLogf(c.Hostname, *packageLogf.Load())
+		default:
+			c.Logger = defaultLogger().With("hostname", c.Hostname)
+		}
+		if c.Logf != nil {
+			c.Logf = prefixLogf(c.Hostname, c.Logf)
+		}
+		groupConfigs = append(groupConfigs, &c)
+	}
+	return groupConfigs, nil
+}
+
+// prefixLogf returns a logging function writing messages prefixed with
+// hostname to logf.
+func prefixLogf(hostname string, logf func(format string, args ...any)) func(format string, args ...any) {
+	return func(format string, args ...any) {
+		logf("%s: %s", hostname, fmt.Sprintf(format, args...))
+	}
+}
+
+// Server returns the server with the specified hostname.
+func (g *ServerGroup) Server(hostname string) (*Server, bool) {
+	for _, srv := range g.servers {
+		if srv.hostname == hostname {
+			return srv, true
+		}
+	}
+	return nil, false
+}
+
+// Servers returns the servers of the group in the order of their
+// configurations.
+func (g *ServerGroup) Servers() []*Server {
+	return append([]*Server(nil), g.servers...)
+}
+
+// Stats returns the traffic counters of every server of the group keyed by
+// hostname.
+func (g *ServerGroup) Stats() map[string]Stats {
+	stats := make(map[string]Stats, len(g.servers))
+	for _, srv := range g.servers {
+		stats[srv.hostname] = srv.Stats()
+	}
+	return stats
+}
+
+// Shutdown shuts every server of the group down gracefully and concurrently
+// as Server.Shutdown does, so that in-flight connections are drained until
+// ctx is done. It returns the errors of all of them.
+func (g *ServerGroup) Shutdown(ctx context.Context) error {
+	return g.each("shut down", func(srv *Server) error { return srv.Shutdown(ctx) })
+}
+
+// Close closes every server of the group without waiting for open
+// connections and returns the errors of all of them.
+func (g *ServerGroup) Close() error {
+	return g.each("close", (*Server).Close)
+}
+
+// each calls f with every server of the group concurrently and returns the
+// errors of all of them.
+func (g *ServerGroup) each(action string, f func(*Server) error) error {
+	errs := make([]error, len(g.servers))
+	var wg sync.WaitGroup
+	for i, srv := range g.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f(srv); err != nil {
+				errs[i] = fmt.Errorf("failed to %s server [%s]: %w", action, srv.hostname, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewGroupConfigs(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []*ServerConfig
+		opts    []Option
+		wantErr string
+	}{
+		{
+			name: "valid",
+			configs: []*ServerConfig{
+				{TailscaleAuthKey: "tskey-test", Hostname: "api", TailscaleStateDirectory: "/var/lib/api"},
+				{TailscaleAuthKey: "tskey-test", Hostname: "web", TailscaleStateDirectory: "/var/lib/web"},
+			},
+		},
+		{
+			name: "state directory from option",
+			configs: []*ServerConfig{
+				{TailscaleAuthKey: "tskey-test", Hostname: "api"},
+			},
+			opts: []Option{WithStateDir("/var/lib/api")},
+		},
+		{name: "empty", wantErr: "at least one"},
+		{name: "nil configuration", configs: []*ServerConfig{nil}, wantErr: "is nil"},
+		{
+			name: "duplicate hostname",
+			configs: []*ServerConfig{
+				{TailscaleAuthKey: "tskey-test", Hostname: "api", TailscaleStateDirectory: "/var/lib/api"},
+				{TailscaleAuthKey: "tskey-test", Hostname: "api", TailscaleStateDirectory: "/var/lib/web"},
+			},
+			wantErr: "hostname [api]",
+		},
+		{
+			name: "duplicate state directory",
+			configs: []*ServerConfig{
+				{TailscaleAuthKey: "tskey-test", Hostname: "api", TailscaleStateDirectory: "/var/lib/api"},
+				{TailscaleAuthKey: "tskey-test", Hostname: "web", TailscaleStateDirectory: "/var/lib/api/"},
+			},
+			wantErr: "state directory [/var/lib/api/]",
+		},
+		{
+			name:    "missing state directory",
+			configs: []*ServerConfig{{TailscaleAuthKey: "tskey-test", Hostname: "api"}},
+			wantErr: "requires a state directory",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configs, err := newGroupConfigs(test.configs, test.opts...)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("got error %v, want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(configs) != len(test.configs) {
+				t.Fatalf("got %d configurations, want %d", len(configs), len(test.configs))
+			}
+		})
+	}
+}
+
+func TestNewGroupConfigsLogging(t *testing.T) {
+	var messages []string
+	logf := func(format string, args ...any) {
+		messages = append(messages, fmt.Sprintf(format, args...))
+	}
+	original := &ServerConfig{TailscaleAuthKey: "tskey-test", Hostname: "api", TailscaleStateDirectory: "/var/lib/api", UserLogf: logf}
+	configs, err := newGroupConfigs([]*ServerConfig{original})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs[0].UserLogf("listening on %d%%", 100)
+	if len(messages) != 1 || messages[0] != "api: listening on 100%" {
+		t.Errorf("got messages %q", messages)
+	}
+	original.UserLogf("unchanged")
+	if len(messages) != 2 || messages[1] != "unchanged" {
+		t.Errorf("original configuration is modified; got messages %q", messages)
+	}
+}
+
+func TestNewGroupConfigsStructuredLogging(t *testing.T) {
+	var buf bytes.Buffer
+	original := &ServerConfig{TailscaleAuthKey: "tskey-test", Hostname: "api", TailscaleStateDirectory: "/var/lib/api"}
+	configs, err := newGroupConfigs([]*ServerConfig{original}, WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs[0].Logger.Info("ready")
+	if got := buf.String(); !strings.Contains(got, "msg=ready hostname=api") {
+		t.Errorf("got %q", got)
+	}
+	if original.Logger != nil {
+		t.Errorf("original configuration is modified")
+	}
+}
+
+func TestServerGroupShutdown(t *testing.T) {
+	var configs []*ServerConfig
+	for _, hostname := range []string{"web", "api"} {
+		config := newConfig("", hostname, WithLocalMode(""))
+		config.TailscaleStateDirectory = t.TempDir()
+		config.UserLogf = t.Logf
+		configs = append(configs, config)
+	}
+	group, err := NewServerGroup(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := group.Stats(); len(got) != 2 {
+		t.Errorf("got stats of %d servers; want 2", len(got))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := group.Shutdown(ctx); err != nil {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -1,9 +1,19 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"tailscale.com/ipn/store/mem"
 )
 
 func serveHandler() http.Handler {
@@ -12,37 +22,6 @@ func serveHandler() http.Handler {
 	})
 }
 
-func TestHSTS(t *testing.T) {
-	tests := []struct {
-		host       string
-		expectHsts bool
-	}{
-		{
-			host:       "test-hostname",
-			expectHsts: false,
-		},
-		{
-			host:       "test-hostname.prawn-universe.ts.net",
-			expectHsts: true,
-		},
-	}
-	for _, tt := range tests {
-		name := "host:[" + tt.host + "]"
-		t.Run(name, func(t *testing.T) {
-			r := httptest.NewRequest("GET", "/", nil)
-			if tt.host != "" {
-				r.Header.Add("Host", tt.host)
-			}
-			w := httptest.NewRecorder()
-			HSTS(serveHandler()).ServeHTTP(w, r)
-			_, found := w.Header()["Strict-Transport-Security"]
-			if found != tt.expectHsts {
-				t.Errorf("HSTS expectation: domain %s want: %t got: %t", tt.host, tt.expectHsts, found)
-			}
-		})
-	}
-}
-
 func TestNonHTTPRedirectWithQuery(t *testing.T) {
 	h := nonHTTPSHandlerFromHostname("foobar.com")
 	r := httptest.NewRequest("GET", "http://example.com/?query=bar", nil)
@@ -80,6 +59,25 @@ func TestValidateConfiguration(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "auth key source",
+			config: &ServerConfig{
+				AuthKeySource:           AuthKeyFromEnv("TS_AUTHKEY"),
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+			},
+			wantErr: false,
+		},
+		{
+			name: "auth key and auth key source",
+			config: &ServerConfig{
+				TailscaleAuthKey:        "tskey-test",
+				AuthKeySource:           AuthKeyFromEnv("TS_AUTHKEY"),
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+			},
+			wantErr: true,
+		},
 		{
 			name: "empty hostname",
 			config: &ServerConfig{
@@ -107,6 +105,19 @@ func TestValidateConfiguration(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "TLS minimum version greater than maximum version",
+			config: &ServerConfig{
+				TailscaleAuthKey:        "tskey-test",
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+				TLSConfig: &tls.Config{
+					MinVersion: tls.VersionTLS13,
+					MaxVersion: tls.VersionTLS12,
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "hostname with slash",
 			config: &ServerConfig{
@@ -116,6 +127,119 @@ func TestValidateConfiguration(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "negative certificate expiry warning threshold",
+			config: &ServerConfig{
+				TailscaleAuthKey:                  "tskey-test",
+				Hostname:                          "test-hostname",
+				TailscaleStateDirectory:           "/tmp/tailscale",
+				CertificateExpiryWarningThreshold: -time.Hour,
+			},
+			wantErr: true,
+		},
+		{
+			name: "advertised tag without prefix",
+			config: &ServerConfig{
+				TailscaleAuthKey:        "tskey-test",
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+				AdvertiseTags:           []string{"tag:web", "internal"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "advertised route with host bits",
+			config: &ServerConfig{
+				TailscaleAuthKey:        "tskey-test",
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+				AdvertiseRoutes:         []string{"192.168.1.1/24"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "in-memory state of ephemeral node",
+			config: &ServerConfig{
+				TailscaleAuthKey:        "tskey-test",
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+				Ephemeral:               true,
+				InMemoryState:           true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "in-memory state of persistent node",
+			config: &ServerConfig{
+				TailscaleAuthKey:        "tskey-test",
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+				InMemoryState:           true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "kubernetes state secret",
+			config: &ServerConfig{
+				TailscaleAuthKey:        "tskey-test",
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+				KubernetesStateSecret:   "web-tailscale-state",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid kubernetes state secret",
+			config: &ServerConfig{
+				TailscaleAuthKey:        "tskey-test",
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+				KubernetesStateSecret:   "apps/web-tailscale-state",
+			},
+			wantErr: true,
+		},
+		{
+			name: "state store and in-memory state",
+			config: &ServerConfig{
+				TailscaleAuthKey:        "tskey-test",
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+				Ephemeral:               true,
+				InMemoryState:           true,
+				StateStore:              new(mem.Store),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Headscale control URL",
+			config: &ServerConfig{
+				TailscaleAuthKey:        "tskey-test",
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+				ControlURL:              "https://headscale.example.com",
+			},
+			wantErr: false,
+		},
+		{
+			name: "relative control URL",
+			config: &ServerConfig{
+				TailscaleAuthKey:        "tskey-test",
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+				ControlURL:              "headscale.example.com",
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative WhoIs cache TTL",
+			config: &ServerConfig{
+				TailscaleAuthKey:        "tskey-test",
+				Hostname:                "test-hostname",
+				TailscaleStateDirectory: "/tmp/tailscale",
+				WhoIsCacheTTL:           -time.Minute,
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -127,3 +251,166 @@ func TestValidateConfiguration(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		wantErr  string
+	}{
+		{hostname: "web"},
+		{hostname: "Web-01"},
+		{hostname: strings.Repeat("a", 63)},
+		{hostname: "", wantErr: "cannot be empty"},
+		{hostname: strings.Repeat("a", 64), wantErr: "64 characters long"},
+		{hostname: "web_01", wantErr: `'_' at position 4`},
+		{hostname: "web.internal", wantErr: "dot at position 4"},
+		{hostname: "web 01", wantErr: `' ' at position 4`},
+		{hostname: "-web", wantErr: "hyphen"},
+		{hostname: "web-", wantErr: "hyphen"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			err := validateHostname(tt.hostname)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateHostname() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateHostname() error = %v; want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateHTTPSPorts(t *testing.T) {
+	tests := []struct {
+		name      string
+		ports     []int
+		want      []int
+		wantIndex int
+		wantErr   bool
+	}{
+		{
+			name:  "valid ports",
+			ports: []int{443, 8443},
+			want:  []int{443, 8443},
+		},
+		{
+			name:  "duplicate ports",
+			ports: []int{443, 8443, 443},
+			want:  []int{443, 8443},
+		},
+		{
+			name:      "port 0",
+			ports:     []int{443, 0},
+			wantIndex: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "negative port",
+			ports:     []int{-443},
+			wantIndex: 0,
+			wantErr:   true,
+		},
+		{
+			name:      "port out of range",
+			ports:     []int{443, 8443, 70000},
+			wantIndex: 2,
+			wantErr:   true,
+		},
+		{
+			name:      "port 80",
+			ports:     []int{443, 80},
+			wantIndex: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateHTTPSPorts(tt.ports)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateHTTPSPorts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var portErr *InvalidPortError
+				if !errors.As(err, &portErr) || portErr.Index != tt.wantIndex || portErr.Port != tt.ports[tt.wantIndex] {
+					t.Errorf("got error %#v; want InvalidPortError at index %d", err, tt.wantIndex)
+				}
+				return
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	config := DefaultConfig()
+	if config.TailscaleStateDirectory == "" ||
+		config.CertificateExpiryWarningThreshold != DefaultCertificateExpiryWarningThreshold ||
+		config.ControlURL == "" {
+		t.Errorf("got %+v", config)
+	}
+	if err := config.Validate(); err == nil {
+		t.Error("Validate() succeeded without auth key and hostname")
+	}
+
+	config.TailscaleAuthKey = "tskey-test"
+	config.Hostname = "test-hostname"
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		closeNow bool
+		wantErr  error
+	}{
+		{name: "drained", closeNow: true},
+		{name: "connection still open", wantErr: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newConfig("", "app", WithLocalMode(""))
+			config.UserLogf = t.Logf
+			srv, err := NewServer(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			listener, err := srv.listen(":" + strconv.Itoa(freePort(t)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = client.Close() }()
+			conn, err := listener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			shutdown := make(chan error, 1)
+			go func() { shutdown <- srv.Shutdown(ctx) }()
+			if tt.closeNow {
+				_ = conn.Close()
+			} else {
+				defer func() { _ = conn.Close() }()
+			}
+			if err := <-shutdown; !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v; want %v", err, tt.wantErr)
+			}
+			if _, err := listener.Accept(); err == nil {
+				t.Error("listener accepts connections after shutdown")
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+// Package servertest provides a fake of server.Interface for unit testing
+// applications built on package server without a tailnet. Package
+// servertest/tailnettest runs a tailnet in process for integration testing
+// against real tsnet nodes.
+package servertest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alexhokl/privateserver/server"
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// DefaultFQDN is the FQDN of a Fake created without one.
+const DefaultFQDN = "test.example.ts.net"
+
+// nodeIP is the Tailscale IP of the node of a Fake.
+var nodeIP = netip.MustParseAddr("100.64.0.1")
+
+// Fake is a server.Interface for unit tests. Its listeners are connected in
+// memory to the clients returned by Client, and the identities of callers
+// are those scripted with SetIdentity by their IP address.
+type Fake struct {
+	fqdn   string
+	router *server.Router
+
+	mu         sync.Mutex
+	identities map[netip.Addr]*apitype.WhoIsResponse
+	errors     map[netip.Addr]error
+	listeners  map[int]*listener
+	nextPort   uint16
+	closed     bool
+}
+
+var _ server.Interface = (*Fake)(nil)
+var _ server.IdentityProvider = (*Fake)(nil)
+
+// NewFake creates a Fake node with the specified FQDN, or DefaultFQDN if it
+// is empty.
+func NewFake(fqdn string) *Fake {
+	if fqdn == "" {
+		fqdn = DefaultFQDN
+	}
+	f := &Fake{
+		fqdn:       fqdn,
+		identities: make(map[netip.Addr]*apitype.WhoIsResponse),
+		errors:     make(map[netip.Addr]error),
+		listeners:  make(map[int]*listener),
+		nextPort:   40000,
+	}
+	f.router = server.NewRouterWithIdentityProvider(f)
+	return f
+}
+
+// Identity returns the identity of a user with the specified login name,
+// such as "alice@example.com", on a node named after the user, with the
+// specified ACL tags.
+func Identity(loginName string, tags ...string) *apitype.WhoIsResponse {
+	name, _, _ := strings.Cut(loginName, "@")
+	return &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			Name: name + ".example.ts.net.",
+			Tags: tags,
+		},
+		UserProfile: &tailcfg.UserProfile{
+			LoginName:   loginName,
+			DisplayName: name,
+		},
+	}
+}
+
+// SetIdentity sets the identity of callers from the specified IP address,
+// such as "100.64.0.2". The address is added to the addresses of the node of
+// the identity. A nil identity makes the caller unknown again. It panics if
+// ip is not an IP address.
+func (f *Fake) SetIdentity(ip string, who *apitype.WhoIsResponse) {
+	addr := netip.MustParseAddr(ip)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.errors, addr)
+	if who == nil {
+		delete(f.identities, addr)
+		return
+	}
+	identity := *who
+	if who.Node != nil {
+		node := *who.Node
+		prefix := netip.PrefixFrom(addr, addr.BitLen())
+		if !slices.Contains(node.Addresses, prefix) {
+			node.Addresses = append(slices.Clone(node.Addresses), prefix)
+		}
+		identity.Node = &node
+	}
+	f.identities[addr] = &identity
+}
+
+// SetIdentityError makes the identity lookup of callers from the specified
+// IP address fail with err, such as a failure of the Tailscale API. It
+// panics if ip is not an IP address.
+func (f *Fake) SetIdentityError(ip string, err error) {
+	addr := netip.MustParseAddr(ip)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors[addr] = err
+}
+
+// WhoIs returns the identity set for the IP address of remoteAddr, which is
+// an IP address or IP:port. It returns local.ErrPeerNotFound for unknown
+// callers.
+func (f *Fake) WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	addr, err := netip.ParseAddr(remoteAddr)
+	if err != nil {
+		addrPort, err := netip.ParseAddrPort(remoteAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote address [%s]: %w", remoteAddr, err)
+		}
+		addr = addrPort.Addr()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors[addr]; err != nil {
+		return nil, err
+	}
+	who, found := f.identities[addr]
+	if !found {
+		return nil, local.ErrPeerNotFound
+	}
+	return who, nil
+}
+
+// Listen listens in memory on the specified HTTPS ports, and on port 80 with
+// a handler redirecting to HTTPS if port 443 is among them. The listeners
+// serve plain HTTP, which the clients returned by Client use for https URLs
+// as well.
+func (f *Fake) Listen(httpsPorts []int) (listeners []net.Listener, nonHTTPSListener net.Listener, nonHTTPSHandler http.Handler, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, nil, nil, fmt.Errorf("server is closed")
+	}
+	var ports []int
+	for i, port := range httpsPorts {
+		switch {
+		case port < 1 || port > 65535:
+			return nil, nil, nil, &server.InvalidPortError{Index: i, Port: port, Reason: "port must be between 1 and 65535"}
+		case port == 80:
+			return nil, nil, nil, &server.InvalidPortError{Index: i, Port: port, Reason: "port 80 is reserved for redirecting HTTP to HTTPS when port 443 is listened on"}
+		case f.listeners[port] != nil:
+			return nil, nil, nil, fmt.Errorf("port [%d] is already listened on", port)
+		}
+		if !slices.Contains(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+	for _, port := range ports {
+		listeners = append(listeners, f.listen(port))
+	}
+	if slices.Contains(ports, 443) && f.listeners[80] == nil {
+		nonHTTPSListener = f.listen(80)
+		nonHTTPSHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := &url.URL{Scheme: "https", Host: f.fqdn, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, u.String(), http.StatusFound)
+		})
+	}
+	return listeners, nonHTTPSListener, nonHTTPSHandler, nil
+}
+
+func (f *Fake) listen(port int) *listener {
+	ln := newListener(net.TCPAddrFromAddrPort(netip.AddrPortFrom(nodeIP, uint16(port))))
+	f.listeners[port] = ln
+	return ln
+}
+
+// Handle registers the handler for the specified pattern with the specified
+// policy. See server.Router.Handle.
+func (f *Fake) Handle(pattern string, policy *server.Policy, h http.Handler, middlewares ...server.Middleware) {
+	f.router.Handle(pattern, policy, h, middlewares...)
+}
+
+// HandleFunc registers the handler function for the specified pattern with
+// the specified policy.
+func (f *Fake) HandleFunc(pattern string, policy *server.Policy, h func(http.ResponseWriter, *http.Request), middlewares ...server.Middleware) {
+	f.router.HandleFunc(pattern, policy, h, middlewares...)
+}
+
+// Use adds middlewares to the routes registered afterwards.
+func (f *Fake) Use(middlewares ...server.Middleware) {
+	f.router.Use(middlewares...)
+}
+
+// Handler returns the router of the registered routes.
+func (f *Fake) Handler() http.Handler {
+	return f.router
+}
+
+// FQDN returns the FQDN the Fake was created with.
+func (f *Fake) FQDN() string {
+	return f.fqdn
+}
+
+// GetCallerIdentity returns the identity of the caller of the request.
+func (f *Fake) GetCallerIdentity(r *http.Request) (*server.CallerIdentity, error) {
+	if server.IsFunnelRequest(r) {
+		return &server.CallerIdentity{IsFunnel: true}, nil
+	}
+	if identity, found := server.CallerIdentityFromContext(r.Context()); found {
+		return identity, nil
+	}
+	who, err := f.WhoIs(r.Context(), r.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %w", err)
+	}
+	return server.NewCallerIdentity(who), nil
+}
+
+// GetCallerIdentityFromRemoteIPAddress returns the identity set for the
+// specified IP address.
+func (f *Fake) GetCallerIdentityFromRemoteIPAddress(ctx context.Context, ipAddress string) (*apitype.WhoIsResponse, error) {
+	who, err := f.WhoIs(ctx, ipAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %w", err)
+	}
+	return who, nil
+}
+
+// WithIdentity wraps the provided handler and stores the identity of the
+// caller in the request context. See server.Server.WithIdentity.
+func (f *Fake) WithIdentity(h http.Handler) http.Handler {
+	return server.WithIdentityProvider(f, h)
+}
+
+// Close closes the listeners of the Fake.
+func (f *Fake) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for _, ln := range f.listeners {
+		_ = ln.Close()
+	}
+	return nil
+}
+
+// Client returns an HTTP client whose requests to the Fake, by its FQDN, its
+// machine name or its IP address, reach its listeners from the specified IP
+// address, such as "100.64.0.2". Requests with https URLs are sent in plain
+// HTTP, as the listeners of the Fake do not terminate TLS.
+func (f *Fake) Client(from string) *http.Client {
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		return f.DialFrom(ctx, from, network, address)
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:    dial,
+			DialTLSContext: dial,
+		},
+	}
+}
+
+// DialFrom connects to the listener of the Fake on the port of address from
+// the specified IP address. The host of address is the FQDN, the machine
+// name or the IP address of the Fake.
+func (f *Fake) DialFrom(ctx context.Context, from, network, address string) (net.Conn, error) {
+	if !strings.HasPrefix(network, "tcp") {
+		return nil, net.UnknownNetworkError(network)
+	}
+	fromIP, err := netip.ParseAddr(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid caller address [%s]: %w", from, err)
+	}
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address [%s]: %w", address, err)
+	}
+	machineName, _, _ := strings.Cut(f.fqdn, ".")
+	if host != f.fqdn && host != machineName && host != nodeIP.String() {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port of [%s]: %w", address, err)
+	}
+
+	f.mu.Lock()
+	ln := f.listeners[port]
+	f.nextPort++
+	source := net.TCPAddrFromAddrPort(netip.AddrPortFrom(fromIP, f.nextPort))
+	f.mu.Unlock()
+	if ln == nil {
+		addr := net.TCPAddrFromAddrPort(netip.AddrPortFrom(nodeIP, uint16(port)))
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: addr, Err: fmt.Errorf("connection refused")}
+	}
+	return ln.dial(ctx, source)
+}
+
+// listener is a net.Listener accepting connections made by dial in memory.
+type listener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newListener(addr net.Addr) *listener {
+	return &listener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (ln *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.conns:
+		return c, nil
+	case <-ln.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ln *listener) Close() error {
+	ln.closeOnce.Do(func() { close(ln.closed) })
+	return nil
+}
+
+func (ln *listener) Addr() net.Addr {
+	return ln.addr
+}
+
+// dial returns the client end of a connection whose server end is accepted
+// by the listener with remote address from.
+func (ln *listener) dial(ctx context.Context, from net.Addr) (net.Conn, error) {
+	client, srv := net.Pipe()
+	select {
+	case ln.conns <- &conn{Conn: srv, local: ln.addr, remote: from}:
+		return &conn{Conn: client, local: from, remote: ln.addr}, nil
+	case <-ln.closed:
+		_ = client.Close()
+		_ = srv.Close()
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Addr: ln.addr, Err: net.ErrClosed}
+	case <-ctx.Done():
+		_ = client.Close()
+		_ = srv.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// conn is a net.Conn with TCP addresses.
+type conn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.local }
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
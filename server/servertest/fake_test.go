@@ -0,0 +1,121 @@
+package servertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/alexhokl/privateserver/server"
+	"tailscale.com/client/local"
+)
+
+func TestFake(t *testing.T) {
+	fake := NewFake("")
+	defer func() { _ = fake.Close() }()
+	fake.SetIdentity("100.64.0.2", Identity("alice@example.com"))
+	fake.SetIdentity("100.64.0.3", Identity("bob@example.com"))
+	fake.SetIdentityError("100.64.0.4", errors.New("tailscale API is down"))
+
+	var srv server.Interface = fake
+	srv.HandleFunc("GET /hello", &server.Policy{Allow: []server.Rule{{LoginNames: []string{"alice@example.com"}}}}, func(w http.ResponseWriter, r *http.Request) {
+		who, err := srv.GetCallerIdentity(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "hello %s from %s", who.DisplayName, who.NodeName)
+	})
+	listeners, nonHTTPSListener, nonHTTPSHandler, err := srv.Listen([]int{443})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = http.Serve(listeners[0], srv.Handler()) }()
+	go func() { _ = http.Serve(nonHTTPSListener, nonHTTPSHandler) }()
+
+	tests := []struct {
+		name     string
+		from     string
+		url      string
+		wantCode int
+		wantBody string
+	}{
+		{name: "authorized", from: "100.64.0.2", url: "https://test.example.ts.net/hello", wantCode: http.StatusOK, wantBody: "hello alice from alice.example.ts.net"},
+		{name: "redirected", from: "100.64.0.2", url: "http://test/hello", wantCode: http.StatusOK, wantBody: "hello alice from alice.example.ts.net"},
+		{name: "unauthorized", from: "100.64.0.3", url: "https://test.example.ts.net/hello", wantCode: http.StatusForbidden},
+		{name: "unknown", from: "100.64.0.9", url: "https://test.example.ts.net/hello", wantCode: http.StatusForbidden},
+		{name: "lookup failure", from: "100.64.0.4", url: "https://test.example.ts.net/hello", wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := fake.Client(tt.from).Get(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("got %d; want %d", resp.StatusCode, tt.wantCode)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("got body %q; want %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	if _, err := fake.Client("100.64.0.2").Get("https://test.example.ts.net:8443/hello"); err == nil {
+		t.Error("got no error for a port which is not listened on")
+	}
+	if _, err := fake.Client("100.64.0.2").Get("https://other.example.ts.net/hello"); err == nil {
+		t.Error("got no error for another host")
+	}
+}
+
+func TestFakeIdentity(t *testing.T) {
+	fake := NewFake("web.prawn-universe.ts.net")
+	if got := fake.FQDN(); got != "web.prawn-universe.ts.net" {
+		t.Errorf("got FQDN %q", got)
+	}
+	fake.SetIdentity("100.64.0.5", Identity("tagged-devices", "tag:ci"))
+	who, err := fake.GetCallerIdentityFromRemoteIPAddress(context.Background(), "100.64.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := server.NewCallerIdentity(who)
+	if !identity.IsTagged || identity.Tags[0] != "tag:ci" || len(identity.TailscaleIPs) != 1 || identity.TailscaleIPs[0].String() != "100.64.0.5" {
+		t.Errorf("got identity %+v", identity)
+	}
+
+	fake.SetIdentity("100.64.0.5", nil)
+	if _, err := fake.WhoIs(context.Background(), "100.64.0.5:1234"); !errors.Is(err, local.ErrPeerNotFound) {
+		t.Errorf("got error %v; want %v", err, local.ErrPeerNotFound)
+	}
+}
+
+func TestFakeListen(t *testing.T) {
+	fake := NewFake("")
+	var portErr *server.InvalidPortError
+	if _, _, _, err := fake.Listen([]int{443, 80}); !errors.As(err, &portErr) || portErr.Index != 1 {
+		t.Errorf("got error %v; want an InvalidPortError of index 1", err)
+	}
+	listeners, nonHTTPSListener, _, err := fake.Listen([]int{8443, 8443})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 || listeners[0].Addr().String() != "100.64.0.1:8443" || nonHTTPSListener != nil {
+		t.Errorf("got listeners %v and %v", listeners, nonHTTPSListener)
+	}
+	if _, _, _, err := fake.Listen([]int{8443}); err == nil {
+		t.Error("got no error listening twice on a port")
+	}
+
+	_ = fake.Close()
+	if _, err := listeners[0].Accept(); err == nil {
+		t.Error("got no error accepting on a closed listener")
+	}
+	if _, _, _, err := fake.Listen([]int{9443}); err == nil {
+		t.Error("got no error listening on a closed fake")
+	}
+}
@@ -0,0 +1,103 @@
+package tailnettest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexhokl/privateserver/server"
+)
+
+func TestTailnet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts tsnet nodes")
+	}
+	tailnet := NewTailnet(t)
+	srv := tailnet.NewServer(tailnet.Config("web"))
+	if got, want := srv.FQDN(), "web"; got != want {
+		t.Errorf("got FQDN %q; want %q", got, want)
+	}
+	srv.HandleFunc("GET /whoami", nil, func(w http.ResponseWriter, r *http.Request) {
+		who, err := srv.GetCallerIdentity(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(w, who.LoginName+" "+who.NodeName)
+	})
+	listeners, nonHTTPSListener, nonHTTPSHandler, err := srv.Listen([]int{443})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = http.Serve(listeners[0], srv.Handler()) }()
+	go func() { _ = http.Serve(nonHTTPSListener, nonHTTPSHandler) }()
+
+	peer := tailnet.NewPeer("laptop")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	localClient, err := peer.LocalClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := localClient.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginName := status.User[status.Self.UserID].LoginName
+	client := Client(peer)
+
+	t.Run("whois", func(t *testing.T) {
+		who, err := srv.GetCallerIdentityFromRemoteIPAddress(ctx, status.TailscaleIPs[0].String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if who.UserProfile.LoginName != loginName {
+			t.Errorf("got login name %q; want %q", who.UserProfile.LoginName, loginName)
+		}
+	})
+
+	t.Run("https", func(t *testing.T) {
+		resp, err := client.Get("https://" + srv.FQDN() + "/whoami")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %d with body %q; want %d", resp.StatusCode, body, http.StatusOK)
+		}
+		if want := loginName + " laptop"; string(body) != want {
+			t.Errorf("got body %q; want %q", body, want)
+		}
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		resp, err := client.Get("http://" + srv.FQDN() + "/whoami?verbose=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("got %d; want %d", resp.StatusCode, http.StatusFound)
+		}
+		if got, want := resp.Header.Get("Location"), "https://"+srv.FQDN()+"/whoami?verbose=1"; got != want {
+			t.Errorf("got location %q; want %q", got, want)
+		}
+	})
+
+	t.Run("policy", func(t *testing.T) {
+		srv.HandleFunc("GET /admin", &server.Policy{Allow: []server.Rule{{LoginNames: []string{"admin@example.com"}}}}, func(w http.ResponseWriter, r *http.Request) {})
+		resp, err := client.Get("https://" + srv.FQDN() + "/admin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("got %d with body %q; want %d", resp.StatusCode, strings.TrimSpace(string(body)), http.StatusForbidden)
+		}
+	})
+}
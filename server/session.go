@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultSessionCookieName is the name of the session cookie unless
+// SessionManager.CookieName is set.
+const DefaultSessionCookieName = "privateserver_session"
+
+// DefaultSessionMaxAge is the lifetime of sessions unless
+// SessionManager.MaxAge is set.
+const DefaultSessionMaxAge = 12 * time.Hour
+
+// minSessionKeySize is the minimum size of the key signing session cookies.
+const minSessionKeySize = 32
+
+// ErrInvalidSession is returned when a session cookie is missing, malformed,
+// tampered with or expired.
+var ErrInvalidSession = errors.New("invalid session")
+
+// sessionContextKey is the context key of the session stored by
+// SessionManager.WithSession.
+type sessionContextKey struct{}
+
+// Session is the state of a user kept in a signed cookie. It is bound to the
+// tailnet identity of the user it was created for.
+type Session struct {
+	LoginName string            `json:"login"`
+	NodeName  string            `json:"node"`
+	CSRFToken string            `json:"csrf"`
+	Expires   time.Time         `json:"exp"`
+	Values    map[string]string `json:"values,omitempty"`
+}
+
+// SessionManager creates and verifies sessions stored in cookies signed with
+// HMAC-SHA256. Cookies are signed but not encrypted so values must not be
+// secrets. Servers sharing the key, such as a backend behind a reverse
+// proxy, can read the sessions.
+type SessionManager struct {
+	key []byte
+
+	// CookieName is the name of the session cookie. It defaults to
+	// DefaultSessionCookieName.
+	CookieName string
+
+	// MaxAge is the lifetime of new sessions. It defaults to
+	// DefaultSessionMaxAge.
+	MaxAge time.Duration
+}
+
+// NewSessionManager creates a SessionManager signing cookies with the
+// specified key of at least 32 bytes.
+func NewSessionManager(key []byte) (*SessionManager, error) {
+	if len(key) < minSessionKeySize {
+		return nil, fmt.Errorf("session key must be at least %d bytes", minSessionKeySize)
+	}
+	return &SessionManager{key: key}, nil
+}
+
+// WithSession wraps the provided handler and stores the session of the
+// caller in the request context. A new session is created and its cookie is
+// set if the caller has no valid session or the session belongs to another
+// user. The handler has to be wrapped by Server.WithIdentity as well.
+func (m *SessionManager) WithSession(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who, found := IdentityFromContext(r.Context())
+		if !found || who.UserProfile == nil || who.Node == nil {
+			authDenied(r, "session without caller identity")
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		session, err := m.Session(r)
+		if err != nil || !strings.EqualFold(session.LoginName, who.UserProfile.LoginName) {
+			session, err = m.newSession(who.UserProfile.LoginName, strings.TrimSuffix(who.Node.Name, "."))
+			if err != nil {
+				logf(slog.LevelError, "failed to create session: %v", err)
+				http.Error(w, "failed to create session", http.StatusInternalServerError)
+				return
+			}
+			if err := m.Save(w, session); err != nil {
+				logf(slog.LevelError, "failed to save session: %v", err)
+				http.Error(w, "failed to save session", http.StatusInternalServerError)
+				return
+			}
+		}
+		ctx := context.WithValue(r.Context(), sessionContextKey{}, session)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Session returns the verified session in the cookie of the request.
+func (m *SessionManager) Session(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(m.cookieName())
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	payload, signature, found := strings.Cut(cookie.Value, ".")
+	if !found {
+		return nil, ErrInvalidSession
+	}
+	gotSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(gotSignature, m.sign(payload)) {
+		return nil, ErrInvalidSession
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	session := new(Session)
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, ErrInvalidSession
+	}
+	if !time.Now().Before(session.Expires) {
+		return nil, ErrInvalidSession
+	}
+	return session, nil
+}
+
+// Save signs the session and sets it as the session cookie of the response.
+// It has to be called before the response is written.
+func (m *SessionManager) Save(w http.ResponseWriter, session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName(),
+		Value:    payload + "." + base64.RawURLEncoding.EncodeToString(m.sign(payload)),
+		Path:     "/",
+		Expires:  session.Expires,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// SessionFromContext returns the session stored in ctx by WithSession.
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	session, ok := ctx.Value(sessionContextKey{}).(*Session)
+	return session, ok
+}
+
+func (m *SessionManager) newSession(loginName, nodeName string) (*Session, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate CSRF token: %w", err)
+	}
+	maxAge := m.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultSessionMaxAge
+	}
+	return &Session{
+		LoginName: loginName,
+		NodeName:  nodeName,
+		CSRFToken: base64.RawURLEncoding.EncodeToString(token),
+		Expires:   time.Now().Add(maxAge),
+	}, nil
+}
+
+func (m *SessionManager) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func (m *SessionManager) cookieName() string {
+	if m.CookieName == "" {
+		return DefaultSessionCookieName
+	}
+	return m.CookieName
+}
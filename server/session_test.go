@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessionManager(t *testing.T) {
+	if _, err := NewSessionManager([]byte("short")); err == nil {
+		t.Error("short key is accepted")
+	}
+	manager, err := NewSessionManager([]byte(strings.Repeat("k", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var session *Session
+	h := manager.WithSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var found bool
+		session, found = SessionFromContext(r.Context())
+		if !found {
+			t.Fatal("session is not found in context")
+		}
+	}))
+	serve := func(loginName string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		r = r.WithContext(ContextWithIdentity(r.Context(), newTestWhoIs(loginName)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("alice@example.com", nil)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies; want 1", len(cookies))
+	}
+	first := session
+	if first.LoginName != "alice@example.com" || first.CSRFToken == "" {
+		t.Errorf("got session %+v", first)
+	}
+
+	t.Run("existing session", func(t *testing.T) {
+		w := serve("alice@example.com", cookies)
+		if len(w.Result().Cookies()) != 0 {
+			t.Error("session cookie is set again")
+		}
+		if session.CSRFToken != first.CSRFToken {
+			t.Error("session is not reused")
+		}
+	})
+
+	t.Run("session of another user", func(t *testing.T) {
+		w := serve("bob@example.com", cookies)
+		if len(w.Result().Cookies()) != 1 {
+			t.Error("session cookie is not replaced")
+		}
+		if session.LoginName != "bob@example.com" {
+			t.Errorf("got login %q; want %q", session.LoginName, "bob@example.com")
+		}
+	})
+
+	t.Run("tampered session", func(t *testing.T) {
+		tampered := *cookies[0]
+		tampered.Value = "x" + tampered.Value
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&tampered)
+		if _, err := manager.Session(r); !errors.Is(err, ErrInvalidSession) {
+			t.Errorf("got error %v; want %v", err, ErrInvalidSession)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// serveSFTP serves the SFTP protocol over channel confining the caller with
+// the specified login name to its directory in directory.
+func serveSFTP(channel io.ReadWriteCloser, directory, loginName string) error {
+	userDir, err := loginDirectory(loginName)
+	if err != nil {
+		return err
+	}
+	parent, err := os.OpenRoot(directory)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = parent.Close() }()
+	if err := parent.MkdirAll(userDir, 0o750); err != nil {
+		return err
+	}
+	root, err := parent.OpenRoot(userDir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = root.Close() }()
+
+	handler := &sftpHandler{root: root}
+	server := sftp.NewRequestServer(channel, sftp.Handlers{
+		FileGet:  handler,
+		FilePut:  handler,
+		FileCmd:  handler,
+		FileList: handler,
+	})
+	defer func() { _ = server.Close() }()
+	if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// sftpHandler serves SFTP requests from the files in root. Paths of requests
+// are resolved within root so that neither ".." nor symbolic links can reach
+// files outside it.
+type sftpHandler struct {
+	root *os.Root
+}
+
+// sftpPath returns the path of a request relative to the root.
+func sftpPath(name string) string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "."
+	}
+	return name[1:]
+}
+
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	return h.root.Open(sftpPath(r.Filepath))
+}
+
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	// O_APPEND is never set as os.File does not support WriteAt with it and
+	// clients send the offset of every write anyway.
+	flags := r.Pflags()
+	mode := os.O_WRONLY
+	if flags.Read {
+		mode = os.O_RDWR
+	}
+	if flags.Creat {
+		mode |= os.O_CREATE
+	}
+	if flags.Trunc {
+		mode |= os.O_TRUNC
+	}
+	if flags.Excl {
+		mode |= os.O_EXCL
+	}
+	return h.root.OpenFile(sftpPath(r.Filepath), mode, 0o640)
+}
+
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	name := sftpPath(r.Filepath)
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(name, r)
+	case "Rename":
+		return h.root.Rename(name, sftpPath(r.Target))
+	case "Rmdir", "Remove":
+		return h.root.Remove(name)
+	case "Mkdir":
+		return h.root.Mkdir(name, 0o750)
+	default:
+		// Links are not supported so that callers cannot create links
+		// which may be followed by other programs outside the root.
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// setstat changes the size, permissions and times of a file. Changes of
+// ownership are ignored as files are owned by the user running the server.
+func (h *sftpHandler) setstat(name string, r *sftp.Request) error {
+	flags := r.AttrFlags()
+	attributes := r.Attributes()
+	if flags.Size {
+		file, err := h.root.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		err = file.Truncate(int64(attributes.Size))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := h.root.Chmod(name, attributes.FileMode().Perm()); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		atime := time.Unix(int64(attributes.Atime), 0)
+		mtime := time.Unix(int64(attributes.Mtime), 0)
+		if err := h.root.Chtimes(name, atime, mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	name := sftpPath(r.Filepath)
+	switch r.Method {
+	case "List":
+		entries, err := fs.ReadDir(h.root.FS(), name)
+		if err != nil {
+			return nil, err
+		}
+		infos := make([]fs.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			infos = append(infos, info)
+		}
+		return sftpLister(infos), nil
+	case "Stat":
+		info, err := h.root.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		return sftpLister{info}, nil
+	default:
+		// Link targets are not revealed as they may be paths outside the
+		// root.
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+func (h *sftpHandler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	info, err := h.root.Lstat(sftpPath(r.Filepath))
+	if err != nil {
+		return nil, err
+	}
+	return sftpLister{info}, nil
+}
+
+// sftpLister lists a fixed set of files.
+type sftpLister []fs.FileInfo
+
+func (l sftpLister) ListAt(infos []fs.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestServeSSHSFTPDirectory(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	directory := t.TempDir()
+	if err := os.WriteFile(filepath.Join(directory, "secret.txt"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := &SSHConfig{
+		Rules:         []SSHRule{{Users: []string{current.Username}}},
+		SFTPDirectory: directory,
+	}
+	addr := startTestSSHServer(t, config, func(context.Context, string) (*apitype.WhoIsResponse, error) {
+		return newTestWhoIs("alice@example.com"), nil
+	})
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{User: current.Username, HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		name     string
+		remote   string
+		wantPath string
+	}{
+		{name: "file", remote: "notes.txt", wantPath: filepath.Join(directory, "alice@example.com", "notes.txt")},
+		{name: "parent directory", remote: "../../notes-2.txt", wantPath: filepath.Join(directory, "alice@example.com", "notes-2.txt")},
+		{name: "absolute path", remote: "/notes-3.txt", wantPath: filepath.Join(directory, "alice@example.com", "notes-3.txt")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := client.Create(test.remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := file.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(test.wantPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != "hello" {
+				t.Errorf("got content %q, want %q", content, "hello")
+			}
+			file, err = client.Open(test.remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			read, err := io.ReadAll(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(read) != "hello" {
+				t.Errorf("got content %q, want %q", read, "hello")
+			}
+		})
+	}
+
+	if _, err := client.Open("../secret.txt"); err == nil {
+		t.Error("file outside of the directory of the caller is readable")
+	}
+	if err := client.Symlink("/", "root"); err == nil {
+		t.Error("symbolic link is created")
+	}
+	if err := client.Mkdir("docs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Rename("notes.txt", "docs/notes.txt"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := client.ReadDir("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "notes.txt" {
+		t.Errorf("got entries %v, want notes.txt", entries)
+	}
+	if err := client.Remove("docs/notes.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RemoveDirectory("docs"); err != nil {
+		t.Fatal(err)
+	}
+}
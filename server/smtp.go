@@ -0,0 +1,435 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultSMTPPort is the port of the tailnet the SMTP receiver listens
+	// on if SMTPConfig.Port is not set.
+	DefaultSMTPPort = 25
+
+	// DefaultSMTPMaxMessageSize is the maximum size of a message if
+	// SMTPConfig.MaxMessageSize is not set.
+	DefaultSMTPMaxMessageSize = 10 << 20
+
+	// smtpMaxRecipients is the maximum number of recipients of a message.
+	smtpMaxRecipients = 100
+
+	// smtpMaxCommandLength is the maximum length of a command line including
+	// its CRLF, as specified by RFC 5321.
+	smtpMaxCommandLength = 512
+
+	// smtpCommandTimeout is the time allowed for a client to send a command
+	// or the content of a message.
+	smtpCommandTimeout = 5 * time.Minute
+)
+
+// SMTPMessage is a message received by the SMTP receiver.
+type SMTPMessage struct {
+	// Caller is the tailnet identity of the node which sent the message.
+	Caller *CallerIdentity
+
+	// From and To are the envelope sender and recipients.
+	From string
+	To   []string
+
+	// Header and Body are the parsed message.
+	Header mail.Header
+	Body   []byte
+
+	// Raw is the message as received with lines ending with "\n", prefixed
+	// with a Received header.
+	Raw []byte
+}
+
+// SMTPConfig configures the SMTP receiver started by ServeSMTP.
+type SMTPConfig struct {
+	// Port is the port of the tailnet to listen on. It defaults to
+	// DefaultSMTPPort.
+	Port int
+
+	// Policy, if set, authorizes the nodes which may send messages.
+	// Otherwise, every node of the tailnet may send messages.
+	Policy *Policy
+
+	// MaxMessageSize is the maximum size of a message in bytes. It defaults
+	// to DefaultSMTPMaxMessageSize.
+	MaxMessageSize int64
+
+	// Handle is called with every received message. Returning an error
+	// rejects the message with a temporary failure so that the sender
+	// retries it.
+	Handle func(ctx context.Context, message *SMTPMessage) error
+
+	// Maildir is the path of a Maildir every received message is delivered
+	// to. Its tmp, new and cur directories are created if they do not exist.
+	// Messages are delivered before Handle is called if both are set.
+	Maildir string
+}
+
+// ServeSMTP listens on the configured port of the tailnet and receives mail
+// from tailnet nodes until the server is closed, so that internal tools and
+// devices can send alerts without a public mail setup. Connections from
+// outside the tailnet are rejected. As WireGuard encrypts the traffic
+// already, neither STARTTLS nor authentication is offered.
+func (s *Server) ServeSMTP(config *SMTPConfig) error {
+	if err := validateSMTPConfig(config); err != nil {
+		return err
+	}
+	port := config.Port
+	if port == 0 {
+		port = DefaultSMTPPort
+	}
+	listener, err := s.listen(fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port [%d]: %w", port, err)
+	}
+	return serveSMTP(listener, config, s.FQDN(), s.whoIs, s.logger)
+}
+
+func validateSMTPConfig(config *SMTPConfig) error {
+	if config == nil {
+		return fmt.Errorf("SMTP configuration is required")
+	}
+	if config.Port < 0 || config.Port > 65535 {
+		return fmt.Errorf("invalid SMTP port [%d]: port must be between 1 and 65535", config.Port)
+	}
+	if config.MaxMessageSize < 0 {
+		return fmt.Errorf("maximum SMTP message size must not be negative")
+	}
+	if config.Handle == nil && config.Maildir == "" {
+		return fmt.Errorf("SMTP handle function or maildir is required")
+	}
+	if config.Maildir != "" {
+		for _, dir := range []string{"tmp", "new", "cur"} {
+			if err := os.MkdirAll(filepath.Join(config.Maildir, dir), 0o750); err != nil {
+				return fmt.Errorf("failed to create maildir [%s]: %w", config.Maildir, err)
+			}
+		}
+	}
+	return nil
+}
+
+// serveSMTP accepts connections from listener until it is closed.
+func serveSMTP(listener net.Listener, config *SMTPConfig, hostname string, whoIs whoIsFunc, logger *logger) error {
+	defer func() { _ = listener.Close() }()
+	maxMessageSize := config.MaxMessageSize
+	if maxMessageSize == 0 {
+		maxMessageSize = DefaultSMTPMaxMessageSize
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		session := &smtpSession{
+			conn:           conn,
+			text:           textproto.NewConn(conn),
+			config:         config,
+			hostname:       hostname,
+			maxMessageSize: maxMessageSize,
+			logger:         logger,
+		}
+		go session.serve(whoIs)
+	}
+}
+
+// smtpSession is a connection of an SMTP client.
+type smtpSession struct {
+	conn           net.Conn
+	text           *textproto.Conn
+	config         *SMTPConfig
+	hostname       string
+	maxMessageSize int64
+	logger         *logger
+
+	caller *CallerIdentity
+	helo   string
+	from   string
+	to     []string
+	inMail bool
+}
+
+func (s *smtpSession) serve(whoIs whoIsFunc) {
+	defer func() { _ = s.text.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), forwardDialTimeout)
+	who, err := whoIs(ctx, s.conn.RemoteAddr().String())
+	cancel()
+	if err != nil {
+		s.logger.logf(slog.LevelWarn, "rejecting SMTP connection from [%s]: %v", s.conn.RemoteAddr(), err)
+		s.replyFinal(554, "5.7.1 Access denied")
+		return
+	}
+	if s.config.Policy != nil && !s.config.Policy.Allowed(who) {
+		s.logger.logf(slog.LevelWarn, "rejecting SMTP connection from [%s]: caller is not authorized", s.conn.RemoteAddr())
+		s.replyFinal(554, "5.7.1 Access denied")
+		return
+	}
+	s.caller = NewCallerIdentity(who)
+	if err := s.reply(220, "%s ESMTP ready", s.hostname); err != nil {
+		return
+	}
+	for {
+		if err := s.conn.SetReadDeadline(time.Now().Add(smtpCommandTimeout)); err != nil {
+			s.logger.logf(slog.LevelWarn, "failed to set SMTP read deadline of [%s]: %v", s.conn.RemoteAddr(), err)
+			return
+		}
+		line, err := s.readCommand()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		if !s.handle(strings.ToUpper(verb), strings.TrimSpace(arg)) {
+			return
+		}
+	}
+}
+
+// handle answers a command and reports whether the session continues.
+func (s *smtpSession) handle(verb, arg string) bool {
+	var err error
+	switch verb {
+	case "HELO":
+		s.helo = arg
+		s.reset()
+		err = s.reply(250, "%s", s.hostname)
+	case "EHLO":
+		s.helo = arg
+		s.reset()
+		err = s.reply(250, "%s\n8BITMIME\nSIZE %d", s.hostname, s.maxMessageSize)
+	case "MAIL":
+		err = s.mail(arg)
+	case "RCPT":
+		err = s.rcpt(arg)
+	case "DATA":
+		err = s.data()
+	case "RSET":
+		s.reset()
+		err = s.reply(250, "2.0.0 OK")
+	case "NOOP":
+		err = s.reply(250, "2.0.0 OK")
+	case "VRFY":
+		err = s.reply(252, "2.5.0 Cannot verify user")
+	case "QUIT":
+		s.replyFinal(221, "2.0.0 Bye")
+		return false
+	default:
+		err = s.reply(502, "5.5.2 Command not recognized")
+	}
+	return err == nil
+}
+
+func (s *smtpSession) mail(arg string) error {
+	if s.helo == "" {
+		return s.reply(503, "5.5.1 Send HELO or EHLO first")
+	}
+	if s.inMail {
+		return s.reply(503, "5.5.1 Sender already specified")
+	}
+	from, params, ok := smtpPath(arg, "FROM:")
+	if !ok {
+		return s.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+	}
+	for _, param := range strings.Fields(params) {
+		key, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(key, "SIZE") {
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil && size > s.maxMessageSize {
+				return s.reply(552, "5.3.4 Message is larger than %d bytes", s.maxMessageSize)
+			}
+		}
+	}
+	s.from = from
+	s.inMail = true
+	return s.reply(250, "2.1.0 OK")
+}
+
+func (s *smtpSession) rcpt(arg string) error {
+	if !s.inMail {
+		return s.reply(503, "5.5.1 Send MAIL first")
+	}
+	to, _, ok := smtpPath(arg, "TO:")
+	if !ok || to == "" {
+		return s.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+	}
+	if len(s.to) >= smtpMaxRecipients {
+		return s.reply(452, "4.5.3 Too many recipients")
+	}
+	s.to = append(s.to, to)
+	return s.reply(250, "2.1.5 OK")
+}
+
+func (s *smtpSession) data() error {
+	if len(s.to) == 0 {
+		return s.reply(503, "5.5.1 Send RCPT first")
+	}
+	if err := s.reply(354, "Send message, end with <CRLF>.<CRLF>"); err != nil {
+		return err
+	}
+	if err := s.conn.SetReadDeadline(time.Now().Add(smtpCommandTimeout)); err != nil {
+		return fmt.Errorf("failed to set read deadline: %w", err)
+	}
+	reader := s.text.DotReader()
+	content, err := io.ReadAll(io.LimitReader(reader, s.maxMessageSize+1))
+	if err != nil {
+		return err
+	}
+	defer s.reset()
+	if int64(len(content)) > s.maxMessageSize {
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			return err
+		}
+		return s.reply(552, "5.3.4 Message is larger than %d bytes", s.maxMessageSize)
+	}
+	message, err := s.message(content)
+	if err != nil {
+		return s.reply(554, "5.6.0 Invalid message: %v", err)
+	}
+	if err := s.deliver(message); err != nil {
+		s.logger.logf(slog.LevelError, "failed to deliver SMTP message from [%s] on [%s]: %v", message.From, s.caller.NodeName, err)
+		return s.reply(451, "4.3.0 Failed to deliver message")
+	}
+	s.logger.logf(slog.LevelInfo, "received SMTP message from [%s] on [%s] to %v", message.From, s.caller.NodeName, message.To)
+	return s.reply(250, "2.0.0 OK")
+}
+
+// message parses content, whose lines end with "\n" as read by DotReader,
+// and prefixes it with a Received header.
+func (s *smtpSession) message(content []byte) (*SMTPMessage, error) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(parsed.Body)
+	if err != nil {
+		return nil, err
+	}
+	var raw bytes.Buffer
+	fmt.Fprintf(&raw, "Received: from %s (%s)\n\tby %s; %s\n",
+		s.helo, s.caller.NodeName, s.hostname, time.Now().Format(time.RFC1123Z))
+	raw.Write(content)
+	return &SMTPMessage{
+		Caller: s.caller,
+		From:   s.from,
+		To:     append([]string(nil), s.to...),
+		Header: parsed.Header,
+		Body:   body,
+		Raw:    raw.Bytes(),
+	}, nil
+}
+
+func (s *smtpSession) deliver(message *SMTPMessage) error {
+	if s.config.Maildir != "" {
+		if err := deliverToMaildir(s.config.Maildir, message.Raw); err != nil {
+			return err
+		}
+	}
+	if s.config.Handle != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), smtpCommandTimeout)
+		defer cancel()
+		return s.config.Handle(ctx, message)
+	}
+	return nil
+}
+
+func (s *smtpSession) reset() {
+	s.from = ""
+	s.to = nil
+	s.inMail = false
+}
+
+// readCommand reads a command line without its CRLF. A line longer than
+// smtpMaxCommandLength is answered with an error and ends the session, so
+// that clients cannot make the server buffer arbitrarily long lines.
+func (s *smtpSession) readCommand() (string, error) {
+	line, err := s.text.R.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) || len(line) > smtpMaxCommandLength {
+		s.replyFinal(500, "5.5.2 Line too long")
+		return "", fmt.Errorf("command line is longer than %d bytes", smtpMaxCommandLength)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// replyFinal writes the last reply of a session, whose failure only needs
+// to be logged as the session ends anyway.
+func (s *smtpSession) replyFinal(code int, format string, args ...any) {
+	if err := s.reply(code, format, args...); err != nil {
+		s.logger.logf(slog.LevelDebug, "failed to send SMTP reply to [%s]: %v", s.conn.RemoteAddr(), err)
+	}
+}
+
+// reply writes a reply with code. Lines of a multiline reply are separated by
+// "\n" in format.
+func (s *smtpSession) reply(code int, format string, args ...any) error {
+	lines := strings.Split(fmt.Sprintf(format, args...), "\n")
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		if err := s.text.PrintfLine("%d%s%s", code, separator, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// smtpPath parses the argument of MAIL and RCPT, such as
+// "FROM:<alice@example.com> SIZE=1024", and returns the address and the
+// parameters following it.
+func smtpPath(arg, prefix string) (string, string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", "", false
+	}
+	address, params, found := strings.Cut(arg[1:], ">")
+	if !found {
+		return "", "", false
+	}
+	return address, strings.TrimSpace(params), true
+}
+
+// maildirCounter makes the names of messages delivered within the same
+// nanosecond unique.
+var maildirCounter atomic.Uint64
+
+// deliverToMaildir writes content to the tmp directory of the Maildir and
+// moves it to the new directory once it is complete.
+func deliverToMaildir(dir string, content []byte) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	hostname = strings.NewReplacer("/", "\\057", ":", "\\072").Replace(hostname)
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), maildirCounter.Add(1), hostname)
+	temp := filepath.Join(dir, "tmp", name)
+	if err := os.WriteFile(temp, content, 0o640); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, filepath.Join(dir, "new", name)); err != nil {
+		return errors.Join(err, os.Remove(temp))
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func startTestSMTPServer(t *testing.T, config *SMTPConfig, who *apitype.WhoIsResponse) string {
+	t.Helper()
+	if err := validateSMTPConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	whoIs := func(context.Context, string) (*apitype.WhoIsResponse, error) {
+		return who, nil
+	}
+	go serveSMTP(listener, config, "mail.prawn-universe.ts.net", whoIs, newLogger(t.Logf, slog.LevelInfo))
+	return listener.Addr().String()
+}
+
+func TestServeSMTP(t *testing.T) {
+	messages := make(chan *SMTPMessage, 1)
+	maildir := t.TempDir()
+	config := &SMTPConfig{
+		Policy:  &Policy{Allow: []Rule{{LoginNames: []string{"alice@example.com"}}}},
+		Maildir: maildir,
+		Handle: func(_ context.Context, message *SMTPMessage) error {
+			messages <- message
+			return nil
+		},
+	}
+	content := "From: nas@example.com\r\nSubject: Disk is full\r\n\r\nVolume 1 is 95% full.\r\n"
+
+	addr := startTestSMTPServer(t, config, newTestWhoIs("alice@example.com"))
+	if err := smtp.SendMail(addr, nil, "nas@example.com", []string{"ops@example.com", "alice@example.com"}, []byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	message := <-messages
+	if message.From != "nas@example.com" ||
+		strings.Join(message.To, ",") != "ops@example.com,alice@example.com" ||
+		message.Header.Get("Subject") != "Disk is full" ||
+		string(message.Body) != "Volume 1 is 95% full.\n" ||
+		message.Caller.LoginName != "alice@example.com" {
+		t.Errorf("got message %+v", message)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(maildir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d messages in maildir; want 1", len(entries))
+	}
+	stored, err := os.ReadFile(filepath.Join(maildir, "new", entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(stored), "Received: from ") || !strings.HasSuffix(string(stored), strings.ReplaceAll(content, "\r\n", "\n")) {
+		t.Errorf("got stored message %q", stored)
+	}
+
+	denied := startTestSMTPServer(t, config, newTestWhoIs("bob@example.com"))
+	if err := smtp.SendMail(denied, nil, "nas@example.com", []string{"ops@example.com"}, []byte(content)); err == nil {
+		t.Error("message from unauthorized caller is accepted")
+	}
+}
+
+func TestServeSMTPMessageSize(t *testing.T) {
+	config := &SMTPConfig{
+		MaxMessageSize: 64,
+		Handle: func(context.Context, *SMTPMessage) error {
+			t.Error("message larger than the maximum size is handled")
+			return nil
+		},
+	}
+	addr := startTestSMTPServer(t, config, newTestWhoIs("alice@example.com"))
+	content := "Subject: Large\r\n\r\n" + strings.Repeat("x", 128) + "\r\n"
+	err := smtp.SendMail(addr, nil, "nas@example.com", []string{"ops@example.com"}, []byte(content))
+	if err == nil || !strings.Contains(err.Error(), "552") {
+		t.Errorf("got error %v; want 552", err)
+	}
+}
+
+func TestServeSMTPCommandLength(t *testing.T) {
+	config := &SMTPConfig{Handle: func(context.Context, *SMTPMessage) error { return nil }}
+	addr := startTestSMTPServer(t, config, newTestWhoIs("alice@example.com"))
+	conn, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.PrintfLine("HELO %s", strings.Repeat("x", 8192)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadResponse(250); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("got error %v; want 500", err)
+	}
+	if _, err := conn.ReadLine(); err == nil {
+		t.Error("session continues after a command line which is too long")
+	}
+}
+
+func TestSMTPPath(t *testing.T) {
+	tests := []struct {
+		arg        string
+		wantAddr   string
+		wantParams string
+		wantOK     bool
+	}{
+		{arg: "FROM:<alice@example.com>", wantAddr: "alice@example.com", wantOK: true},
+		{arg: "from: <alice@example.com> SIZE=1024", wantAddr: "alice@example.com", wantParams: "SIZE=1024", wantOK: true},
+		{arg: "FROM:<>", wantOK: true},
+		{arg: "FROM:alice@example.com"},
+		{arg: "TO:<alice@example.com>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			addr, params, ok := smtpPath(tt.arg, "FROM:")
+			if addr != tt.wantAddr || params != tt.wantParams || ok != tt.wantOK {
+				t.Errorf("smtpPath() = %q, %q, %t", addr, params, ok)
+			}
+		})
+	}
+}
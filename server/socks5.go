@@ -0,0 +1,372 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// DefaultSOCKS5Port is the port ServeSOCKS5 listens on if SOCKS5Config.Port
+// is not set.
+const DefaultSOCKS5Port = 1080
+
+// socks5HandshakeTimeout is the time allowed for a client to send its
+// greeting and request.
+const socks5HandshakeTimeout = 30 * time.Second
+
+const (
+	socks5Version = 0x05
+
+	socks5MethodNoAuth       = 0x00
+	socks5MethodNoAcceptable = 0xff
+
+	socks5CommandConnect = 0x01
+
+	socks5AddressIPv4   = 0x01
+	socks5AddressDomain = 0x03
+	socks5AddressIPv6   = 0x04
+
+	socks5ReplySucceeded               = 0x00
+	socks5ReplyGeneralFailure          = 0x01
+	socks5ReplyNotAllowed              = 0x02
+	socks5ReplyHostUnreachable         = 0x04
+	socks5ReplyConnectionRefused       = 0x05
+	socks5ReplyCommandNotSupported     = 0x07
+	socks5ReplyAddressTypeNotSupported = 0x08
+)
+
+// SOCKS5Rule allows tailnet callers to connect to destinations.
+type SOCKS5Rule struct {
+	// Callers matches the callers the rule applies to.
+	Callers Rule
+
+	// Prefixes are the IP address ranges callers may connect to. Host names
+	// requested by clients are resolved by the server and matched by their
+	// addresses.
+	Prefixes []netip.Prefix
+
+	// Hosts are the host names callers may connect to regardless of their
+	// addresses. A pattern may start with "*." to match any subdomain, such
+	// as "*.lab.example.com".
+	Hosts []string
+
+	// Ports are the ports callers may connect to. Any port is allowed if it
+	// is empty.
+	Ports []int
+}
+
+// SOCKS5Config configures the SOCKS5 proxy started by ServeSOCKS5.
+type SOCKS5Config struct {
+	// Port is the tailnet port to listen on. It defaults to
+	// DefaultSOCKS5Port.
+	Port int
+
+	// Rules decide which destinations a caller may connect to. Requests
+	// matching no rule are refused.
+	Rules []SOCKS5Rule
+}
+
+// ServeSOCKS5 listens on the tailnet and serves a SOCKS5 proxy, which
+// connects to destinations from the network of this node, until the server is
+// closed. Callers are authenticated by their tailnet identity so clients use
+// no authentication method. Only the CONNECT command is supported.
+func (s *Server) ServeSOCKS5(config *SOCKS5Config) error {
+	if err := validateSOCKS5Config(config); err != nil {
+		return err
+	}
+	port := config.Port
+	if port == 0 {
+		port = DefaultSOCKS5Port
+	}
+	listener, err := s.listen(fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port [%d]: %w", port, err)
+	}
+	return serveSOCKS5(listener, config, s.whoIs, net.DefaultResolver, s.logger)
+}
+
+func validateSOCKS5Config(config *SOCKS5Config) error {
+	if config == nil {
+		return fmt.Errorf("SOCKS5 configuration is required")
+	}
+	if config.Port < 0 || config.Port > 65535 {
+		return fmt.Errorf("invalid SOCKS5 port [%d]: port must be between 1 and 65535", config.Port)
+	}
+	if len(config.Rules) == 0 {
+		return fmt.Errorf("at least one SOCKS5 rule is required")
+	}
+	for i, rule := range config.Rules {
+		if len(rule.Prefixes) == 0 && len(rule.Hosts) == 0 {
+			return fmt.Errorf("SOCKS5 rule [%d] has no prefixes or hosts", i)
+		}
+	}
+	return nil
+}
+
+// socks5Resolver resolves host names requested by clients.
+type socks5Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// serveSOCKS5 accepts connections from listener until it is closed.
+func serveSOCKS5(listener net.Listener, config *SOCKS5Config, whoIs whoIsFunc, resolver socks5Resolver, logger *logger) error {
+	defer func() { _ = listener.Close() }()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go handleSOCKS5Connection(conn, config, whoIs, resolver, logger)
+	}
+}
+
+func handleSOCKS5Connection(conn net.Conn, config *SOCKS5Config, whoIs whoIsFunc, resolver socks5Resolver, logger *logger) {
+	defer func() { _ = conn.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), socks5HandshakeTimeout)
+	defer cancel()
+	if err := conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout)); err != nil {
+		logger.logf(slog.LevelWarn, "failed to set SOCKS5 handshake deadline of [%s]: %v", conn.RemoteAddr(), err)
+		return
+	}
+	refuse := func(reply byte) {
+		if err := socks5WriteReply(conn, reply, nil); err != nil {
+			logger.logf(slog.LevelDebug, "failed to send SOCKS5 reply to [%s]: %v", conn.RemoteAddr(), err)
+		}
+	}
+
+	who, err := whoIs(ctx, conn.RemoteAddr().String())
+	if err != nil {
+		logger.logf(slog.LevelWarn, "rejecting SOCKS5 connection from [%s]: %v", conn.RemoteAddr(), err)
+		return
+	}
+	reader := bufio.NewReader(conn)
+	if err := socks5Negotiate(reader, conn); err != nil {
+		logger.logf(slog.LevelDebug, "SOCKS5 negotiation with [%s] failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	host, port, reply, err := socks5ReadRequest(reader)
+	if err != nil {
+		logger.logf(slog.LevelDebug, "invalid SOCKS5 request from [%s]: %v", conn.RemoteAddr(), err)
+		if reply != socks5ReplySucceeded {
+			refuse(reply)
+		}
+		return
+	}
+	addresses, reply := socks5Authorize(ctx, config.Rules, who, host, port, resolver)
+	if reply != socks5ReplySucceeded {
+		logger.logf(slog.LevelWarn, "refusing SOCKS5 connection from [%s] to [%s]", conn.RemoteAddr(), net.JoinHostPort(host, strconv.Itoa(port)))
+		refuse(reply)
+		return
+	}
+	upstream, err := socks5Dial(ctx, addresses, port)
+	if err != nil {
+		logger.logf(slog.LevelWarn, "failed to connect to [%s]: %v", net.JoinHostPort(host, strconv.Itoa(port)), err)
+		refuse(socks5DialReply(err))
+		return
+	}
+	defer func() { _ = upstream.Close() }()
+	if err := socks5WriteReply(conn, socks5ReplySucceeded, upstream.LocalAddr()); err != nil {
+		logger.logf(slog.LevelDebug, "failed to send SOCKS5 reply to [%s]: %v", conn.RemoteAddr(), err)
+		return
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		logger.logf(slog.LevelWarn, "failed to clear SOCKS5 deadline of [%s]: %v", conn.RemoteAddr(), err)
+		return
+	}
+	if reader.Buffered() > 0 {
+		buffered, _ := reader.Peek(reader.Buffered())
+		if _, err := upstream.Write(buffered); err != nil {
+			return
+		}
+	}
+	pipe(conn, upstream)
+}
+
+// socks5Negotiate reads the greeting of a client and selects the method
+// without authentication.
+func socks5Negotiate(reader *bufio.Reader, w io.Writer) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return err
+	}
+	if header[0] != socks5Version {
+		return fmt.Errorf("unsupported SOCKS version [%d]", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return err
+	}
+	if !slices.Contains(methods, socks5MethodNoAuth) {
+		if _, err := w.Write([]byte{socks5Version, socks5MethodNoAcceptable}); err != nil {
+			return fmt.Errorf("failed to refuse the methods of the client: %w", err)
+		}
+		return fmt.Errorf("client does not offer the method without authentication")
+	}
+	_, err := w.Write([]byte{socks5Version, socks5MethodNoAuth})
+	return err
+}
+
+// socks5ReadRequest reads the request of a client. If an error is returned,
+// the returned reply code is to be sent to the client unless it is
+// socks5ReplySucceeded, which means the request could not be read at all.
+func socks5ReadRequest(reader *bufio.Reader) (string, int, byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", 0, socks5ReplySucceeded, err
+	}
+	if header[0] != socks5Version {
+		return "", 0, socks5ReplyGeneralFailure, fmt.Errorf("unsupported SOCKS version [%d]", header[0])
+	}
+	if header[1] != socks5CommandConnect {
+		return "", 0, socks5ReplyCommandNotSupported, fmt.Errorf("unsupported command [%d]", header[1])
+	}
+	var host string
+	switch header[3] {
+	case socks5AddressIPv4, socks5AddressIPv6:
+		size := net.IPv4len
+		if header[3] == socks5AddressIPv6 {
+			size = net.IPv6len
+		}
+		address := make([]byte, size)
+		if _, err := io.ReadFull(reader, address); err != nil {
+			return "", 0, socks5ReplySucceeded, err
+		}
+		addr, _ := netip.AddrFromSlice(address)
+		host = addr.String()
+	case socks5AddressDomain:
+		size, err := reader.ReadByte()
+		if err != nil {
+			return "", 0, socks5ReplySucceeded, err
+		}
+		domain := make([]byte, size)
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return "", 0, socks5ReplySucceeded, err
+		}
+		host = string(domain)
+	default:
+		return "", 0, socks5ReplyAddressTypeNotSupported, fmt.Errorf("unsupported address type [%d]", header[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return "", 0, socks5ReplySucceeded, err
+	}
+	return host, int(binary.BigEndian.Uint16(port)), socks5ReplySucceeded, nil
+}
+
+// socks5Authorize returns the addresses of host the caller may connect to.
+// Host names are resolved here so that the addresses checked against the
+// rules are the ones dialled.
+func socks5Authorize(ctx context.Context, rules []SOCKS5Rule, who *apitype.WhoIsResponse, host string, port int, resolver socks5Resolver) ([]netip.Addr, byte) {
+	var matching []SOCKS5Rule
+	for _, rule := range rules {
+		if rule.Callers.Matches(who) && (len(rule.Ports) == 0 || slices.Contains(rule.Ports, port)) {
+			matching = append(matching, rule)
+		}
+	}
+	if len(matching) == 0 {
+		return nil, socks5ReplyNotAllowed
+	}
+	var addresses []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addresses = []netip.Addr{addr.Unmap()}
+	} else {
+		resolved, err := resolver.LookupNetIP(ctx, "ip", host)
+		if err != nil || len(resolved) == 0 {
+			return nil, socks5ReplyHostUnreachable
+		}
+		for _, addr := range resolved {
+			addresses = append(addresses, addr.Unmap())
+		}
+		for _, rule := range matching {
+			if matchesHostPattern(rule.Hosts, host) {
+				return addresses, socks5ReplySucceeded
+			}
+		}
+	}
+	var allowed []netip.Addr
+	for _, addr := range addresses {
+		if slices.ContainsFunc(matching, func(rule SOCKS5Rule) bool {
+			return slices.ContainsFunc(rule.Prefixes, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+		}) {
+			allowed = append(allowed, addr)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, socks5ReplyNotAllowed
+	}
+	return allowed, socks5ReplySucceeded
+}
+
+// matchesHostPattern reports whether host matches any of the patterns.
+func matchesHostPattern(patterns []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if suffix, found := strings.CutPrefix(pattern, "*."); found {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if pattern == host {
+			return true
+		}
+	}
+	return false
+}
+
+// socks5Dial connects to the first reachable address.
+func socks5Dial(ctx context.Context, addresses []netip.Addr, port int) (net.Conn, error) {
+	var dialer net.Dialer
+	var errs []error
+	for _, addr := range addresses {
+		conn, err := dialer.DialContext(ctx, Protocol, netip.AddrPortFrom(addr, uint16(port)).String())
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// socks5DialReply returns the reply code describing a dial error.
+func socks5DialReply(err error) byte {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks5ReplyConnectionRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return socks5ReplyHostUnreachable
+	default:
+		return socks5ReplyGeneralFailure
+	}
+}
+
+// socks5WriteReply writes a reply with the bound address.
+func socks5WriteReply(w io.Writer, reply byte, bound net.Addr) error {
+	addrPort := netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+	if tcpAddr, ok := bound.(*net.TCPAddr); ok {
+		addrPort = tcpAddr.AddrPort()
+	}
+	addr := addrPort.Addr().Unmap()
+	message := []byte{socks5Version, reply, 0x00, socks5AddressIPv4}
+	if addr.Is6() {
+		message[3] = socks5AddressIPv6
+	}
+	message = append(message, addr.AsSlice()...)
+	message = binary.BigEndian.AppendUint16(message, addrPort.Port())
+	_, err := w.Write(message)
+	return err
+}
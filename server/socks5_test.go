@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/proxy"
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+type testResolver map[string][]netip.Addr
+
+func (r testResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	addresses, found := r[host]
+	if !found {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addresses, nil
+}
+
+func TestServeSOCKS5(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("hello"))
+			}()
+		}
+	}()
+	upstreamAddr := upstream.Addr().(*net.TCPAddr).AddrPort()
+	resolver := testResolver{
+		"db.lab.example.com": {upstreamAddr.Addr()},
+		"evil.example.com":   {upstreamAddr.Addr()},
+	}
+	loopback := netip.MustParsePrefix("127.0.0.0/8")
+	alice := newTestWhoIs("alice@example.com")
+
+	tests := []struct {
+		name      string
+		rules     []SOCKS5Rule
+		err       error
+		host      string
+		wantAllow bool
+	}{
+		{name: "allowed prefix", rules: []SOCKS5Rule{{Prefixes: []netip.Prefix{loopback}}}, host: upstreamAddr.Addr().String(), wantAllow: true},
+		{name: "resolved into allowed prefix", rules: []SOCKS5Rule{{Prefixes: []netip.Prefix{loopback}}}, host: "evil.example.com", wantAllow: true},
+		{name: "allowed host", rules: []SOCKS5Rule{{Hosts: []string{"*.lab.example.com"}}}, host: "db.lab.example.com", wantAllow: true},
+		{name: "other host", rules: []SOCKS5Rule{{Hosts: []string{"*.lab.example.com"}}}, host: "evil.example.com"},
+		{name: "address not in hosts", rules: []SOCKS5Rule{{Hosts: []string{"*.lab.example.com"}}}, host: upstreamAddr.Addr().String()},
+		{name: "other prefix", rules: []SOCKS5Rule{{Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}}, host: upstreamAddr.Addr().String()},
+		{name: "other port", rules: []SOCKS5Rule{{Prefixes: []netip.Prefix{loopback}, Ports: []int{22}}}, host: upstreamAddr.Addr().String()},
+		{
+			name:  "other caller",
+			rules: []SOCKS5Rule{{Callers: Rule{LoginNames: []string{"bob@example.com"}}, Prefixes: []netip.Prefix{loopback}}},
+			host:  upstreamAddr.Addr().String(),
+		},
+		{name: "unknown peer", rules: []SOCKS5Rule{{Prefixes: []netip.Prefix{loopback}}}, err: local.ErrPeerNotFound, host: upstreamAddr.Addr().String()},
+		{name: "unresolvable host", rules: []SOCKS5Rule{{Hosts: []string{"*"}}}, host: "missing.example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			whoIs := func(context.Context, string) (*apitype.WhoIsResponse, error) {
+				if test.err != nil {
+					return nil, test.err
+				}
+				return alice, nil
+			}
+			config := &SOCKS5Config{Rules: test.rules}
+			if err := validateSOCKS5Config(config); err != nil {
+				t.Fatal(err)
+			}
+			go serveSOCKS5(listener, config, whoIs, resolver, newLogger(t.Logf, slog.LevelDebug))
+			defer listener.Close()
+
+			dialer, err := proxy.SOCKS5("tcp", listener.Addr().String(), nil, proxy.Direct)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := dialer.Dial("tcp", net.JoinHostPort(test.host, strconv.Itoa(int(upstreamAddr.Port()))))
+			if !test.wantAllow {
+				if err == nil {
+					conn.Close()
+					t.Fatal("expected connection to be refused")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			data, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "hello" {
+				t.Errorf("got %q, want %q", data, "hello")
+			}
+		})
+	}
+}
+
+func TestValidateSOCKS5Config(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *SOCKS5Config
+	}{
+		{name: "nil", config: nil},
+		{name: "no rules", config: &SOCKS5Config{}},
+		{name: "rule without destinations", config: &SOCKS5Config{Rules: []SOCKS5Rule{{Ports: []int{22}}}}},
+		{name: "invalid port", config: &SOCKS5Config{Port: -1, Rules: []SOCKS5Rule{{Hosts: []string{"example.com"}}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateSOCKS5Config(test.config); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
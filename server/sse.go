@@ -0,0 +1,236 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSSEKeepAlive is the interval of keepalive comments if
+	// SSEOptions.KeepAlive is not set.
+	DefaultSSEKeepAlive = 15 * time.Second
+
+	// DefaultSSEBufferSize is the number of events buffered per subscriber
+	// if SSEOptions.BufferSize is not set.
+	DefaultSSEBufferSize = 16
+)
+
+// SSEEvent is an event sent to subscribers of an SSEBroker.
+type SSEEvent struct {
+	// ID, if set, is sent as the event ID which browsers pass back in the
+	// Last-Event-ID header when reconnecting.
+	ID string
+
+	// Event, if set, is the event type dispatched by browsers. It defaults
+	// to "message" on the client.
+	Event string
+
+	// Data is the payload of the event. It may span multiple lines.
+	Data string
+}
+
+// SSEOptions configures an SSEBroker.
+type SSEOptions struct {
+	// KeepAlive is the interval of comments sent to idle subscribers so
+	// that proxies and clients do not close the connection. It defaults to
+	// DefaultSSEKeepAlive.
+	KeepAlive time.Duration
+
+	// BufferSize is the number of events buffered per subscriber. Slow
+	// subscribers whose buffer is full are disconnected so that they cannot
+	// hold up publishers. It defaults to DefaultSSEBufferSize.
+	BufferSize int
+}
+
+// SSEBroker publishes Server-Sent Events to subscribers of topics.
+type SSEBroker struct {
+	keepAlive  time.Duration
+	bufferSize int
+
+	mu          sync.Mutex
+	subscribers map[string]map[*sseSubscriber]struct{}
+	closed      bool
+}
+
+type sseSubscriber struct {
+	events chan SSEEvent
+}
+
+// NewSSEBroker creates an SSEBroker.
+func NewSSEBroker(opts SSEOptions) *SSEBroker {
+	keepAlive := opts.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = DefaultSSEKeepAlive
+	}
+	bufferSize := opts.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultSSEBufferSize
+	}
+	return &SSEBroker{
+		keepAlive:   keepAlive,
+		bufferSize:  bufferSize,
+		subscribers: make(map[string]map[*sseSubscriber]struct{}),
+	}
+}
+
+// Publish sends the event to every current subscriber of topic. It never
+// blocks.
+func (b *SSEBroker) Publish(topic string, event SSEEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for subscriber := range b.subscribers[topic] {
+		select {
+		case subscriber.events <- event:
+		default:
+			logf(slog.LevelWarn, "disconnecting slow subscriber of topic [%s]", topic)
+			b.removeLocked(subscriber)
+		}
+	}
+}
+
+// Close disconnects all subscribers. Later subscriptions end immediately.
+func (b *SSEBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, subscribers := range b.subscribers {
+		for subscriber := range subscribers {
+			b.removeLocked(subscriber)
+		}
+	}
+}
+
+// subscribe returns a subscriber of topics, or nil if the broker is closed.
+func (b *SSEBroker) subscribe(topics []string) *sseSubscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	subscriber := &sseSubscriber{events: make(chan SSEEvent, b.bufferSize)}
+	for _, topic := range topics {
+		if b.subscribers[topic] == nil {
+			b.subscribers[topic] = make(map[*sseSubscriber]struct{})
+		}
+		b.subscribers[topic][subscriber] = struct{}{}
+	}
+	return subscriber
+}
+
+func (b *SSEBroker) unsubscribe(subscriber *sseSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeLocked(subscriber)
+}
+
+// removeLocked removes the subscriber from all topics and closes its
+// channel if it is still subscribed.
+func (b *SSEBroker) removeLocked(subscriber *sseSubscriber) {
+	found := false
+	for topic, subscribers := range b.subscribers {
+		if _, ok := subscribers[subscriber]; ok {
+			found = true
+			delete(subscribers, subscriber)
+			if len(subscribers) == 0 {
+				delete(b.subscribers, topic)
+			}
+		}
+	}
+	if found {
+		close(subscriber.events)
+	}
+}
+
+// Handler returns a handler streaming the events of topics to clients. If no
+// topic is specified, clients choose topics with the query parameter
+// "topic", which may be repeated. The read and write timeouts of the
+// http.Server are lifted for the streams and every event is flushed
+// immediately.
+func (b *SSEBroker) Handler(topics ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		subscribed := topics
+		if len(subscribed) == 0 {
+			subscribed = r.URL.Query()["topic"]
+		}
+		if len(subscribed) == 0 {
+			http.Error(w, "no topic is specified", http.StatusBadRequest)
+			return
+		}
+		subscriber := b.subscribe(subscribed)
+		if subscriber == nil {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer b.unsubscribe(subscriber)
+
+		clearDeadlines(w)
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		ticker := time.NewTicker(b.keepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event, ok := <-subscriber.events:
+				if !ok {
+					return
+				}
+				if err := writeSSEEvent(w, event); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// writeSSEEvent writes the event in the text/event-stream format.
+func writeSSEEvent(w io.Writer, event SSEEvent) error {
+	var b strings.Builder
+	if event.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", sseField(event.ID))
+	}
+	if event.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", sseField(event.Event))
+	}
+	data := sseLineBreaks.Replace(event.Data)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// sseLineBreaks normalises the line breaks of data, as clients also end
+// lines at a lone "\r".
+var sseLineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// sseField removes line breaks from a single-line field.
+func sseField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteSSEEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event SSEEvent
+		want  string
+	}{
+		{name: "data", event: SSEEvent{Data: "hello"}, want: "data: hello\n\n"},
+		{name: "all fields", event: SSEEvent{ID: "1", Event: "update", Data: "hello"}, want: "id: 1\nevent: update\ndata: hello\n\n"},
+		{name: "multiple lines", event: SSEEvent{Data: "a\r\nb\nc"}, want: "data: a\ndata: b\ndata: c\n\n"},
+		{name: "carriage returns", event: SSEEvent{Data: "a\rb\r\nc"}, want: "data: a\ndata: b\ndata: c\n\n"},
+		{name: "line break in event", event: SSEEvent{Event: "up\ndate", Data: "x"}, want: "event: update\ndata: x\n\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := writeSSEEvent(&b, test.event); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != test.want {
+				t.Errorf("got %q, want %q", b.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestSSEBroker(t *testing.T) {
+	broker := NewSSEBroker(SSEOptions{KeepAlive: 50 * time.Millisecond})
+	server := httptest.NewUnstartedServer(broker.Handler())
+	server.Config.WriteTimeout = 150 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?topic=builds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("got content type %q", got)
+	}
+	go func() {
+		for i := range 3 {
+			broker.Publish("deployments", SSEEvent{Data: "other topic"})
+			broker.Publish("builds", SSEEvent{ID: string(rune('1' + i)), Data: "build"})
+			time.Sleep(100 * time.Millisecond)
+		}
+		broker.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("stream interrupted after %q: %v", body, err)
+	}
+	var events, keepAlives int
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		switch line := scanner.Text(); {
+		case strings.HasPrefix(line, "data: "):
+			if line != "data: build" {
+				t.Errorf("got unexpected line %q", line)
+			}
+			events++
+		case line == ": keepalive":
+			keepAlives++
+		}
+	}
+	if events != 3 {
+		t.Errorf("got %d events, want 3: %q", events, body)
+	}
+	if keepAlives == 0 {
+		t.Errorf("got no keepalive comments: %q", body)
+	}
+}
+
+func TestSSEBrokerHandler(t *testing.T) {
+	broker := NewSSEBroker(SSEOptions{})
+	broker.Close()
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{name: "POST", handler: broker.Handler("builds"), method: http.MethodPost, target: "/", wantStatus: http.StatusMethodNotAllowed},
+		{name: "without topic", handler: broker.Handler(), method: http.MethodGet, target: "/", wantStatus: http.StatusBadRequest},
+		{name: "closed", handler: broker.Handler("builds"), method: http.MethodGet, target: "/", wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			test.handler.ServeHTTP(w, httptest.NewRequest(test.method, test.target, nil))
+			if w.Code != test.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, test.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// DefaultSSHPort is the port ServeSSH listens on if SSHConfig.Port is not
+// set.
+const DefaultSSHPort = 22
+
+// DefaultSSHShell is the shell used to run commands if SSHConfig.Shell is not
+// set.
+const DefaultSSHShell = "/bin/sh"
+
+// defaultSFTPServerPaths are the locations searched for the SFTP server of
+// OpenSSH if SSHConfig.SFTPServer is not set.
+var defaultSFTPServerPaths = []string{
+	"/usr/lib/openssh/sftp-server",
+	"/usr/libexec/openssh/sftp-server",
+	"/usr/libexec/sftp-server",
+	"/usr/lib/ssh/sftp-server",
+}
+
+// SSHRule maps tailnet callers to the system users they may log in as.
+type SSHRule struct {
+	// Callers matches the callers the rule applies to.
+	Callers Rule
+
+	// Users are the system users the callers may log in as, such as
+	// "deploy".
+	Users []string
+
+	// Command, if set, is run instead of the shell or the command requested
+	// by the client. The requested command is passed in the environment
+	// variable SSH_ORIGINAL_COMMAND. SFTP is not available if it is set.
+	Command string
+}
+
+// SSHConfig configures the SSH server started by ServeSSH.
+type SSHConfig struct {
+	// Port is the tailnet port to listen on. It defaults to DefaultSSHPort.
+	Port int
+
+	// Rules decide which system users a caller may log in as. The first
+	// rule matching both the caller and the requested user is applied and
+	// connections matching no rule are rejected.
+	Rules []SSHRule
+
+	// HostKey is the private host key of the server.
+	HostKey ssh.Signer
+
+	// HostKeyFile is the path of a PEM encoded private host key used if
+	// HostKey is not set. An Ed25519 key is generated and written to the
+	// file if it does not exist. If neither HostKey nor HostKeyFile is set,
+	// a new key is generated on every start.
+	HostKeyFile string
+
+	// Shell runs commands and interactive sessions. It defaults to
+	// DefaultSSHShell.
+	Shell string
+
+	// SFTPServer is the path of the SFTP server executable, such as
+	// "/usr/lib/openssh/sftp-server". The locations used by common Linux
+	// distributions are searched if it is not set.
+	SFTPServer string
+
+	// SFTPDirectory, if set, serves the SFTP subsystem in this process
+	// instead of running SFTPServer. Each caller is confined to a directory
+	// of SFTPDirectory named after its login name, which is created on
+	// demand, and files are owned by the user running this process rather
+	// than the user logged in as.
+	SFTPDirectory string
+}
+
+// ServeSSH listens on the tailnet and serves SSH until the server is closed.
+// Callers are authenticated purely by their tailnet identity so clients do
+// not need keys or passwords. Sessions run commands, a shell without a
+// pseudo-terminal or the SFTP subsystem as the requested system user, which
+// requires the process to run as root unless it is the current user. Port
+// forwarding is not supported.
+func (s *Server) ServeSSH(config *SSHConfig) error {
+	serverConfig, err := newSSHServerConfig(context.Background(), config, s.whoIs, s.logger)
+	if err != nil {
+		return err
+	}
+	port := config.Port
+	if port == 0 {
+		port = DefaultSSHPort
+	}
+	listener, err := s.listen(fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port [%d]: %w", port, err)
+	}
+	return serveSSH(listener, serverConfig, config, s.logger)
+}
+
+// newSSHServerConfig returns the configuration of the SSH protocol which
+// authenticates callers with whoIs.
+func newSSHServerConfig(ctx context.Context, config *SSHConfig, whoIs whoIsFunc, logger *logger) (*ssh.ServerConfig, error) {
+	if config == nil {
+		return nil, fmt.Errorf("SSH configuration is required")
+	}
+	if config.Port < 0 || config.Port > 65535 {
+		return nil, fmt.Errorf("invalid SSH port [%d]: port must be between 1 and 65535", config.Port)
+	}
+	if len(config.Rules) == 0 {
+		return nil, fmt.Errorf("at least one SSH rule is required")
+	}
+	for i, rule := range config.Rules {
+		if len(rule.Users) == 0 {
+			return nil, fmt.Errorf("SSH rule [%d] has no users", i)
+		}
+	}
+	if config.SFTPDirectory != "" {
+		info, err := os.Stat(config.SFTPDirectory)
+		if err != nil {
+			return nil, fmt.Errorf("failed to access SFTP directory [%s]: %w", config.SFTPDirectory, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("SFTP directory [%s] is not a directory", config.SFTPDirectory)
+		}
+	}
+	hostKey, err := sshHostKey(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	serverConfig := &ssh.ServerConfig{
+		NoClientAuth: true,
+		NoClientAuthCallback: func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+			who, err := whoIs(ctx, conn.RemoteAddr().String())
+			if err != nil {
+				logger.logf(slog.LevelWarn, "rejecting SSH connection from [%s]: %v", conn.RemoteAddr(), err)
+				return nil, fmt.Errorf("caller is not a known tailnet peer")
+			}
+			rule, found := matchSSHRule(config.Rules, who, conn.User())
+			if !found {
+				logger.logf(slog.LevelWarn, "rejecting SSH login from [%s] as [%s]", conn.RemoteAddr(), conn.User())
+				return nil, fmt.Errorf("caller may not log in as [%s]", conn.User())
+			}
+			return &ssh.Permissions{
+				Extensions: map[string]string{
+					sshExtensionLoginName: who.UserProfile.LoginName,
+					sshExtensionCommand:   rule.Command,
+				},
+			}, nil
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+	return serverConfig, nil
+}
+
+const (
+	sshExtensionLoginName = "privateserver-login-name"
+	sshExtensionCommand   = "privateserver-command"
+)
+
+// matchSSHRule returns the first rule allowing the caller to log in as the
+// system user.
+func matchSSHRule(rules []SSHRule, who *apitype.WhoIsResponse, systemUser string) (SSHRule, bool) {
+	for _, rule := range rules {
+		if slices.Contains(rule.Users, systemUser) && rule.Callers.Matches(who) {
+			return rule, true
+		}
+	}
+	return SSHRule{}, false
+}
+
+// sshHostKey returns the host key configured in config.
+func sshHostKey(config *SSHConfig, logger *logger) (ssh.Signer, error) {
+	if config.HostKey != nil {
+		return config.HostKey, nil
+	}
+	if config.HostKeyFile == "" {
+		logger.logf(slog.LevelWarn, "no SSH host key is configured; clients will see a new host key on every start")
+		return generateSSHHostKey()
+	}
+	data, err := os.ReadFile(config.HostKeyFile)
+	if err == nil {
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH host key [%s]: %w", config.HostKeyFile, err)
+		}
+		return signer, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read SSH host key [%s]: %w", config.HostKeyFile, err)
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate SSH host key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SSH host key: %w", err)
+	}
+	if err := os.WriteFile(config.HostKeyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write SSH host key [%s]: %w", config.HostKeyFile, err)
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+func generateSSHHostKey() (ssh.Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate SSH host key: %w", err)
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// serveSSH accepts connections from listener until it is closed.
+func serveSSH(listener net.Listener, serverConfig *ssh.ServerConfig, config *SSHConfig, logger *logger) error {
+	defer func() { _ = listener.Close() }()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go handleSSHConnection(conn, serverConfig, config, logger)
+	}
+}
+
+func handleSSHConnection(conn net.Conn, serverConfig *ssh.ServerConfig, config *SSHConfig, logger *logger) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		logger.logf(slog.LevelDebug, "SSH handshake with [%s] failed: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	defer func() { _ = serverConn.Close() }()
+	logger.logf(slog.LevelInfo, "SSH login of [%s] as [%s] from [%s]",
+		serverConn.Permissions.Extensions[sshExtensionLoginName], serverConn.User(), serverConn.RemoteAddr())
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			if err := newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported"); err != nil {
+				logger.logf(slog.LevelDebug, "failed to reject SSH channel from [%s]: %v", serverConn.RemoteAddr(), err)
+			}
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			logger.logf(slog.LevelWarn, "failed to accept SSH channel from [%s]: %v", serverConn.RemoteAddr(), err)
+			continue
+		}
+		go handleSSHSession(serverConn, channel, channelRequests, config, logger)
+	}
+}
+
+// handleSSHSession runs the command requested in a session channel.
+func handleSSHSession(conn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request, config *SSHConfig, logger *logger) {
+	defer func() { _ = channel.Close() }()
+	for request := range requests {
+		var cmd *exec.Cmd
+		var err error
+		switch request.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(request.Payload, &payload); err != nil {
+				_ = request.Reply(false, nil)
+				continue
+			}
+			cmd, err = sshCommand(conn, config, payload.Command)
+		case "shell":
+			cmd, err = sshCommand(conn, config, "")
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(request.Payload, &payload); err != nil || payload.Name != "sftp" {
+				_ = request.Reply(false, nil)
+				continue
+			}
+			if config.SFTPDirectory != "" {
+				serveSFTPSession(conn, channel, request, config, logger)
+				return
+			}
+			cmd, err = sftpCommand(conn, config)
+		default:
+			// Pseudo-terminals, environment variables and signals are not
+			// supported.
+			_ = request.Reply(false, nil)
+			continue
+		}
+		if err != nil {
+			logger.logf(slog.LevelWarn, "failed to start SSH session of [%s]: %v", conn.User(), err)
+			_ = request.Reply(false, nil)
+			return
+		}
+		_ = request.Reply(true, nil)
+		status := runSSHCommand(cmd, channel, logger)
+		sendSSHExitStatus(channel, status, logger)
+		return
+	}
+}
+
+// serveSFTPSession serves the SFTP subsystem in this process.
+func serveSFTPSession(conn *ssh.ServerConn, channel ssh.Channel, request *ssh.Request, config *SSHConfig, logger *logger) {
+	if conn.Permissions.Extensions[sshExtensionCommand] != "" {
+		logger.logf(slog.LevelWarn, "refusing SFTP session of [%s]: SFTP is not available with a forced command", conn.User())
+		_ = request.Reply(false, nil)
+		return
+	}
+	_ = request.Reply(true, nil)
+	var status uint32
+	loginName := conn.Permissions.Extensions[sshExtensionLoginName]
+	if err := serveSFTP(channel, config.SFTPDirectory, loginName); err != nil {
+		logger.logf(slog.LevelError, "SFTP session of [%s] failed: %v", loginName, err)
+		status = 1
+	}
+	sendSSHExitStatus(channel, status, logger)
+}
+
+// sendSSHExitStatus reports the exit status of a session to the client.
+func sendSSHExitStatus(channel ssh.Channel, status uint32, logger *logger) {
+	if _, err := channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status})); err != nil {
+		logger.logf(slog.LevelDebug, "failed to send SSH exit status: %v", err)
+	}
+}
+
+// sshCommand returns the command running command, or the shell if command is
+// empty, as the user of the connection.
+func sshCommand(conn *ssh.ServerConn, config *SSHConfig, command string) (*exec.Cmd, error) {
+	shell := config.Shell
+	if shell == "" {
+		shell = DefaultSSHShell
+	}
+	var env []string
+	if forced := conn.Permissions.Extensions[sshExtensionCommand]; forced != "" {
+		env = append(env, "SSH_ORIGINAL_COMMAND="+command)
+		command = forced
+	}
+	var cmd *exec.Cmd
+	if command == "" {
+		cmd = exec.Command(shell)
+	} else {
+		cmd = exec.Command(shell, "-c", command)
+	}
+	if err := runAsUser(cmd, conn.User(), shell); err != nil {
+		return nil, err
+	}
+	cmd.Env = append(cmd.Env, env...)
+	return cmd, nil
+}
+
+// sftpCommand returns the command running the SFTP server as the user of the
+// connection.
+func sftpCommand(conn *ssh.ServerConn, config *SSHConfig) (*exec.Cmd, error) {
+	if conn.Permissions.Extensions[sshExtensionCommand] != "" {
+		return nil, fmt.Errorf("SFTP is not available with a forced command")
+	}
+	path := config.SFTPServer
+	if path == "" {
+		for _, candidate := range defaultSFTPServerPaths {
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
+	}
+	if path == "" {
+		return nil, fmt.Errorf("SFTP server is not found")
+	}
+	cmd := exec.Command(path)
+	shell := config.Shell
+	if shell == "" {
+		shell = DefaultSSHShell
+	}
+	if err := runAsUser(cmd, conn.User(), shell); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// runAsUser sets up cmd to run as the system user with the environment of a
+// login.
+func runAsUser(cmd *exec.Cmd, username, shell string) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return fmt.Errorf("failed to look up user [%s]: %w", username, err)
+	}
+	if err := setCredential(cmd, u); err != nil {
+		return err
+	}
+	cmd.Dir = u.HomeDir
+	cmd.Env = []string{
+		"HOME=" + u.HomeDir,
+		"USER=" + u.Username,
+		"LOGNAME=" + u.Username,
+		"SHELL=" + shell,
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+	}
+	return nil
+}
+
+// runSSHCommand runs cmd with its standard streams connected to channel and
+// returns its exit status.
+func runSSHCommand(cmd *exec.Cmd, channel ssh.Channel, logger *logger) uint32 {
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		logger.logf(slog.LevelError, "failed to connect SSH session input: %v", err)
+		return 255
+	}
+	if err := cmd.Start(); err != nil {
+		logger.logf(slog.LevelError, "failed to start [%s]: %v", cmd.Path, err)
+		return 255
+	}
+	go func() {
+		if _, err := io.Copy(stdin, channel); err != nil {
+			logger.logf(slog.LevelDebug, "failed to copy SSH session input: %v", err)
+		}
+		if err := stdin.Close(); err != nil {
+			logger.logf(slog.LevelDebug, "failed to close SSH session input: %v", err)
+		}
+	}()
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return uint32(exitErr.ExitCode())
+	}
+	if err != nil {
+		logger.logf(slog.LevelError, "failed to run [%s]: %v", cmd.Path, err)
+		return 255
+	}
+	return 0
+}
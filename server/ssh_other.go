@@ -0,0 +1,22 @@
+//go:build !unix
+
+package server
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+)
+
+// setCredential returns an error as switching users is only supported on
+// Unix.
+func setCredential(cmd *exec.Cmd, u *user.User) error {
+	current, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("failed to look up current user: %w", err)
+	}
+	if current.Uid != u.Uid {
+		return fmt.Errorf("running SSH sessions as user [%s] is not supported on this platform", u.Username)
+	}
+	return nil
+}
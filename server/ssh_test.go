@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func startTestSSHServer(t *testing.T, config *SSHConfig, whoIs whoIsFunc) string {
+	t.Helper()
+	logger := newLogger(t.Logf, slog.LevelInfo)
+	serverConfig, err := newSSHServerConfig(context.Background(), config, whoIs, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go serveSSH(listener, serverConfig, config, logger)
+	return listener.Addr().String()
+}
+
+func TestServeSSH(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	alice := newTestWhoIs("alice@example.com")
+	tests := []struct {
+		name       string
+		rules      []SSHRule
+		err        error
+		user       string
+		command    string
+		wantOutput string
+		wantDenied bool
+	}{
+		{
+			name:       "command",
+			rules:      []SSHRule{{Callers: Rule{LoginNames: []string{"alice@example.com"}}, Users: []string{current.Username}}},
+			user:       current.Username,
+			command:    "echo hello",
+			wantOutput: "hello\n",
+		},
+		{
+			name:       "forced command",
+			rules:      []SSHRule{{Users: []string{current.Username}, Command: `echo "forced $SSH_ORIGINAL_COMMAND"`}},
+			user:       current.Username,
+			command:    "ls",
+			wantOutput: "forced ls\n",
+		},
+		{
+			name:       "other caller",
+			rules:      []SSHRule{{Callers: Rule{LoginNames: []string{"bob@example.com"}}, Users: []string{current.Username}}},
+			user:       current.Username,
+			command:    "echo hello",
+			wantDenied: true,
+		},
+		{
+			name:       "other user",
+			rules:      []SSHRule{{Users: []string{current.Username}}},
+			user:       "not-" + current.Username,
+			command:    "echo hello",
+			wantDenied: true,
+		},
+		{
+			name:       "unknown peer",
+			rules:      []SSHRule{{Users: []string{current.Username}}},
+			err:        local.ErrPeerNotFound,
+			user:       current.Username,
+			command:    "echo hello",
+			wantDenied: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := startTestSSHServer(t, &SSHConfig{Rules: test.rules}, func(context.Context, string) (*apitype.WhoIsResponse, error) {
+				if test.err != nil {
+					return nil, test.err
+				}
+				return alice, nil
+			})
+			client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+				User:            test.user,
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			})
+			if test.wantDenied {
+				if err == nil {
+					client.Close()
+					t.Fatal("expected connection to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			output, err := session.Output(test.command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != test.wantOutput {
+				t.Errorf("got output %q, want %q", output, test.wantOutput)
+			}
+		})
+	}
+}
+
+func TestServeSSHExitStatus(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	addr := startTestSSHServer(t, &SSHConfig{Rules: []SSHRule{{Users: []string{current.Username}}}}, func(context.Context, string) (*apitype.WhoIsResponse, error) {
+		return newTestWhoIs("alice@example.com"), nil
+	})
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{User: current.Username, HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	session.Stdin = strings.NewReader("exit 3\n")
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	err = session.Shell()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.Wait()
+	exitErr, ok := err.(*ssh.ExitError)
+	if !ok || exitErr.ExitStatus() != 3 {
+		t.Fatalf("got error %v, want exit status 3 (stderr: %s)", err, stderr.String())
+	}
+}
+
+func TestSSHHostKeyFile(t *testing.T) {
+	config := &SSHConfig{HostKeyFile: filepath.Join(t.TempDir(), "ssh_host_ed25519_key")}
+	logger := newLogger(t.Logf, slog.LevelInfo)
+	first, err := sshHostKey(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sshHostKey(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.PublicKey().Marshal(), second.PublicKey().Marshal()) {
+		t.Error("host key changed after reloading the key file")
+	}
+}
+
+func TestNewSSHServerConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *SSHConfig
+	}{
+		{name: "nil", config: nil},
+		{name: "no rules", config: &SSHConfig{}},
+		{name: "rule without users", config: &SSHConfig{Rules: []SSHRule{{}}}},
+		{name: "invalid port", config: &SSHConfig{Port: 70000, Rules: []SSHRule{{Users: []string{"root"}}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newSSHServerConfig(context.Background(), test.config, nil, newLogger(t.Logf, slog.LevelInfo))
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
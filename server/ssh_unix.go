@@ -0,0 +1,43 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// setCredential sets up cmd to run as u if it is not the current user.
+func setCredential(cmd *exec.Cmd, u *user.User) error {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid user ID [%s] of user [%s]: %w", u.Uid, u.Username, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid group ID [%s] of user [%s]: %w", u.Gid, u.Username, err)
+	}
+	if int(uid) == os.Getuid() {
+		return nil
+	}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return fmt.Errorf("failed to look up groups of user [%s]: %w", u.Username, err)
+	}
+	groups := make([]uint32, 0, len(groupIDs))
+	for _, groupID := range groupIDs {
+		group, err := strconv.ParseUint(groupID, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid group ID [%s] of user [%s]: %w", groupID, u.Username, err)
+		}
+		groups = append(groups, uint32(group))
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups},
+	}
+	return nil
+}
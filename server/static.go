@@ -0,0 +1,202 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StaticOptions configures a handler created by StaticHandler.
+type StaticOptions struct {
+	// IndexFiles are served for requests of a directory, in order of
+	// preference. They default to index.html.
+	IndexFiles []string
+
+	// DirectoryListing lists the files of directories without an index
+	// file. Such requests are answered with 404 Not Found otherwise.
+	DirectoryListing bool
+}
+
+// StaticHandler returns a handler serving the files in dir, such as internal
+// documentation or build artifacts. Content types are derived from file
+// extensions. Files are served with an ETag derived from their content and a
+// Last-Modified header, so that clients revalidating with If-None-Match or
+// If-Modified-Since are answered with 304 Not Modified, and Range requests
+// are honored so that interrupted downloads of large artifacts can resume.
+// ETags are cached until the size or modification time of a file changes.
+// Files are opened through an os.Root so that neither ".." in paths nor
+// symbolic links can reach files outside dir, and files or directories whose
+// name starts with a dot are never served.
+func StaticHandler(dir string, opts StaticOptions) (http.Handler, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open static directory: %w", err)
+	}
+	indexFiles := opts.IndexFiles
+	if len(indexFiles) == 0 {
+		indexFiles = []string{"index.html"}
+	}
+	return &staticHandler{
+		fsys:             root.FS(),
+		indexFiles:       indexFiles,
+		directoryListing: opts.DirectoryListing,
+		etags:            make(map[string]staticETag),
+	}, nil
+}
+
+type staticHandler struct {
+	fsys             fs.FS
+	indexFiles       []string
+	directoryListing bool
+
+	etagsMu sync.Mutex
+	etags   map[string]staticETag
+}
+
+// staticETag is the cached ETag of a file, valid as long as the size and the
+// modification time of the file are unchanged.
+type staticETag struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	urlPath := path.Clean("/" + r.URL.Path)
+	name := strings.TrimPrefix(urlPath, "/")
+	if name == "" {
+		name = "."
+	}
+	if isHiddenPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Files which are missing, unreadable or outside dir are all reported as
+	// missing so that callers cannot probe the file system.
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if !info.IsDir() {
+		h.serveFile(w, r, name, info)
+		return
+	}
+
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		target := urlPath + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+	for _, index := range h.indexFiles {
+		indexName := path.Join(name, index)
+		if info, err := fs.Stat(h.fsys, indexName); err == nil && !info.IsDir() {
+			h.serveFile(w, r, indexName, info)
+			return
+		}
+	}
+	if !h.directoryListing {
+		http.NotFound(w, r)
+		return
+	}
+	http.FileServerFS(hiddenFilesFS{h.fsys}).ServeHTTP(w, r)
+}
+
+// serveFile serves the file with its ETag. http.ServeFileFS evaluates the
+// conditional and Range headers of the request against it.
+func (h *staticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo) {
+	etag, err := h.etag(name, info)
+	if err != nil {
+		logf(slog.LevelWarn, "failed to compute ETag of [%s]: %v", name, err)
+	} else {
+		w.Header().Set("ETag", etag)
+	}
+	http.ServeFileFS(w, r, h.fsys, name)
+}
+
+// etag returns the ETag of the file, computing it from the content of the file
+// if it has changed since it was cached.
+func (h *staticHandler) etag(name string, info fs.FileInfo) (string, error) {
+	h.etagsMu.Lock()
+	cached, found := h.etags[name]
+	h.etagsMu.Unlock()
+	if found && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.etag, nil
+	}
+
+	file, err := h.fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+	digest := sha256.New()
+	if _, err := io.Copy(digest, file); err != nil {
+		return "", err
+	}
+	etag := `"` + base64.RawURLEncoding.EncodeToString(digest.Sum(nil)[:16]) + `"`
+
+	h.etagsMu.Lock()
+	h.etags[name] = staticETag{size: info.Size(), modTime: info.ModTime(), etag: etag}
+	h.etagsMu.Unlock()
+	return etag, nil
+}
+
+// isHiddenPath reports whether any element of name starts with a dot.
+func isHiddenPath(name string) bool {
+	for _, element := range strings.Split(name, "/") {
+		if element != "." && strings.HasPrefix(element, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenFilesFS leaves hidden files out of directory listings.
+type hiddenFilesFS struct {
+	fs.FS
+}
+
+func (f hiddenFilesFS) Open(name string) (fs.File, error) {
+	file, err := f.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if dir, ok := file.(fs.ReadDirFile); ok {
+		return hiddenFilesDir{dir}, nil
+	}
+	return file, nil
+}
+
+type hiddenFilesDir struct {
+	fs.ReadDirFile
+}
+
+func (d hiddenFilesDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := d.ReadDirFile.ReadDir(n)
+	visible := entries[:0]
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			visible = append(visible, entry)
+		}
+	}
+	return visible, err
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeStaticFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestStaticHandler(t *testing.T) {
+	dir := writeStaticFiles(t, map[string]string{
+		"index.html":          "<h1>docs</h1>",
+		"guide/index.html":    "<h1>guide</h1>",
+		"style.css":           "body {}",
+		"artifacts/app.tar":   "tar",
+		".env":                "SECRET=1",
+		".git/config":         "[core]",
+		"artifacts/.checksum": "sum",
+	})
+	outside := writeStaticFiles(t, map[string]string{"secret.txt": "secret"})
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "escape.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		opts            StaticOptions
+		path            string
+		wantCode        int
+		wantBody        string
+		wantContentType string
+		wantLocation    string
+	}{
+		{
+			name:            "root index",
+			path:            "/",
+			wantCode:        http.StatusOK,
+			wantBody:        "<h1>docs</h1>",
+			wantContentType: "text/html; charset=utf-8",
+		},
+		{
+			name:         "directory without trailing slash",
+			path:         "/guide",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "/guide/",
+		},
+		{
+			name:     "directory index",
+			path:     "/guide/",
+			wantCode: http.StatusOK,
+			wantBody: "<h1>guide</h1>",
+		},
+		{
+			name:            "content type",
+			path:            "/style.css",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/css; charset=utf-8",
+		},
+		{
+			name:     "directory listing disabled",
+			path:     "/artifacts/",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "directory listing",
+			opts:     StaticOptions{DirectoryListing: true},
+			path:     "/artifacts/",
+			wantCode: http.StatusOK,
+			wantBody: "app.tar",
+		},
+		{
+			name:     "hidden file",
+			path:     "/.env",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "hidden directory",
+			path:     "/.git/config",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "parent directory",
+			path:     "/../" + filepath.Base(outside) + "/secret.txt",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "symbolic link out of directory",
+			path:     "/escape.txt",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "missing file",
+			path:     "/missing.html",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := StaticHandler(dir, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path = tt.path
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d; want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("got body %q; want %q", rec.Body.String(), tt.wantBody)
+			}
+			if strings.Contains(rec.Body.String(), ".checksum") {
+				t.Errorf("hidden file listed in %q", rec.Body.String())
+			}
+			if tt.wantContentType != "" && rec.Header().Get("Content-Type") != tt.wantContentType {
+				t.Errorf("got content type %q; want %q", rec.Header().Get("Content-Type"), tt.wantContentType)
+			}
+			if tt.wantLocation != "" && rec.Header().Get("Location") != tt.wantLocation {
+				t.Errorf("got location %q; want %q", rec.Header().Get("Location"), tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestStaticHandlerMissingDirectory(t *testing.T) {
+	if _, err := StaticHandler(filepath.Join(t.TempDir(), "missing"), StaticOptions{}); err == nil {
+		t.Error("StaticHandler() succeeded for missing directory")
+	}
+}
+
+func TestStaticHandlerConditionalRequests(t *testing.T) {
+	dir := writeStaticFiles(t, map[string]string{"artifacts/app.tar": "0123456789"})
+	h, err := StaticHandler(dir, StaticOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/artifacts/app.tar", nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	first := serve(nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Header().Get("Last-Modified") == "" {
+		t.Fatalf("got %d with ETag %q and Last-Modified %q", first.Code, etag, first.Header().Get("Last-Modified"))
+	}
+	if again := serve(nil).Header().Get("ETag"); again != etag {
+		t.Errorf("got ETag %q on second request; want %q", again, etag)
+	}
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		wantCode int
+		wantBody string
+	}{
+		{name: "matching ETag", header: http.Header{"If-None-Match": {etag}}, wantCode: http.StatusNotModified},
+		{name: "other ETag", header: http.Header{"If-None-Match": {`"other"`}}, wantCode: http.StatusOK, wantBody: "0123456789"},
+		{name: "not modified since", header: http.Header{"If-Modified-Since": {first.Header().Get("Last-Modified")}}, wantCode: http.StatusNotModified},
+		{name: "range", header: http.Header{"Range": {"bytes=2-4"}}, wantCode: http.StatusPartialContent, wantBody: "234"},
+		{name: "range with matching If-Range", header: http.Header{"Range": {"bytes=2-4"}, "If-Range": {etag}}, wantCode: http.StatusPartialContent, wantBody: "234"},
+		{name: "range with stale If-Range", header: http.Header{"Range": {"bytes=2-4"}, "If-Range": {`"other"`}}, wantCode: http.StatusOK, wantBody: "0123456789"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.header)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got %d; want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("got body %q; want %q", got, tt.wantBody)
+			}
+		})
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "artifacts", "app.tar"), []byte("changed content"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if changed := serve(nil).Header().Get("ETag"); changed == etag {
+		t.Errorf("got unchanged ETag %q after the file changed", changed)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"slices"
+)
+
+// statusPageTemplate is the page served by StatusPage.
+var statusPageTemplate = template.Must(NewLayout().Parse(`{{define "title"}}{{.Data.Hostname}}{{end}}
+{{define "content"}}{{with .Data}}<h1>{{.Hostname}}</h1>
+<table>
+<tr><th>FQDN</th><td>{{.FQDN}}</td></tr>
+<tr><th>Listening ports</th><td>{{range $i, $port := .Ports}}{{if $i}}, {{end}}{{$port}}{{else}}none{{end}}</td></tr>
+<tr><th>State</th><td>{{.BackendState}}</td></tr>
+<tr><th>Tailscale IPs</th><td>{{range $i, $ip := .TailscaleIPs}}{{if $i}}, {{end}}{{$ip}}{{end}}</td></tr>
+<tr><th>Tailscale version</th><td>{{.Version}}</td></tr>
+</table>{{end}}
+{{with .Caller}}<h2>Caller</h2>
+<table>
+<tr><th>Login name</th><td>{{.LoginName}}</td></tr>
+<tr><th>Display name</th><td>{{.DisplayName}}</td></tr>
+<tr><th>Node</th><td>{{.NodeName}}</td></tr>
+{{with .Tags}}<tr><th>Tags</th><td>{{range $i, $tag := .}}{{if $i}}, {{end}}{{$tag}}{{end}}</td></tr>{{end}}
+</table>{{end}}{{end}}`))
+
+// statusPageData is shown on the status page.
+type statusPageData struct {
+	Hostname     string
+	FQDN         string
+	Ports        []int
+	BackendState string
+	TailscaleIPs []netip.Addr
+	Version      string
+}
+
+// StatusPage returns a handler showing the hostname, FQDN, listening ports
+// and Tailscale state of this node together with the identity of the caller,
+// as a smoke test after deployment. Requests from unknown peers and requests
+// over Tailscale Funnel are rejected with status 403.
+func (s *Server) StatusPage() http.Handler {
+	return newStatusPage(s.identify, s.statusPageData)
+}
+
+func newStatusPage(identify identifyFunc, data func(ctx context.Context) (statusPageData, error)) http.Handler {
+	return withIdentity(identify, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, err := data(r.Context())
+		if err != nil {
+			logf(slog.LevelError, "failed to get status of node: %v", err)
+			http.Error(w, "failed to get status of node", http.StatusInternalServerError)
+			return
+		}
+		Render(w, r, statusPageTemplate, "layout", page)
+	}))
+}
+
+func (s *Server) statusPageData(ctx context.Context) (statusPageData, error) {
+	if s.localMode() {
+		return statusPageData{}, ErrLocalMode
+	}
+	status, err := s.tsClient.StatusWithoutPeers(ctx)
+	if err != nil {
+		return statusPageData{}, err
+	}
+	return statusPageData{
+		Hostname:     s.hostname,
+		FQDN:         s.fqdn,
+		Ports:        s.listeningPorts(),
+		BackendState: status.BackendState,
+		TailscaleIPs: status.TailscaleIPs,
+		Version:      status.Version,
+	}, nil
+}
+
+// recordListeningPorts records ports listened on for the status page.
+func (s *Server) recordListeningPorts(ports ...int) {
+	s.portsMu.Lock()
+	defer s.portsMu.Unlock()
+	for _, port := range ports {
+		if !slices.Contains(s.ports, port) {
+			s.ports = append(s.ports, port)
+		}
+	}
+	slices.Sort(s.ports)
+}
+
+// listeningPorts returns the ports listened on by the server, including
+// those of ListenFunnel and of subsystems such as ServeSSH.
+func (s *Server) listeningPorts() []int {
+	s.portsMu.Lock()
+	defer s.portsMu.Unlock()
+	return slices.Clone(s.ports)
+}
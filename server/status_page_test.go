@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestStatusPage(t *testing.T) {
+	data := statusPageData{
+		Hostname:     "builds",
+		FQDN:         "builds.prawn-universe.ts.net",
+		Ports:        []int{80, 443},
+		BackendState: "Running",
+		TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+		Version:      "1.92.5",
+	}
+	tests := []struct {
+		name         string
+		who          *apitype.WhoIsResponse
+		whoIsErr     error
+		dataErr      error
+		wantStatus   int
+		wantContains []string
+	}{
+		{
+			name:         "known peer",
+			who:          newTestWhoIs("alice@example.com"),
+			wantStatus:   http.StatusOK,
+			wantContains: []string{"<h1>builds</h1>", "builds.prawn-universe.ts.net", "80, 443", "Running", "100.64.0.1", "alice@example.com", "test-node.prawn-universe.ts.net"},
+		},
+		{name: "unknown peer", whoIsErr: local.ErrPeerNotFound, wantStatus: http.StatusForbidden},
+		{name: "status failure", who: newTestWhoIs("alice@example.com"), dataErr: errors.New("not running"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identify := func(*http.Request) (*apitype.WhoIsResponse, error) { return test.who, test.whoIsErr }
+			h := newStatusPage(identify, func(context.Context) (statusPageData, error) { return data, test.dataErr })
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != test.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, test.wantStatus)
+			}
+			for _, want := range test.wantContains {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("body does not contain %q:\n%s", want, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRecordListeningPorts(t *testing.T) {
+	s := new(Server)
+	s.recordListeningPorts(8443, 443)
+	s.recordListeningPorts(80, 443)
+	if got, want := s.listeningPorts(), []int{80, 443, 8443}; !slices.Equal(got, want) {
+		t.Errorf("got ports %v, want %v", got, want)
+	}
+}
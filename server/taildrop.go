@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+
+	// Register the Taildrop extension which is not part of tsnet by default.
+	_ "tailscale.com/feature/taildrop"
+)
+
+// taildropRetryInterval is the time to wait before retrying after failing to
+// list or deliver the received files.
+const taildropRetryInterval = 5 * time.Second
+
+// TaildropFile describes a file received with Taildrop.
+type TaildropFile struct {
+	// Name is the name of the file chosen by the sender.
+	Name string
+
+	// Path is the path the file is delivered to. It differs from the name
+	// if a file with the same name already exists.
+	Path string
+
+	// Size is the size of the file in bytes.
+	Size int64
+}
+
+// TaildropConfig configures the delivery of files received with Taildrop.
+type TaildropConfig struct {
+	// Directory is where received files are delivered to. It has to exist.
+	Directory string
+
+	// OnReceive, if set, is called after each file is delivered.
+	OnReceive func(file TaildropFile)
+}
+
+// taildropClient is the part of the local client used to receive files.
+type taildropClient interface {
+	AwaitWaitingFiles(ctx context.Context, d time.Duration) ([]apitype.WaitingFile, error)
+	GetWaitingFile(ctx context.Context, baseName string) (io.ReadCloser, int64, error)
+	DeleteWaitingFile(ctx context.Context, baseName string) error
+}
+
+// ReceiveTaildrop delivers files sent to this node with Taildrop to the
+// configured directory until ctx is cancelled. Files are only accepted if
+// the tailnet policy grants file sharing to the senders.
+func (s *Server) ReceiveTaildrop(ctx context.Context, config *TaildropConfig) error {
+	if err := validateTaildropConfig(config); err != nil {
+		return err
+	}
+	if s.localMode() {
+		return ErrLocalMode
+	}
+	return receiveTaildrop(ctx, s.tsClient, config, s.logger)
+}
+
+func validateTaildropConfig(config *TaildropConfig) error {
+	if config == nil {
+		return fmt.Errorf("taildrop configuration is required")
+	}
+	if config.Directory == "" {
+		return fmt.Errorf("taildrop directory is required")
+	}
+	info, err := os.Stat(config.Directory)
+	if err != nil {
+		return fmt.Errorf("failed to access taildrop directory [%s]: %w", config.Directory, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("taildrop directory [%s] is not a directory", config.Directory)
+	}
+	return nil
+}
+
+// receiveTaildrop waits for files and delivers them until ctx is cancelled.
+func receiveTaildrop(ctx context.Context, client taildropClient, config *TaildropConfig, logger *logger) error {
+	for {
+		files, err := client.AwaitWaitingFiles(ctx, time.Hour)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		failed := err != nil
+		if err != nil {
+			logger.logf(slog.LevelWarn, "failed to list received taildrop files: %v", err)
+		}
+		for _, waiting := range files {
+			file, err := deliverTaildropFile(ctx, client, config.Directory, waiting.Name)
+			if err != nil {
+				logger.logf(slog.LevelError, "failed to deliver taildrop file [%s]: %v", waiting.Name, err)
+				failed = true
+				continue
+			}
+			logger.logf(slog.LevelInfo, "received taildrop file [%s] of %d bytes", file.Name, file.Size)
+			if config.OnReceive != nil {
+				config.OnReceive(file)
+			}
+		}
+		// Files which failed to be delivered are still waiting so they would
+		// be returned again immediately.
+		if failed {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(taildropRetryInterval):
+			}
+		}
+	}
+}
+
+// deliverTaildropFile copies a received file into directory and removes it
+// from the waiting files.
+func deliverTaildropFile(ctx context.Context, client taildropClient, directory, name string) (TaildropFile, error) {
+	base := filepath.Base(name)
+	if base != name || strings.HasPrefix(base, ".") {
+		return TaildropFile{}, fmt.Errorf("invalid file name")
+	}
+	reader, _, err := client.GetWaitingFile(ctx, name)
+	if err != nil {
+		return TaildropFile{}, err
+	}
+	defer func() { _ = reader.Close() }()
+
+	temp, err := os.CreateTemp(directory, ".taildrop-*")
+	if err != nil {
+		return TaildropFile{}, err
+	}
+	defer func() { _ = os.Remove(temp.Name()) }()
+	size, err := io.Copy(temp, reader)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return TaildropFile{}, err
+	}
+	path, err := linkUnique(temp.Name(), directory, name)
+	if err != nil {
+		return TaildropFile{}, err
+	}
+	if err := client.DeleteWaitingFile(ctx, name); err != nil {
+		return TaildropFile{}, fmt.Errorf("file was delivered to [%s] but could not be removed from the waiting files: %w", path, err)
+	}
+	return TaildropFile{Name: name, Path: path, Size: size}, nil
+}
+
+// linkUnique links the file at source into directory under name, or under
+// "name (n).ext" if name is taken, and returns the path of the link.
+func linkUnique(source, directory, name string) (string, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 0; i < 100; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = stem + " (" + strconv.Itoa(i) + ")" + ext
+		}
+		path := filepath.Join(directory, candidate)
+		err := os.Link(source, path)
+		if err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("too many files named [%s]", name)
+}
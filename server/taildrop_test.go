@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+type fakeTaildropClient struct {
+	mu      sync.Mutex
+	files   map[string]string
+	listed  bool
+	deleted []string
+}
+
+func (c *fakeTaildropClient) AwaitWaitingFiles(ctx context.Context, _ time.Duration) ([]apitype.WaitingFile, error) {
+	c.mu.Lock()
+	if !c.listed {
+		c.listed = true
+		var files []apitype.WaitingFile
+		for name, content := range c.files {
+			files = append(files, apitype.WaitingFile{Name: name, Size: int64(len(content))})
+		}
+		c.mu.Unlock()
+		return files, nil
+	}
+	c.mu.Unlock()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c *fakeTaildropClient) GetWaitingFile(_ context.Context, name string) (io.ReadCloser, int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	content, found := c.files[name]
+	if !found {
+		return nil, 0, errors.New("file not found")
+	}
+	return io.NopCloser(strings.NewReader(content)), int64(len(content)), nil
+}
+
+func (c *fakeTaildropClient) DeleteWaitingFile(_ context.Context, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleted = append(c.deleted, name)
+	return nil
+}
+
+func TestReceiveTaildrop(t *testing.T) {
+	directory := t.TempDir()
+	if err := os.WriteFile(filepath.Join(directory, "report.pdf"), []byte("existing"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client := &fakeTaildropClient{files: map[string]string{
+		"report.pdf": "report",
+		"notes.txt":  "notes",
+		"../escape":  "escape",
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan TaildropFile, 3)
+	config := &TaildropConfig{
+		Directory: directory,
+		OnReceive: func(file TaildropFile) { received <- file },
+	}
+	done := make(chan error)
+	go func() { done <- receiveTaildrop(ctx, client, config, newLogger(t.Logf, slog.LevelInfo)) }()
+
+	got := make(map[string]TaildropFile)
+	for range 2 {
+		select {
+		case file := <-received:
+			got[file.Name] = file
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for files")
+		}
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+
+	tests := []struct {
+		name        string
+		wantPath    string
+		wantContent string
+	}{
+		{name: "report.pdf", wantPath: filepath.Join(directory, "report (1).pdf"), wantContent: "report"},
+		{name: "notes.txt", wantPath: filepath.Join(directory, "notes.txt"), wantContent: "notes"},
+	}
+	for _, test := range tests {
+		file, found := got[test.name]
+		if !found {
+			t.Errorf("file [%s] was not received", test.name)
+			continue
+		}
+		if file.Path != test.wantPath {
+			t.Errorf("got path %s, want %s", file.Path, test.wantPath)
+		}
+		content, err := os.ReadFile(file.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != test.wantContent || file.Size != int64(len(test.wantContent)) {
+			t.Errorf("got content %q of size %d, want %q", content, file.Size, test.wantContent)
+		}
+	}
+	if len(client.deleted) != 2 {
+		t.Errorf("got deleted files %v, want 2 files", client.deleted)
+	}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("got %d entries in directory, want 3", len(entries))
+	}
+}
+
+func TestValidateTaildropConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		config  *TaildropConfig
+		wantErr bool
+	}{
+		{name: "valid", config: &TaildropConfig{Directory: t.TempDir()}},
+		{name: "nil", config: nil, wantErr: true},
+		{name: "no directory", config: &TaildropConfig{}, wantErr: true},
+		{name: "missing directory", config: &TaildropConfig{Directory: filepath.Join(t.TempDir(), "missing")}, wantErr: true},
+		{name: "file", config: &TaildropConfig{Directory: file}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateTaildropConfig(test.config)
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
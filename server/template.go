@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"net/http"
+)
+
+// layoutTemplate is the base layout of NewLayout. Pages define the templates
+// "title" and "content", and may override "head".
+const layoutTemplate = `{{define "layout"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{block "title" .}}{{end}}</title>
+{{block "head" .}}<style>
+body { font-family: system-ui, sans-serif; margin: 0; }
+header { display: flex; justify-content: flex-end; padding: 0.5rem 1rem; background: #f4f4f5; font-size: 0.875rem; }
+main { padding: 1rem; }
+</style>{{end}}
+</head>
+<body>
+<header>{{with .Caller}}{{if .IsFunnel}}Public visitor{{else}}Signed in as <strong title="{{.LoginName}}">{{if .DisplayName}}{{.DisplayName}}{{else}}{{.LoginName}}{{end}}</strong>{{with .MachineName}} from {{.}}{{end}}{{end}}{{end}}</header>
+<main>{{block "content" .}}{{end}}</main>
+</body>
+</html>
+{{end}}`
+
+// PageData is passed to templates executed by Render.
+type PageData struct {
+	// Caller is the identity of the caller, or nil if it is not known.
+	Caller *CallerIdentity
+
+	// Data is the data passed to Render.
+	Data any
+}
+
+// NewLayout returns a template holding the base layout "layout", which shows
+// the caller of the page in a header. Pages are added by parsing templates
+// named "title" and "content" into a clone of it, and are rendered with
+// Render and the name "layout".
+func NewLayout() *template.Template {
+	return template.Must(template.New("layout").Parse(layoutTemplate))
+}
+
+// Render executes the template name of t with PageData holding the identity
+// of the caller and data, and writes the result as HTML. The identity is read
+// from the request context so the handler has to be wrapped by
+// Server.WithIdentity as well for pages to show the caller. The response is
+// only written if the template executes successfully; status 500 is returned
+// otherwise.
+func Render(w http.ResponseWriter, r *http.Request, t *template.Template, name string, data any) {
+	page := PageData{Data: data}
+	if caller, found := CallerIdentityFromContext(r.Context()); found {
+		page.Caller = caller
+	} else if IsFunnelRequest(r) {
+		page.Caller = &CallerIdentity{IsFunnel: true}
+	}
+	var b bytes.Buffer
+	if err := t.ExecuteTemplate(&b, name, page); err != nil {
+		logf(slog.LevelError, "failed to render template [%s]: %v", name, err)
+		http.Error(w, "failed to render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = b.WriteTo(w)
+}
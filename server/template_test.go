@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	page := template.Must(NewLayout().Parse(`{{define "title"}}Builds{{end}}{{define "content"}}<p>{{.Data}}</p>{{end}}`))
+	alice := newTestWhoIs("alice@example.com")
+	alice.UserProfile.DisplayName = "Alice <Admin>"
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		templateName string
+		data         any
+		wantStatus   int
+		wantContains []string
+	}{
+		{
+			name:         "caller",
+			ctx:          ContextWithIdentity(context.Background(), alice),
+			templateName: "layout",
+			data:         "3 running",
+			wantStatus:   http.StatusOK,
+			wantContains: []string{"<title>Builds</title>", "Signed in as <strong title=\"alice@example.com\">Alice &lt;Admin&gt;</strong> from test-node", "<p>3 running</p>"},
+		},
+		{
+			name:         "funnel",
+			ctx:          context.WithValue(context.Background(), funnelContextKey{}, netip.MustParseAddrPort("203.0.113.1:1234")),
+			templateName: "layout",
+			wantStatus:   http.StatusOK,
+			wantContains: []string{"Public visitor"},
+		},
+		{
+			name:         "missing template",
+			ctx:          context.Background(),
+			templateName: "missing",
+			wantStatus:   http.StatusInternalServerError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(test.ctx)
+			w := httptest.NewRecorder()
+			Render(w, r, page, test.templateName, test.data)
+			if w.Code != test.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, test.wantStatus)
+			}
+			for _, want := range test.wantContains {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("body does not contain %q:\n%s", want, w.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Timeout returns a middleware answering requests whose handler does not
+// finish within d with status 503, as http.TimeoutHandler does, and
+// cancelling their context so that slow upstreams cannot pile up goroutines.
+// Long-lived requests, which are protocol upgrades such as WebSocket, event
+// streams and gRPC calls, are exempt as they are expected to outlive any
+// deadline and need to flush their responses. It suits per-route use with
+// Router.Handle.
+func Timeout(d time.Duration) Middleware {
+	return func(h http.Handler) http.Handler {
+		timeout := http.TimeoutHandler(h, d, "request timed out")
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isStreamingRequest(r) || strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+				h.ServeHTTP(w, r)
+				return
+			}
+			timeout.ServeHTTP(w, r)
+		})
+	}
+}
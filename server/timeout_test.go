@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		wantCode int
+	}{
+		{name: "slow request", wantCode: http.StatusServiceUnavailable},
+		{name: "event stream", headers: map[string]string{"Accept": "text/event-stream"}, wantCode: http.StatusOK},
+		{name: "websocket", headers: map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"}, wantCode: http.StatusOK},
+		{name: "gRPC", headers: map[string]string{"Content-Type": "application/grpc+proto"}, wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			Timeout(20*time.Millisecond)(slow).ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got status %d; want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
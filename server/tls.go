@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+var errHTTPSNotEnabled = errors.New("HTTPS is not enabled for this tailnet; see https://tailscale.com/s/https")
+
+// listenTLS listens on the specified address on the tailnet and wraps the
+// listener with the TLS configuration of the server.
+func (s *Server) listenTLS(addr string) (net.Listener, error) {
+	if s.selfSignedCertificate == nil && len(s.certDomains) == 0 {
+		return nil, errHTTPSNotEnabled
+	}
+	listener, err := s.listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(listener, s.tlsConfig), nil
+}
+
+// WarmCertificates fetches the TLS certificates of all certificate domains of
+// this node so that they are cached before the first TLS handshake. It returns
+// an error if any of the certificates cannot be obtained. It does nothing
+// other than recording the expiry for a self-signed certificate.
+func (s *Server) WarmCertificates(ctx context.Context) error {
+	if s.selfSignedCertificate != nil {
+		leaf, err := leafCertificate(s.selfSignedCertificate)
+		if err != nil {
+			return fmt.Errorf("failed to parse self-signed certificate: %w", err)
+		}
+		s.certificates.record(s.fqdn, leaf.NotAfter)
+		return nil
+	}
+	if len(s.certDomains) == 0 {
+		return errHTTPSNotEnabled
+	}
+	for _, domain := range s.certDomains {
+		certPEM, keyPEM, err := s.tsClient.CertPair(ctx, domain)
+		if err != nil {
+			return fmt.Errorf("failed to fetch certificate of [%s]: %w", domain, err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate of [%s]: %w", domain, err)
+		}
+		leaf, err := leafCertificate(&cert)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate of [%s]: %w", domain, err)
+		}
+		s.certificates.record(domain, leaf.NotAfter)
+		s.logger.log(slog.LevelInfo, "certificate_ready", fmt.Sprintf("certificate of [%s] is ready", domain), "domain", domain, "not_after", leaf.NotAfter)
+	}
+	return nil
+}
+
+// tlsConfigFromTemplate returns a copy of the specified TLS configuration
+// template with certificate selection delegated to getCertificate. If template
+// is nil, a configuration with default settings is returned.
+func tlsConfigFromTemplate(template *tls.Config, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	var config *tls.Config
+	if template == nil {
+		config = &tls.Config{}
+	} else {
+		config = template.Clone()
+	}
+	config.Certificates = nil
+	config.GetCertificate = getCertificate
+	return config
+}
+
+// chainGetCertificate returns a certificate selection function which consults
+// custom first and uses fallback if custom is nil or it does not return a
+// certificate.
+func chainGetCertificate(custom, fallback func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if custom == nil {
+		return fallback
+	}
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := custom(hello)
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil {
+			return cert, nil
+		}
+		return fallback(hello)
+	}
+}
+
+// applyClientCertificateConfig configures config to request and verify client
+// certificates against clientCAs. It does nothing if clientCAs is nil.
+func applyClientCertificateConfig(config *tls.Config, clientCAs *x509.CertPool, optional bool) {
+	if clientCAs == nil {
+		return
+	}
+	config.ClientCAs = clientCAs
+	if optional {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	} else {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+}
+
+// VerifiedClientChains returns the verified certificate chains of the client
+// certificate presented in the TLS handshake of the request. The first
+// element of each chain is the client certificate. It returns nil if no
+// certificate has been verified.
+func VerifiedClientChains(r *http.Request) [][]*x509.Certificate {
+	if r.TLS == nil {
+		return nil
+	}
+	return r.TLS.VerifiedChains
+}
+
+// VerifiedClientCertificate returns the verified client certificate of the
+// request.
+func VerifiedClientCertificate(r *http.Request) (*x509.Certificate, bool) {
+	chains := VerifiedClientChains(r)
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil, false
+	}
+	return chains[0][0], true
+}
+
+// RequireClientCertificate wraps the provided handler and rejects requests
+// without a verified client certificate with status 403.
+func RequireClientCertificate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := VerifiedClientCertificate(r); !ok {
+			authDenied(r, "missing client certificate")
+			http.Error(w, "a verified client certificate is required", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
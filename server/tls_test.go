@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTLSConfigFromTemplate(t *testing.T) {
+	cert := &tls.Certificate{}
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return cert, nil
+	}
+
+	t.Run("nil template", func(t *testing.T) {
+		config := tlsConfigFromTemplate(nil, getCertificate)
+		if config.GetCertificate == nil {
+			t.Fatal("GetCertificate is not set")
+		}
+		if config.MinVersion != 0 {
+			t.Errorf("got MinVersion %d; want 0", config.MinVersion)
+		}
+	})
+
+	t.Run("template settings are kept", func(t *testing.T) {
+		template := &tls.Config{
+			MinVersion:       tls.VersionTLS13,
+			CurvePreferences: []tls.CurveID{tls.X25519},
+			NextProtos:       []string{"h2", "http/1.1"},
+			Certificates:     []tls.Certificate{{}},
+		}
+		config := tlsConfigFromTemplate(template, getCertificate)
+		if config == template {
+			t.Fatal("template is not copied")
+		}
+		if config.MinVersion != tls.VersionTLS13 {
+			t.Errorf("got MinVersion %d; want %d", config.MinVersion, tls.VersionTLS13)
+		}
+		if len(config.NextProtos) != 2 || len(config.CurvePreferences) != 1 {
+			t.Errorf("got NextProtos %v and CurvePreferences %v", config.NextProtos, config.CurvePreferences)
+		}
+		if len(config.Certificates) != 0 {
+			t.Errorf("got %d certificates; want 0", len(config.Certificates))
+		}
+		if template.GetCertificate != nil || len(template.Certificates) != 1 {
+			t.Error("template is modified")
+		}
+		got, err := config.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil || got != cert {
+			t.Errorf("GetCertificate returned %v, %v", got, err)
+		}
+	})
+}
+
+func TestChainGetCertificate(t *testing.T) {
+	customCert := &tls.Certificate{}
+	fallbackCert := &tls.Certificate{}
+	errCustom := errors.New("custom error")
+	fallback := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return fallbackCert, nil
+	}
+	custom := func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		switch hello.ServerName {
+		case "internal.example.com":
+			return customCert, nil
+		case "broken.example.com":
+			return nil, errCustom
+		}
+		return nil, nil
+	}
+
+	tests := []struct {
+		serverName string
+		wantCert   *tls.Certificate
+		wantErr    error
+	}{
+		{
+			serverName: "internal.example.com",
+			wantCert:   customCert,
+		},
+		{
+			serverName: "broken.example.com",
+			wantErr:    errCustom,
+		},
+		{
+			serverName: "test-hostname.prawn-universe.ts.net",
+			wantCert:   fallbackCert,
+		},
+	}
+	getCertificate := chainGetCertificate(custom, fallback)
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			cert, err := getCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v; want %v", err, tt.wantErr)
+			}
+			if cert != tt.wantCert {
+				t.Errorf("got certificate %p; want %p", cert, tt.wantCert)
+			}
+		})
+	}
+}
+
+func TestApplyClientCertificateConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		clientCAs      *x509.CertPool
+		optional       bool
+		wantClientAuth tls.ClientAuthType
+	}{
+		{
+			name:           "no client certificate authorities",
+			clientCAs:      nil,
+			wantClientAuth: tls.NoClientCert,
+		},
+		{
+			name:           "required client certificate",
+			clientCAs:      x509.NewCertPool(),
+			wantClientAuth: tls.RequireAndVerifyClientCert,
+		},
+		{
+			name:           "optional client certificate",
+			clientCAs:      x509.NewCertPool(),
+			optional:       true,
+			wantClientAuth: tls.VerifyClientCertIfGiven,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &tls.Config{}
+			applyClientCertificateConfig(config, tt.clientCAs, tt.optional)
+			if config.ClientAuth != tt.wantClientAuth {
+				t.Errorf("got ClientAuth %v; want %v", config.ClientAuth, tt.wantClientAuth)
+			}
+			if config.ClientCAs != tt.clientCAs {
+				t.Error("ClientCAs is not set")
+			}
+		})
+	}
+}
+
+func TestRequireClientCertificate(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    *tls.ConnectionState
+		wantCode int
+	}{
+		{
+			name:     "plaintext request",
+			state:    nil,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "no verified chain",
+			state:    &tls.ConnectionState{},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "verified chain",
+			state: &tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{{}}},
+			},
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.TLS = tt.state
+			w := httptest.NewRecorder()
+			RequireClientCertificate(serveHandler()).ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d; want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestWarmCertificates(t *testing.T) {
+	selfSigned, err := newSelfSignedCertificate([]string{"localhost"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		selfSigned   *tls.Certificate
+		wantErr      error
+		wantNotAfter map[string]time.Time
+	}{
+		{
+			name:         "self-signed certificate",
+			selfSigned:   selfSigned,
+			wantNotAfter: map[string]time.Time{"localhost": selfSigned.Leaf.NotAfter},
+		},
+		{
+			name:         "HTTPS not enabled",
+			wantErr:      errHTTPSNotEnabled,
+			wantNotAfter: map[string]time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := newLogger(t.Logf, slog.LevelInfo)
+			s := &Server{
+				fqdn:                  "localhost",
+				selfSignedCertificate: tt.selfSigned,
+				certificates:          newCertificateTracker(0, logger),
+				logger:                logger,
+			}
+			if err := s.WarmCertificates(context.Background()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if got := s.certificates.snapshot(); !maps.Equal(got, tt.wantNotAfter) {
+				t.Errorf("got expiry %v; want %v", got, tt.wantNotAfter)
+			}
+		})
+	}
+}
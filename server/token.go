@@ -0,0 +1,142 @@
+package server
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HeaderIdentityToken is the header carrying the token set by
+// TokenIssuer.InjectToken unless TokenIssuerConfig.Header is set.
+const HeaderIdentityToken = "Tailscale-Identity-Token"
+
+// DefaultIdentityTokenLifetime is the lifetime of tokens issued by
+// TokenIssuer unless TokenIssuerConfig.Lifetime is set.
+const DefaultIdentityTokenLifetime = 5 * time.Minute
+
+// IdentityClaims are the claims of identity tokens issued by TokenIssuer.
+type IdentityClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  string   `json:"aud,omitempty"`
+	Expiry    int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	LoginName string   `json:"login"`
+	NodeName  string   `json:"node"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// TokenIssuerConfig is the configuration of a TokenIssuer.
+type TokenIssuerConfig struct {
+	// Issuer is the value of the iss claim of tokens.
+	Issuer string
+
+	// Audience, if set, is the value of the aud claim of tokens.
+	Audience string
+
+	// SigningKey is the key signing tokens. A key is generated if it is nil.
+	SigningKey *rsa.PrivateKey
+
+	// Lifetime is the lifetime of tokens. It defaults to
+	// DefaultIdentityTokenLifetime.
+	Lifetime time.Duration
+
+	// Header is the request header carrying tokens. It defaults to
+	// HeaderIdentityToken.
+	Header string
+}
+
+// TokenIssuer issues short-lived JSON web tokens signed with RS256 which
+// carry the tailnet identity of callers so that services behind a proxy,
+// possibly off this node, can verify the identity cryptographically using
+// the keys served by JWKSHandler.
+type TokenIssuer struct {
+	issuer   string
+	audience string
+	lifetime time.Duration
+	header   string
+	signer   *jwtSigner
+}
+
+// NewTokenIssuer creates a TokenIssuer.
+func NewTokenIssuer(config *TokenIssuerConfig) (*TokenIssuer, error) {
+	if config.Issuer == "" {
+		return nil, fmt.Errorf("token issuer cannot be empty")
+	}
+	signer, err := newJWTSigner(config.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	ti := &TokenIssuer{
+		issuer:   config.Issuer,
+		audience: config.Audience,
+		lifetime: config.Lifetime,
+		header:   config.Header,
+		signer:   signer,
+	}
+	if ti.lifetime == 0 {
+		ti.lifetime = DefaultIdentityTokenLifetime
+	}
+	if ti.header == "" {
+		ti.header = HeaderIdentityToken
+	}
+	return ti, nil
+}
+
+// InjectToken wraps the provided handler, typically a reverse proxy, and
+// replaces the token header of requests with a token carrying the identity
+// of the caller. Tokens supplied by the caller are always removed. The
+// handler has to be wrapped by Server.WithIdentity as well.
+func (ti *TokenIssuer) InjectToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+		r.Header.Del(ti.header)
+		who, found := IdentityFromContext(r.Context())
+		if found && who.Node != nil && who.UserProfile != nil {
+			now := time.Now()
+			token, err := ti.signer.sign(IdentityClaims{
+				Issuer:    ti.issuer,
+				Subject:   who.UserProfile.LoginName,
+				Audience:  ti.audience,
+				Expiry:    now.Add(ti.lifetime).Unix(),
+				IssuedAt:  now.Unix(),
+				LoginName: who.UserProfile.LoginName,
+				NodeName:  strings.TrimSuffix(who.Node.Name, "."),
+				Tags:      who.Node.Tags,
+			})
+			if err != nil {
+				logf(slog.LevelError, "failed to issue identity token: %v", err)
+				http.Error(w, "failed to issue identity token", http.StatusInternalServerError)
+				return
+			}
+			r.Header.Set(ti.header, token)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Verify verifies a token issued by ti and returns its claims.
+func (ti *TokenIssuer) Verify(token string) (*IdentityClaims, error) {
+	claims := new(IdentityClaims)
+	if err := ti.signer.verify(token, claims); err != nil {
+		return nil, err
+	}
+	if claims.Issuer != ti.issuer || claims.Audience != ti.audience {
+		return nil, errInvalidToken
+	}
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, fmt.Errorf("token has expired: %w", errInvalidToken)
+	}
+	return claims, nil
+}
+
+// JWKSHandler returns a handler serving the JSON web key set for verifying
+// tokens issued by ti.
+func (ti *TokenIssuer) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, ti.signer.jwks())
+	})
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestTokenIssuerInjectToken(t *testing.T) {
+	issuer, err := NewTokenIssuer(&TokenIssuerConfig{Issuer: "https://proxy.prawn-universe.ts.net", Audience: "backend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var token string
+	h := issuer.InjectToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get(HeaderIdentityToken)
+	}))
+
+	who := newTestWhoIs("alice@example.com")
+	who.Node.Tags = []string{"tag:web"}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(HeaderIdentityToken, "forged")
+	r = r.WithContext(ContextWithIdentity(r.Context(), who))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	claims, err := issuer.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.LoginName != "alice@example.com" || claims.NodeName != "test-node.prawn-universe.ts.net" || !slices.Equal(claims.Tags, who.Node.Tags) {
+		t.Errorf("got claims %+v", claims)
+	}
+
+	t.Run("no identity", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(HeaderIdentityToken, "forged")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if token != "" {
+			t.Errorf("got token %q; want none", token)
+		}
+	})
+
+	t.Run("token of another issuer", func(t *testing.T) {
+		other, err := NewTokenIssuer(&TokenIssuerConfig{Issuer: "https://other.prawn-universe.ts.net"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Verify(claimsToken(t, issuer, claims)); err == nil {
+			t.Error("token of another issuer is accepted")
+		}
+	})
+}
+
+func claimsToken(t *testing.T, issuer *TokenIssuer, claims *IdentityClaims) string {
+	t.Helper()
+	token, err := issuer.signer.sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// tracerName is the name of the tracer of the spans of this package.
+const tracerName = "github.com/alexhokl/privateserver/server"
+
+// TracingOptions configures the middleware returned by Tracing.
+type TracingOptions struct {
+	// TracerProvider creates the tracer of the spans. It defaults to the
+	// global TracerProvider of OpenTelemetry.
+	TracerProvider trace.TracerProvider
+
+	// Propagator extracts the trace context of inbound requests and injects
+	// it into requests forwarded by ReverseProxy. It defaults to the W3C
+	// Trace Context propagator reading and writing traceparent headers.
+	Propagator propagation.TextMapPropagator
+}
+
+// tracingPropagatorContextKey is the context key of the propagator of the
+// request being traced.
+type tracingPropagatorContextKey struct{}
+
+// Tracing returns a middleware tracing requests with OpenTelemetry. Each
+// request gets a server span, continuing the trace of an inbound traceparent
+// header, named by the route pattern once a Router or http.ServeMux has
+// matched it. Server.WithIdentity inside the middleware adds the login name,
+// node and tags of the caller to the span and traces the WhoIs lookup in a
+// child span, and ReverseProxy traces the upstream request in a child span
+// and propagates the trace to the upstream server. Place it right after
+// RequestID so that the spans cover the rest of the chain.
+func Tracing(opts TracingOptions) Middleware {
+	provider := opts.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	propagator := opts.Propagator
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
+	tracer := provider.Tracer(tracerName)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx = context.WithValue(ctx, tracingPropagatorContextKey{}, propagator)
+			ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("server.address", r.Host),
+				attribute.String("client.address", remoteIP(r)),
+			))
+			defer span.End()
+			if id, found := RequestIDFromContext(ctx); found {
+				span.SetAttributes(attribute.String("http.request.id", id))
+			}
+
+			r, info := withRequestInfo(r.WithContext(ctx))
+			recorder := newStatusRecorder(w)
+			h.ServeHTTP(recorder, r)
+
+			status := recorder.Status()
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+			if pattern := info.pattern(r); pattern != "" {
+				span.SetName(spanName(r.Method, pattern))
+				span.SetAttributes(attribute.String("http.route", pattern))
+			}
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+		})
+	}
+}
+
+// spanName returns the name of the span of a request matching the pattern,
+// such as "GET /items/{id}", adding the method of the request to patterns
+// without one.
+func spanName(method, pattern string) string {
+	if strings.Contains(pattern, " ") {
+		return pattern
+	}
+	return method + " " + pattern
+}
+
+// identityAttributes returns the span attributes of a caller.
+func identityAttributes(who *apitype.WhoIsResponse) []attribute.KeyValue {
+	var attributes []attribute.KeyValue
+	if who.UserProfile != nil {
+		attributes = append(attributes, attribute.String("tailscale.login_name", who.UserProfile.LoginName))
+	}
+	if who.Node != nil {
+		attributes = append(attributes, attribute.String("tailscale.node_name", strings.TrimSuffix(who.Node.Name, ".")))
+		if len(who.Node.Tags) > 0 {
+			attributes = append(attributes, attribute.StringSlice("tailscale.tags", who.Node.Tags))
+		}
+	}
+	return attributes
+}
+
+// startChildSpan starts a span under the span of ctx with the tracer provider
+// of that span, so that nothing is recorded for requests which are not
+// traced.
+func startChildSpan(ctx context.Context, name string, kind trace.SpanKind, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.SpanContext().IsValid() {
+		return ctx, parent
+	}
+	return parent.TracerProvider().Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attributes...))
+}
+
+// tracingTransport traces requests to upstream servers in client spans and
+// propagates the trace to them.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	propagator, traced := r.Context().Value(tracingPropagatorContextKey{}).(propagation.TextMapPropagator)
+	if !traced {
+		return next.RoundTrip(r)
+	}
+	ctx, span := startChildSpan(r.Context(), "proxy "+r.Method, trace.SpanKindClient,
+		attribute.String("http.request.method", r.Method),
+		attribute.String("server.address", r.URL.Host),
+		attribute.String("url.full", r.URL.String()),
+	)
+	defer span.End()
+	r = r.Clone(ctx)
+	propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+	resp, err := next.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
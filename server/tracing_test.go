@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// testTracerProvider records the spans of its tracers.
+type testTracerProvider struct {
+	embedded.TracerProvider
+
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (p *testTracerProvider) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return testTracer{provider: p}
+}
+
+// span returns the span with the specified name.
+func (p *testTracerProvider) span(t *testing.T, name string) *testSpan {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, span := range p.spans {
+		if span.name == name {
+			return span
+		}
+	}
+	t.Fatalf("got no span [%s]", name)
+	return nil
+}
+
+type testTracer struct {
+	embedded.Tracer
+	provider *testTracerProvider
+}
+
+func (t testTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(opts...)
+	parent := trace.SpanContextFromContext(ctx)
+	traceID := parent.TraceID()
+	if !traceID.IsValid() {
+		_, _ = rand.Read(traceID[:])
+	}
+	var spanID trace.SpanID
+	_, _ = rand.Read(spanID[:])
+	span := &testSpan{
+		provider: t.provider,
+		name:     name,
+		kind:     config.SpanKind(),
+		parent:   parent,
+		sc: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     spanID,
+			TraceFlags: trace.FlagsSampled,
+		}),
+		attributes: config.Attributes(),
+	}
+	t.provider.mu.Lock()
+	t.provider.spans = append(t.provider.spans, span)
+	t.provider.mu.Unlock()
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type testSpan struct {
+	noop.Span
+	provider *testTracerProvider
+
+	name       string
+	kind       trace.SpanKind
+	parent     trace.SpanContext
+	sc         trace.SpanContext
+	attributes []attribute.KeyValue
+	status     codes.Code
+	ended      bool
+}
+
+func (s *testSpan) SpanContext() trace.SpanContext       { return s.sc }
+func (s *testSpan) IsRecording() bool                    { return !s.ended }
+func (s *testSpan) SetName(name string)                  { s.name = name }
+func (s *testSpan) SetStatus(code codes.Code, _ string)  { s.status = code }
+func (s *testSpan) End(...trace.SpanEndOption)           { s.ended = true }
+func (s *testSpan) TracerProvider() trace.TracerProvider { return s.provider }
+func (s *testSpan) SetAttributes(attributes ...attribute.KeyValue) {
+	s.attributes = append(s.attributes, attributes...)
+}
+
+func (s *testSpan) attribute(key attribute.Key) attribute.Value {
+	for _, kv := range slices.Backward(s.attributes) {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing(t *testing.T) {
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("Traceparent")
+	}))
+	defer upstream.Close()
+	proxy, err := NewReverseProxy(ProxyRoute{Pattern: "/items/", Upstream: upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	whoIs := func(context.Context, string) (*apitype.WhoIsResponse, error) {
+		return newTestWhoIs("alice@example.com"), nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /items/{id}", withIdentity(identifyByRemoteAddr(whoIs), proxy))
+	provider := new(testTracerProvider)
+	h := Tracing(TracingOptions{TracerProvider: provider})(mux)
+
+	r := httptest.NewRequest("GET", "/items/42", nil)
+	r.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d; want %d", w.Code, http.StatusOK)
+	}
+
+	server := provider.span(t, "GET /items/{id}")
+	if got := server.sc.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("got trace ID %s; want the inbound trace ID", got)
+	}
+	if server.kind != trace.SpanKindServer || !server.ended {
+		t.Errorf("got server span of kind %v, ended %t", server.kind, server.ended)
+	}
+	for key, want := range map[attribute.Key]string{
+		"tailscale.login_name": "alice@example.com",
+		"tailscale.node_name":  "test-node.prawn-universe.ts.net",
+		"http.route":           "GET /items/{id}",
+	} {
+		if got := server.attribute(key).AsString(); got != want {
+			t.Errorf("got %s %q; want %q", key, got, want)
+		}
+	}
+	if got := server.attribute("http.response.status_code").AsInt64(); got != http.StatusOK {
+		t.Errorf("got status code attribute %d; want %d", got, http.StatusOK)
+	}
+
+	for _, name := range []string{"tailscale.WhoIs", "proxy GET"} {
+		child := provider.span(t, name)
+		if child.parent.SpanID() != server.sc.SpanID() || !child.ended {
+			t.Errorf("got span [%s] with parent %s, ended %t; want a child of the server span", name, child.parent.SpanID(), child.ended)
+		}
+	}
+	proxySpan := provider.span(t, "proxy GET")
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + proxySpan.sc.SpanID().String() + "-01"
+	if upstreamTraceparent != want {
+		t.Errorf("got upstream traceparent %q; want %q", upstreamTraceparent, want)
+	}
+}
+
+func TestTracingServerError(t *testing.T) {
+	provider := new(testTracerProvider)
+	h := Tracing(TracingOptions{TracerProvider: provider})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	span := provider.span(t, "POST")
+	if span.status != codes.Error {
+		t.Errorf("got status %v; want %v", span.status, codes.Error)
+	}
+}
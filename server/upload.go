@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultMaxUploadSize is the maximum size of an uploaded file if
+// UploadOptions.MaxFileSize is not set.
+const DefaultMaxUploadSize = 32 << 20
+
+// UploadOptions configures a handler created by UploadHandler.
+type UploadOptions struct {
+	// MaxFileSize is the maximum size of an uploaded file in bytes. It
+	// defaults to DefaultMaxUploadSize.
+	MaxFileSize int64
+
+	// AllowedTypes are the media types of files which may be uploaded, such
+	// as "application/pdf" or "image/*". The type is detected from the
+	// content of a file rather than taken from the request. Any type is
+	// allowed if it is empty.
+	AllowedTypes []string
+}
+
+// UploadedFile describes a file stored by a handler created by
+// UploadHandler.
+type UploadedFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// UploadHandler returns a handler storing files uploaded by callers in a
+// directory of dir per login name. A POST request with a multipart form
+// stores every file of the form and answers with status 201 and the stored
+// files as JSON. A GET request lists the files uploaded by the caller as JSON.
+// Files with the same name are replaced and all tagged nodes share the
+// directory "tagged-devices". The identity of the caller is read
+// from the request context so the handler has to be wrapped by
+// Server.WithIdentity as well.
+func UploadHandler(dir string, opts UploadOptions) (http.Handler, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload directory: %w", err)
+	}
+	maxFileSize := opts.MaxFileSize
+	if maxFileSize == 0 {
+		maxFileSize = DefaultMaxUploadSize
+	}
+	if maxFileSize < 0 {
+		return nil, fmt.Errorf("maximum upload size must not be negative")
+	}
+	for _, allowed := range opts.AllowedTypes {
+		if _, _, err := mime.ParseMediaType(allowed); err != nil {
+			return nil, fmt.Errorf("invalid media type [%s]: %w", allowed, err)
+		}
+	}
+	return &uploadHandler{
+		root:         root,
+		maxFileSize:  maxFileSize,
+		allowedTypes: opts.AllowedTypes,
+	}, nil
+}
+
+type uploadHandler struct {
+	root         *os.Root
+	maxFileSize  int64
+	allowedTypes []string
+}
+
+func (h *uploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	who, found := IdentityFromContext(r.Context())
+	if !found || who.UserProfile == nil {
+		logf(slog.LevelWarn, "denying upload request from [%s] without caller identity; is WithIdentity missing?", r.RemoteAddr)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	userDir, err := loginDirectory(who.UserProfile.LoginName)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.list(w, userDir)
+	case http.MethodPost:
+		h.upload(w, r, userDir)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// list writes the files in userDir.
+func (h *uploadHandler) list(w http.ResponseWriter, userDir string) {
+	files := []UploadedFile{}
+	entries, err := fs.ReadDir(h.root.FS(), userDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logf(slog.LevelError, "failed to list uploads in [%s]: %v", userDir, err)
+		http.Error(w, "failed to list uploads", http.StatusInternalServerError)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, UploadedFile{Name: entry.Name(), Size: info.Size(), Modified: info.ModTime()})
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+// upload stores the files of the multipart form of r in userDir.
+func (h *uploadHandler) upload(w http.ResponseWriter, r *http.Request, userDir string) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "request is not a multipart form", http.StatusBadRequest)
+		return
+	}
+	if err := h.root.MkdirAll(userDir, 0o750); err != nil {
+		logf(slog.LevelError, "failed to create upload directory [%s]: %v", userDir, err)
+		http.Error(w, "failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	files := []UploadedFile{}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			http.Error(w, "invalid multipart form", http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		file, status, err := h.store(part, userDir, part.FileName())
+		if err != nil {
+			if status == http.StatusInternalServerError {
+				logf(slog.LevelError, "failed to store upload [%s] in [%s]: %v", part.FileName(), userDir, err)
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		http.Error(w, "no file is uploaded", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, files)
+}
+
+// store writes the content of src to userDir/name through a temporary file
+// so that a partial upload never replaces an existing file. The returned
+// status describes the error.
+func (h *uploadHandler) store(src io.Reader, userDir, name string) (UploadedFile, int, error) {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || strings.HasPrefix(name, ".") {
+		return UploadedFile{}, http.StatusBadRequest, fmt.Errorf("invalid file name")
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return UploadedFile{}, http.StatusBadRequest, fmt.Errorf("failed to read upload")
+	}
+	head = head[:n]
+	if contentType := http.DetectContentType(head); !allowedMediaType(h.allowedTypes, contentType) {
+		return UploadedFile{}, http.StatusUnsupportedMediaType, fmt.Errorf("file type [%s] is not allowed", contentType)
+	}
+
+	temp := path.Join(userDir, fmt.Sprintf(".upload-%d", time.Now().UnixNano()))
+	file, err := h.root.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return UploadedFile{}, http.StatusInternalServerError, err
+	}
+	defer func() { _ = h.root.Remove(temp) }()
+	content := io.MultiReader(bytes.NewReader(head), src)
+	size, err := io.Copy(file, io.LimitReader(content, h.maxFileSize+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return UploadedFile{}, http.StatusBadRequest, fmt.Errorf("failed to read upload")
+	}
+	if size > h.maxFileSize {
+		return UploadedFile{}, http.StatusRequestEntityTooLarge, fmt.Errorf("file is larger than %d bytes", h.maxFileSize)
+	}
+	target := path.Join(userDir, name)
+	if err := h.root.Rename(temp, target); err != nil {
+		return UploadedFile{}, http.StatusInternalServerError, err
+	}
+	return UploadedFile{Name: name, Size: size, Modified: time.Now()}, http.StatusCreated, nil
+}
+
+// loginDirectory returns the name of the directory of files owned by a login
+// name.
+func loginDirectory(loginName string) (string, error) {
+	if loginName == "" || strings.HasPrefix(loginName, ".") || strings.ContainsAny(loginName, `/\`+"\x00") {
+		return "", fmt.Errorf("login name [%s] cannot be used as a directory", loginName)
+	}
+	return loginName, nil
+}
+
+// allowedMediaType reports whether contentType matches any of the allowed
+// media types. Any type is allowed if allowed is empty.
+func allowedMediaType(allowed []string, contentType string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(allowed, func(pattern string) bool {
+		if prefix, found := strings.CutSuffix(pattern, "/*"); found {
+			return strings.HasPrefix(mediaType, prefix+"/")
+		}
+		return strings.EqualFold(pattern, mediaType)
+	})
+}
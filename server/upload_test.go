@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newUploadRequest(t *testing.T, loginName string, files map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	writer.Close()
+	r := httptest.NewRequest(http.MethodPost, "/", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	if loginName != "" {
+		r = r.WithContext(ContextWithIdentity(r.Context(), newTestWhoIs(loginName)))
+	}
+	return r
+}
+
+func TestUploadHandler(t *testing.T) {
+	pngHeader := "\x89PNG\r\n\x1a\n"
+	tests := []struct {
+		name       string
+		opts       UploadOptions
+		loginName  string
+		files      map[string]string
+		wantStatus int
+		wantFile   string
+	}{
+		{
+			name:       "stored under login name",
+			loginName:  "alice@example.com",
+			files:      map[string]string{"notes.txt": "hello"},
+			wantStatus: http.StatusCreated,
+			wantFile:   filepath.Join("alice@example.com", "notes.txt"),
+		},
+		{
+			name:       "path in file name",
+			loginName:  "alice@example.com",
+			files:      map[string]string{"../../notes.txt": "hello"},
+			wantStatus: http.StatusCreated,
+			wantFile:   filepath.Join("alice@example.com", "notes.txt"),
+		},
+		{
+			name:       "hidden file",
+			loginName:  "alice@example.com",
+			files:      map[string]string{".bashrc": "hello"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "too large",
+			opts:       UploadOptions{MaxFileSize: 4},
+			loginName:  "alice@example.com",
+			files:      map[string]string{"notes.txt": "hello"},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "allowed type",
+			opts:       UploadOptions{AllowedTypes: []string{"image/*"}},
+			loginName:  "alice@example.com",
+			files:      map[string]string{"image.png": pngHeader + "data"},
+			wantStatus: http.StatusCreated,
+			wantFile:   filepath.Join("alice@example.com", "image.png"),
+		},
+		{
+			name:       "type detected from content",
+			opts:       UploadOptions{AllowedTypes: []string{"image/png"}},
+			loginName:  "alice@example.com",
+			files:      map[string]string{"image.png": "not an image"},
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "without identity",
+			files:      map[string]string{"notes.txt": "hello"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "without files",
+			loginName:  "alice@example.com",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			h, err := UploadHandler(dir, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, newUploadRequest(t, test.loginName, test.files))
+			if w.Code != test.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, test.wantStatus, w.Body.String())
+			}
+			if test.wantFile != "" {
+				if _, err := os.Stat(filepath.Join(dir, test.wantFile)); err != nil {
+					t.Error(err)
+				}
+			}
+			entries, _ := os.ReadDir(filepath.Join(dir, "alice@example.com"))
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Name(), ".upload-") {
+					t.Errorf("temporary file %s is left behind", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestUploadHandlerList(t *testing.T) {
+	h, err := UploadHandler(t.TempDir(), UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newUploadRequest(t, "alice@example.com", map[string]string{"a.txt": "a", "b.txt": "bb"}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	h.ServeHTTP(httptest.NewRecorder(), newUploadRequest(t, "bob@example.com", map[string]string{"c.txt": "c"}))
+
+	tests := []struct {
+		loginName string
+		want      map[string]int64
+	}{
+		{loginName: "alice@example.com", want: map[string]int64{"a.txt": 1, "b.txt": 2}},
+		{loginName: "bob@example.com", want: map[string]int64{"c.txt": 1}},
+		{loginName: "carol@example.com", want: map[string]int64{}},
+	}
+	for _, test := range tests {
+		t.Run(test.loginName, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r = r.WithContext(ContextWithIdentity(r.Context(), newTestWhoIs(test.loginName)))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d", w.Code)
+			}
+			var files []UploadedFile
+			if err := json.NewDecoder(w.Body).Decode(&files); err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]int64)
+			for _, file := range files {
+				got[file.Name] = file.Size
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got files %v, want %v", got, test.want)
+			}
+			for name, size := range test.want {
+				if got[name] != size {
+					t.Errorf("got size %d of %s, want %d", got[name], name, size)
+				}
+			}
+		})
+	}
+}
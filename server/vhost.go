@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// VirtualHostRouter is a http.Handler dispatching requests by their Host
+// header so that one node can serve several sites, such as its own name and
+// names pointed at it with CNAME records. Each site usually has its own
+// Router with its own policies.
+type VirtualHostRouter struct {
+	mu        sync.RWMutex
+	hosts     map[string]http.Handler
+	wildcards map[string]http.Handler
+	fallback  http.Handler
+}
+
+// NewVirtualHostRouter creates an empty VirtualHostRouter.
+func NewVirtualHostRouter() *VirtualHostRouter {
+	return &VirtualHostRouter{
+		hosts:     make(map[string]http.Handler),
+		wildcards: make(map[string]http.Handler),
+	}
+}
+
+// Handle registers the handler for requests to host, such as
+// "docs.example.com", or to any subdomain if host starts with "*.", such as
+// "*.example.com". Hosts are matched case-insensitively without the port, and
+// exact hosts take precedence over wildcards. It panics if host is empty or
+// already registered.
+func (v *VirtualHostRouter) Handle(host string, h http.Handler) {
+	host = normalizeHost(host)
+	if host == "" || host == "*." {
+		panic("server: virtual host must not be empty")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	hosts, key := v.hosts, host
+	if suffix, found := strings.CutPrefix(host, "*."); found {
+		hosts, key = v.wildcards, suffix
+	}
+	if _, found := hosts[key]; found {
+		panic(fmt.Sprintf("server: virtual host [%s] is already registered", host))
+	}
+	hosts[key] = h
+}
+
+// HandleDefault registers the handler for requests to hosts which are not
+// registered. Such requests are answered with status 421 Misdirected Request
+// otherwise.
+func (v *VirtualHostRouter) HandleDefault(h http.Handler) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fallback = h
+}
+
+// ServeHTTP dispatches the request to the handler of its host.
+func (v *VirtualHostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h := v.handler(r.Host); h != nil {
+		h.ServeHTTP(w, r)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusMisdirectedRequest), http.StatusMisdirectedRequest)
+}
+
+// handler returns the handler of host, the most specific wildcard matching
+// it, or the default handler.
+func (v *VirtualHostRouter) handler(host string) http.Handler {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = normalizeHost(host)
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if h, found := v.hosts[host]; found {
+		return h
+	}
+	for domain := host; ; {
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		if h, found := v.wildcards[parent]; found {
+			return h
+		}
+		domain = parent
+	}
+	return v.fallback
+}
+
+// normalizeHost lowercases host and removes the trailing dot of a fully
+// qualified name.
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
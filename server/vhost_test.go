@@ -0,0 +1,67 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVirtualHostRouter(t *testing.T) {
+	site := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, name)
+		})
+	}
+	v := NewVirtualHostRouter()
+	v.Handle("builds.prawn-universe.ts.net", site("builds"))
+	v.Handle("Docs.Example.com", site("docs"))
+	v.Handle("*.example.com", site("wildcard"))
+	v.Handle("*.internal.example.com", site("internal"))
+
+	withDefault := NewVirtualHostRouter()
+	withDefault.HandleDefault(site("default"))
+
+	tests := []struct {
+		name       string
+		router     *VirtualHostRouter
+		host       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "exact", router: v, host: "builds.prawn-universe.ts.net", wantStatus: http.StatusOK, wantBody: "builds"},
+		{name: "with port", router: v, host: "builds.prawn-universe.ts.net:8443", wantStatus: http.StatusOK, wantBody: "builds"},
+		{name: "trailing dot", router: v, host: "builds.prawn-universe.ts.net.", wantStatus: http.StatusOK, wantBody: "builds"},
+		{name: "case-insensitive", router: v, host: "DOCS.example.com", wantStatus: http.StatusOK, wantBody: "docs"},
+		{name: "wildcard", router: v, host: "wiki.example.com", wantStatus: http.StatusOK, wantBody: "wildcard"},
+		{name: "most specific wildcard", router: v, host: "git.internal.example.com", wantStatus: http.StatusOK, wantBody: "internal"},
+		{name: "wildcard does not match apex", router: v, host: "example.com", wantStatus: http.StatusMisdirectedRequest},
+		{name: "unknown", router: v, host: "other.net", wantStatus: http.StatusMisdirectedRequest},
+		{name: "default", router: withDefault, host: "other.net", wantStatus: http.StatusOK, wantBody: "default"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Host = test.host
+			w := httptest.NewRecorder()
+			test.router.ServeHTTP(w, r)
+			if w.Code != test.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, test.wantStatus)
+			}
+			if test.wantBody != "" && w.Body.String() != test.wantBody {
+				t.Errorf("got body %q, want %q", w.Body.String(), test.wantBody)
+			}
+		})
+	}
+}
+
+func TestVirtualHostRouterDuplicate(t *testing.T) {
+	v := NewVirtualHostRouter()
+	v.Handle("docs.example.com", http.NotFoundHandler())
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a duplicate host to panic")
+		}
+	}()
+	v.Handle("DOCS.example.com.", http.NotFoundHandler())
+}
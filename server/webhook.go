@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWebhookMaxBodySize is the maximum size of the body of a webhook
+	// request if WebhookConfig.MaxBodySize is not set.
+	DefaultWebhookMaxBodySize = 1 << 20
+
+	// DefaultWebhookReplayWindow is how long deliveries are remembered to
+	// reject replays if WebhookConfig.ReplayWindow is not set.
+	DefaultWebhookReplayWindow = 24 * time.Hour
+
+	// DefaultStripeSignatureTolerance is the maximum age of a Stripe
+	// signature if no tolerance is passed to StripeWebhookVerifier.
+	DefaultStripeSignatureTolerance = 5 * time.Minute
+)
+
+// ErrInvalidWebhookSignature is returned by a WebhookVerifier if the
+// signature of a request is missing or does not match its body.
+var ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+
+// WebhookVerifier verifies the signature of a webhook request.
+type WebhookVerifier interface {
+	// Verify checks the signature in header against body and returns an
+	// identifier of the delivery used to reject replays.
+	Verify(header http.Header, body []byte, now time.Time) (string, error)
+}
+
+// WebhookVerifierFunc is an adapter to use an ordinary function as a
+// WebhookVerifier.
+type WebhookVerifierFunc func(header http.Header, body []byte, now time.Time) (string, error)
+
+// Verify calls f(header, body, now).
+func (f WebhookVerifierFunc) Verify(header http.Header, body []byte, now time.Time) (string, error) {
+	return f(header, body, now)
+}
+
+// HMACWebhookVerifier returns a verifier of the hex encoded HMAC-SHA256 of the
+// body in the specified header, optionally prefixed with "sha256=".
+func HMACWebhookVerifier(secret []byte, header string) WebhookVerifier {
+	return WebhookVerifierFunc(func(h http.Header, body []byte, _ time.Time) (string, error) {
+		signature := strings.TrimPrefix(h.Get(header), "sha256=")
+		if !validHMAC(secret, body, signature) {
+			return "", ErrInvalidWebhookSignature
+		}
+		return signature, nil
+	})
+}
+
+// GitHubWebhookVerifier returns a verifier of webhooks signed by GitHub with
+// the specified secret. Deliveries are identified by their X-GitHub-Delivery
+// header so that redeliveries triggered from GitHub are treated as replays.
+func GitHubWebhookVerifier(secret []byte) WebhookVerifier {
+	return WebhookVerifierFunc(func(h http.Header, body []byte, _ time.Time) (string, error) {
+		signature, found := strings.CutPrefix(h.Get("X-Hub-Signature-256"), "sha256=")
+		if !found || !validHMAC(secret, body, signature) {
+			return "", ErrInvalidWebhookSignature
+		}
+		if delivery := h.Get("X-GitHub-Delivery"); delivery != "" {
+			return delivery, nil
+		}
+		return signature, nil
+	})
+}
+
+// StripeWebhookVerifier returns a verifier of webhooks signed by Stripe with
+// the specified endpoint secret. Signatures older than tolerance are
+// rejected; tolerance defaults to DefaultStripeSignatureTolerance if it is 0.
+func StripeWebhookVerifier(secret []byte, tolerance time.Duration) WebhookVerifier {
+	if tolerance == 0 {
+		tolerance = DefaultStripeSignatureTolerance
+	}
+	return WebhookVerifierFunc(func(h http.Header, body []byte, now time.Time) (string, error) {
+		var timestamp string
+		var signatures []string
+		for _, item := range strings.Split(h.Get("Stripe-Signature"), ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+			switch key {
+			case "t":
+				timestamp = value
+			case "v1":
+				signatures = append(signatures, value)
+			}
+		}
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || len(signatures) == 0 {
+			return "", ErrInvalidWebhookSignature
+		}
+		if age := now.Sub(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+			return "", fmt.Errorf("%w: timestamp is outside the tolerance", ErrInvalidWebhookSignature)
+		}
+		payload := append([]byte(timestamp+"."), body...)
+		for _, signature := range signatures {
+			if validHMAC(secret, payload, signature) {
+				return timestamp + "." + signature, nil
+			}
+		}
+		return "", ErrInvalidWebhookSignature
+	})
+}
+
+// validHMAC reports whether signature is the hex encoded HMAC-SHA256 of
+// message.
+func validHMAC(secret, message []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil || len(expected) != sha256.Size {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(message)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// WebhookEvent is a verified webhook request.
+type WebhookEvent struct {
+	// ID identifies the delivery as returned by the verifier.
+	ID     string
+	Header http.Header
+	Body   []byte
+}
+
+// WebhookConfig configures a handler created by WebhookHandler.
+type WebhookConfig struct {
+	// Verifier verifies the signature of requests.
+	Verifier WebhookVerifier
+
+	// Handle is called with every verified delivery which is not a replay.
+	// Returning an error answers the request with status 500 so that the
+	// provider retries the delivery.
+	Handle func(ctx context.Context, event WebhookEvent) error
+
+	// MaxBodySize is the maximum size of a request body in bytes. It
+	// defaults to DefaultWebhookMaxBodySize.
+	MaxBodySize int64
+
+	// ReplayWindow is how long handled deliveries are remembered. Replays
+	// within it are acknowledged with status 200 without calling Handle. It
+	// defaults to DefaultWebhookReplayWindow.
+	ReplayWindow time.Duration
+}
+
+// WebhookHandler returns a handler receiving webhooks, which are typically
+// the requests served over Tailscale Funnel. Only POST requests with a valid
+// signature are passed to config.Handle. It panics if config has no
+// verifier or handle function, or a negative body size or replay window.
+func WebhookHandler(config WebhookConfig) http.Handler {
+	if config.Verifier == nil {
+		panic("server: webhook verifier is required")
+	}
+	if config.Handle == nil {
+		panic("server: webhook handle function is required")
+	}
+	if config.MaxBodySize < 0 || config.ReplayWindow < 0 {
+		panic("server: webhook body size and replay window must not be negative")
+	}
+	if config.MaxBodySize == 0 {
+		config.MaxBodySize = DefaultWebhookMaxBodySize
+	}
+	if config.ReplayWindow == 0 {
+		config.ReplayWindow = DefaultWebhookReplayWindow
+	}
+	return &webhookHandler{
+		config:     config,
+		now:        time.Now,
+		deliveries: make(map[string]time.Time),
+	}
+}
+
+type webhookHandler struct {
+	config WebhookConfig
+	now    func() time.Time
+
+	mu         sync.Mutex
+	deliveries map[string]time.Time
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.config.MaxBodySize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	now := h.now()
+	id, err := h.config.Verifier.Verify(r.Header, body, now)
+	if err != nil {
+		logf(slog.LevelWarn, "rejecting webhook from [%s]: %v", r.RemoteAddr, err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if !h.claim(id, now) {
+		logf(slog.LevelInfo, "ignoring replayed webhook delivery [%s]", id)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	event := WebhookEvent{ID: id, Header: r.Header, Body: body}
+	if err := h.config.Handle(r.Context(), event); err != nil {
+		h.release(id)
+		logf(slog.LevelError, "failed to handle webhook delivery [%s]: %v", id, err)
+		http.Error(w, "failed to handle webhook", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// claim records the delivery and reports whether it has not been seen within
+// the replay window. Expired deliveries are forgotten.
+func (h *webhookHandler) claim(id string, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for delivery, expiry := range h.deliveries {
+		if !now.Before(expiry) {
+			delete(h.deliveries, delivery)
+		}
+	}
+	if _, found := h.deliveries[id]; found {
+		return false
+	}
+	h.deliveries[id] = now.Add(h.config.ReplayWindow)
+	return true
+}
+
+// release forgets a delivery which failed to be handled so that it can be
+// retried.
+func (h *webhookHandler) release(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.deliveries, id)
+}
+
+// HandleWebhook registers a webhook handler created from config for the
+// specified pattern, such as "POST /webhooks/github". The route has no
+// policy since webhooks usually arrive over Tailscale Funnel without a
+// tailnet identity; the signature is the only authentication. It panics if
+// config is invalid; see WebhookHandler.
+func (rt *Router) HandleWebhook(pattern string, config WebhookConfig, middlewares ...Middleware) {
+	rt.Handle(pattern, nil, WebhookHandler(config), middlewares...)
+}
+
+// HandleWebhook registers a webhook handler on the router of the server. See
+// Router.HandleWebhook.
+func (s *Server) HandleWebhook(pattern string, config WebhookConfig, middlewares ...Middleware) {
+	s.router.HandleWebhook(pattern, config, middlewares...)
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testHMAC(secret, message string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookVerifiers(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := `{"action":"opened"}`
+	tests := []struct {
+		name     string
+		verifier WebhookVerifier
+		header   http.Header
+		wantID   string
+		wantErr  bool
+	}{
+		{
+			name:     "github",
+			verifier: GitHubWebhookVerifier([]byte("secret")),
+			header:   http.Header{"X-Hub-Signature-256": {"sha256=" + testHMAC("secret", body)}, "X-Github-Delivery": {"delivery-1"}},
+			wantID:   "delivery-1",
+		},
+		{
+			name:     "github with wrong secret",
+			verifier: GitHubWebhookVerifier([]byte("secret")),
+			header:   http.Header{"X-Hub-Signature-256": {"sha256=" + testHMAC("other", body)}},
+			wantErr:  true,
+		},
+		{
+			name:     "github without prefix",
+			verifier: GitHubWebhookVerifier([]byte("secret")),
+			header:   http.Header{"X-Hub-Signature-256": {testHMAC("secret", body)}},
+			wantErr:  true,
+		},
+		{
+			name:     "stripe",
+			verifier: StripeWebhookVerifier([]byte("secret"), 0),
+			header:   http.Header{"Stripe-Signature": {"t=" + timestamp + ",v1=" + testHMAC("other", timestamp+"."+body) + ",v1=" + testHMAC("secret", timestamp+"."+body)}},
+			wantID:   timestamp + "." + testHMAC("secret", timestamp+"."+body),
+		},
+		{
+			name:     "stripe with old timestamp",
+			verifier: StripeWebhookVerifier([]byte("secret"), time.Minute),
+			header:   http.Header{"Stripe-Signature": {"t=1699999000,v1=" + testHMAC("secret", "1699999000."+body)}},
+			wantErr:  true,
+		},
+		{
+			name:     "stripe with body signature",
+			verifier: StripeWebhookVerifier([]byte("secret"), 0),
+			header:   http.Header{"Stripe-Signature": {"t=" + timestamp + ",v1=" + testHMAC("secret", body)}},
+			wantErr:  true,
+		},
+		{
+			name:     "hmac",
+			verifier: HMACWebhookVerifier([]byte("secret"), "X-Signature"),
+			header:   http.Header{"X-Signature": {testHMAC("secret", body)}},
+			wantID:   testHMAC("secret", body),
+		},
+		{
+			name:     "hmac without header",
+			verifier: HMACWebhookVerifier([]byte("secret"), "X-Signature"),
+			header:   http.Header{},
+			wantErr:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := test.verifier.Verify(test.header, []byte(body), now)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidWebhookSignature) {
+					t.Errorf("got error %v, want ErrInvalidWebhookSignature", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != test.wantID {
+				t.Errorf("got ID %q, want %q", id, test.wantID)
+			}
+		})
+	}
+}
+
+func TestWebhookHandler(t *testing.T) {
+	var events []WebhookEvent
+	fail := true
+	h := WebhookHandler(WebhookConfig{
+		Verifier:    GitHubWebhookVerifier([]byte("secret")),
+		MaxBodySize: 64,
+		Handle: func(_ context.Context, event WebhookEvent) error {
+			if fail {
+				fail = false
+				return errors.New("temporary failure")
+			}
+			events = append(events, event)
+			return nil
+		},
+	})
+	newRequest := func(method, delivery, body string) *http.Request {
+		r := httptest.NewRequest(method, "/webhook", strings.NewReader(body))
+		r.Header.Set("X-Hub-Signature-256", "sha256="+testHMAC("secret", body))
+		r.Header.Set("X-GitHub-Delivery", delivery)
+		return r
+	}
+	tests := []struct {
+		name       string
+		request    *http.Request
+		wantStatus int
+		wantEvents int
+	}{
+		{name: "handler failure", request: newRequest(http.MethodPost, "1", "{}"), wantStatus: http.StatusInternalServerError},
+		{name: "retry after failure", request: newRequest(http.MethodPost, "1", "{}"), wantStatus: http.StatusOK, wantEvents: 1},
+		{name: "replay", request: newRequest(http.MethodPost, "1", "{}"), wantStatus: http.StatusOK, wantEvents: 1},
+		{name: "new delivery", request: newRequest(http.MethodPost, "2", "{}"), wantStatus: http.StatusOK, wantEvents: 2},
+		{name: "GET", request: newRequest(http.MethodGet, "3", ""), wantStatus: http.StatusMethodNotAllowed, wantEvents: 2},
+		{name: "too large", request: newRequest(http.MethodPost, "4", strings.Repeat("a", 65)), wantStatus: http.StatusRequestEntityTooLarge, wantEvents: 2},
+		{
+			name: "invalid signature",
+			request: func() *http.Request {
+				r := newRequest(http.MethodPost, "5", "{}")
+				r.Header.Set("X-Hub-Signature-256", "sha256="+testHMAC("other", "{}"))
+				return r
+			}(),
+			wantStatus: http.StatusUnauthorized,
+			wantEvents: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, test.request)
+			if w.Code != test.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, test.wantStatus)
+			}
+			if len(events) != test.wantEvents {
+				t.Errorf("got %d events, want %d", len(events), test.wantEvents)
+			}
+		})
+	}
+}
+
+func TestWebhookHandlerReplayWindow(t *testing.T) {
+	calls := 0
+	handler := WebhookHandler(WebhookConfig{
+		Verifier:     HMACWebhookVerifier([]byte("secret"), "X-Signature"),
+		ReplayWindow: time.Hour,
+		Handle: func(context.Context, WebhookEvent) error {
+			calls++
+			return nil
+		},
+	})
+	h := handler.(*webhookHandler)
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	for _, elapsed := range []time.Duration{0, 30 * time.Minute, 61 * time.Minute} {
+		now = now.Add(elapsed)
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		r.Header.Set("X-Signature", testHMAC("secret", "{}"))
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want 2", calls)
+	}
+}
+
+func TestWebhookHandlerInvalidConfig(t *testing.T) {
+	verifier := HMACWebhookVerifier([]byte("secret"), "X-Signature")
+	handle := func(context.Context, WebhookEvent) error { return nil }
+	tests := []struct {
+		name   string
+		config WebhookConfig
+	}{
+		{name: "no verifier", config: WebhookConfig{Handle: handle}},
+		{name: "no handle function", config: WebhookConfig{Verifier: verifier}},
+		{name: "negative body size", config: WebhookConfig{Verifier: verifier, Handle: handle, MaxBodySize: -1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("got no panic")
+				}
+			}()
+			WebhookHandler(test.config)
+		})
+	}
+}
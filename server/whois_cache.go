@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+)
+
+// whoIsCacheSweepSize is the number of cached entries above which expired
+// entries are removed when a new entry is stored.
+const whoIsCacheSweepSize = 1024
+
+// whoIsCache caches the identities returned by WhoIs lookups for a period of
+// time.
+type whoIsCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]whoIsCacheEntry
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type whoIsCacheEntry struct {
+	who     *apitype.WhoIsResponse
+	expires time.Time
+}
+
+func newWhoIsCache(ttl time.Duration) *whoIsCache {
+	return &whoIsCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]whoIsCacheEntry),
+	}
+}
+
+// wrap returns a lookup function which serves identities from the cache and
+// falls back to whoIs on a miss. Failed lookups are not cached.
+func (c *whoIsCache) wrap(whoIs whoIsFunc) whoIsFunc {
+	return func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+		key := whoIsCacheKey(remoteAddr)
+		if who, found := c.get(key); found {
+			c.hits.Add(1)
+			return who, nil
+		}
+		c.misses.Add(1)
+		who, err := whoIs(ctx, remoteAddr)
+		if err != nil {
+			return nil, err
+		}
+		c.set(key, who)
+		return who, nil
+	}
+}
+
+func (c *whoIsCache) get(key string) (*apitype.WhoIsResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.who, true
+}
+
+func (c *whoIsCache) set(key string, who *apitype.WhoIsResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if len(c.entries) >= whoIsCacheSweepSize {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = whoIsCacheEntry{
+		who:     who,
+		expires: now.Add(c.ttl),
+	}
+}
+
+// size returns the number of cached identities, including expired ones which
+// have not been removed yet.
+func (c *whoIsCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// invalidate removes all cached identities.
+func (c *whoIsCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+// invalidateOnNetMapChange removes all cached identities whenever the network
+// map of the node changes, as peers, users and tags may have changed. It
+// returns when ctx is cancelled.
+func (c *whoIsCache) invalidateOnNetMapChange(ctx context.Context, client *local.Client, logger *logger) {
+	for ctx.Err() == nil {
+		if err := c.watchNetMap(ctx, client); err != nil && ctx.Err() == nil {
+			logger.logf(slog.LevelError, "failed to watch network map changes: %v", err)
+			c.invalidate()
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+func (c *whoIsCache) watchNetMap(ctx context.Context, client *local.Client) error {
+	watcher, err := client.WatchIPNBus(ctx, ipn.NotifyRateLimit)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = watcher.Close() }()
+	for {
+		n, err := watcher.Next()
+		if err != nil {
+			return err
+		}
+		if n.NetMap != nil {
+			c.invalidate()
+		}
+	}
+}
+
+// whoIsCacheKey returns the cache key of remoteAddr. Identities are keyed by
+// IP address as all connections from a node share the same identity.
+func whoIsCacheKey(remoteAddr string) string {
+	if addrPort, err := netip.ParseAddrPort(remoteAddr); err == nil {
+		return addrPort.Addr().String()
+	}
+	return remoteAddr
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestWhoIsCache(t *testing.T) {
+	now := time.Now()
+	cache := newWhoIsCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	calls := 0
+	var lookupErr error
+	lookup := cache.wrap(func(context.Context, string) (*apitype.WhoIsResponse, error) {
+		calls++
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+		return newTestWhoIs("alice@example.com"), nil
+	})
+
+	steps := []struct {
+		name       string
+		remoteAddr string
+		advance    time.Duration
+		invalidate bool
+		err        error
+		wantCalls  int
+		wantErr    bool
+	}{
+		{
+			name:       "first lookup",
+			remoteAddr: "100.64.0.1:1234",
+			wantCalls:  1,
+		},
+		{
+			name:       "cached lookup from another port",
+			remoteAddr: "100.64.0.1:5678",
+			advance:    30 * time.Second,
+			wantCalls:  1,
+		},
+		{
+			name:       "lookup after expiry",
+			remoteAddr: "100.64.0.1:1234",
+			advance:    time.Minute,
+			wantCalls:  2,
+		},
+		{
+			name:       "lookup after invalidation",
+			remoteAddr: "100.64.0.1:1234",
+			invalidate: true,
+			wantCalls:  3,
+		},
+		{
+			name:       "failed lookup",
+			remoteAddr: "100.64.0.2:1234",
+			err:        errors.New("connection refused"),
+			wantCalls:  4,
+			wantErr:    true,
+		},
+		{
+			name:       "failed lookup is not cached",
+			remoteAddr: "100.64.0.2:1234",
+			wantCalls:  5,
+		},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.invalidate {
+			cache.invalidate()
+		}
+		lookupErr = step.err
+		_, err := lookup(context.Background(), step.remoteAddr)
+		if (err != nil) != step.wantErr {
+			t.Errorf("%s: got error %v; want error %t", step.name, err, step.wantErr)
+		}
+		if calls != step.wantCalls {
+			t.Errorf("%s: got %d lookups; want %d", step.name, calls, step.wantCalls)
+		}
+	}
+}